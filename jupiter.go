@@ -238,6 +238,7 @@ func (app *Application) Job(runner job.Runner) error {
 // SetRegistry set customize registry
 func (app *Application) SetRegistry(reg registry.Registry) {
 	app.registerer = reg
+	registry.DefaultRegisterer = reg
 }
 
 // SetGovernor set governor addr (default 127.0.0.1:0)
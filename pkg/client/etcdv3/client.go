@@ -48,7 +48,6 @@ func newClient(config *Config) *Client {
 		DialKeepAliveTime:    10 * time.Second,
 		DialKeepAliveTimeout: 3 * time.Second,
 		DialOptions: []grpc.DialOption{
-			grpc.WithBlock(),
 			grpc.WithUnaryInterceptor(grpcprom.UnaryClientInterceptor),
 			grpc.WithStreamInterceptor(grpcprom.StreamClientInterceptor),
 		},
@@ -61,6 +60,10 @@ func newClient(config *Config) *Client {
 		config.logger.Panic("client etcd endpoints empty", xlog.FieldMod(ecode.ModClientETCD), xlog.FieldValueAny(config))
 	}
 
+	if !config.NonBlock {
+		conf.DialOptions = append(conf.DialOptions, grpc.WithBlock())
+	}
+
 	if !config.Secure {
 		conf.DialOptions = append(conf.DialOptions, grpc.WithInsecure())
 	}
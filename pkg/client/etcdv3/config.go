@@ -36,6 +36,8 @@ type (
 		// 连接超时时间
 		ConnectTimeout time.Duration `json:"connectTimeout"`
 		Secure         bool          `json:"secure"`
+		// 非阻塞连接, etcd不可用时也能创建客户端, 请求在连接建立前失败
+		NonBlock bool `json:"nonBlock"`
 		// 自动同步member list的间隔
 		AutoSyncInterval time.Duration `json:"autoAsyncInterval"`
 		TTL              int           // 单位：s
@@ -16,7 +16,11 @@ package balancer
 
 import (
 	"errors"
-	"fmt"
+	"sync"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/xlog"
 
 	"google.golang.org/grpc/attributes"
 	"google.golang.org/grpc/balancer"
@@ -28,10 +32,16 @@ import (
 
 // NewBalancerBuilderV2 returns a base balancer builder configured by the provided config.
 func NewBalancerBuilderV2(name string, pb PickerBuilder, config base.Config) balancer.Builder {
+	return NewBalancerBuilderV2WithOutlier(name, pb, config, DefaultOutlierConfig())
+}
+
+// NewBalancerBuilderV2WithOutlier returns a base balancer builder with outlier detection config.
+func NewBalancerBuilderV2WithOutlier(name string, pb PickerBuilder, config base.Config, outlier *OutlierConfig) balancer.Builder {
 	return &baseBuilder{
 		name:            name,
 		v2PickerBuilder: pb,
 		config:          config,
+		outlier:         outlier,
 	}
 }
 
@@ -39,6 +49,7 @@ type baseBuilder struct {
 	name            string
 	v2PickerBuilder PickerBuilder
 	config          base.Config
+	outlier         *OutlierConfig
 }
 
 // Build ...
@@ -51,11 +62,24 @@ func (bb *baseBuilder) Build(cc balancer.ClientConn, opt balancer.BuildOptions)
 		scStates: make(map[balancer.SubConn]connectivity.State),
 		csEvltr:  &balancer.ConnectivityStateEvaluator{},
 		config:   bb.config,
+		logger:   xlog.JupiterLogger.With(xlog.FieldMod(ecode.ModClientGrpc), xlog.String("balancer", bb.name)),
 	}
 	// Initialize picker to a picker that always returns
 	// ErrNoSubConnAvailable, because when state of a SubConn changes, we
 	// may call UpdateState with this picker.
 	bal.v2Picker = NewErrPickerV2(balancer.ErrNoSubConnAvailable)
+
+	if bb.outlier != nil && bb.outlier.Enable {
+		bal.outliers = make(map[balancer.SubConn]*outlierStats)
+		bal.detector = &outlierDetector{
+			target: opt.Target.Endpoint,
+			config: bb.outlier,
+			logger: bal.logger,
+		}
+		bal.triggered = make(chan struct{}, 1)
+		bal.done = make(chan struct{})
+		go bal.watchOutliers()
+	}
 	return bal
 }
 
@@ -78,6 +102,14 @@ type baseBalancer struct {
 	v2Picker   balancer.V2Picker
 	config     base.Config
 	attributes *attributes.Attributes
+	logger     *xlog.Logger
+
+	// mu guards the balancer, as outlier detection updates picker in its own goroutine
+	mu        sync.Mutex
+	outliers  map[balancer.SubConn]*outlierStats
+	detector  *outlierDetector
+	triggered chan struct{}
+	done      chan struct{}
 }
 
 // HandleResolvedAddrs ...
@@ -87,6 +119,8 @@ func (b *baseBalancer) HandleResolvedAddrs(addrs []resolver.Address, err error)
 
 // ResolverError ...
 func (b *baseBalancer) ResolverError(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
 	switch b.state {
 	case connectivity.TransientFailure, connectivity.Idle, connectivity.Connecting:
 		b.v2Picker = NewErrPickerV2(err)
@@ -100,10 +134,11 @@ func (b *baseBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
 	if grpclog.V(2) {
 		grpclog.Infoln("base.baseBalancer: got new ClientConn state: ", s)
 	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
 	// addrsSet is the set converted from addrs, it's used for quick lookup of an address.
 	addrsSet := make(map[resolver.Address]struct{})
-	fmt.Printf("s.ResolverState.Addresses = %+v\n", s.ResolverState.Addresses)
-	fmt.Printf("s.ResolverState.Attributes = %+v\n", s.ResolverState.Attributes)
+	b.logger.Debug("update client conn state", xlog.Any("addresses", s.ResolverState.Addresses), xlog.Any("attributes", s.ResolverState.Attributes))
 	for _, a := range s.ResolverState.Addresses {
 		addrsSet[a] = struct{}{}
 		if _, ok := b.subConns[a]; !ok {
@@ -118,17 +153,30 @@ func (b *baseBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
 			}
 			b.subConns[a] = sc
 			b.scStates[sc] = connectivity.Idle
+			if b.outliers != nil {
+				b.outliers[sc] = &outlierStats{addr: a.Addr}
+			}
 			sc.Connect()
 		}
 	}
 
-	b.attributes = s.ResolverState.Attributes
+	// 路由等配置变更后重新生成picker
+	if b.attributes != s.ResolverState.Attributes {
+		b.attributes = s.ResolverState.Attributes
+		if b.state == connectivity.Ready {
+			b.regeneratePicker(nil)
+			b.cc.UpdateState(balancer.State{ConnectivityState: b.state, Picker: b.v2Picker})
+		}
+	}
 
 	for a, sc := range b.subConns {
 		// a was removed by resolver.
 		if _, ok := addrsSet[a]; !ok {
 			b.cc.RemoveSubConn(sc)
 			delete(b.subConns, a)
+			if b.outliers != nil {
+				delete(b.outliers, sc)
+			}
 			// Keep the state of this sc in b.scStates until sc's state becomes Shutdown.
 			// The entry will be deleted in HandleSubConnStateChange.
 		}
@@ -161,12 +209,76 @@ func (b *baseBalancer) regeneratePicker(err error) {
 			readySCs[sc] = base.SubConnInfo{Address: addr}
 		}
 	}
-	b.v2Picker = b.v2PickerBuilder.Build(
-		PickerBuildInfo{
-			ReadySCs:   readySCs,
-			Attributes: b.attributes,
-		},
-	)
+
+	if b.outliers == nil {
+		b.v2Picker = b.v2PickerBuilder.Build(
+			PickerBuildInfo{
+				ReadySCs:   readySCs,
+				Attributes: b.attributes,
+			},
+		)
+		return
+	}
+
+	// 摘除异常节点, 若全部被摘除则保留所有节点
+	var now = time.Now()
+	var stats = make(map[balancer.SubConn]*outlierStats, len(readySCs))
+	var healthySCs = make(map[balancer.SubConn]base.SubConnInfo, len(readySCs))
+	for sc, info := range readySCs {
+		if s, ok := b.outliers[sc]; ok {
+			stats[sc] = s
+			if s.ejected(now) {
+				continue
+			}
+		}
+		healthySCs[sc] = info
+	}
+	if len(healthySCs) == 0 {
+		healthySCs = readySCs
+	}
+	b.v2Picker = &outlierPicker{
+		V2Picker: b.v2PickerBuilder.Build(
+			PickerBuildInfo{
+				ReadySCs:   healthySCs,
+				Attributes: b.attributes,
+			},
+		),
+		stats:   stats,
+		config:  b.detector.config,
+		trigger: b.triggerOutlierDetection,
+	}
+}
+
+func (b *baseBalancer) triggerOutlierDetection() {
+	select {
+	case b.triggered <- struct{}{}:
+	default:
+	}
+}
+
+func (b *baseBalancer) watchOutliers() {
+	ticker := time.NewTicker(b.detector.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+			b.detectOutliers(true)
+		case <-b.triggered:
+			b.detectOutliers(false)
+		}
+	}
+}
+
+func (b *baseBalancer) detectOutliers(interval bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.detector.detect(b.outliers, interval) || b.state == connectivity.TransientFailure {
+		return
+	}
+	b.regeneratePicker(nil)
+	b.cc.UpdateState(balancer.State{ConnectivityState: b.state, Picker: b.v2Picker})
 }
 
 // HandleSubConnStateChange ...
@@ -180,6 +292,8 @@ func (b *baseBalancer) UpdateSubConnState(sc balancer.SubConn, state balancer.Su
 	if grpclog.V(2) {
 		grpclog.Infof("base.baseBalancer: handle SubConn state change: %p, %v", sc, s)
 	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
 	oldS, ok := b.scStates[sc]
 	if !ok {
 		if grpclog.V(2) {
@@ -213,9 +327,11 @@ func (b *baseBalancer) UpdateSubConnState(sc balancer.SubConn, state balancer.Su
 	b.cc.UpdateState(balancer.State{ConnectivityState: b.state, Picker: b.v2Picker})
 }
 
-// Close is a nop because base balancer doesn't have internal state to clean up,
-// and it doesn't need to call RemoveSubConn for the SubConns.
+// Close stops outlier detection, it doesn't need to call RemoveSubConn for the SubConns.
 func (b *baseBalancer) Close() {
+	if b.done != nil {
+		close(b.done)
+	}
 }
 
 // NewErrPickerV2 returns a V2Picker that always returns err on Pick().
@@ -0,0 +1,126 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chash
+
+import (
+	"context"
+	"sync/atomic"
+
+	xbalancer "github.com/douyu/jupiter/pkg/client/grpc/balancer"
+	"github.com/douyu/jupiter/pkg/constant"
+	"github.com/douyu/jupiter/pkg/imeta"
+	"github.com/douyu/jupiter/pkg/server"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// Name is the name of consistent hash balancer.
+	Name = "consistent_hash"
+	// DefaultHashKey metadata key of hash key
+	DefaultHashKey = "x-hash-key"
+	// DefaultReplicas virtual nodes of node with default weight
+	DefaultReplicas = 160
+)
+
+func init() {
+	Register(Name, DefaultHashKey)
+}
+
+// Register registers consistent hash balancer of name, which picks subconn by value of
+// hashKey in outgoing metadata or imeta of request
+func Register(name string, hashKey string) {
+	balancer.Register(
+		xbalancer.NewBalancerBuilderV2(name, &chashPickerBuilder{hashKey: hashKey}, base.Config{HealthCheck: true}),
+	)
+}
+
+// WithHashKey returns context with hash key of balancer registered with DefaultHashKey,
+// requests of the same key are routed to the same backend
+func WithHashKey(ctx context.Context, key string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, DefaultHashKey, key)
+}
+
+type chashPickerBuilder struct {
+	hashKey string
+}
+
+// Build ...
+func (b *chashPickerBuilder) Build(info xbalancer.PickerBuildInfo) balancer.V2Picker {
+	grpclog.Infof("chashPickerBuilder: newPicker called with readySCs: %v", info.ReadySCs)
+	if len(info.ReadySCs) == 0 {
+		return xbalancer.NewErrPickerV2(balancer.ErrNoSubConnAvailable)
+	}
+
+	var ring = NewRing(DefaultReplicas)
+	var subConns = make([]balancer.SubConn, 0, len(info.ReadySCs))
+	for sc, scInfo := range info.ReadySCs {
+		var weight float64 = xbalancer.DefaultWeight
+		if scInfo.Address.Attributes != nil {
+			if serviceInfo, ok := scInfo.Address.Attributes.Value(constant.KeyServiceInfo).(server.ServiceInfo); ok && serviceInfo.Weight > 0 {
+				weight = serviceInfo.Weight
+			}
+		}
+		// 以地址作为节点标识, 节点上下线时只影响该节点的key
+		ring.Add(scInfo.Address.Addr, sc, weight/xbalancer.DefaultWeight)
+		subConns = append(subConns, sc)
+	}
+
+	return &chashPicker{
+		hashKey:  b.hashKey,
+		ring:     ring,
+		subConns: subConns,
+	}
+}
+
+type chashPicker struct {
+	hashKey  string
+	ring     *Ring
+	subConns []balancer.SubConn
+	next     uint32
+}
+
+// Pick ...
+func (p *chashPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	if key := p.key(info.Ctx); key != "" {
+		if sc, ok := p.ring.Get(key).(balancer.SubConn); ok {
+			return balancer.PickResult{SubConn: sc}, nil
+		}
+	}
+
+	// 未携带key的请求轮询
+	next := atomic.AddUint32(&p.next, 1)
+	return balancer.PickResult{SubConn: p.subConns[int(next)%len(p.subConns)]}, nil
+}
+
+func (p *chashPicker) key(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	if md, ok := metadata.FromOutgoingContext(ctx); ok {
+		if vals := md.Get(p.hashKey); len(vals) > 0 {
+			return vals[0]
+		}
+	}
+	if md, ok := imeta.FromContext(ctx); ok {
+		if vals := md.Get(p.hashKey); len(vals) > 0 {
+			return vals[0]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,111 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chash
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	xbalancer "github.com/douyu/jupiter/pkg/client/grpc/balancer"
+	"github.com/douyu/jupiter/pkg/constant"
+	"github.com/douyu/jupiter/pkg/imeta"
+	"github.com/douyu/jupiter/pkg/server"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+)
+
+type testSubConn struct {
+	addr string
+}
+
+func (sc *testSubConn) UpdateAddresses([]resolver.Address) {}
+
+func (sc *testSubConn) Connect() {}
+
+func TestRingRemap(t *testing.T) {
+	before, after := NewRing(DefaultReplicas), NewRing(DefaultReplicas)
+	for i := 0; i < 5; i++ {
+		addr := fmt.Sprintf("10.0.0.%d:9090", i)
+		before.Add(addr, addr, 1)
+		after.Add(addr, addr, 1)
+	}
+	after.Add("10.0.0.5:9090", "10.0.0.5:9090", 1)
+
+	var moved, total = 0, 10000
+	for i := 0; i < total; i++ {
+		key := fmt.Sprintf("room-%d", i)
+		if b, a := before.Get(key), after.Get(key); b != a {
+			// keys only move to the new node
+			assert.Equal(t, "10.0.0.5:9090", a)
+			moved++
+		}
+	}
+	// about 1/6 of keys are remapped
+	assert.InDelta(t, total/6, moved, float64(total)/20)
+}
+
+func TestRingWeight(t *testing.T) {
+	ring := NewRing(DefaultReplicas)
+	ring.Add("a", "a", 1)
+	ring.Add("b", "b", 3)
+
+	countMap := make(map[interface{}]int)
+	for i := 0; i < 10000; i++ {
+		countMap[ring.Get(fmt.Sprintf("user-%d", i))]++
+	}
+	assert.InDelta(t, 7500, countMap["b"], 500)
+}
+
+func TestChashPicker(t *testing.T) {
+	info := xbalancer.PickerBuildInfo{ReadySCs: make(map[balancer.SubConn]base.SubConnInfo)}
+	for i := 0; i < 3; i++ {
+		addr := fmt.Sprintf("10.0.0.%d:9090", i)
+		info.ReadySCs[&testSubConn{addr: addr}] = base.SubConnInfo{
+			Address: resolver.Address{
+				Addr:       addr,
+				Attributes: attributes.New(constant.KeyServiceInfo, server.ServiceInfo{Address: addr, Weight: 100}),
+			},
+		}
+	}
+	picker := (&chashPickerBuilder{hashKey: DefaultHashKey}).Build(info)
+
+	pick := func(ctx context.Context) string {
+		res, err := picker.Pick(balancer.PickInfo{Ctx: ctx})
+		assert.Nil(t, err)
+		return res.SubConn.(*testSubConn).addr
+	}
+
+	ctx := WithHashKey(context.Background(), "room-1")
+	addr := pick(ctx)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, addr, pick(ctx))
+	}
+
+	// key in imeta
+	mctx := imeta.WithContext(context.Background(), imeta.Pairs(DefaultHashKey, "room-1"))
+	assert.Equal(t, addr, pick(mctx))
+
+	// requests without key are round robin
+	countMap := make(map[string]int)
+	for i := 0; i < 30; i++ {
+		countMap[pick(context.Background())]++
+	}
+	assert.Equal(t, 3, len(countMap))
+}
@@ -0,0 +1,87 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chash
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// Ring consistent hash ring, nodes are placed on ring by virtual nodes
+// in proportion to their weights, so only keys of joined or left nodes are remapped
+type Ring struct {
+	replicas int
+	hashes   []uint64
+	nodes    map[uint64]interface{}
+}
+
+// NewRing returns ring with replicas virtual nodes per unit weight
+func NewRing(replicas int) *Ring {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	return &Ring{
+		replicas: replicas,
+		nodes:    make(map[uint64]interface{}),
+	}
+}
+
+// Add adds node identified by id, weight is relative to 1
+func (r *Ring) Add(id string, node interface{}, weight float64) {
+	count := int(float64(r.replicas) * weight)
+	if count < 1 {
+		count = 1
+	}
+	for i := 0; i < count; i++ {
+		h := hash(id + "#" + strconv.Itoa(i))
+		if _, ok := r.nodes[h]; ok {
+			continue
+		}
+		r.nodes[h] = node
+		r.hashes = append(r.hashes, h)
+	}
+	sort.Slice(r.hashes, func(i, j int) bool {
+		return r.hashes[i] < r.hashes[j]
+	})
+}
+
+// Get returns node of key, nil if ring is empty
+func (r *Ring) Get(key string) interface{} {
+	if len(r.hashes) == 0 {
+		return nil
+	}
+	h := hash(key)
+	idx := sort.Search(len(r.hashes), func(i int) bool {
+		return r.hashes[i] >= h
+	})
+	if idx == len(r.hashes) {
+		idx = 0
+	}
+	return r.nodes[r.hashes[idx]]
+}
+
+func hash(key string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	// fnv is weak on short similar keys, mix bits with murmur3 finalizer
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,293 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balancer
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/metric"
+	"github.com/douyu/jupiter/pkg/xlog"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// OutlierConsecutiveErrors subconn ejected for consecutive errors
+	OutlierConsecutiveErrors = "consecutive_errors"
+	// OutlierSuccessRate subconn ejected for low success rate
+	OutlierSuccessRate = "success_rate"
+	// OutlierLatency subconn ejected for high latency
+	OutlierLatency = "latency"
+)
+
+// OutlierConfig outlier detection config, outlier subconns are ejected from picker temporarily
+type OutlierConfig struct {
+	// Enable enable outlier detection, false by default, build balancer with
+	// NewBalancerBuilderV2WithOutlier to opt in
+	Enable bool
+	// Interval interval of success rate and latency detection
+	Interval time.Duration
+	// ConsecutiveErrors subconn is ejected after ConsecutiveErrors errors in a row, disabled if 0
+	ConsecutiveErrors int
+	// MinHosts success rate and latency detection requires at least MinHosts subconns with enough requests
+	MinHosts int
+	// MinRequests subconns with less requests in interval are not checked by success rate and latency detection
+	MinRequests int
+	// SuccessRateStdevFactor subconn is ejected when success rate < mean - factor * stdev, disabled if 0
+	SuccessRateStdevFactor float64
+	// LatencyFactor subconn is ejected when average latency > factor * median of subconns, disabled if 0,
+	// median is used rather than stdev, which can't tell an outlier among a few subconns
+	LatencyFactor float64
+	// BaseEjectionTime ejection time doubles every time the subconn is ejected again, up to MaxEjectionTime
+	BaseEjectionTime time.Duration
+	MaxEjectionTime  time.Duration
+	// MaxEjectionPercent max percent of ejected subconns, at least one subconn can be ejected
+	// unless it is the only one
+	MaxEjectionPercent int
+}
+
+// DefaultOutlierConfig outlier detection is disabled, other fields are defaults once enabled
+func DefaultOutlierConfig() *OutlierConfig {
+	return &OutlierConfig{
+		Enable:                 false,
+		Interval:               10 * time.Second,
+		ConsecutiveErrors:      5,
+		MinHosts:               5,
+		MinRequests:            100,
+		SuccessRateStdevFactor: 1.9,
+		LatencyFactor:          5,
+		BaseEjectionTime:       30 * time.Second,
+		MaxEjectionTime:        300 * time.Second,
+		MaxEjectionPercent:     10,
+	}
+}
+
+type outlierStats struct {
+	addr string
+
+	consecutiveErrors int64
+
+	mu       sync.Mutex
+	requests int64
+	failures int64
+	latency  time.Duration
+
+	// fields below are guarded by baseBalancer.mu
+	ejections    int
+	ejectedUntil time.Time
+}
+
+func (s *outlierStats) record(err error, latency time.Duration) int64 {
+	s.mu.Lock()
+	s.requests++
+	s.latency += latency
+	if isOutlierFailure(err) {
+		s.failures++
+	}
+	s.mu.Unlock()
+
+	if isOutlierFailure(err) {
+		return atomic.AddInt64(&s.consecutiveErrors, 1)
+	}
+	atomic.StoreInt64(&s.consecutiveErrors, 0)
+	return 0
+}
+
+// reset returns and resets stats of interval
+func (s *outlierStats) reset() (requests, failures int64, latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requests, failures, latency = s.requests, s.failures, s.latency
+	s.requests, s.failures, s.latency = 0, 0, 0
+	return
+}
+
+func (s *outlierStats) ejected(now time.Time) bool {
+	return now.Before(s.ejectedUntil)
+}
+
+// isOutlierFailure returns whether err indicates the subconn is unhealthy, business errors are not failures
+func isOutlierFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Internal, codes.Aborted:
+		return true
+	}
+	return false
+}
+
+// outlierPicker records results of picked subconns for outlier detection
+type outlierPicker struct {
+	balancer.V2Picker
+	stats   map[balancer.SubConn]*outlierStats
+	config  *OutlierConfig
+	trigger func()
+}
+
+// Pick ...
+func (p *outlierPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	res, err := p.V2Picker.Pick(info)
+	if err != nil {
+		return res, err
+	}
+	stats, ok := p.stats[res.SubConn]
+	if !ok {
+		return res, err
+	}
+	start := time.Now()
+	done := res.Done
+	res.Done = func(di balancer.DoneInfo) {
+		if done != nil {
+			done(di)
+		}
+		n := stats.record(di.Err, time.Since(start))
+		if p.config.ConsecutiveErrors > 0 && n == int64(p.config.ConsecutiveErrors) {
+			p.trigger()
+		}
+	}
+	return res, nil
+}
+
+// outlierDetector detects outliers of subconns, all methods must be called with baseBalancer.mu held
+type outlierDetector struct {
+	target string
+	config *OutlierConfig
+	logger *xlog.Logger
+}
+
+// detect ejects outliers and recovers expired ones, returns whether ejected subconns changed,
+// success rate and latency are only checked on interval
+func (d *outlierDetector) detect(stats map[balancer.SubConn]*outlierStats, interval bool) bool {
+	var now = time.Now()
+	var changed bool
+	var ejected int
+	for _, s := range stats {
+		if s.ejected(now) {
+			ejected++
+		} else if !s.ejectedUntil.IsZero() {
+			// 摘除时间到期, 恢复节点
+			s.ejectedUntil = time.Time{}
+			atomic.StoreInt64(&s.consecutiveErrors, 0)
+			changed = true
+			d.logger.Info("outlier subconn recovered", xlog.FieldName(d.target), xlog.FieldAddr(s.addr))
+		}
+	}
+
+	maxEjected := len(stats) * d.config.MaxEjectionPercent / 100
+	if maxEjected < 1 && len(stats) > 1 {
+		maxEjected = 1
+	}
+	eject := func(s *outlierStats, reason string) {
+		if ejected >= maxEjected || s.ejected(now) {
+			return
+		}
+		ejected++
+		s.ejections++
+		ejectionTime := d.config.BaseEjectionTime * time.Duration(math.Pow(2, float64(s.ejections-1)))
+		if ejectionTime > d.config.MaxEjectionTime || ejectionTime <= 0 {
+			ejectionTime = d.config.MaxEjectionTime
+		}
+		s.ejectedUntil = now.Add(ejectionTime)
+		changed = true
+		metric.ClientOutlierEjectionCounter.Inc(d.target, s.addr, reason)
+		d.logger.Warn("outlier subconn ejected", xlog.FieldName(d.target), xlog.FieldAddr(s.addr), xlog.String("reason", reason), xlog.Duration("ejection", ejectionTime))
+	}
+
+	if d.config.ConsecutiveErrors > 0 {
+		for _, s := range stats {
+			if atomic.LoadInt64(&s.consecutiveErrors) >= int64(d.config.ConsecutiveErrors) {
+				eject(s, OutlierConsecutiveErrors)
+			}
+		}
+	}
+	if !interval {
+		return changed
+	}
+
+	type sample struct {
+		stats   *outlierStats
+		success float64
+		latency float64
+	}
+	var samples []sample
+	for _, s := range stats {
+		requests, failures, latency := s.reset()
+		if s.ejected(now) || requests < int64(d.config.MinRequests) || requests == 0 {
+			continue
+		}
+		// 摘除后恢复的节点重新表现正常时, 逐步降低其摘除次数
+		if failures == 0 && s.ejections > 0 {
+			s.ejections--
+		}
+		samples = append(samples, sample{
+			stats:   s,
+			success: float64(requests-failures) / float64(requests),
+			latency: float64(latency) / float64(requests),
+		})
+	}
+	if len(samples) < d.config.MinHosts {
+		return changed
+	}
+
+	if d.config.SuccessRateStdevFactor > 0 {
+		var values = make([]float64, 0, len(samples))
+		for _, s := range samples {
+			values = append(values, s.success)
+		}
+		mean, stdev := meanStdev(values)
+		for _, s := range samples {
+			if s.success < mean-d.config.SuccessRateStdevFactor*stdev {
+				eject(s.stats, OutlierSuccessRate)
+			}
+		}
+	}
+	if d.config.LatencyFactor > 0 {
+		var values = make([]float64, 0, len(samples))
+		for _, s := range samples {
+			values = append(values, s.latency)
+		}
+		sort.Float64s(values)
+		median := values[len(values)/2]
+		if len(values)%2 == 0 {
+			median = (values[len(values)/2-1] + values[len(values)/2]) / 2
+		}
+		for _, s := range samples {
+			if s.latency > median*d.config.LatencyFactor {
+				eject(s.stats, OutlierLatency)
+			}
+		}
+	}
+	return changed
+}
+
+func meanStdev(values []float64) (mean, stdev float64) {
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	for _, v := range values {
+		stdev += (v - mean) * (v - mean)
+	}
+	stdev = math.Sqrt(stdev / float64(len(values)))
+	return
+}
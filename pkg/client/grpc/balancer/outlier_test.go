@@ -0,0 +1,156 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balancer
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/xlog"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestOutliers(n int) (map[balancer.SubConn]*outlierStats, []*outlierStats) {
+	var stats = make(map[balancer.SubConn]*outlierStats)
+	var list []*outlierStats
+	for i := 0; i < n; i++ {
+		s := &outlierStats{addr: fmt.Sprintf("127.0.0.1:%d", 9000+i)}
+		stats[&testSubConn{addr: s.addr}] = s
+		list = append(list, s)
+	}
+	return stats, list
+}
+
+func newTestDetector() *outlierDetector {
+	config := DefaultOutlierConfig()
+	return &outlierDetector{target: "test", config: config, logger: xlog.JupiterLogger}
+}
+
+func TestOutlierDetector_ConsecutiveErrors(t *testing.T) {
+	d := newTestDetector()
+	stats, list := newTestOutliers(3)
+	unavailable := status.Error(codes.Unavailable, "unavailable")
+	for i := 0; i < 5; i++ {
+		list[0].record(unavailable, time.Millisecond)
+		list[1].record(unavailable, time.Millisecond)
+		// business errors are not failures
+		list[2].record(status.Error(codes.Code(10001), "biz"), time.Millisecond)
+	}
+
+	assert.True(t, d.detect(stats, false))
+	// only one subconn can be ejected by max ejection percent
+	assert.Equal(t, 1, countEjected(list))
+	assert.False(t, list[2].ejected(time.Now()))
+
+	// ejection time doubles when ejected again
+	var ejected *outlierStats
+	for _, s := range list {
+		if s.ejected(time.Now()) {
+			ejected = s
+		}
+	}
+	assert.InDelta(t, float64(30*time.Second), float64(time.Until(ejected.ejectedUntil)), float64(time.Second))
+	// the other failing subconn recovers
+	for _, s := range list {
+		if s != ejected {
+			s.record(nil, time.Millisecond)
+		}
+	}
+	ejected.ejectedUntil = time.Now().Add(-time.Second)
+	assert.True(t, d.detect(stats, false))
+	assert.Equal(t, int64(0), ejected.consecutiveErrors)
+	for i := 0; i < 5; i++ {
+		ejected.record(unavailable, time.Millisecond)
+	}
+	assert.True(t, d.detect(stats, false))
+	assert.True(t, ejected.ejected(time.Now()))
+	assert.InDelta(t, float64(60*time.Second), float64(time.Until(ejected.ejectedUntil)), float64(time.Second))
+}
+
+func TestOutlierDetector_Interval(t *testing.T) {
+	d := newTestDetector()
+	stats, list := newTestOutliers(6)
+	unavailable := status.Error(codes.Unavailable, "unavailable")
+	for _, s := range list {
+		for i := 0; i < 100; i++ {
+			var err error
+			// success rate of list[0] is 50%
+			if s == list[0] && i%2 == 0 {
+				err = unavailable
+			}
+			latency := time.Millisecond
+			if s == list[1] {
+				latency = 50 * time.Millisecond
+			}
+			s.record(err, latency)
+		}
+	}
+
+	d.config.MaxEjectionPercent = 50
+	assert.True(t, d.detect(stats, true))
+	assert.True(t, list[0].ejected(time.Now()))
+	assert.True(t, list[1].ejected(time.Now()))
+	assert.Equal(t, 2, countEjected(list))
+
+	// recovered after ejection time
+	list[0].ejectedUntil = time.Now().Add(-time.Second)
+	assert.True(t, d.detect(stats, false))
+	assert.False(t, list[0].ejected(time.Now()))
+}
+
+func TestOutlierPicker(t *testing.T) {
+	stats, list := newTestOutliers(1)
+	var sc balancer.SubConn
+	for k := range stats {
+		sc = k
+	}
+	var triggered int
+	picker := &outlierPicker{
+		V2Picker: &fixedPicker{sc: sc},
+		stats:    stats,
+		config:   DefaultOutlierConfig(),
+		trigger:  func() { triggered++ },
+	}
+	for i := 0; i < 6; i++ {
+		res, err := picker.Pick(balancer.PickInfo{})
+		assert.Nil(t, err)
+		res.Done(balancer.DoneInfo{Err: status.Error(codes.Unavailable, "unavailable")})
+	}
+	assert.Equal(t, 1, triggered)
+	assert.Equal(t, int64(6), list[0].consecutiveErrors)
+}
+
+type fixedPicker struct {
+	sc balancer.SubConn
+}
+
+func (p *fixedPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	return balancer.PickResult{SubConn: p.sc}, nil
+}
+
+func countEjected(list []*outlierStats) int {
+	var n int
+	for _, s := range list {
+		if s.ejected(time.Now()) {
+			n++
+		}
+	}
+	return n
+}
@@ -15,16 +15,16 @@
 package p2c
 
 import (
-	"context"
-
+	xbalancer "github.com/douyu/jupiter/pkg/client/grpc/balancer"
+	"github.com/douyu/jupiter/pkg/constant"
+	"github.com/douyu/jupiter/pkg/server"
 	"github.com/douyu/jupiter/pkg/util/xp2c"
-	"github.com/douyu/jupiter/pkg/util/xp2c/leastloaded"
+	"github.com/douyu/jupiter/pkg/util/xp2c/peakewma"
 
 	"google.golang.org/grpc/balancer"
 	"google.golang.org/grpc/balancer/base"
 	"google.golang.org/grpc/grpclog"
 	_ "google.golang.org/grpc/health"
-	"google.golang.org/grpc/resolver"
 )
 
 // Name is the name of p2c with least loaded balancer.
@@ -34,7 +34,7 @@ const (
 
 // newBuilder creates a new balance builder.
 func newBuilder() balancer.Builder {
-	return base.NewBalancerBuilderWithConfig(Name, &p2cPickerBuilder{}, base.Config{HealthCheck: true})
+	return xbalancer.NewBalancerBuilderV2(Name, &p2cPickerBuilder{}, base.Config{HealthCheck: true})
 }
 
 func init() {
@@ -43,16 +43,27 @@ func init() {
 
 type p2cPickerBuilder struct{}
 
-func (*p2cPickerBuilder) Build(readySCs map[resolver.Address]balancer.SubConn) balancer.Picker {
-	grpclog.Infof("p2cPickerBuilder: newPicker called with readySCs: %v", readySCs)
-	if len(readySCs) == 0 {
-		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+// Build ...
+func (*p2cPickerBuilder) Build(info xbalancer.PickerBuildInfo) balancer.V2Picker {
+	grpclog.Infof("p2cPickerBuilder: newPicker called with readySCs: %v", info.ReadySCs)
+	if len(info.ReadySCs) == 0 {
+		return xbalancer.NewErrPickerV2(balancer.ErrNoSubConnAvailable)
 	}
 
-	var p2c = leastloaded.New()
-
-	for _, sc := range readySCs {
-		p2c.Add(sc)
+	var p2c = peakewma.New()
+
+	for sc, scInfo := range info.ReadySCs {
+		// 节点权重, 未配置时各节点权重相同
+		var weight float64
+		if scInfo.Address.Attributes != nil {
+			if serviceInfo, ok := scInfo.Address.Attributes.Value(constant.KeyServiceInfo).(server.ServiceInfo); ok {
+				weight = serviceInfo.Weight
+			}
+		}
+		if weight <= 0 {
+			weight = xbalancer.DefaultWeight
+		}
+		p2c.AddWithWeight(sc, weight)
 	}
 
 	rp := &p2cPicker{
@@ -67,12 +78,11 @@ type p2cPicker struct {
 }
 
 // Pick ...
-func (p *p2cPicker) Pick(ctx context.Context, opts balancer.PickOptions) (balancer.SubConn, func(balancer.DoneInfo), error) {
-
+func (p *p2cPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
 	item, done := p.p2c.Next()
 	if item == nil {
-		return nil, nil, balancer.ErrNoSubConnAvailable
+		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
 	}
 
-	return item.(balancer.SubConn), done, nil
+	return balancer.PickResult{SubConn: item.(balancer.SubConn), Done: done}, nil
 }
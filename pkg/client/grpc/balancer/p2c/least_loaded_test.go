@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -26,6 +27,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer/roundrobin"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/resolver"
@@ -36,10 +38,13 @@ import (
 
 type testServer struct {
 	testpb.TestServiceServer
+	delay time.Duration
 }
 
 func (s *testServer) EmptyCall(ctx context.Context, in *testpb.Empty) (*testpb.Empty, error) {
-	// time.Sleep(1 * time.Millisecond)
+	if s.delay > 0 {
+		time.Sleep(s.delay)
+	}
 	return &testpb.Empty{}, nil
 }
 
@@ -59,6 +64,11 @@ func (t *test) cleanup() {
 }
 
 func startTestServers(count int) (_ *test, err error) {
+	return startDelayedTestServers(make([]time.Duration, count)...)
+}
+
+// startDelayedTestServers starts a server for each delay, which sleeps delay on every call
+func startDelayedTestServers(delays ...time.Duration) (_ *test, err error) {
 	t := &test{}
 
 	defer func() {
@@ -66,14 +76,14 @@ func startTestServers(count int) (_ *test, err error) {
 			t.cleanup()
 		}
 	}()
-	for i := 0; i < count; i++ {
+	for _, delay := range delays {
 		lis, err := net.Listen("tcp", "localhost:0")
 		if err != nil {
 			return nil, fmt.Errorf("failed to listen %v", err)
 		}
 
 		s := grpc.NewServer()
-		testpb.RegisterTestServiceServer(s, &testServer{})
+		testpb.RegisterTestServiceServer(s, &testServer{delay: delay})
 		t.servers = append(t.servers, s)
 		t.addresses = append(t.addresses, lis.Addr().String())
 
@@ -300,12 +310,82 @@ func TestBackendsRandom(t *testing.T) {
 	total := 0
 	for addr, count := range countMap {
 		total = total + count
-		assert.True(t, count > 900, "req count less than 900", addr, countMap)
+		assert.True(t, count > 500, "req count less than half of even share", addr, countMap)
 	}
 
 	assert.Equal(t, total, 1000*backendCount)
 }
 
+func TestNewBackendNotFlooded(t *testing.T) {
+	r, cleanup := manual.GenerateAndRegisterManualResolver()
+	defer cleanup()
+
+	backendCount := 4
+	test, err := startDelayedTestServers(time.Millisecond, time.Millisecond, time.Millisecond, time.Millisecond)
+	if err != nil {
+		t.Fatalf("failed to start servers: %v", err)
+	}
+	defer test.cleanup()
+
+	cc, err := grpc.Dial(r.Scheme()+":///test.server", grpc.WithInsecure(), grpc.WithBalancerName(p2c.Name))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer cc.Close()
+	testc := testpb.NewTestServiceClient(cc)
+
+	var resolvedAddrs []resolver.Address
+	for i := 0; i < backendCount-1; i++ {
+		resolvedAddrs = append(resolvedAddrs, resolver.Address{Addr: test.addresses[i]})
+	}
+	r.UpdateState(resolver.State{Addresses: resolvedAddrs})
+	// warm up existing backends
+	for i := 0; i < 100; i++ {
+		if _, err := testc.EmptyCall(context.Background(), &testpb.Empty{}, grpc.WaitForReady(true)); err != nil {
+			t.Fatalf("EmptyCall() = _, %v, want _, <nil>", err)
+		}
+	}
+
+	newAddr := test.addresses[backendCount-1]
+	r.UpdateState(resolver.State{Addresses: append(resolvedAddrs, resolver.Address{Addr: newAddr})})
+	var p peer.Peer
+	for i := 0; i < 1000; i++ {
+		if _, err := testc.EmptyCall(context.Background(), &testpb.Empty{}, grpc.Peer(&p)); err != nil {
+			t.Fatalf("EmptyCall() = _, %v, want _, <nil>", err)
+		}
+		if p.Addr.String() == newAddr {
+			break
+		}
+	}
+	if p.Addr.String() != newAddr {
+		t.Fatalf("new backend %v is never picked", newAddr)
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	countMap := make(map[string]int)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				var p peer.Peer
+				if _, err := testc.EmptyCall(context.Background(), &testpb.Empty{}, grpc.Peer(&p)); err != nil {
+					t.Errorf("EmptyCall() = _, %v, want _, <nil>", err)
+					return
+				}
+				mu.Lock()
+				countMap[p.Addr.String()]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// 新节点尚无延迟采样, 不应承接大部分流量
+	assert.True(t, countMap[newAddr] < 20*20/2, "new backend picked too often", countMap)
+}
+
 func TestCloseWithPendingRPC(t *testing.T) {
 
 	r, cleanup := manual.GenerateAndRegisterManualResolver()
@@ -460,3 +540,97 @@ func TestAllServersDown(t *testing.T) {
 	}
 	t.Fatalf("Failfast RPCs didn't fail with Unavailable after all servers are stopped")
 }
+
+func TestSlowBackendAvoided(t *testing.T) {
+	r, cleanup := manual.GenerateAndRegisterManualResolver()
+	defer cleanup()
+
+	test, err := startDelayedTestServers(0, 0, 20*time.Millisecond)
+	if err != nil {
+		t.Fatalf("failed to start servers: %v", err)
+	}
+	defer test.cleanup()
+
+	cc, err := grpc.Dial(r.Scheme()+":///test.server", grpc.WithInsecure(), grpc.WithBalancerName(p2c.Name))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer cc.Close()
+	testc := testpb.NewTestServiceClient(cc)
+
+	var resolvedAddrs []resolver.Address
+	for _, addr := range test.addresses {
+		resolvedAddrs = append(resolvedAddrs, resolver.Address{Addr: addr})
+	}
+	r.UpdateState(resolver.State{Addresses: resolvedAddrs})
+
+	var p peer.Peer
+	countMap := make(map[string]int)
+	for i := 0; i < 300; i++ {
+		if _, err := testc.EmptyCall(context.Background(), &testpb.Empty{}, grpc.Peer(&p)); err != nil {
+			t.Fatalf("EmptyCall() = _, %v, want _, <nil>", err)
+		}
+		countMap[p.Addr.String()]++
+	}
+
+	assert.True(t, countMap[test.addresses[2]] < 30, "slow backend picked too often", countMap)
+}
+
+// BenchmarkSkewedBackends compares p2c with round robin when one of backends is slow
+func BenchmarkSkewedBackends(b *testing.B) {
+	for _, name := range []string{p2c.Name, roundrobin.Name} {
+		b.Run(name, func(b *testing.B) {
+			r, cleanup := manual.GenerateAndRegisterManualResolver()
+			defer cleanup()
+
+			test, err := startDelayedTestServers(time.Millisecond, time.Millisecond, 10*time.Millisecond)
+			if err != nil {
+				b.Fatalf("failed to start servers: %v", err)
+			}
+			defer test.cleanup()
+
+			cc, err := grpc.Dial(r.Scheme()+":///test.server", grpc.WithInsecure(), grpc.WithBalancerName(name))
+			if err != nil {
+				b.Fatalf("failed to dial: %v", err)
+			}
+			defer cc.Close()
+			testc := testpb.NewTestServiceClient(cc)
+
+			var resolvedAddrs []resolver.Address
+			for _, addr := range test.addresses {
+				resolvedAddrs = append(resolvedAddrs, resolver.Address{Addr: addr})
+			}
+			r.UpdateState(resolver.State{Addresses: resolvedAddrs})
+			// wait for all backends ready, slow backend may only be picked by force pick of p2c
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			for _, addr := range test.addresses {
+				for {
+					var p peer.Peer
+					if _, err := testc.EmptyCall(ctx, &testpb.Empty{}, grpc.WaitForReady(true), grpc.Peer(&p)); err != nil {
+						b.Fatalf("EmptyCall() = _, %v, want _, <nil>", err)
+					}
+					if p.Addr.String() == addr {
+						break
+					}
+				}
+			}
+
+			var slow int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				var p peer.Peer
+				for pb.Next() {
+					if _, err := testc.EmptyCall(context.Background(), &testpb.Empty{}, grpc.Peer(&p)); err != nil {
+						b.Errorf("EmptyCall() = _, %v, want _, <nil>", err)
+						return
+					}
+					if p.Addr.String() == test.addresses[2] {
+						atomic.AddInt64(&slow, 1)
+					}
+				}
+			})
+			b.ReportMetric(float64(slow)/float64(b.N), "slow/op")
+		})
+	}
+}
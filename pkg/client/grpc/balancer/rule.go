@@ -0,0 +1,147 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balancer
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+	"strings"
+
+	"github.com/douyu/jupiter/pkg"
+	"github.com/douyu/jupiter/pkg/imeta"
+	"github.com/douyu/jupiter/pkg/registry"
+	"google.golang.org/grpc/metadata"
+)
+
+// RouteAnyURI uri of routes applied to all methods
+const RouteAnyURI = "*"
+
+// sortRoutes sorts routes by priority, conditional routes are matched before
+// unconditional ones of the same priority
+func sortRoutes(routes []*swrRoute) {
+	sort.SliceStable(routes, func(i, j int) bool {
+		if routes[i].priority != routes[j].priority {
+			return routes[i].priority > routes[j].priority
+		}
+		if ei, ej := routes[i].match.Empty(), routes[j].match.Empty(); ei != ej {
+			return ej
+		}
+		return routes[i].key < routes[j].key
+	})
+}
+
+// matchRoute returns whether request of ctx satisfies all conditions of match
+func matchRoute(ctx context.Context, match registry.RouteMatch) bool {
+	if match.Empty() {
+		return true
+	}
+	for key, val := range match.Headers {
+		if metadataValue(ctx, strings.ToLower(key)) != val {
+			return false
+		}
+	}
+
+	aid := metadataValue(ctx, "aid")
+	if aid == "" {
+		aid = pkg.AppID()
+	}
+	if len(match.AIDs) > 0 && !contains(match.AIDs, aid) {
+		return false
+	}
+	if match.Percent > 0 && match.Percent < 100 {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(aid))
+		if int(h.Sum32()%100) >= match.Percent {
+			return false
+		}
+	}
+	return true
+}
+
+// metadataValue returns value of key in outgoing metadata, or in imeta
+func metadataValue(ctx context.Context, key string) string {
+	if ctx == nil {
+		return ""
+	}
+	if md, ok := metadata.FromOutgoingContext(ctx); ok {
+		if vals := md.Get(key); len(vals) > 0 {
+			return vals[0]
+		}
+	}
+	if md, ok := imeta.FromContext(ctx); ok {
+		if vals := md[key]; len(vals) > 0 {
+			return vals[0]
+		}
+	}
+	return ""
+}
+
+// applyProviderConfigs removes disabled nodes, and overrides attributes of nodes,
+// disabling is ignored if all nodes would be disabled
+func applyProviderConfigs(nodes []swrNode, configs map[string]registry.ProviderConfig) []swrNode {
+	if len(configs) == 0 {
+		return nodes
+	}
+	var byHost = make(map[string]registry.ProviderConfig, len(configs))
+	for _, config := range configs {
+		byHost[config.Host] = config
+	}
+
+	var enabled = make([]swrNode, 0, len(nodes))
+	for i := range nodes {
+		node := &nodes[i]
+		config, ok := byHost[node.addr]
+		if !ok {
+			enabled = append(enabled, *node)
+			continue
+		}
+		if config.Region != "" {
+			node.info.Region = config.Region
+		}
+		if config.Zone != "" {
+			node.info.Zone = config.Zone
+		}
+		if config.Deployment != "" {
+			node.info.Deployment = config.Deployment
+		}
+		if len(config.Metadata) > 0 {
+			metadata := make(map[string]string, len(node.info.Metadata)+len(config.Metadata))
+			for key, val := range node.info.Metadata {
+				metadata[key] = val
+			}
+			for key, val := range config.Metadata {
+				metadata[key] = val
+			}
+			node.info.Metadata = metadata
+		}
+		if config.Enabled() {
+			enabled = append(enabled, *node)
+		}
+	}
+	if len(enabled) == 0 {
+		return nodes
+	}
+	return enabled
+}
+
+func contains(vals []string, val string) bool {
+	for _, v := range vals {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
@@ -16,9 +16,10 @@ package balancer
 
 import (
 	"errors"
-	"fmt"
+	"math"
 	"sync"
 
+	"github.com/douyu/jupiter/pkg"
 	"github.com/douyu/jupiter/pkg/constant"
 	"github.com/douyu/jupiter/pkg/registry"
 	"github.com/douyu/jupiter/pkg/server"
@@ -32,6 +33,9 @@ import (
 const (
 	// NameSmoothWeightRoundRobin ...
 	NameSmoothWeightRoundRobin = "swr"
+
+	// DefaultWeight weight of node which doesn't set weight
+	DefaultWeight = 100
 )
 
 // PickerBuildInfo ...
@@ -47,33 +51,97 @@ type PickerBuilder interface {
 	Build(info PickerBuildInfo) balancer.V2Picker
 }
 
+// SWRConfig config of swr balancer
+type SWRConfig struct {
+	// Zone zone of client, pkg.AppZone() if empty
+	Zone string
+	// Region region of client, pkg.AppRegion() if empty
+	Region string
+	// ZoneMinNodes same-zone nodes are preferred when ready same-zone nodes are no less than ZoneMinNodes,
+	// otherwise traffic spills over to same-region nodes, zone preference is disabled if 0
+	ZoneMinNodes int
+	// RegionMinNodes same-region nodes are preferred when ready same-region nodes are no less than RegionMinNodes,
+	// otherwise traffic spills over to all nodes, region preference is disabled if 0
+	RegionMinNodes int
+}
+
+// DefaultSWRConfig zone and region preference are disabled, so that traffic isn't pinned to few
+// same-zone nodes, register balancer of other name with RegisterSWR to enable them
+func DefaultSWRConfig() *SWRConfig {
+	return &SWRConfig{}
+}
+
 func init() {
+	RegisterSWR(NameSmoothWeightRoundRobin, DefaultSWRConfig())
+}
+
+// RegisterSWR registers swr balancer of name with config
+func RegisterSWR(name string, config *SWRConfig) {
 	balancer.Register(
-		NewBalancerBuilderV2(NameSmoothWeightRoundRobin, &swrPickerBuilder{}, base.Config{HealthCheck: true}),
+		NewBalancerBuilderV2(name, &swrPickerBuilder{config: config}, base.Config{HealthCheck: true}),
 	)
 }
 
-type swrPickerBuilder struct{}
+type swrPickerBuilder struct {
+	config *SWRConfig
+}
 
 // Build ...
 func (s swrPickerBuilder) Build(info PickerBuildInfo) balancer.V2Picker {
-	return newSWRPicker(info)
+	if len(info.ReadySCs) == 0 {
+		return NewErrPickerV2(balancer.ErrNoSubConnAvailable)
+	}
+	return newSWRPicker(s.config, info)
 }
 
 type swrPicker struct {
-	readySCs     map[balancer.SubConn]base.SubConnInfo
-	mu           sync.Mutex
-	next         int
-	buckets      *weighted.SW
-	routeBuckets map[string]*weighted.SW
-	*attributes.Attributes
+	mu      sync.Mutex
+	config  *SWRConfig
+	buckets *weightedGroups
+	// routes of URI, sorted by priority
+	routes map[string][]*swrRoute
+}
+
+type swrRoute struct {
+	match    registry.RouteMatch
+	priority int
+	key      string
+	buckets  *weightedGroups
 }
 
-func newSWRPicker(info PickerBuildInfo) *swrPicker {
+// weightedGroups balances traffic among groups by group weight,
+// and among subconns of the same group by node weight
+type weightedGroups struct {
+	groups *weighted.SW
+}
+
+func (wg *weightedGroups) add(subConns *weighted.SW, weight int) {
+	if len(subConns.All()) > 0 && weight > 0 {
+		wg.groups.Add(subConns, weight)
+	}
+}
+
+func (wg *weightedGroups) next() (balancer.SubConn, bool) {
+	subConns, ok := wg.groups.Next().(*weighted.SW)
+	if !ok {
+		return nil, false
+	}
+	sub, ok := subConns.Next().(balancer.SubConn)
+	return sub, ok
+}
+
+type swrNode struct {
+	subConn balancer.SubConn
+	addr    string
+	info    server.ServiceInfo
+	weight  int
+}
+
+func newSWRPicker(config *SWRConfig, info PickerBuildInfo) *swrPicker {
 	picker := &swrPicker{
-		buckets:      &weighted.SW{},
-		readySCs:     info.ReadySCs,
-		routeBuckets: map[string]*weighted.SW{},
+		config:  config,
+		buckets: &weightedGroups{groups: &weighted.SW{}},
+		routes:  map[string][]*swrRoute{},
 	}
 	picker.parseBuildInfo(info)
 	return picker
@@ -85,12 +153,12 @@ func (p *swrPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
 	defer p.mu.Unlock()
 
 	var buckets = p.buckets
-	if bs, ok := p.routeBuckets[info.FullMethodName]; ok {
-		// 根据URI进行流量分组路由
-		buckets = bs
+	if route := p.route(info); route != nil {
+		// 根据URI及匹配条件进行流量分组路由
+		buckets = route.buckets
 	}
 
-	sub, ok := buckets.Next().(balancer.SubConn)
+	sub, ok := buckets.next()
 	if ok {
 		return balancer.PickResult{SubConn: sub}, nil
 	}
@@ -98,73 +166,151 @@ func (p *swrPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
 	return balancer.PickResult{}, errors.New("pick failed")
 }
 
-func (p *swrPicker) parseBuildInfo(info PickerBuildInfo) {
-	var hostedSubConns = map[string]balancer.SubConn{}
-	var groupedSubConns = map[string][]balancer.SubConn{}
-
-	for subConn, info := range info.ReadySCs {
-		p.buckets.Add(subConn, 1)
-		if info.Address.Attributes != nil {
-			if serviceInfo, ok := info.Address.Attributes.Value(constant.KeyServiceInfo).(server.ServiceInfo); ok {
-				// todo(gorexlv): 分组
-				group := serviceInfo.Group
-				if _, ok := groupedSubConns[group]; !ok {
-					groupedSubConns[group] = make([]balancer.SubConn, 0)
-				}
-				groupedSubConns[group] = append(groupedSubConns[group], subConn)
+// route returns the first matched route of method, routes of URI "*" apply to all methods
+func (p *swrPicker) route(info balancer.PickInfo) *swrRoute {
+	for _, uri := range []string{info.FullMethodName, RouteAnyURI} {
+		for _, route := range p.routes[uri] {
+			if matchRoute(info.Ctx, route.match) {
+				return route
 			}
 		}
-		host := info.Address.Addr
-		hostedSubConns[host] = subConn
-		p.buckets.Add(subConn, 1)
 	}
+	return nil
+}
 
-	if info.Attributes == nil {
-		return
+func (p *swrPicker) parseBuildInfo(info PickerBuildInfo) {
+	var nodes = make([]swrNode, 0, len(info.ReadySCs))
+	for subConn, scInfo := range info.ReadySCs {
+		node := swrNode{
+			subConn: subConn,
+			addr:    scInfo.Address.Addr,
+			weight:  DefaultWeight,
+		}
+		if scInfo.Address.Attributes != nil {
+			if serviceInfo, ok := scInfo.Address.Attributes.Value(constant.KeyServiceInfo).(server.ServiceInfo); ok {
+				node.info = serviceInfo
+				if serviceInfo.Weight > 0 {
+					node.weight = int(math.Max(1, math.Round(serviceInfo.Weight)))
+				}
+			}
+		}
+		nodes = append(nodes, node)
 	}
 
-	providerConfig, ok := info.Attributes.Value(constant.KeyProviderConfig).(registry.ProviderConfig)
-	if !ok {
-		return
+	if info.Attributes != nil {
+		// 节点规则: 禁用节点、覆盖节点属性
+		if providerConfigs, ok := info.Attributes.Value(constant.KeyProviderConfig).(map[string]registry.ProviderConfig); ok {
+			nodes = applyProviderConfigs(nodes, providerConfigs)
+		}
 	}
 
-	fmt.Printf("providerConfig => %v\n", providerConfig)
+	// 默认流量只进入默认部署组
+	p.buckets.add(p.localize(filterDeployment(nodes, constant.DefaultDeployment), nil), 1)
 
-	consumerConfigs, ok := info.Attributes.Value(constant.KeyConsumerConfig).(map[string]registry.ConsumerConfig)
-	if !ok {
+	if info.Attributes == nil {
 		return
 	}
 
-	fmt.Printf("consumerConfigs => %v\n", consumerConfigs)
-
 	// 路由配置
 	routeConfigs, ok := info.Attributes.Value(constant.KeyRouteConfig).(map[string]registry.RouteConfig)
 	if !ok {
 		return
 	}
-	for _, config := range routeConfigs {
-		if _, ok := p.routeBuckets[config.URI]; !ok {
-			p.routeBuckets[config.URI] = &weighted.SW{}
+	for key, config := range routeConfigs {
+		if config.URI == "" {
+			continue
 		}
+		p.routes[config.URI] = append(p.routes[config.URI], &swrRoute{
+			match:    config.Match,
+			priority: config.Priority,
+			key:      key,
+			buckets:  p.buildRoute(config, nodes),
+		})
+	}
+	for _, routes := range p.routes {
+		sortRoutes(routes)
+	}
+}
 
-		// 基于Group的权重配置, 同一分组下的IP分配同一个权重值
-		for group, weight := range config.Upstream.Groups {
-			sConns, ok := groupedSubConns[group]
-			if !ok {
-				continue
-			}
+// buildRoute builds buckets of route, traffic is isolated in deployment of route
+func (p *swrPicker) buildRoute(config registry.RouteConfig, nodes []swrNode) *weightedGroups {
+	var buckets = &weightedGroups{groups: &weighted.SW{}}
+	nodes = filterDeployment(nodes, config.Deployment)
 
-			for _, sConn := range sConns {
-				p.routeBuckets[config.URI].Add(sConn, weight)
+	// 基于Node IP的权重配置, 如果配置了对应Node，将会覆盖节点自身的权重
+	var nodeWeights = config.Upstream.Nodes
+	if len(config.Upstream.Groups) == 0 {
+		if len(nodeWeights) == 0 {
+			buckets.add(p.localize(nodes, nil), 1)
+			return buckets
+		}
+		var listed = make([]swrNode, 0, len(nodes))
+		for _, node := range nodes {
+			if _, ok := nodeWeights[node.addr]; ok {
+				listed = append(listed, node)
 			}
 		}
+		buckets.add(p.localize(listed, nodeWeights), 1)
+		return buckets
+	}
+
+	// 基于Group的权重配置, 流量按比率在各个分组间分配
+	var grouped = map[string][]swrNode{}
+	for _, node := range nodes {
+		grouped[node.info.Group] = append(grouped[node.info.Group], node)
+	}
+	for group, weight := range config.Upstream.Groups {
+		buckets.add(p.localize(grouped[group], nodeWeights), weight)
+	}
+	return buckets
+}
+
+// localize returns weighted subconns of nodes, preferring same-zone then same-region nodes
+func (p *swrPicker) localize(nodes []swrNode, nodeWeights map[string]int) *weighted.SW {
+	var zone, region = p.config.Zone, p.config.Region
+	if zone == "" {
+		zone = pkg.AppZone()
+	}
+	if region == "" {
+		region = pkg.AppRegion()
+	}
 
-		// 基于Node IP的权重配置, 如果配置了对应Node，将会覆盖Group中配置的权重
-		for node, weight := range config.Upstream.Nodes {
-			if sConn, ok := hostedSubConns[node]; ok {
-				p.routeBuckets[config.URI].Add(sConn, weight)
+	var zoned, regioned []swrNode
+	for _, node := range nodes {
+		if region != "" && node.info.Region == region {
+			regioned = append(regioned, node)
+			if zone != "" && node.info.Zone == zone {
+				zoned = append(zoned, node)
 			}
 		}
+	}
 
+	switch {
+	case p.config.ZoneMinNodes > 0 && len(zoned) > 0 && len(zoned) >= p.config.ZoneMinNodes:
+		nodes = zoned
+	case p.config.RegionMinNodes > 0 && len(regioned) > 0 && len(regioned) >= p.config.RegionMinNodes:
+		nodes = regioned
+	}
+
+	var subConns = &weighted.SW{}
+	for _, node := range nodes {
+		weight := node.weight
+		if w, ok := nodeWeights[node.addr]; ok {
+			weight = w
+		}
+		if weight > 0 {
+			subConns.Add(node.subConn, weight)
+		}
+	}
+	return subConns
+}
+
+func filterDeployment(nodes []swrNode, deployment string) []swrNode {
+	var rets = make([]swrNode, 0, len(nodes))
+	for _, node := range nodes {
+		if node.info.Deployment == deployment {
+			rets = append(rets, node)
+		}
 	}
+	return rets
 }
@@ -0,0 +1,204 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balancer
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/douyu/jupiter/pkg/constant"
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/server"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/resolver"
+)
+
+type testSubConn struct {
+	addr string
+}
+
+func (sc *testSubConn) UpdateAddresses([]resolver.Address) {}
+
+func (sc *testSubConn) Connect() {}
+
+func buildTestInfo(nodes []server.ServiceInfo, routes map[string]registry.RouteConfig) PickerBuildInfo {
+	info := PickerBuildInfo{
+		ReadySCs: make(map[balancer.SubConn]base.SubConnInfo),
+	}
+	for _, node := range nodes {
+		info.ReadySCs[&testSubConn{addr: node.Address}] = base.SubConnInfo{
+			Address: resolver.Address{
+				Addr:       node.Address,
+				Attributes: attributes.New(constant.KeyServiceInfo, node),
+			},
+		}
+	}
+	if routes != nil {
+		info.Attributes = attributes.New(constant.KeyRouteConfig, routes)
+	}
+	return info
+}
+
+func pickN(t *testing.T, picker balancer.V2Picker, method string, n int) map[string]int {
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		res, err := picker.Pick(balancer.PickInfo{FullMethodName: method})
+		if err != nil {
+			t.Fatalf("pick failed: %v", err)
+		}
+		counts[res.SubConn.(*testSubConn).addr]++
+	}
+	return counts
+}
+
+func TestSWRPicker_Weight(t *testing.T) {
+	picker := swrPickerBuilder{config: &SWRConfig{}}.Build(buildTestInfo([]server.ServiceInfo{
+		{Address: "a", Weight: 300},
+		{Address: "b", Weight: 100},
+		{Address: "c"},
+	}, nil))
+
+	counts := pickN(t, picker, "/svc/Method", 500)
+	assert.Equal(t, map[string]int{"a": 300, "b": 100, "c": 100}, counts)
+}
+
+func TestSWRPicker_Deployment(t *testing.T) {
+	picker := swrPickerBuilder{config: &SWRConfig{}}.Build(buildTestInfo([]server.ServiceInfo{
+		{Address: "a"},
+		{Address: "b", Deployment: "canary"},
+		{Address: "c", Deployment: "internal"},
+	}, map[string]registry.RouteConfig{
+		"r1": {URI: "/svc/Canary", Deployment: "canary"},
+		"r2": {URI: "/svc/Missing", Deployment: "missing"},
+	}))
+
+	assert.Equal(t, map[string]int{"a": 10}, pickN(t, picker, "/svc/Method", 10))
+	assert.Equal(t, map[string]int{"b": 10}, pickN(t, picker, "/svc/Canary", 10))
+
+	_, err := picker.Pick(balancer.PickInfo{FullMethodName: "/svc/Missing"})
+	assert.NotNil(t, err)
+}
+
+func TestSWRPicker_WeightGroups(t *testing.T) {
+	picker := swrPickerBuilder{config: &SWRConfig{}}.Build(buildTestInfo([]server.ServiceInfo{
+		{Address: "a1", Group: "a"},
+		{Address: "a2", Group: "a"},
+		{Address: "b1", Group: "b"},
+	}, map[string]registry.RouteConfig{
+		"r1": {URI: "/svc/Grouped", Upstream: registry.Upstream{Groups: map[string]int{"a": 1, "b": 3}}},
+		"r2": {URI: "/svc/Noded", Upstream: registry.Upstream{Nodes: map[string]int{"a1": 1, "b1": 2}}},
+	}))
+
+	counts := pickN(t, picker, "/svc/Grouped", 400)
+	assert.Equal(t, 300, counts["b1"])
+	assert.Equal(t, 100, counts["a1"]+counts["a2"])
+
+	assert.Equal(t, map[string]int{"a1": 100, "b1": 200}, pickN(t, picker, "/svc/Noded", 300))
+}
+
+func TestSWRPicker_Locality(t *testing.T) {
+	nodes := []server.ServiceInfo{
+		{Address: "z1", Region: "r1", Zone: "z1"},
+		{Address: "z2", Region: "r1", Zone: "z2"},
+		{Address: "z3", Region: "r2", Zone: "z3"},
+	}
+
+	picker := swrPickerBuilder{config: &SWRConfig{Region: "r1", Zone: "z1", ZoneMinNodes: 1, RegionMinNodes: 1}}.Build(buildTestInfo(nodes, nil))
+	assert.Equal(t, map[string]int{"z1": 10}, pickN(t, picker, "/svc/Method", 10))
+
+	// not enough same-zone nodes, spill over to region
+	picker = swrPickerBuilder{config: &SWRConfig{Region: "r1", Zone: "z1", ZoneMinNodes: 2, RegionMinNodes: 1}}.Build(buildTestInfo(nodes, nil))
+	assert.Equal(t, map[string]int{"z1": 5, "z2": 5}, pickN(t, picker, "/svc/Method", 10))
+
+	// not enough same-region nodes, spill over to all
+	picker = swrPickerBuilder{config: &SWRConfig{Region: "r1", Zone: "z1", ZoneMinNodes: 2, RegionMinNodes: 3}}.Build(buildTestInfo(nodes, nil))
+	assert.Equal(t, map[string]int{"z1": 3, "z2": 3, "z3": 3}, pickN(t, picker, "/svc/Method", 9))
+
+	// locality is disabled by default
+	config := DefaultSWRConfig()
+	config.Region, config.Zone = "r1", "z1"
+	picker = swrPickerBuilder{config: config}.Build(buildTestInfo(nodes, nil))
+	assert.Equal(t, map[string]int{"z1": 3, "z2": 3, "z3": 3}, pickN(t, picker, "/svc/Method", 9))
+}
+
+func TestSWRPicker_RouteMatch(t *testing.T) {
+	picker := swrPickerBuilder{config: &SWRConfig{}}.Build(buildTestInfo([]server.ServiceInfo{
+		{Address: "a"},
+		{Address: "b", Deployment: "canary"},
+		{Address: "c", Deployment: "beta"},
+	}, map[string]registry.RouteConfig{
+		"r1": {URI: "/svc/Method", Deployment: "canary", Match: registry.RouteMatch{Headers: map[string]string{"X-Canary": "1"}}},
+		"r2": {URI: "/svc/Method", Deployment: "beta", Priority: 10, Match: registry.RouteMatch{AIDs: []string{"tester"}}},
+		"r3": {URI: RouteAnyURI, Deployment: "canary", Match: registry.RouteMatch{AIDs: []string{"everywhere"}}},
+	}))
+
+	pick := func(method string, kvs ...string) string {
+		ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs(kvs...))
+		res, err := picker.Pick(balancer.PickInfo{FullMethodName: method, Ctx: ctx})
+		assert.Nil(t, err)
+		return res.SubConn.(*testSubConn).addr
+	}
+	assert.Equal(t, "a", pick("/svc/Method"))
+	assert.Equal(t, "b", pick("/svc/Method", "x-canary", "1"))
+	// higher priority route is matched first
+	assert.Equal(t, "c", pick("/svc/Method", "x-canary", "1", "aid", "tester"))
+	assert.Equal(t, "b", pick("/svc/Other", "aid", "everywhere"))
+	assert.Equal(t, "a", pick("/svc/Other", "aid", "tester"))
+}
+
+func TestMatchRoute_Percent(t *testing.T) {
+	var matched int
+	for i := 0; i < 1000; i++ {
+		ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("aid", strconv.Itoa(i)))
+		if matchRoute(ctx, registry.RouteMatch{Percent: 20}) {
+			matched++
+		}
+	}
+	assert.InDelta(t, 200, matched, 50)
+}
+
+func TestSWRPicker_ProviderConfig(t *testing.T) {
+	enable, disable := true, false
+	info := buildTestInfo([]server.ServiceInfo{
+		{Address: "a"},
+		{Address: "b"},
+		{Address: "c"},
+		{Address: "d"},
+	}, map[string]registry.RouteConfig{})
+	info.Attributes = info.Attributes.WithValues(constant.KeyProviderConfig, map[string]registry.ProviderConfig{
+		"grpc://b/providers/1": {ID: "1", Scheme: "grpc", Host: "b", Enable: &disable},
+		"grpc://c/providers/1": {ID: "1", Scheme: "grpc", Host: "c", Enable: &enable, Deployment: "canary"},
+		// node is kept if only attributes are overridden
+		"grpc://d/providers/1": {ID: "1", Scheme: "grpc", Host: "d", Zone: "z2"},
+	})
+	picker := swrPickerBuilder{config: &SWRConfig{}}.Build(info)
+	counts := pickN(t, picker, "/svc/Method", 100)
+	assert.Equal(t, 100, counts["a"]+counts["d"], counts)
+	assert.True(t, counts["d"] > 0, counts)
+
+	// disabling is ignored if all nodes are disabled
+	info = buildTestInfo([]server.ServiceInfo{{Address: "a"}}, map[string]registry.RouteConfig{})
+	info.Attributes = info.Attributes.WithValues(constant.KeyProviderConfig, map[string]registry.ProviderConfig{
+		"grpc://a/providers/1": {ID: "1", Scheme: "grpc", Host: "a", Enable: &disable},
+	})
+	picker = swrPickerBuilder{config: &SWRConfig{}}.Build(info)
+	assert.Equal(t, map[string]int{"a": 10}, pickN(t, picker, "/svc/Method", 10))
+}
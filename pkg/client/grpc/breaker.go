@@ -0,0 +1,274 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpc
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/metric"
+	"github.com/douyu/jupiter/pkg/server/governor"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+
+	jsoniter "github.com/json-iterator/go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// BreakerState state of circuit breaker
+type BreakerState int
+
+const (
+	// BreakerClosed requests are allowed
+	BreakerClosed BreakerState = iota
+	// BreakerHalfOpen limited probe requests are allowed
+	BreakerHalfOpen
+	// BreakerOpen requests are rejected
+	BreakerOpen
+)
+
+// String ...
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerHalfOpen:
+		return "half_open"
+	case BreakerOpen:
+		return "open"
+	}
+	return "unknown"
+}
+
+// BreakerConfig circuit breaker config, breaker opens when error ratio or slow ratio
+// of requests in Window exceeds threshold. A breaker is shared by all requests of a
+// client to its target, not one per subconn: the breaker runs before the balancer picks
+// a subconn, so it guards the target as a whole, and failing backends are left to the balancer.
+type BreakerConfig struct {
+	Enable bool
+	// Window statistic window
+	Window time.Duration
+	// MinRequests min requests in window before breaker can open
+	MinRequests int
+	// ErrorRatio breaker opens when ratio of failed requests exceeds ErrorRatio, disabled if 0
+	ErrorRatio float64
+	// SlowThreshold requests cost over SlowThreshold are slow requests
+	SlowThreshold time.Duration
+	// SlowRatio breaker opens when ratio of slow requests exceeds SlowRatio, disabled if 0
+	SlowRatio float64
+	// OpenTimeout breaker turns half open after OpenTimeout
+	OpenTimeout time.Duration
+	// HalfOpenRequests probe requests allowed in half open state, breaker closes after all of them succeeded
+	HalfOpenRequests int
+}
+
+// DefaultBreakerConfig ...
+func DefaultBreakerConfig() *BreakerConfig {
+	return &BreakerConfig{
+		Enable:           false,
+		Window:           xtime.Duration("10s"),
+		MinRequests:      20,
+		ErrorRatio:       0.5,
+		SlowThreshold:    0,
+		SlowRatio:        0,
+		OpenTimeout:      xtime.Duration("5s"),
+		HalfOpenRequests: 5,
+	}
+}
+
+// errBreakerOpen is returned when request is rejected by circuit breaker
+var errBreakerOpen = status.Error(codes.Unavailable, "circuit breaker is open")
+
+// isBreakerOpen reports whether err is rejection of circuit breaker, which is never
+// retried or hedged though its code is Unavailable, retries would only hit the open breaker
+func isBreakerOpen(err error) bool {
+	return err == errBreakerOpen
+}
+
+// breakers holds all circuit breakers, keyed by client name and target
+var breakers sync.Map
+
+func init() {
+	governor.HandleFunc("/debug/client/grpc/breakers", func(w http.ResponseWriter, r *http.Request) {
+		var rets = make([]BreakerStats, 0)
+		breakers.Range(func(key, val interface{}) bool {
+			rets = append(rets, val.(*breaker).Stats())
+			return true
+		})
+		_ = jsoniter.NewEncoder(w).Encode(rets)
+	})
+}
+
+// BreakerStats ...
+type BreakerStats struct {
+	Name     string `json:"name"`
+	Target   string `json:"target"`
+	State    string `json:"state"`
+	Requests int    `json:"requests"`
+	Failures int    `json:"failures"`
+	Slows    int    `json:"slows"`
+}
+
+type breaker struct {
+	mu          sync.Mutex
+	config      *BreakerConfig
+	name        string
+	target      string
+	state       BreakerState
+	windowStart time.Time
+	openedAt    time.Time
+	requests    int
+	failures    int
+	slows       int
+	probes      int
+	successes   int
+}
+
+func newBreaker(config *BreakerConfig, name, target string) *breaker {
+	return &breaker{
+		config:      config,
+		name:        name,
+		target:      target,
+		windowStart: time.Now(),
+	}
+}
+
+// Stats ...
+func (b *breaker) Stats() BreakerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return BreakerStats{
+		Name:     b.name,
+		Target:   b.target,
+		State:    b.state.String(),
+		Requests: b.requests,
+		Failures: b.failures,
+		Slows:    b.slows,
+	}
+}
+
+func (b *breaker) setState(state BreakerState, now time.Time) {
+	b.state = state
+	b.windowStart = now
+	b.requests, b.failures, b.slows = 0, 0, 0
+	b.probes, b.successes = 0, 0
+	if state == BreakerOpen {
+		b.openedAt = now
+	}
+	metric.ClientBreakerStateGauge.Set(float64(state), b.name, b.target)
+}
+
+// allow reports whether request is allowed
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	switch b.state {
+	case BreakerOpen:
+		if now.Sub(b.openedAt) < b.config.OpenTimeout {
+			return false
+		}
+		b.setState(BreakerHalfOpen, now)
+		fallthrough
+	case BreakerHalfOpen:
+		if b.probes >= b.config.HalfOpenRequests {
+			return false
+		}
+		b.probes++
+		return true
+	default:
+		if now.Sub(b.windowStart) >= b.config.Window {
+			b.windowStart = now
+			b.requests, b.failures, b.slows = 0, 0, 0
+		}
+		return true
+	}
+}
+
+// done records result of allowed request
+func (b *breaker) done(err error, cost time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	failed := isBreakerFailure(err)
+	switch b.state {
+	case BreakerHalfOpen:
+		if failed {
+			b.setState(BreakerOpen, now)
+			return
+		}
+		b.successes++
+		if b.successes >= b.config.HalfOpenRequests {
+			b.setState(BreakerClosed, now)
+		}
+	case BreakerClosed:
+		b.requests++
+		if failed {
+			b.failures++
+		}
+		if b.config.SlowThreshold > 0 && cost > b.config.SlowThreshold {
+			b.slows++
+		}
+		if b.requests < b.config.MinRequests {
+			return
+		}
+		if (b.config.ErrorRatio > 0 && float64(b.failures) >= b.config.ErrorRatio*float64(b.requests)) ||
+			(b.config.SlowRatio > 0 && float64(b.slows) >= b.config.SlowRatio*float64(b.requests)) {
+			b.setState(BreakerOpen, now)
+		}
+	}
+}
+
+// isBreakerFailure reports whether err is a system failure of server,
+// business errors and errors caused by client are not counted
+func isBreakerFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	code := status.Code(err)
+	if int32(code) > ecode.EcodeNum {
+		return false
+	}
+	switch code {
+	case codes.Unknown, codes.DeadlineExceeded, codes.ResourceExhausted,
+		codes.Internal, codes.Unavailable, codes.DataLoss:
+		return true
+	}
+	return false
+}
+
+// breakerUnaryClientInterceptor rejects requests with Unavailable when breaker of target is open
+func breakerUnaryClientInterceptor(config *Config) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		val, ok := breakers.Load(config.Name + "@" + cc.Target())
+		if !ok {
+			val, _ = breakers.LoadOrStore(config.Name+"@"+cc.Target(), newBreaker(config.Breaker, config.Name, cc.Target()))
+		}
+		var b = val.(*breaker)
+		if !b.allow() {
+			metric.ClientBreakerRejectCounter.Inc(metric.TypeGRPCUnary, config.Name, method, cc.Target())
+			return errBreakerOpen
+		}
+
+		beg := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		b.done(err, time.Since(beg))
+		return err
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBreakerUnaryClientInterceptor(t *testing.T) {
+	cc := fakeClientConn(t)
+	defer cc.Close()
+	config := DefaultConfig()
+	config.Name = "test-breaker"
+	config.Breaker = &BreakerConfig{
+		Enable:           true,
+		Window:           time.Second,
+		MinRequests:      4,
+		ErrorRatio:       0.5,
+		OpenTimeout:      50 * time.Millisecond,
+		HalfOpenRequests: 1,
+	}
+	interceptor := breakerUnaryClientInterceptor(config)
+	var invokeErr error
+	invoke := func() error {
+		return interceptor(context.Background(), "/testproto.Greeter/SayHello", nil, nil, cc, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			return invokeErr
+		})
+	}
+
+	Convey("test breaker opens on error ratio", t, func() {
+		invokeErr = status.Error(codes.Unavailable, "unavailable")
+		for i := 0; i < 4; i++ {
+			So(invoke(), ShouldEqual, invokeErr)
+		}
+		So(invoke(), ShouldEqual, errBreakerOpen)
+	})
+
+	Convey("test breaker closes after probe succeeded", t, func() {
+		time.Sleep(60 * time.Millisecond)
+		invokeErr = nil
+		So(invoke(), ShouldBeNil)
+		val, _ := breakers.Load(config.Name + "@" + cc.Target())
+		So(val.(*breaker).Stats().State, ShouldEqual, BreakerClosed.String())
+	})
+
+	Convey("test business error is not failure", t, func() {
+		So(isBreakerFailure(status.Error(codes.Code(10001), "biz")), ShouldBeFalse)
+		So(isBreakerFailure(status.Error(codes.InvalidArgument, "invalid")), ShouldBeFalse)
+		So(isBreakerFailure(status.Error(codes.DeadlineExceeded, "timeout")), ShouldBeTrue)
+	})
+}
+
+func TestBreakerOpenIsNotRetried(t *testing.T) {
+	cc := fakeClientConn(t)
+	defer cc.Close()
+	config := DefaultConfig()
+	config.Methods = map[string]MethodConfig{
+		"SayHello": {Retry: DefaultRetryConfig()},
+	}
+	retry := retryUnaryClientInterceptor(config)
+
+	Convey("test rejection of open breaker is returned without retry", t, func() {
+		var attempts int
+		err := retry(context.Background(), "/testproto.Greeter/SayHello", nil, nil, cc, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			attempts++
+			return errBreakerOpen
+		})
+		So(err, ShouldEqual, errBreakerOpen)
+		So(attempts, ShouldEqual, 1)
+
+		attempts = 0
+		err = retry(context.Background(), "/testproto.Greeter/SayHello", nil, nil, cc, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			attempts++
+			return status.Error(codes.Unavailable, "unavailable")
+		})
+		So(status.Code(err), ShouldEqual, codes.Unavailable)
+		So(attempts, ShouldEqual, 3)
+	})
+}
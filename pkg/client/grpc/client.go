@@ -23,14 +23,33 @@ import (
 	"github.com/douyu/jupiter/pkg/xlog"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 func newGRPCClient(config *Config) *grpc.ClientConn {
-	var ctx = context.Background()
-	var dialOptions = config.dialOptions
 	logger := config.logger.With(
 		xlog.FieldAddr(config.Address),
 	)
+	cc, err := dialGRPCClient(config)
+	if err != nil {
+		if config.OnDialError == "panic" {
+			logger.Panic("dial grpc server", xlog.FieldErrKind(ecode.ErrKindRequestErr), xlog.FieldErr(err))
+		} else {
+			logger.Error("dial grpc server", xlog.FieldErrKind(ecode.ErrKindRequestErr), xlog.FieldErr(err))
+		}
+	}
+	logger.Info("start grpc client")
+	return cc
+}
+
+// dialGRPCClient dials grpc server by config, interceptors should be built before
+func dialGRPCClient(config *Config) (cc *grpc.ClientConn, err error) {
+	if config.consumer != nil {
+		defer func() { config.consumer.attach(cc) }()
+	}
+
+	var ctx = context.Background()
+	var dialOptions = config.dialOptions
 	// 默认配置使用block
 	if config.Block {
 		if config.DialTimeout > time.Duration(0) {
@@ -42,21 +61,21 @@ func newGRPCClient(config *Config) *grpc.ClientConn {
 		dialOptions = append(dialOptions, grpc.WithBlock())
 	}
 
+	if config.TLS != nil && config.TLS.Enable {
+		tlsConfig, err := config.TLS.ClientTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		dialOptions = append(dialOptions, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	} else {
+		dialOptions = append(dialOptions, grpc.WithInsecure())
+	}
+
 	if config.KeepAlive != nil {
 		dialOptions = append(dialOptions, grpc.WithKeepaliveParams(*config.KeepAlive))
 	}
 
 	dialOptions = append(dialOptions, grpc.WithBalancerName(config.BalancerName))
 
-	cc, err := grpc.DialContext(ctx, config.Address, dialOptions...)
-
-	if err != nil {
-		if config.OnDialError == "panic" {
-			logger.Panic("dial grpc server", xlog.FieldErrKind(ecode.ErrKindRequestErr), xlog.FieldErr(err))
-		} else {
-			logger.Error("dial grpc server", xlog.FieldErrKind(ecode.ErrKindRequestErr), xlog.FieldErr(err))
-		}
-	}
-	logger.Info("start grpc client")
-	return cc
+	return grpc.DialContext(ctx, config.Address, dialOptions...)
 }
@@ -7,8 +7,12 @@ import (
 
 	"github.com/douyu/jupiter/pkg/util/xtest/proto/testproto"
 	"github.com/douyu/jupiter/pkg/util/xtest/server/yell"
+	"github.com/douyu/jupiter/pkg/xlog"
 
 	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // TestBase test direct dial with New()
@@ -49,3 +53,29 @@ func TestConfigBlockFalse(t *testing.T) {
 		So(conn.GetState().String(), ShouldEqual, "IDLE")
 	})
 }
+
+func TestTimeoutUnaryClientInterceptor(t *testing.T) {
+	interceptor := timeoutUnaryClientInterceptor(xlog.JupiterLogger, time.Second, 10*time.Millisecond, 0)
+	Convey("test inherited deadline subtracted by margin", t, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		upstream, _ := ctx.Deadline()
+		err := interceptor(ctx, "/test.Greeter/SayHello", nil, nil, nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			deadline, ok := ctx.Deadline()
+			So(ok, ShouldBeTrue)
+			So(upstream.Sub(deadline), ShouldBeGreaterThanOrEqualTo, 10*time.Millisecond)
+			return nil
+		})
+		So(err, ShouldBeNil)
+	})
+
+	Convey("test exhausted deadline budget fails fast", t, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+		defer cancel()
+		err := interceptor(ctx, "/test.Greeter/SayHello", nil, nil, nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			t.Fatal("invoker should not be called")
+			return nil
+		})
+		So(status.Code(err), ShouldEqual, codes.DeadlineExceeded)
+	})
+}
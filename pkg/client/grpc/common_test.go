@@ -12,6 +12,7 @@ import (
 )
 
 var directClient testproto.GreeterClient
+var directAddr string
 
 func TestMain(m *testing.M) {
 	l, s := startServer("127.0.0.1:0", "srv1")
@@ -19,6 +20,7 @@ func TestMain(m *testing.M) {
 
 	cfg := DefaultConfig()
 	cfg.Address = l.Addr().String()
+	directAddr = cfg.Address
 
 	conn := newGRPCClient(cfg)
 	directClient = testproto.NewGreeterClient(conn)
@@ -18,6 +18,7 @@ import (
 	"time"
 
 	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/douyu/jupiter/pkg/util/xtls"
 
 	"github.com/douyu/jupiter/pkg/conf"
 	"github.com/douyu/jupiter/pkg/ecode"
@@ -38,11 +39,19 @@ type Config struct {
 	ReadTimeout  time.Duration
 	Direct       bool
 	OnDialError  string // panic | error
+	Lazy         bool   // dial on first use when managed by ConnManager
 	KeepAlive    *keepalive.ClientParameters
 	logger       *xlog.Logger
 	dialOptions  []grpc.DialOption
+	consumer     *consumer
+
+	// TLS tls config, insecure if nil or not enabled
+	TLS *xtls.Config
 
 	SlowThreshold time.Duration
+	// DeadlineMargin safety margin subtracted from inherited deadline, leaving time
+	// for upstream to handle the response before its own deadline exceeded
+	DeadlineMargin time.Duration
 
 	Debug                     bool
 	DisableTraceInterceptor   bool
@@ -50,22 +59,33 @@ type Config struct {
 	DisableTimeoutInterceptor bool
 	DisableMetricInterceptor  bool
 	DisableAccessInterceptor  bool
-	AccessInterceptorLevel    string
+	// DisableConsumerRegister disables registering consumer record of client, which is
+	// registered into registry of target resolver with methods called, and unregistered when client is closed
+	DisableConsumerRegister bool
+	AccessInterceptorLevel  string
+	// AccessLog access log policy: payload truncation and redaction, sampling and levels
+	AccessLog *xlog.AccessConfig
+	// Methods per method retry and hedging policies, keyed by full method or method name
+	Methods map[string]MethodConfig
+	// Breaker circuit breaker of target, shared by all subconns of target
+	Breaker *BreakerConfig
 }
 
 // DefaultConfig ...
 func DefaultConfig() *Config {
 	return &Config{
-		dialOptions: []grpc.DialOption{
-			grpc.WithInsecure(),
-		},
+		dialOptions:            []grpc.DialOption{},
 		logger:                 xlog.JupiterLogger.With(xlog.FieldMod(ecode.ModClientGrpc)),
 		BalancerName:           roundrobin.Name, // round robin by default
 		DialTimeout:            time.Second * 3,
 		ReadTimeout:            xtime.Duration("1s"),
+		DeadlineMargin:         xtime.Duration("5ms"),
 		SlowThreshold:          xtime.Duration("600ms"),
 		OnDialError:            "panic",
 		AccessInterceptorLevel: "info",
+		AccessLog:              xlog.DefaultAccessConfig(),
+		Methods:                make(map[string]MethodConfig),
+		Breaker:                DefaultBreakerConfig(),
 		Block:                  true,
 	}
 }
@@ -101,6 +121,12 @@ func (config *Config) WithDialOption(opts ...grpc.DialOption) *Config {
 
 // Build ...
 func (config *Config) Build() *grpc.ClientConn {
+	config.buildInterceptors()
+	return newGRPCClient(config)
+}
+
+// buildInterceptors appends interceptors to dial options
+func (config *Config) buildInterceptors() {
 	if config.Debug {
 		config.dialOptions = append(config.dialOptions,
 			grpc.WithChainUnaryInterceptor(debugUnaryClientInterceptor(config.Address)),
@@ -110,32 +136,59 @@ func (config *Config) Build() *grpc.ClientConn {
 	if !config.DisableAidInterceptor {
 		config.dialOptions = append(config.dialOptions,
 			grpc.WithChainUnaryInterceptor(aidUnaryClientInterceptor()),
+			grpc.WithChainStreamInterceptor(aidStreamClientInterceptor()),
 		)
 	}
 
 	if !config.DisableTimeoutInterceptor {
 		config.dialOptions = append(config.dialOptions,
-			grpc.WithChainUnaryInterceptor(timeoutUnaryClientInterceptor(config.logger, config.ReadTimeout, config.SlowThreshold)),
+			grpc.WithChainUnaryInterceptor(timeoutUnaryClientInterceptor(config.logger, config.ReadTimeout, config.DeadlineMargin, config.SlowThreshold)),
+			grpc.WithChainStreamInterceptor(timeoutStreamClientInterceptor(config.DeadlineMargin)),
 		)
 	}
 
 	if !config.DisableTraceInterceptor {
 		config.dialOptions = append(config.dialOptions,
 			grpc.WithChainUnaryInterceptor(traceUnaryClientInterceptor()),
+			grpc.WithChainStreamInterceptor(traceStreamClientInterceptor()),
 		)
 	}
 
 	if !config.DisableAccessInterceptor {
+		if config.AccessLog == nil {
+			config.AccessLog = xlog.DefaultAccessConfig()
+		}
+		var policy = config.AccessLog.Build()
 		config.dialOptions = append(config.dialOptions,
-			grpc.WithChainUnaryInterceptor(loggerUnaryClientInterceptor(config.logger, config.Name, config.AccessInterceptorLevel)),
+			grpc.WithChainUnaryInterceptor(loggerUnaryClientInterceptor(config.logger, config.Name, config.AccessInterceptorLevel, config.SlowThreshold, policy)),
+			grpc.WithChainStreamInterceptor(loggerStreamClientInterceptor(config.logger, config.Name, config.AccessInterceptorLevel, policy)),
 		)
 	}
 
 	if !config.DisableMetricInterceptor {
 		config.dialOptions = append(config.dialOptions,
 			grpc.WithChainUnaryInterceptor(metricUnaryClientInterceptor(config.Name)),
+			grpc.WithChainStreamInterceptor(metricStreamClientInterceptor(config.Name)),
 		)
 	}
 
-	return newGRPCClient(config)
+	if !config.DisableConsumerRegister {
+		if consumer := newConsumer(config); consumer != nil {
+			config.consumer = consumer
+			config.dialOptions = append(config.dialOptions,
+				grpc.WithChainUnaryInterceptor(consumerUnaryClientInterceptor(consumer)),
+				grpc.WithChainStreamInterceptor(consumerStreamClientInterceptor(consumer)),
+			)
+		}
+	}
+
+	config.dialOptions = append(config.dialOptions,
+		grpc.WithChainUnaryInterceptor(retryUnaryClientInterceptor(config), hedgingUnaryClientInterceptor(config)),
+	)
+
+	if config.Breaker != nil && config.Breaker.Enable {
+		config.dialOptions = append(config.dialOptions,
+			grpc.WithChainUnaryInterceptor(breakerUnaryClientInterceptor(config)),
+		)
+	}
 }
@@ -0,0 +1,195 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/douyu/jupiter/pkg"
+	"github.com/douyu/jupiter/pkg/client/grpc/resolver"
+	"github.com/douyu/jupiter/pkg/constant"
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/util/xgo"
+	"github.com/douyu/jupiter/pkg/util/xnet"
+	"github.com/douyu/jupiter/pkg/xlog"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+var (
+	// consumers consumer records of this process, keyed by dial target
+	consumers   = make(map[string]*consumer)
+	consumersMu sync.Mutex
+)
+
+// consumer registers consumer record of target into registry of target resolver,
+// the record is updated when a method is called for the first time,
+// and unregistered when all client conns of target are closed
+type consumer struct {
+	target string
+	reg    registry.Registry
+	info   server.ServiceInfo
+	logger *xlog.Logger
+	refs   int // client conns of target, guarded by consumersMu
+
+	methods sync.Map
+	// 串行注册, 保证最后一次注册的调用方法最全
+	mu     sync.Mutex
+	closed bool
+}
+
+// newConsumer returns consumer of target, nil if target isn't resolved by registry,
+// the consumer should be attached to the client conn dialed
+func newConsumer(config *Config) *consumer {
+	scheme, name := parseTarget(config.Address)
+	reg, ok := resolver.Registry(scheme)
+	if !ok || name == "" {
+		return nil
+	}
+
+	consumersMu.Lock()
+	defer consumersMu.Unlock()
+	if c, ok := consumers[config.Address]; ok {
+		c.refs++
+		return c
+	}
+
+	ip, err := xnet.GetLocalIP()
+	if err != nil {
+		ip = pkg.HostName()
+	}
+	c := &consumer{
+		target: config.Address,
+		refs:   1,
+		reg:    reg,
+		info: server.ServiceInfo{
+			Name:    name,
+			AppID:   pkg.AppID(),
+			Scheme:  "grpc",
+			Address: fmt.Sprintf("%s:%d", ip, os.Getpid()), // 进程维度的调用方
+			Enable:  true,
+			Healthy: true,
+			Kind:    constant.ServiceConsumer,
+			Metadata: map[string]string{
+				registry.MetaConsumerApp:    pkg.Name(),
+				registry.MetaConsumerTarget: config.Address,
+			},
+		},
+		logger: config.logger.With(xlog.FieldName(name)),
+	}
+	consumers[config.Address] = c
+	xgo.Go(c.register)
+	return c
+}
+
+// attach releases consumer after cc is closed, or immediately if cc failed to dial
+func (c *consumer) attach(cc *grpc.ClientConn) {
+	if cc == nil {
+		c.release()
+		return
+	}
+	xgo.Go(func() {
+		for state := cc.GetState(); state != connectivity.Shutdown; state = cc.GetState() {
+			cc.WaitForStateChange(context.Background(), state)
+		}
+		c.release()
+	})
+}
+
+// release unregisters consumer record if no client conn of target is alive
+func (c *consumer) release() {
+	consumersMu.Lock()
+	c.refs--
+	if c.refs > 0 {
+		consumersMu.Unlock()
+		return
+	}
+	delete(consumers, c.target)
+	consumersMu.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	if err := c.reg.UnregisterService(context.Background(), &c.info); err != nil {
+		c.logger.Error("unregister consumer", xlog.FieldErrKind(ecode.ErrKindRegisterErr), xlog.FieldErr(err))
+	}
+}
+
+// observe records method called, consumer record is updated if method is new
+func (c *consumer) observe(method string) {
+	if _, loaded := c.methods.LoadOrStore(method, struct{}{}); !loaded {
+		xgo.Go(c.register)
+	}
+}
+
+func (c *consumer) register() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+
+	var methods = make([]string, 0)
+	c.methods.Range(func(key, _ interface{}) bool {
+		methods = append(methods, key.(string))
+		return true
+	})
+	sort.Strings(methods)
+
+	info := c.info
+	info.Metadata = make(map[string]string, len(c.info.Metadata)+1)
+	for key, val := range c.info.Metadata {
+		info.Metadata[key] = val
+	}
+	info.Metadata[registry.MetaConsumerMethods] = strings.Join(methods, ",")
+	if err := c.reg.RegisterService(context.Background(), &info); err != nil {
+		c.logger.Error("register consumer", xlog.FieldErrKind(ecode.ErrKindRegisterErr), xlog.FieldErr(err))
+	}
+}
+
+// parseTarget returns scheme and endpoint of target, {scheme}://{authority}/{endpoint}
+func parseTarget(target string) (scheme, endpoint string) {
+	idx := strings.Index(target, "://")
+	if idx < 0 {
+		return "", target
+	}
+	scheme, rest := target[:idx], target[idx+3:]
+	if idx = strings.Index(rest, "/"); idx < 0 {
+		return scheme, ""
+	}
+	return scheme, rest[idx+1:]
+}
+
+func consumerUnaryClientInterceptor(c *consumer) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		c.observe(method)
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+func consumerStreamClientInterceptor(c *consumer) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		c.observe(method)
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
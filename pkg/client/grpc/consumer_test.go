@@ -0,0 +1,88 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/client/grpc/resolver"
+	"github.com/douyu/jupiter/pkg/constant"
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/registry/memory"
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/util/xtest/proto/testproto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseTarget(t *testing.T) {
+	for target, want := range map[string][2]string{
+		"etcd:///demo":       {"etcd", "demo"},
+		"etcd://authz/demo":  {"etcd", "demo"},
+		"127.0.0.1:9091":     {"", "127.0.0.1:9091"},
+		"passthrough://demo": {"passthrough", ""},
+	} {
+		scheme, endpoint := parseTarget(target)
+		assert.Equal(t, want, [2]string{scheme, endpoint}, target)
+	}
+}
+
+func TestConsumerRegister(t *testing.T) {
+	store := memory.NewStore()
+	resolver.Register("consumer_test", memory.New(store))
+	store.Put(&server.ServiceInfo{Name: "greeter", Scheme: "grpc", Address: directAddr, Enable: true, Kind: constant.ServiceProvider})
+
+	config := DefaultConfig()
+	config.Address = "consumer_test:///greeter"
+	cc := config.Build()
+	client := testproto.NewGreeterClient(cc)
+
+	consumer := func() *server.ServiceInfo {
+		for _, info := range store.Catalog() {
+			if info.Kind == constant.ServiceConsumer {
+				return info
+			}
+		}
+		return nil
+	}
+	assert.Eventually(t, func() bool { return consumer() != nil }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "greeter", consumer().Name)
+	assert.Equal(t, "consumer_test:///greeter", consumer().Metadata[registry.MetaConsumerTarget])
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := client.SayHello(ctx, &testproto.HelloRequest{Name: "hello"})
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		return consumer().Metadata[registry.MetaConsumerMethods] == "/testproto.Greeter/SayHello"
+	}, time.Second, 10*time.Millisecond)
+
+	// consumer record isn't discovered as provider
+	services, err := memory.New(store).ListServices(ctx, "greeter", "grpc")
+	assert.Nil(t, err)
+	assert.Len(t, services, 1)
+
+	// consumer record is kept until all client conns of target are closed
+	config = DefaultConfig()
+	config.Address = "consumer_test:///greeter"
+	other := config.Build()
+	assert.Nil(t, cc.Close())
+	time.Sleep(100 * time.Millisecond)
+	assert.NotNil(t, consumer())
+	assert.Nil(t, other.Close())
+	assert.Eventually(t, func() bool { return consumer() == nil }, time.Second, 10*time.Millisecond)
+}
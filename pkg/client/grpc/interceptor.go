@@ -16,9 +16,10 @@ package grpc
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"sync"
 	"time"
 
 	"github.com/douyu/jupiter/pkg"
@@ -30,6 +31,7 @@ import (
 	"github.com/douyu/jupiter/pkg/util/xcolor"
 	"github.com/douyu/jupiter/pkg/util/xstring"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/opentracing/opentracing-go/ext"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -63,16 +65,26 @@ func metricUnaryClientInterceptor(name string) func(ctx context.Context, method
 	}
 }
 
-func metricStreamClientInterceptor(name string) func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+// metricStreamClientInterceptor 统计 stream 消息数，以及 stream 结束时的状态与耗时
+func metricStreamClientInterceptor(name string) grpc.StreamClientInterceptor {
 	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
 		beg := time.Now()
-		clientStream, err := streamer(ctx, desc, cc, method, opts...)
+		typ := streamType(desc)
+		onFinish := func(err error) {
+			// 暂时用默认的grpc的默认err收敛
+			codes := ecode.ExtractCodes(err)
+			metric.ClientHandleCounter.Inc(typ, name, method, cc.Target(), codes.GetMessage())
+			metric.ClientHandleHistogram.Observe(time.Since(beg).Seconds(), typ, name, method, cc.Target())
+		}
 
-		// 暂时用默认的grpc的默认err收敛
-		codes := ecode.ExtractCodes(err)
-		metric.ClientHandleCounter.Inc(metric.TypeGRPCStream, name, method, cc.Target(), codes.GetMessage())
-		metric.ClientHandleHistogram.Observe(time.Since(beg).Seconds(), metric.TypeGRPCStream, name, method, cc.Target())
-		return clientStream, err
+		clientStream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			onFinish(err)
+			return nil, err
+		}
+		return newMonitoredClientStream(ctx, clientStream, desc, func(direction string, size int) {
+			metric.ClientStreamMsgCounter.Inc(typ, name, method, cc.Target(), direction)
+		}, onFinish), nil
 	}
 }
 
@@ -144,15 +156,26 @@ func aidUnaryClientInterceptor() grpc.UnaryClientInterceptor {
 }
 
 // timeoutUnaryClientInterceptor gRPC客户端超时拦截器
-func timeoutUnaryClientInterceptor(_logger *xlog.Logger, timeout time.Duration, slowThreshold time.Duration) grpc.UnaryClientInterceptor {
+// 继承上游的 deadline 时，扣除 margin 作为上游处理响应的余量
+func timeoutUnaryClientInterceptor(_logger *xlog.Logger, timeout time.Duration, margin time.Duration, slowThreshold time.Duration) grpc.UnaryClientInterceptor {
 	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
 		now := time.Now()
 		// 若无自定义超时设置，默认设置超时
-		_, ok := ctx.Deadline()
+		deadline, ok := ctx.Deadline()
 		if !ok {
 			var cancel context.CancelFunc
 			ctx, cancel = context.WithTimeout(ctx, timeout)
 			defer cancel()
+		} else {
+			// 剩余预算不足，直接失败，不再请求下游
+			if deadline.Sub(now) <= margin {
+				return status.Errorf(codes.DeadlineExceeded, "deadline budget exhausted before calling %s", method)
+			}
+			if margin > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithDeadline(ctx, deadline.Add(-margin))
+				defer cancel()
+			}
 		}
 
 		err := invoker(ctx, method, req, reply, cc, opts...)
@@ -176,57 +199,294 @@ func timeoutUnaryClientInterceptor(_logger *xlog.Logger, timeout time.Duration,
 }
 
 // loggerUnaryClientInterceptor gRPC客户端日志中间件
-func loggerUnaryClientInterceptor(_logger *xlog.Logger, name string, accessInterceptorLevel string) grpc.UnaryClientInterceptor {
+func loggerUnaryClientInterceptor(_logger *xlog.Logger, name string, accessInterceptorLevel string, slowThreshold time.Duration, policy *xlog.AccessPolicy) grpc.UnaryClientInterceptor {
 	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
 		beg := time.Now()
 		err := invoker(ctx, method, req, reply, cc, opts...)
+		du := time.Since(beg)
 
 		spbStatus := ecode.ExtractCodes(err)
+		var result = xlog.AccessResultNormal
+		switch {
+		// 只记录系统级别错误
+		case err != nil && spbStatus.Code < ecode.EcodeNum:
+			result = xlog.AccessResultError
+		// 业务报错只做warning
+		case err != nil:
+			result = xlog.AccessResultBizError
+		case slowThreshold > time.Duration(0) && du > slowThreshold:
+			result = xlog.AccessResultSlow
+		case accessInterceptorLevel != "info":
+			return err
+		}
+
+		if !policy.Enabled(result, method) {
+			return err
+		}
+
+		var fields = []xlog.Field{
+			xlog.FieldType("unary"),
+			xlog.FieldCode(spbStatus.Code),
+			xlog.FieldName(name),
+			xlog.FieldMethod(method),
+			xlog.FieldCost(du),
+			policy.Payload("req", req),
+			policy.Payload("reply", reply),
+		}
 		if err != nil {
-			// 只记录系统级别错误
-			if spbStatus.Code < ecode.EcodeNum {
-				// 只记录系统级别错误
-				_logger.Error(
-					"access",
-					xlog.FieldType("unary"),
-					xlog.FieldCode(spbStatus.Code),
-					xlog.FieldStringErr(spbStatus.Message),
-					xlog.FieldName(name),
-					xlog.FieldMethod(method),
-					xlog.FieldCost(time.Since(beg)),
-					xlog.Any("req", json.RawMessage(xstring.Json(req))),
-					xlog.Any("reply", json.RawMessage(xstring.Json(reply))),
-				)
-			} else {
-				// 业务报错只做warning
-				_logger.Warn(
-					"access",
-					xlog.FieldType("unary"),
-					xlog.FieldCode(spbStatus.Code),
-					xlog.FieldStringErr(spbStatus.Message),
-					xlog.FieldName(name),
-					xlog.FieldMethod(method),
-					xlog.FieldCost(time.Since(beg)),
-					xlog.Any("req", json.RawMessage(xstring.Json(req))),
-					xlog.Any("reply", json.RawMessage(xstring.Json(reply))),
-				)
+			fields = append(fields, xlog.FieldStringErr(spbStatus.Message))
+		}
+		policy.Log(_logger, result, "access", fields...)
+		return err
+	}
+}
+
+// monitoredClientStream wraps grpc.ClientStream, onMsg is called on each message
+// sent or received, and onFinish is called once when stream ends
+type monitoredClientStream struct {
+	grpc.ClientStream
+	desc     *grpc.StreamDesc
+	onMsg    func(direction string, size int)
+	onFinish func(err error)
+	donec    chan struct{}
+
+	mu       sync.Mutex
+	finished bool
+	err      error
+	// outer is the monitored stream wrapping this one, finished along with this one
+	outer *monitoredClientStream
+}
+
+func newMonitoredClientStream(ctx context.Context, cs grpc.ClientStream, desc *grpc.StreamDesc, onMsg func(direction string, size int), onFinish func(err error)) *monitoredClientStream {
+	mcs := &monitoredClientStream{
+		ClientStream: cs,
+		desc:         desc,
+		onMsg:        onMsg,
+		onFinish:     onFinish,
+		donec:        make(chan struct{}),
+	}
+	// 内层 stream 的 ctx 派生自外层, 只由最内层监听 ctx 并逐层通知, 每个 stream 只需一个 goroutine
+	if inner, ok := cs.(*monitoredClientStream); ok {
+		inner.mu.Lock()
+		finished, err := inner.finished, inner.err
+		if !finished {
+			inner.outer = mcs
+		}
+		inner.mu.Unlock()
+		if finished {
+			mcs.finish(err)
+		}
+		return mcs
+	}
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				mcs.finish(ctx.Err())
+			case <-mcs.donec:
 			}
-			return err
+		}()
+	}
+	return mcs
+}
+
+func (mcs *monitoredClientStream) finish(err error) {
+	mcs.mu.Lock()
+	if mcs.finished {
+		mcs.mu.Unlock()
+		return
+	}
+	mcs.finished, mcs.err = true, err
+	outer := mcs.outer
+	mcs.mu.Unlock()
+
+	close(mcs.donec)
+	mcs.onFinish(err)
+	if outer != nil {
+		outer.finish(err)
+	}
+}
+
+// SendMsg implements grpc.ClientStream
+func (mcs *monitoredClientStream) SendMsg(m interface{}) error {
+	err := mcs.ClientStream.SendMsg(m)
+	switch {
+	case err == nil:
+		if mcs.onMsg != nil {
+			mcs.onMsg("sent", msgSize(m))
+		}
+	// io.EOF 表示服务端已结束 stream，真实状态由 RecvMsg 返回
+	case err != io.EOF:
+		mcs.finish(err)
+	}
+	return err
+}
+
+// RecvMsg implements grpc.ClientStream
+func (mcs *monitoredClientStream) RecvMsg(m interface{}) error {
+	err := mcs.ClientStream.RecvMsg(m)
+	switch {
+	case err == nil:
+		if mcs.onMsg != nil {
+			mcs.onMsg("received", msgSize(m))
+		}
+		if !mcs.desc.ServerStreams {
+			mcs.finish(nil)
+		}
+	case err == io.EOF:
+		mcs.finish(nil)
+	default:
+		mcs.finish(err)
+	}
+	return err
+}
+
+func msgSize(m interface{}) int {
+	if msg, ok := m.(proto.Message); ok {
+		return proto.Size(msg)
+	}
+	return 0
+}
+
+func streamType(desc *grpc.StreamDesc) string {
+	switch {
+	case desc.ClientStreams && desc.ServerStreams:
+		return metric.TypeGRPCBidiStream
+	case desc.ClientStreams:
+		return metric.TypeGRPCClientStream
+	case desc.ServerStreams:
+		return metric.TypeGRPCServerStream
+	}
+	return metric.TypeGRPCStream
+}
+
+func aidStreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		md, ok := metadata.FromOutgoingContext(ctx)
+		clientAidMD := metadata.Pairs("aid", pkg.AppID())
+		if ok {
+			md = metadata.Join(md, clientAidMD)
+		} else {
+			md = clientAidMD
+		}
+		ctx = metadata.NewOutgoingContext(ctx, md)
+
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
+
+// timeoutStreamClientInterceptor stream 通常是长连接，不设置默认超时，
+// 只在继承上游 deadline 时扣除 margin
+func timeoutStreamClientInterceptor(margin time.Duration) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+		if time.Until(deadline) <= margin {
+			return nil, status.Errorf(codes.DeadlineExceeded, "deadline budget exhausted before calling %s", method)
+		}
+		if margin <= 0 {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+
+		ctx, cancel := context.WithDeadline(ctx, deadline.Add(-margin))
+		clientStream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		return newMonitoredClientStream(ctx, clientStream, desc, nil, func(error) { cancel() }), nil
+	}
+}
+
+func traceStreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		md, ok := metadata.FromOutgoingContext(ctx)
+		if !ok {
+			md = metadata.New(nil)
 		} else {
-			if accessInterceptorLevel == "info" {
-				_logger.Info(
-					"access",
-					xlog.FieldType("unary"),
-					xlog.FieldCode(spbStatus.Code),
-					xlog.FieldName(name),
-					xlog.FieldMethod(method),
-					xlog.FieldCost(time.Since(beg)),
-					xlog.Any("req", json.RawMessage(xstring.Json(req))),
-					xlog.Any("reply", json.RawMessage(xstring.Json(reply))),
-				)
+			md = md.Copy()
+		}
+
+		span, ctx := trace.StartSpanFromContext(
+			ctx,
+			method,
+			trace.TagSpanKind("client"),
+			trace.TagComponent("grpc"),
+			trace.CustomTag("grpc.stream_type", streamType(desc)),
+		)
+		onFinish := func(err error) {
+			if err != nil {
+				span.SetTag("response_code", status.Code(err))
+				ext.Error.Set(span, true)
+				span.LogFields(trace.String("event", "error"), trace.String("message", err.Error()))
+			}
+			span.Finish()
+		}
+
+		clientStream, err := streamer(trace.MetadataInjector(ctx, md), desc, cc, method, opts...)
+		if err != nil {
+			onFinish(err)
+			return nil, err
+		}
+		return newMonitoredClientStream(ctx, clientStream, desc, nil, onFinish), nil
+	}
+}
+
+// loggerStreamClientInterceptor gRPC客户端 stream 日志中间件，stream 结束时记录一条 access 日志
+// stream 通常是长连接，不区分慢请求
+func loggerStreamClientInterceptor(_logger *xlog.Logger, name string, accessInterceptorLevel string, policy *xlog.AccessPolicy) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		beg := time.Now()
+		var mu sync.Mutex
+		var sent, received int
+		onFinish := func(err error) {
+			du := time.Since(beg)
+			spbStatus := ecode.ExtractCodes(err)
+			var result = xlog.AccessResultNormal
+			switch {
+			case err != nil && spbStatus.Code < ecode.EcodeNum:
+				result = xlog.AccessResultError
+			case err != nil:
+				result = xlog.AccessResultBizError
+			case accessInterceptorLevel != "info":
+				return
+			}
+			if !policy.Enabled(result, method) {
+				return
+			}
+
+			mu.Lock()
+			var fields = []xlog.Field{
+				xlog.FieldType(streamType(desc)),
+				xlog.FieldCode(spbStatus.Code),
+				xlog.FieldName(name),
+				xlog.FieldMethod(method),
+				xlog.FieldCost(du),
+				xlog.Int("sent", sent),
+				xlog.Int("received", received),
+			}
+			mu.Unlock()
+			if err != nil {
+				fields = append(fields, xlog.FieldStringErr(spbStatus.Message))
 			}
+			policy.Log(_logger, result, "access", fields...)
 		}
 
-		return nil
+		clientStream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			onFinish(err)
+			return nil, err
+		}
+		return newMonitoredClientStream(ctx, clientStream, desc, func(direction string, size int) {
+			mu.Lock()
+			if direction == "sent" {
+				sent++
+			} else {
+				received++
+			}
+			mu.Unlock()
+		}, onFinish), nil
 	}
 }
@@ -0,0 +1,125 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/util/xtest/proto/testproto"
+	"github.com/douyu/jupiter/pkg/util/xtest/server/yell"
+	"github.com/douyu/jupiter/pkg/xlog"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestStreamClientInterceptors(t *testing.T) {
+	var finished = make(chan error, 1)
+	var sent, received int
+	captureInterceptor := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return newMonitoredClientStream(ctx, cs, desc, func(direction string, size int) {
+			if direction == "sent" {
+				sent++
+			} else {
+				received++
+			}
+		}, func(err error) {
+			finished <- err
+		}), nil
+	}
+
+	cc, err := grpc.Dial(directAddr, grpc.WithInsecure(), grpc.WithChainStreamInterceptor(
+		aidStreamClientInterceptor(),
+		timeoutStreamClientInterceptor(time.Millisecond),
+		traceStreamClientInterceptor(),
+		loggerStreamClientInterceptor(xlog.JupiterLogger, "test", "info", xlog.DefaultAccessConfig().Build()),
+		metricStreamClientInterceptor("test"),
+		captureInterceptor,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+
+	Convey("test stream finished with messages counted", t, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		stream, err := testproto.NewGreeterClient(cc).StreamHello(ctx)
+		So(err, ShouldBeNil)
+		So(stream.Send(&testproto.HelloRequest{Name: "bye"}), ShouldBeNil)
+		reply, err := stream.Recv()
+		So(err, ShouldBeNil)
+		So(reply.Message, ShouldEqual, yell.RespBye.Message)
+		_, err = stream.Recv()
+		So(err, ShouldNotBeNil)
+		So(<-finished, ShouldBeNil)
+		So(sent, ShouldEqual, 1)
+		So(received, ShouldEqual, 1)
+	})
+
+	Convey("test stream finished with error", t, func() {
+		stream, err := testproto.NewGreeterClient(cc).StreamHello(context.Background())
+		So(err, ShouldBeNil)
+		So(stream.Send(&testproto.HelloRequest{Name: "needErr"}), ShouldBeNil)
+		_, err = stream.Recv()
+		So(err, ShouldNotBeNil)
+		So(status.Code(<-finished), ShouldEqual, status.Code(err))
+	})
+
+	Convey("test stream rejected when deadline budget exhausted", t, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Microsecond)
+		defer cancel()
+		_, err := testproto.NewGreeterClient(cc).StreamHello(ctx)
+		So(status.Code(err), ShouldEqual, codes.DeadlineExceeded)
+	})
+}
+
+func TestMonitoredClientStream(t *testing.T) {
+	desc := &grpc.StreamDesc{ServerStreams: true}
+
+	Convey("test nested streams finished by the innermost watcher", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		var finished = make(chan error, 2)
+		onFinish := func(err error) { finished <- err }
+		inner := newMonitoredClientStream(ctx, nil, desc, nil, onFinish)
+		outer := newMonitoredClientStream(ctx, inner, desc, nil, onFinish)
+		So(inner.outer, ShouldEqual, outer)
+
+		cancel()
+		So(<-finished, ShouldEqual, context.Canceled)
+		So(<-finished, ShouldEqual, context.Canceled)
+		<-outer.donec
+	})
+
+	Convey("test stream wrapping a finished stream", t, func() {
+		var finished = make(chan error, 2)
+		onFinish := func(err error) { finished <- err }
+		inner := newMonitoredClientStream(context.Background(), nil, desc, nil, onFinish)
+		inner.finish(nil)
+		So(<-finished, ShouldBeNil)
+
+		outer := newMonitoredClientStream(context.Background(), inner, desc, nil, onFinish)
+		So(<-finished, ShouldBeNil)
+		<-outer.donec
+	})
+}
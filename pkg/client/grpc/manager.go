@@ -0,0 +1,382 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/metric"
+	"github.com/douyu/jupiter/pkg/server/governor"
+	"github.com/douyu/jupiter/pkg/xlog"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+var (
+	// ErrConnNotRegistered client config not registered in manager
+	ErrConnNotRegistered = errors.New("grpc client not registered")
+	// ErrConnRegistered client config already registered in manager
+	ErrConnRegistered = errors.New("grpc client already registered")
+	// ErrConnClosed conn manager closed
+	ErrConnClosed = errors.New("grpc client closed")
+	// ErrConnNotReady conn not ready before context done
+	ErrConnNotReady = errors.New("grpc client not ready")
+)
+
+// DefaultConnManager ...
+var DefaultConnManager = NewConnManager()
+
+func init() {
+	governor.HandleFunc("/debug/client/grpc/conns", func(w http.ResponseWriter, r *http.Request) {
+		_ = jsoniter.NewEncoder(w).Encode(DefaultConnManager.Stats())
+	})
+}
+
+// ConnError is returned by ConnManager instead of panicking when conn is unavailable
+type ConnError struct {
+	Name    string
+	Address string
+	Err     error
+}
+
+// Error ...
+func (e *ConnError) Error() string {
+	return fmt.Sprintf("grpc client %s(%s): %v", e.Name, e.Address, e.Err)
+}
+
+// Cause implements errors.Cause
+func (e *ConnError) Cause() error {
+	return e.Err
+}
+
+// Unwrap ...
+func (e *ConnError) Unwrap() error {
+	return e.Err
+}
+
+// ConnStats ...
+type ConnStats struct {
+	Name    string `json:"name"`
+	Key     string `json:"key"`
+	Address string `json:"address"`
+	State   string `json:"state"`
+	Dialed  bool   `json:"dialed"`
+	Error   string `json:"error"`
+}
+
+// ConnManager manages client conns keyed by Config.Name, conns are dialed without blocking,
+// lazily on first use or in background on register, and shared by all users of the same name
+type ConnManager struct {
+	mu     sync.RWMutex
+	conns  map[string]*managedConn
+	closed bool
+	watch  sync.Once
+	// closeDelay old conn is closed after closeDelay on refresh, leaving time for in-flight requests
+	closeDelay time.Duration
+}
+
+// NewConnManager ...
+func NewConnManager() *ConnManager {
+	return &ConnManager{
+		conns:      make(map[string]*managedConn),
+		closeDelay: 10 * time.Second,
+	}
+}
+
+// Register registers config keyed by config.Name, conn is dialed in background
+// unless config.Lazy is set
+func (m *ConnManager) Register(config *Config) error {
+	return m.register(config, "")
+}
+
+// RegisterStd registers config of "jupiter.client."+name, conn is refreshed when the config changes
+func (m *ConnManager) RegisterStd(name string) error {
+	key := "jupiter.client." + name
+	config, err := parseConfig(conf.Get(key), key)
+	if err != nil {
+		return &ConnError{Name: name, Err: err}
+	}
+	if config.Name == "" {
+		config.Name = name
+	}
+	m.watch.Do(func() {
+		conf.OnChange(m.onConfigChange)
+	})
+	return m.register(config, key)
+}
+
+func (m *ConnManager) register(config *Config, key string) error {
+	mc := newManagedConn(config, key)
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return &ConnError{Name: config.Name, Address: config.Address, Err: ErrConnClosed}
+	}
+	if _, ok := m.conns[config.Name]; ok {
+		m.mu.Unlock()
+		return &ConnError{Name: config.Name, Address: config.Address, Err: ErrConnRegistered}
+	}
+	m.conns[config.Name] = mc
+	m.mu.Unlock()
+
+	if !config.Lazy {
+		_, err := mc.dial()
+		return err
+	}
+	return nil
+}
+
+// Get returns conn of name, dialing it on first use
+func (m *ConnManager) Get(name string) (*grpc.ClientConn, error) {
+	m.mu.RLock()
+	mc, ok := m.conns[name]
+	closed := m.closed
+	m.mu.RUnlock()
+	if closed {
+		return nil, &ConnError{Name: name, Err: ErrConnClosed}
+	}
+	if !ok {
+		return nil, &ConnError{Name: name, Err: ErrConnNotRegistered}
+	}
+	return mc.dial()
+}
+
+// WaitReady returns conn of name once it's ready, or ConnError if ctx done before that
+func (m *ConnManager) WaitReady(ctx context.Context, name string) (*grpc.ClientConn, error) {
+	cc, err := m.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		state := cc.GetState()
+		if state == connectivity.Ready {
+			return cc, nil
+		}
+		if state == connectivity.Shutdown {
+			return nil, &ConnError{Name: name, Address: cc.Target(), Err: ErrConnClosed}
+		}
+		if !cc.WaitForStateChange(ctx, state) {
+			return nil, &ConnError{Name: name, Address: cc.Target(), Err: errors.Wrapf(ErrConnNotReady, "state %s", state)}
+		}
+	}
+}
+
+// Refresh replaces conn of config.Name with a new one dialed by config,
+// the old conn is closed after a delay
+func (m *ConnManager) Refresh(config *Config) error {
+	m.mu.Lock()
+	old, ok := m.conns[config.Name]
+	if m.closed || !ok {
+		m.mu.Unlock()
+		if m.closed {
+			return &ConnError{Name: config.Name, Address: config.Address, Err: ErrConnClosed}
+		}
+		return &ConnError{Name: config.Name, Address: config.Address, Err: ErrConnNotRegistered}
+	}
+	mc := newManagedConn(config, old.key)
+	m.conns[config.Name] = mc
+	m.mu.Unlock()
+
+	config.logger.Info("refresh grpc client", xlog.FieldName(config.Name), xlog.FieldAddr(config.Address))
+	if cc := old.stop(); cc != nil {
+		time.AfterFunc(m.closeDelay, func() {
+			_ = cc.Close()
+		})
+	}
+	if !config.Lazy {
+		_, err := mc.dial()
+		return err
+	}
+	return nil
+}
+
+// Close closes all conns, conns can't be used after close
+func (m *ConnManager) Close() error {
+	m.mu.Lock()
+	conns := m.conns
+	m.conns = make(map[string]*managedConn)
+	m.closed = true
+	m.mu.Unlock()
+
+	var errs []string
+	for _, mc := range conns {
+		if cc := mc.stop(); cc != nil {
+			if err := cc.Close(); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("close grpc clients: %v", errs)
+	}
+	return nil
+}
+
+// Stats ...
+func (m *ConnManager) Stats() []ConnStats {
+	m.mu.RLock()
+	var rets = make([]ConnStats, 0, len(m.conns))
+	for _, mc := range m.conns {
+		rets = append(rets, mc.Stats())
+	}
+	m.mu.RUnlock()
+	sort.Slice(rets, func(i, j int) bool {
+		return rets[i].Name < rets[j].Name
+	})
+	return rets
+}
+
+func (m *ConnManager) onConfigChange(c *conf.Configuration) {
+	m.mu.RLock()
+	var changed []*managedConn
+	for _, mc := range m.conns {
+		if mc.key != "" && mc.raw != snapshotConfig(c.Get(mc.key)) {
+			changed = append(changed, mc)
+		}
+	}
+	m.mu.RUnlock()
+
+	for _, mc := range changed {
+		config, err := parseConfig(c.Get(mc.key), mc.key)
+		if err != nil {
+			mc.config.logger.Error("refresh grpc client", xlog.FieldName(mc.name), xlog.FieldKey(mc.key), xlog.FieldErr(err))
+			continue
+		}
+		config.Name = mc.name
+		if err := m.Refresh(config); err != nil {
+			mc.config.logger.Error("refresh grpc client", xlog.FieldName(mc.name), xlog.FieldKey(mc.key), xlog.FieldErr(err))
+		}
+	}
+}
+
+func parseConfig(raw interface{}, key string) (*Config, error) {
+	var config = DefaultConfig()
+	if raw == nil {
+		return nil, errors.Errorf("config %s not found", key)
+	}
+	if err := conf.UnmarshalKey(key, &config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func snapshotConfig(raw interface{}) string {
+	bs, _ := jsoniter.Marshal(raw)
+	return string(bs)
+}
+
+type managedConn struct {
+	name   string
+	key    string
+	raw    string
+	config *Config
+
+	once sync.Once
+	mu   sync.Mutex
+	cc   *grpc.ClientConn
+	err  error
+}
+
+func newManagedConn(config *Config, key string) *managedConn {
+	mc := &managedConn{
+		name:   config.Name,
+		key:    key,
+		config: config,
+	}
+	if key != "" {
+		mc.raw = snapshotConfig(conf.Get(key))
+	}
+	return mc
+}
+
+func (mc *managedConn) dial() (*grpc.ClientConn, error) {
+	mc.once.Do(func() {
+		config := *mc.config
+		config.dialOptions = append([]grpc.DialOption{}, mc.config.dialOptions...)
+		config.Block = false
+		config.buildInterceptors()
+		cc, err := dialGRPCClient(&config)
+
+		mc.mu.Lock()
+		defer mc.mu.Unlock()
+		if err != nil {
+			mc.err = &ConnError{Name: mc.name, Address: config.Address, Err: err}
+			config.logger.Error("dial grpc server", xlog.FieldName(mc.name), xlog.FieldAddr(config.Address), xlog.FieldErr(err))
+			return
+		}
+		mc.cc = cc
+		config.logger.Info("start grpc client", xlog.FieldName(mc.name), xlog.FieldAddr(config.Address))
+		go mc.watchState(cc)
+	})
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.cc, mc.err
+}
+
+// stop prevents conn from being dialed and returns the dialed one
+func (mc *managedConn) stop() *grpc.ClientConn {
+	mc.once.Do(func() {
+		mc.mu.Lock()
+		mc.err = &ConnError{Name: mc.name, Address: mc.config.Address, Err: ErrConnClosed}
+		mc.mu.Unlock()
+	})
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.cc
+}
+
+func (mc *managedConn) watchState(cc *grpc.ClientConn) {
+	state := cc.GetState()
+	for {
+		metric.ClientConnStateGauge.Set(float64(state), mc.name, mc.config.Address)
+		if state == connectivity.Shutdown {
+			return
+		}
+		if !cc.WaitForStateChange(context.Background(), state) {
+			return
+		}
+		state = cc.GetState()
+		mc.config.logger.Info("grpc client state changed", xlog.FieldName(mc.name), xlog.FieldAddr(mc.config.Address), xlog.String("state", state.String()))
+	}
+}
+
+// Stats ...
+func (mc *managedConn) Stats() ConnStats {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	stats := ConnStats{
+		Name:    mc.name,
+		Key:     mc.key,
+		Address: mc.config.Address,
+		State:   "idle",
+		Dialed:  mc.cc != nil,
+	}
+	if mc.cc != nil {
+		stats.State = mc.cc.GetState().String()
+	}
+	if mc.err != nil {
+		stats.Error = mc.err.Error()
+	}
+	return stats
+}
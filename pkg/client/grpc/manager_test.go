@@ -0,0 +1,75 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/util/xtest/proto/testproto"
+	"github.com/douyu/jupiter/pkg/util/xtls"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/connectivity"
+)
+
+func TestConnManager(t *testing.T) {
+	m := NewConnManager()
+	m.closeDelay = 10 * time.Millisecond
+
+	_, err := m.Get("missing")
+	assert.Equal(t, ErrConnNotRegistered, errors.Cause(err))
+
+	config := DefaultConfig()
+	config.Name = "greeter"
+	config.Address = directAddr
+	config.Lazy = true
+	assert.Nil(t, m.Register(config))
+	assert.Equal(t, ErrConnRegistered, errors.Cause(m.Register(config)))
+	assert.False(t, m.Stats()[0].Dialed)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	cc, err := m.WaitReady(ctx, "greeter")
+	assert.Nil(t, err)
+	_, err = testproto.NewGreeterClient(cc).SayHello(ctx, &testproto.HelloRequest{Name: "hello"})
+	assert.Nil(t, err)
+
+	same, err := m.Get("greeter")
+	assert.Nil(t, err)
+	assert.True(t, cc == same)
+
+	// refresh replaces conn and closes the old one after delay
+	refreshed := DefaultConfig()
+	refreshed.Name = "greeter"
+	refreshed.Address = directAddr
+	assert.Nil(t, m.Refresh(refreshed))
+	newcc, err := m.Get("greeter")
+	assert.Nil(t, err)
+	assert.True(t, cc != newcc)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, connectivity.Shutdown, cc.GetState())
+
+	assert.Nil(t, m.Close())
+	assert.Equal(t, connectivity.Shutdown, newcc.GetState())
+	_, err = m.Get("greeter")
+	assert.Equal(t, ErrConnClosed, errors.Cause(err))
+}
+
+func TestConnManagerDialError(t *testing.T) {
+	m := NewConnManager()
+	defer m.Close()
+
+	config := DefaultConfig()
+	config.Name = "bad"
+	config.Address = directAddr
+	config.Lazy = true
+	config.TLS = &xtls.Config{Enable: true, CAFile: "not_exist.pem"}
+	assert.Nil(t, m.Register(config))
+
+	// no panic, typed error returned on first use
+	_, err := m.Get("bad")
+	connErr, ok := err.(*ConnError)
+	assert.True(t, ok)
+	assert.Equal(t, "bad", connErr.Name)
+	assert.NotEmpty(t, m.Stats()[0].Error)
+}
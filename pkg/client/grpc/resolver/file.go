@@ -0,0 +1,200 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resolver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/util/xgo"
+	"github.com/douyu/jupiter/pkg/xlog"
+
+	"github.com/BurntSushi/toml"
+	"github.com/fsnotify/fsnotify"
+	"google.golang.org/grpc/resolver"
+)
+
+const (
+	// SchemeFile scheme of file resolver, file:///etc/nodes.json for absolute path,
+	// file://./conf/nodes.toml for path relative to working directory
+	SchemeFile = "file"
+)
+
+func init() {
+	resolver.Register(&fileBuilder{})
+}
+
+type fileBuilder struct{}
+
+// Build ...
+func (b *fileBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	path := "/" + target.Endpoint
+	if target.Authority == "." {
+		path = target.Endpoint
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := ParseFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cc.UpdateState(newState(nodes, target.Endpoint))
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	// watch dir, so that file replaced by rename is also noticed
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &fileResolver{
+		path:    path,
+		name:    target.Endpoint,
+		cc:      cc,
+		watcher: watcher,
+		ctx:     ctx,
+		cancel:  cancel,
+		rn:      make(chan struct{}, 1),
+		nodes:   nodes,
+	}
+	xgo.Go(r.watch)
+	return r, nil
+}
+
+// Scheme ...
+func (b *fileBuilder) Scheme() string {
+	return SchemeFile
+}
+
+type fileResolver struct {
+	path    string
+	name    string
+	cc      resolver.ClientConn
+	watcher *fsnotify.Watcher
+	ctx     context.Context
+	cancel  context.CancelFunc
+	rn      chan struct{}
+	nodes   []server.ServiceInfo // last resolved nodes
+}
+
+// ResolveNow ...
+func (r *fileResolver) ResolveNow(options resolver.ResolveNowOptions) {
+	select {
+	case r.rn <- struct{}{}:
+	default:
+	}
+}
+
+// Close ...
+func (r *fileResolver) Close() {
+	r.cancel()
+}
+
+func (r *fileResolver) watch() {
+	defer r.watcher.Close()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case event := <-r.watcher.Events:
+			// kubernetes configmap volume swaps files with symlink `..data`,
+			// so any change in the dir may change the file, the content is compared after parsing
+			const changedMask = fsnotify.Write | fsnotify.Create | fsnotify.Rename | fsnotify.Remove
+			if event.Op&changedMask == 0 {
+				continue
+			}
+		case err := <-r.watcher.Errors:
+			xlog.Error("watch file", xlog.FieldMod("resolver.file"), xlog.String("path", r.path), xlog.FieldErr(err))
+			continue
+		case <-r.rn:
+		}
+
+		nodes, err := ParseFile(r.path)
+		if err != nil {
+			// keep the last nodes when file is invalid, e.g. being written
+			xlog.Error("parse file", xlog.FieldMod("resolver.file"), xlog.String("path", r.path), xlog.FieldErr(err))
+			r.cc.ReportError(err)
+			continue
+		}
+		if reflect.DeepEqual(nodes, r.nodes) {
+			continue
+		}
+		r.nodes = nodes
+		r.cc.UpdateState(newState(nodes, r.name))
+	}
+}
+
+// fileNodes content of file, json file may also be a list of nodes
+type fileNodes struct {
+	Nodes []server.ServiceInfo `json:"nodes" toml:"nodes"`
+}
+
+// ParseFile parses nodes from json or toml file by extension
+func ParseFile(path string) ([]server.ServiceInfo, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file fileNodes
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		err = toml.Unmarshal(content, &file)
+	case ".json":
+		if trimmed := strings.TrimSpace(string(content)); strings.HasPrefix(trimmed, "[") {
+			err = json.Unmarshal(content, &file.Nodes)
+		} else {
+			err = json.Unmarshal(content, &file)
+		}
+	default:
+		return nil, fmt.Errorf("file resolver: unsupported file %s", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("file resolver: parse %s: %v", path, err)
+	}
+
+	var nodes = make([]server.ServiceInfo, 0, len(file.Nodes))
+	for _, node := range file.Nodes {
+		if node.Address == "" {
+			return nil, fmt.Errorf("file resolver: node without address in %s", path)
+		}
+		// 未配置的字段使用默认值
+		defaults := newServiceInfo(node.Name, node.Address)
+		if node.Scheme == "" {
+			node.Scheme = defaults.Scheme
+		}
+		if node.Weight == 0 {
+			node.Weight = defaults.Weight
+		}
+		if node.Metadata == nil {
+			node.Metadata = defaults.Metadata
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
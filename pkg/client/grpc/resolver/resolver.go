@@ -16,17 +16,23 @@ package resolver
 
 import (
 	"context"
+	"sync"
 
 	"github.com/douyu/jupiter/pkg/constant"
 	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/server"
 	"github.com/douyu/jupiter/pkg/util/xgo"
 
 	"google.golang.org/grpc/attributes"
 	"google.golang.org/grpc/resolver"
 )
 
+// registries registries of resolvers, keyed by scheme
+var registries sync.Map
+
 // Register ...
 func Register(name string, reg registry.Registry) {
+	registries.Store(name, reg)
 	resolver.Register(&baseBuilder{
 		name: name,
 		reg:  reg,
@@ -59,11 +65,7 @@ func (b *baseBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts
 					),
 				}
 				for _, node := range endpoint.Nodes {
-					var address resolver.Address
-					address.Addr = node.Address
-					address.ServerName = target.Endpoint
-					address.Attributes = attributes.New(constant.KeyServiceInfo, node)
-					state.Addresses = append(state.Addresses, address)
+					state.Addresses = append(state.Addresses, newAddress(node, target.Endpoint))
 				}
 				cc.UpdateState(state)
 			case <-stop:
@@ -77,6 +79,15 @@ func (b *baseBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts
 	}, nil
 }
 
+// Registry returns registry of resolver registered with scheme
+func Registry(scheme string) (registry.Registry, bool) {
+	reg, ok := registries.Load(scheme)
+	if !ok {
+		return nil, false
+	}
+	return reg.(registry.Registry), true
+}
+
 // Scheme ...
 func (b baseBuilder) Scheme() string {
 	return b.name
@@ -91,3 +102,37 @@ func (b *baseResolver) ResolveNow(options resolver.ResolveNowOptions) {}
 
 // Close ...
 func (b *baseResolver) Close() { b.stop <- struct{}{} }
+
+// newAddress returns address of node with service info attributes, used by balancers for routing
+func newAddress(node server.ServiceInfo, serverName string) resolver.Address {
+	return resolver.Address{
+		Addr:       node.Address,
+		ServerName: serverName,
+		Attributes: attributes.New(constant.KeyServiceInfo, node),
+	}
+}
+
+// newState returns resolver state of nodes
+func newState(nodes []server.ServiceInfo, serverName string) resolver.State {
+	var state = resolver.State{
+		Addresses: make([]resolver.Address, 0, len(nodes)),
+	}
+	for _, node := range nodes {
+		state.Addresses = append(state.Addresses, newAddress(node, serverName))
+	}
+	return state
+}
+
+// newServiceInfo returns service info of address with defaults of registered providers
+func newServiceInfo(name, address string) server.ServiceInfo {
+	return server.ServiceInfo{
+		Name:     name,
+		Scheme:   "grpc",
+		Address:  address,
+		Weight:   100,
+		Enable:   true,
+		Healthy:  true,
+		Metadata: make(map[string]string),
+		Kind:     constant.ServiceProvider,
+	}
+}
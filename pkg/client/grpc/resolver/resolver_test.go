@@ -14,8 +14,175 @@
 
 package resolver
 
-import "testing"
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/constant"
+	"github.com/douyu/jupiter/pkg/registry/memory"
+	"github.com/douyu/jupiter/pkg/server"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+)
 
 func Test_baseResolver(t *testing.T) {
+	store := memory.NewStore()
+	Register("memory_test", memory.New(store))
+	store.Put(&server.ServiceInfo{Name: "demo", Scheme: "grpc", Address: "127.0.0.1:9091", Weight: 100, Enable: true})
+
+	cc := &testClientConn{}
+	r, err := resolver.Get("memory_test").Build(resolver.Target{Scheme: "memory_test", Endpoint: "demo"}, cc, resolver.BuildOptions{})
+	assert.Nil(t, err)
+	defer r.Close()
+	assert.Eventually(t, func() bool { return len(cc.nodes()) == 1 }, time.Second, 10*time.Millisecond)
+
+	store.Put(&server.ServiceInfo{Name: "demo", Scheme: "grpc", Address: "127.0.0.1:9092", Weight: 100, Enable: true})
+	assert.Eventually(t, func() bool { return len(cc.nodes()) == 2 }, time.Second, 10*time.Millisecond)
+}
+
+type testClientConn struct {
+	mu     sync.Mutex
+	states []resolver.State
+	err    error
+}
+
+func (cc *testClientConn) UpdateState(state resolver.State) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.states = append(cc.states, state)
+}
+
+func (cc *testClientConn) ReportError(err error) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.err = err
+}
+
+func (cc *testClientConn) NewAddress(addresses []resolver.Address) {}
+
+func (cc *testClientConn) NewServiceConfig(serviceConfig string) {}
+
+func (cc *testClientConn) ParseServiceConfig(serviceConfigJSON string) *serviceconfig.ParseResult {
+	return nil
+}
+
+// nodes returns service infos of the last state
+func (cc *testClientConn) nodes() []server.ServiceInfo {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if len(cc.states) == 0 {
+		return nil
+	}
+	var nodes []server.ServiceInfo
+	for _, addr := range cc.states[len(cc.states)-1].Addresses {
+		nodes = append(nodes, addr.Attributes.Value(constant.KeyServiceInfo).(server.ServiceInfo))
+	}
+	return nodes
+}
+
+func Test_staticResolver(t *testing.T) {
+	cc := &testClientConn{}
+	r, err := resolver.Get(SchemeStatic).Build(resolver.Target{
+		Scheme:   SchemeStatic,
+		Endpoint: "127.0.0.1:9091?weight=200&zone=z1&group=red&env=test,127.0.0.1:9092",
+	}, cc, resolver.BuildOptions{})
+	assert.Nil(t, err)
+	defer r.Close()
+
+	nodes := cc.nodes()
+	assert.Equal(t, 2, len(nodes))
+	assert.Equal(t, "127.0.0.1:9091", nodes[0].Address)
+	assert.Equal(t, float64(200), nodes[0].Weight)
+	assert.Equal(t, "z1", nodes[0].Zone)
+	assert.Equal(t, "red", nodes[0].Group)
+	assert.Equal(t, "test", nodes[0].Metadata["env"])
+	assert.Equal(t, float64(100), nodes[1].Weight)
+
+	_, err = ParseStatic("127.0.0.1:9091?weight=x")
+	assert.NotNil(t, err)
+}
+
+func Test_srvResolver(t *testing.T) {
+	var records = []*net.SRV{
+		{Target: "a.example.com.", Port: 9091, Priority: 10, Weight: 60},
+		{Target: "b.example.com.", Port: 9092, Priority: 10, Weight: 40},
+		{Target: "backup.example.com.", Port: 9093, Priority: 20, Weight: 100},
+	}
+	lookupSRV = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		return name, records, nil
+	}
+	defer func() { lookupSRV = net.DefaultResolver.LookupSRV }()
+
+	cc := &testClientConn{}
+	r, err := resolver.Get(SchemeSRV).Build(resolver.Target{Scheme: SchemeSRV, Endpoint: "_grpc._tcp.example.com"}, cc, resolver.BuildOptions{})
+	assert.Nil(t, err)
+	defer r.Close()
+
+	assert.Eventually(t, func() bool { return len(cc.nodes()) == 2 }, time.Second, 10*time.Millisecond)
+	nodes := cc.nodes()
+	assert.Equal(t, "a.example.com:9091", nodes[0].Address)
+	assert.Equal(t, float64(60), nodes[0].Weight)
+}
+
+func Test_fileResolver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolver")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "nodes.json")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`[{"address":"127.0.0.1:9091","zone":"z1"}]`), 0644))
+
+	cc := &testClientConn{}
+	r, err := resolver.Get(SchemeFile).Build(resolver.Target{Scheme: SchemeFile, Endpoint: path[1:]}, cc, resolver.BuildOptions{})
+	assert.Nil(t, err)
+	defer r.Close()
+
+	nodes := cc.nodes()
+	assert.Equal(t, 1, len(nodes))
+	assert.Equal(t, "z1", nodes[0].Zone)
+	assert.Equal(t, float64(100), nodes[0].Weight)
+
+	// file changed
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"nodes":[{"address":"127.0.0.1:9091"},{"address":"127.0.0.1:9092","weight":50}]}`), 0644))
+	assert.Eventually(t, func() bool { return len(cc.nodes()) == 2 }, 3*time.Second, 10*time.Millisecond)
+
+	tomlPath := filepath.Join(dir, "nodes.toml")
+	assert.Nil(t, ioutil.WriteFile(tomlPath, []byte("[[nodes]]\naddress = \"127.0.0.1:9093\"\ngroup = \"red\"\n"), 0644))
+	nodes, err = ParseFile(tomlPath)
+	assert.Nil(t, err)
+	assert.Equal(t, "red", nodes[0].Group)
+}
+
+func Test_fileResolver_symlink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolver")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// same layout as kubernetes configmap volume
+	writeData := func(version, content string) {
+		assert.Nil(t, os.Mkdir(filepath.Join(dir, version), 0755))
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, version, "nodes.json"), []byte(content), 0644))
+		assert.Nil(t, os.Symlink(version, filepath.Join(dir, "..data_tmp")))
+		assert.Nil(t, os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")))
+	}
+	writeData("..v1", `[{"address":"127.0.0.1:9091"}]`)
+	path := filepath.Join(dir, "nodes.json")
+	assert.Nil(t, os.Symlink(filepath.Join("..data", "nodes.json"), path))
+
+	cc := &testClientConn{}
+	r, err := resolver.Get(SchemeFile).Build(resolver.Target{Scheme: SchemeFile, Endpoint: path[1:]}, cc, resolver.BuildOptions{})
+	assert.Nil(t, err)
+	defer r.Close()
+	assert.Equal(t, 1, len(cc.nodes()))
 
+	writeData("..v2", `[{"address":"127.0.0.1:9091"},{"address":"127.0.0.1:9092"}]`)
+	assert.Eventually(t, func() bool { return len(cc.nodes()) == 2 }, 3*time.Second, 10*time.Millisecond)
 }
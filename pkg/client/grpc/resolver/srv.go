@@ -0,0 +1,145 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resolver
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/util/xgo"
+	"github.com/douyu/jupiter/pkg/xlog"
+
+	"google.golang.org/grpc/resolver"
+)
+
+const (
+	// SchemeSRV scheme of dns srv resolver, e.g. srv:///_grpc._tcp.example.com
+	SchemeSRV = "srv"
+	// DefaultSRVRefreshInterval ...
+	DefaultSRVRefreshInterval = 30 * time.Second
+)
+
+// lookupSRV is replaced in tests
+var lookupSRV = net.DefaultResolver.LookupSRV
+
+func init() {
+	RegisterSRV(SchemeSRV, DefaultSRVRefreshInterval)
+}
+
+// RegisterSRV registers dns srv resolver of scheme, records are refreshed every interval
+func RegisterSRV(scheme string, interval time.Duration) {
+	resolver.Register(&srvBuilder{
+		scheme:   scheme,
+		interval: interval,
+	})
+}
+
+type srvBuilder struct {
+	scheme   string
+	interval time.Duration
+}
+
+// Build ...
+func (b *srvBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &srvResolver{
+		name:     target.Endpoint,
+		cc:       cc,
+		interval: b.interval,
+		ctx:      ctx,
+		cancel:   cancel,
+		rn:       make(chan struct{}, 1),
+	}
+	xgo.Go(r.watch)
+	return r, nil
+}
+
+// Scheme ...
+func (b *srvBuilder) Scheme() string {
+	return b.scheme
+}
+
+type srvResolver struct {
+	name     string
+	cc       resolver.ClientConn
+	interval time.Duration
+	ctx      context.Context
+	cancel   context.CancelFunc
+	rn       chan struct{}
+}
+
+// ResolveNow ...
+func (r *srvResolver) ResolveNow(options resolver.ResolveNowOptions) {
+	select {
+	case r.rn <- struct{}{}:
+	default:
+	}
+}
+
+// Close ...
+func (r *srvResolver) Close() {
+	r.cancel()
+}
+
+func (r *srvResolver) watch() {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		nodes, err := r.lookup()
+		if err != nil {
+			xlog.Error("lookup srv", xlog.FieldMod("resolver.srv"), xlog.FieldName(r.name), xlog.FieldErr(err))
+			r.cc.ReportError(err)
+		} else {
+			r.cc.UpdateState(newState(nodes, r.name))
+		}
+
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.rn:
+		}
+	}
+}
+
+// lookup returns nodes of srv records with the lowest priority
+func (r *srvResolver) lookup() ([]server.ServiceInfo, error) {
+	_, records, err := lookupSRV(r.ctx, "", "", r.name)
+	if err != nil {
+		return nil, err
+	}
+	var nodes = make([]server.ServiceInfo, 0, len(records))
+	var priority uint16
+	for _, record := range records {
+		if len(nodes) > 0 && record.Priority > priority {
+			continue
+		}
+		if len(nodes) == 0 || record.Priority < priority {
+			priority = record.Priority
+			nodes = nodes[:0]
+		}
+		host := strings.TrimSuffix(record.Target, ".")
+		node := newServiceInfo(r.name, net.JoinHostPort(host, strconv.Itoa(int(record.Port))))
+		if record.Weight > 0 {
+			node.Weight = float64(record.Weight)
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
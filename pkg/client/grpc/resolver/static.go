@@ -0,0 +1,108 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resolver
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/douyu/jupiter/pkg/server"
+
+	"google.golang.org/grpc/resolver"
+)
+
+const (
+	// SchemeStatic scheme of static resolver, e.g. static:///127.0.0.1:9091?weight=200&zone=z1,127.0.0.1:9092
+	SchemeStatic = "static"
+)
+
+func init() {
+	resolver.Register(&staticBuilder{})
+}
+
+type staticBuilder struct{}
+
+// Build ...
+func (b *staticBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	nodes, err := ParseStatic(target.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	cc.UpdateState(newState(nodes, target.Endpoint))
+	return &staticResolver{}, nil
+}
+
+// Scheme ...
+func (b *staticBuilder) Scheme() string {
+	return SchemeStatic
+}
+
+type staticResolver struct{}
+
+// ResolveNow ...
+func (r *staticResolver) ResolveNow(options resolver.ResolveNowOptions) {}
+
+// Close ...
+func (r *staticResolver) Close() {}
+
+// ParseStatic parses comma separated addresses, each address may have query of
+// weight, region, zone, group, deployment, other queries are parsed as metadata
+func ParseStatic(endpoint string) ([]server.ServiceInfo, error) {
+	var nodes = make([]server.ServiceInfo, 0)
+	for _, item := range strings.Split(endpoint, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		var addr, query = item, ""
+		if idx := strings.Index(item, "?"); idx >= 0 {
+			addr, query = item[:idx], item[idx+1:]
+		}
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			return nil, fmt.Errorf("static resolver: invalid address %s: %v", item, err)
+		}
+
+		node := newServiceInfo(endpoint, addr)
+		for key := range values {
+			val := values.Get(key)
+			switch key {
+			case "weight":
+				weight, err := strconv.ParseFloat(val, 64)
+				if err != nil {
+					return nil, fmt.Errorf("static resolver: invalid weight of %s: %v", addr, err)
+				}
+				node.Weight = weight
+			case "region":
+				node.Region = val
+			case "zone":
+				node.Zone = val
+			case "group":
+				node.Group = val
+			case "deployment":
+				node.Deployment = val
+			default:
+				node.Metadata[key] = val
+			}
+		}
+		nodes = append(nodes, node)
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("static resolver: no address in %s", endpoint)
+	}
+	return nodes, nil
+}
@@ -0,0 +1,256 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpc
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/metric"
+	"github.com/douyu/jupiter/pkg/util/xrand"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// MethodConfig represents per method config of client
+type MethodConfig struct {
+	// Retry retry policy of method, nil for no retry
+	Retry *RetryConfig
+	// Hedging hedging policy of method, only for idempotent methods, takes precedence over Retry
+	Hedging *HedgingConfig
+}
+
+// RetryConfig retry policy
+type RetryConfig struct {
+	// MaxAttempts max attempts including the first one, no retry if less than 2
+	MaxAttempts int
+	// Codes retryable codes, e.g. "Unavailable", "ResourceExhausted"
+	Codes []string
+	// InitialBackoff backoff before first retry
+	InitialBackoff time.Duration
+	// MaxBackoff max backoff between retries
+	MaxBackoff time.Duration
+	// BackoffMultiplier backoff grows by BackoffMultiplier after each retry
+	BackoffMultiplier float64
+	// BudgetRatio max ratio of retries to requests in a second, no limit if 0
+	BudgetRatio float64
+	// BudgetMinPerSecond retries allowed per second regardless of BudgetRatio
+	BudgetMinPerSecond int
+}
+
+// DefaultRetryConfig ...
+func DefaultRetryConfig() *RetryConfig {
+	return &RetryConfig{
+		MaxAttempts:        3,
+		Codes:              []string{"Unavailable"},
+		InitialBackoff:     xtime.Duration("50ms"),
+		MaxBackoff:         xtime.Duration("1s"),
+		BackoffMultiplier:  2,
+		BudgetRatio:        0.1,
+		BudgetMinPerSecond: 10,
+	}
+}
+
+// HedgingConfig hedging policy, requests are sent every Delay until one of them succeeds
+type HedgingConfig struct {
+	// MaxAttempts max requests in flight including the first one, no hedging if less than 2
+	MaxAttempts int
+	// Delay delay of sending next hedged request
+	Delay time.Duration
+	// NonFatalCodes codes after which next hedged request is sent immediately,
+	// other errors are returned at once
+	NonFatalCodes []string
+}
+
+// methodConfig returns config of full method, which is looked up by full method first and then method name
+func (config *Config) methodConfig(fullMethod string) (MethodConfig, bool) {
+	if mc, ok := config.Methods[fullMethod]; ok {
+		return mc, true
+	}
+	sli := strings.Split(fullMethod, "/")
+	mc, ok := config.Methods[sli[len(sli)-1]]
+	return mc, ok
+}
+
+// parseCodes parses code names case insensitively, e.g. "Unavailable", "DEADLINE_EXCEEDED"
+func parseCodes(names []string) map[codes.Code]bool {
+	var rets = make(map[codes.Code]bool, len(names))
+	for _, name := range names {
+		name = strings.ToLower(strings.Replace(name, "_", "", -1))
+		for c := codes.OK; c <= codes.Unauthenticated; c++ {
+			if strings.ToLower(c.String()) == name {
+				rets[c] = true
+			}
+		}
+	}
+	return rets
+}
+
+// retryBudget limits ratio of retries to requests in a second
+type retryBudget struct {
+	mu           sync.Mutex
+	ratio        float64
+	minPerSecond int
+	windowStart  time.Time
+	requests     int
+	retries      int
+}
+
+func (b *retryBudget) roll(now time.Time) {
+	if now.Sub(b.windowStart) >= time.Second {
+		b.windowStart = now
+		b.requests = 0
+		b.retries = 0
+	}
+}
+
+func (b *retryBudget) onRequest() {
+	b.mu.Lock()
+	b.roll(time.Now())
+	b.requests++
+	b.mu.Unlock()
+}
+
+func (b *retryBudget) allowRetry() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.roll(time.Now())
+	if b.ratio <= 0 || b.retries < b.minPerSecond || float64(b.retries) < b.ratio*float64(b.requests) {
+		b.retries++
+		return true
+	}
+	return false
+}
+
+// backoff returns backoff before nth retry with jitter of ±20%
+func (rc *RetryConfig) backoff(retries int) time.Duration {
+	backoff := float64(rc.InitialBackoff) * math.Pow(rc.BackoffMultiplier, float64(retries-1))
+	if rc.MaxBackoff > 0 && backoff > float64(rc.MaxBackoff) {
+		backoff = float64(rc.MaxBackoff)
+	}
+	return time.Duration(backoff * (0.8 + 0.4*xrand.Float64()))
+}
+
+// retryUnaryClientInterceptor retries failed requests by retry policy of method
+func retryUnaryClientInterceptor(config *Config) grpc.UnaryClientInterceptor {
+	var budgets sync.Map
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		mc, ok := config.methodConfig(method)
+		if !ok || mc.Hedging != nil || mc.Retry == nil || mc.Retry.MaxAttempts < 2 {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		var policy = mc.Retry
+		var retryable = parseCodes(policy.Codes)
+		val, _ := budgets.LoadOrStore(method, &retryBudget{ratio: policy.BudgetRatio, minPerSecond: policy.BudgetMinPerSecond})
+		var budget = val.(*retryBudget)
+		budget.onRequest()
+
+		for attempt := 1; ; attempt++ {
+			err := invoker(ctx, method, req, reply, cc, opts...)
+			code := status.Code(err)
+			if err == nil || attempt >= policy.MaxAttempts || !retryable[code] || isBreakerOpen(err) {
+				return err
+			}
+			if !budget.allowRetry() {
+				metric.ClientRetryCounter.Inc(metric.TypeGRPCUnary, config.Name, method, cc.Target(), "budget_exhausted")
+				return err
+			}
+
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(policy.backoff(attempt)):
+			}
+			metric.ClientRetryCounter.Inc(metric.TypeGRPCUnary, config.Name, method, cc.Target(), code.String())
+		}
+	}
+}
+
+type hedgingResult struct {
+	reply interface{}
+	err   error
+}
+
+// hedgingUnaryClientInterceptor sends hedged requests by hedging policy of method,
+// and returns the first successful reply
+func hedgingUnaryClientInterceptor(config *Config) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		mc, ok := config.methodConfig(method)
+		if !ok || mc.Hedging == nil || mc.Hedging.MaxAttempts < 2 {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		// 每个请求需要独立的 reply，无法构造时不做 hedging
+		replyMsg, ok := reply.(proto.Message)
+		if !ok {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		var policy = mc.Hedging
+		var nonFatal = parseCodes(policy.NonFatalCodes)
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		var results = make(chan hedgingResult, policy.MaxAttempts)
+		var sent, finished int
+		send := func() {
+			sent++
+			if sent > 1 {
+				metric.ClientRetryCounter.Inc(metric.TypeGRPCUnary, config.Name, method, cc.Target(), "hedging")
+			}
+			attemptReply := reflect.New(reflect.TypeOf(reply).Elem()).Interface()
+			go func() {
+				err := invoker(ctx, method, req, attemptReply, cc, opts...)
+				results <- hedgingResult{reply: attemptReply, err: err}
+			}()
+		}
+
+		send()
+		var timer = time.NewTimer(policy.Delay)
+		defer timer.Stop()
+		for {
+			select {
+			case ret := <-results:
+				finished++
+				if ret.err == nil {
+					replyMsg.Reset()
+					proto.Merge(replyMsg, ret.reply.(proto.Message))
+					return nil
+				}
+				if !nonFatal[status.Code(ret.err)] || isBreakerOpen(ret.err) || finished >= policy.MaxAttempts {
+					return ret.err
+				}
+				if sent < policy.MaxAttempts {
+					send()
+				} else if finished >= sent {
+					return ret.err
+				}
+			case <-timer.C:
+				if sent < policy.MaxAttempts {
+					send()
+					timer.Reset(policy.Delay)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpc
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/util/xtest/proto/testproto"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func fakeClientConn(t *testing.T) *grpc.ClientConn {
+	cc, err := grpc.Dial("127.0.0.1:1", grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cc
+}
+
+func TestRetryUnaryClientInterceptor(t *testing.T) {
+	cc := fakeClientConn(t)
+	defer cc.Close()
+	config := DefaultConfig()
+	retry := DefaultRetryConfig()
+	retry.InitialBackoff = time.Millisecond
+	config.Methods["SayHello"] = MethodConfig{Retry: retry}
+	interceptor := retryUnaryClientInterceptor(config)
+
+	Convey("test retry on retryable code", t, func() {
+		var attempts int32
+		err := interceptor(context.Background(), "/testproto.Greeter/SayHello", nil, nil, cc, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			if atomic.AddInt32(&attempts, 1) < 3 {
+				return status.Error(codes.Unavailable, "unavailable")
+			}
+			return nil
+		})
+		So(err, ShouldBeNil)
+		So(attempts, ShouldEqual, 3)
+	})
+
+	Convey("test no retry on other code", t, func() {
+		var attempts int32
+		err := interceptor(context.Background(), "/testproto.Greeter/SayHello", nil, nil, cc, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			atomic.AddInt32(&attempts, 1)
+			return status.Error(codes.InvalidArgument, "invalid")
+		})
+		So(status.Code(err), ShouldEqual, codes.InvalidArgument)
+		So(attempts, ShouldEqual, 1)
+	})
+
+	Convey("test retry budget", t, func() {
+		budget := &retryBudget{ratio: 0.1, minPerSecond: 2}
+		for i := 0; i < 10; i++ {
+			budget.onRequest()
+		}
+		So(budget.allowRetry(), ShouldBeTrue)
+		So(budget.allowRetry(), ShouldBeTrue)
+		So(budget.allowRetry(), ShouldBeFalse)
+	})
+}
+
+func TestHedgingUnaryClientInterceptor(t *testing.T) {
+	cc := fakeClientConn(t)
+	defer cc.Close()
+	config := DefaultConfig()
+	config.Methods["SayHello"] = MethodConfig{Hedging: &HedgingConfig{MaxAttempts: 3, Delay: 10 * time.Millisecond}}
+	interceptor := hedgingUnaryClientInterceptor(config)
+
+	Convey("test hedged request wins", t, func() {
+		var attempts int32
+		reply := &testproto.HelloReply{}
+		err := interceptor(context.Background(), "/testproto.Greeter/SayHello", nil, reply, cc, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			if atomic.AddInt32(&attempts, 1) == 1 {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(time.Second):
+				}
+				reply.(*testproto.HelloReply).Message = "slow"
+				return nil
+			}
+			reply.(*testproto.HelloReply).Message = "hedged"
+			return nil
+		})
+		So(err, ShouldBeNil)
+		So(reply.Message, ShouldEqual, "hedged")
+		So(atomic.LoadInt32(&attempts), ShouldEqual, 2)
+	})
+
+	Convey("test fatal error returned at once", t, func() {
+		err := interceptor(context.Background(), "/testproto.Greeter/SayHello", nil, &testproto.HelloReply{}, cc, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			return status.Error(codes.InvalidArgument, "invalid")
+		})
+		So(status.Code(err), ShouldEqual, codes.InvalidArgument)
+	})
+}
@@ -28,6 +28,8 @@ const (
 	ErrKindFlagErr = "flag err"
 	// ErrKindListenErr ...
 	ErrKindListenErr = "listen err"
+	// ErrKindTLSErr ...
+	ErrKindTLSErr = "tls err"
 	// ErrKindAny ...
 	ErrKindAny = "any"
 )
@@ -113,6 +115,12 @@ const (
 	ModGrpcServer = "server.grpc"
 	// ModRegistryETCD ...
 	ModRegistryETCD = "registry.etcd"
+	// ModRegistryConsul ...
+	ModRegistryConsul = "registry.consul"
+	// ModRegistryKubernetes ...
+	ModRegistryKubernetes = "registry.kubernetes"
+	// ModRegistryMulticast ...
+	ModRegistryMulticast = "registry.multicast"
 	// ModClientETCD ...
 	ModClientETCD = "client.etcd"
 	// ModClientGrpc ...
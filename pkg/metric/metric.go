@@ -15,13 +15,11 @@
 package metric
 
 import (
-	"net/http"
 	"time"
 
 	"github.com/douyu/jupiter/pkg"
-	"github.com/douyu/jupiter/pkg/server/governor"
 
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 var (
@@ -31,6 +29,12 @@ var (
 	TypeGRPCUnary = "unary"
 	// TypeGRPCStream ...
 	TypeGRPCStream = "stream"
+	// TypeGRPCClientStream ...
+	TypeGRPCClientStream = "client_stream"
+	// TypeGRPCServerStream ...
+	TypeGRPCServerStream = "server_stream"
+	// TypeGRPCBidiStream ...
+	TypeGRPCBidiStream = "bidi_stream"
 	// TypeRedis ...
 	TypeRedis = "redis"
 	TypeGorm  = "gorm"
@@ -38,6 +42,8 @@ var (
 	TypeRocketMQ = "rocketmq"
 	// TypeWebsocket ...
 	TypeWebsocket = "ws"
+	// TypeGRPCGateway ...
+	TypeGRPCGateway = "grpc_gateway"
 
 	// TypeMySQL ...
 	TypeMySQL = "mysql"
@@ -58,6 +64,10 @@ var (
 	DefaultNamespace = "jupiter"
 )
 
+// HTTPLabels labels of http server metrics, prometheus treats empty label as absent,
+// so that servers select labels by leaving others empty
+var HTTPLabels = []string{"method", "route", "peer", "code", "code_class"}
+
 var (
 	// ServerHandleCounter ...
 	ServerHandleCounter = CounterVecOpts{
@@ -73,6 +83,49 @@ var (
 		Labels:    []string{"type", "method", "peer"},
 	}.Build()
 
+	// ServerHTTPHandleCounter counts http requests, labels not selected by server are left empty
+	ServerHTTPHandleCounter = CounterVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "server_http_handle_total",
+		Labels:    HTTPLabels,
+	}.Build()
+
+	// ServerHTTPHandleHistogram observes latency of http requests, labels not selected by server are left empty
+	ServerHTTPHandleHistogram = HistogramVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "server_http_handle_seconds",
+		Labels:    HTTPLabels,
+	}.Build()
+
+	// ServerStreamMsgCounter ...
+	ServerStreamMsgCounter = CounterVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "server_stream_msg_total",
+		Labels:    []string{"type", "method", "peer", "direction"},
+	}.Build()
+
+	// ServerStreamMsgBytesHistogram ...
+	ServerStreamMsgBytesHistogram = HistogramVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "server_stream_msg_bytes",
+		Labels:    []string{"type", "method", "peer", "direction"},
+		Buckets:   prometheus.ExponentialBuckets(64, 4, 8),
+	}.Build()
+
+	// ServerBlockedCounter ...
+	ServerBlockedCounter = CounterVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "server_blocked_total",
+		Labels:    []string{"type", "method", "peer", "reason"},
+	}.Build()
+
+	// ServerExpiredCounter counts requests which arrived with deadline already exceeded
+	ServerExpiredCounter = CounterVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "server_expired_total",
+		Labels:    []string{"type", "method", "peer"},
+	}.Build()
+
 	// ClientHandleCounter ...
 	ClientHandleCounter = CounterVecOpts{
 		Namespace: DefaultNamespace,
@@ -87,6 +140,49 @@ var (
 		Labels:    []string{"type", "name", "method", "peer"},
 	}.Build()
 
+	// ClientStreamMsgCounter ...
+	ClientStreamMsgCounter = CounterVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "client_stream_msg_total",
+		Labels:    []string{"type", "name", "method", "peer", "direction"},
+	}.Build()
+
+	// ClientRetryCounter counts retries and hedged requests of client, reason is the code of previous attempt,
+	// "hedging" for hedged requests, or "budget_exhausted" when retry is rejected by budget
+	ClientRetryCounter = CounterVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "client_retry_total",
+		Labels:    []string{"type", "name", "method", "peer", "reason"},
+	}.Build()
+
+	// ClientBreakerStateGauge state of client circuit breaker, 0 closed, 1 half open, 2 open
+	ClientBreakerStateGauge = GaugeVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "client_breaker_state",
+		Labels:    []string{"name", "peer"},
+	}.Build()
+
+	// ClientBreakerRejectCounter counts requests rejected by client circuit breaker
+	ClientBreakerRejectCounter = CounterVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "client_breaker_reject_total",
+		Labels:    []string{"type", "name", "method", "peer"},
+	}.Build()
+
+	// ClientConnStateGauge connectivity state of managed client conns, values are grpc connectivity.State
+	ClientConnStateGauge = GaugeVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "client_conn_state",
+		Labels:    []string{"name", "peer"},
+	}.Build()
+
+	// ClientOutlierEjectionCounter counts subconns ejected by balancer outlier detection
+	ClientOutlierEjectionCounter = CounterVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "client_outlier_ejection_total",
+		Labels:    []string{"target", "peer", "reason"},
+	}.Build()
+
 	// JobHandleCounter ...
 	JobHandleCounter = CounterVecOpts{
 		Namespace: DefaultNamespace,
@@ -133,6 +229,20 @@ var (
 		Labels:    []string{"type", "name", "action"},
 	}.Build()
 
+	// TLSCertExpiryGauge unix timestamp in seconds when the certificate expires
+	TLSCertExpiryGauge = GaugeVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "tls_cert_expiry_timestamp_seconds",
+		Labels:    []string{"file", "subject"},
+	}.Build()
+
+	// TLSCertReloadCounter results of certificate reloads
+	TLSCertReloadCounter = CounterVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "tls_cert_reload_total",
+		Labels:    []string{"file", "code"},
+	}.Build()
+
 	// BuildInfoGauge ...
 	BuildInfoGauge = GaugeVecOpts{
 		Namespace: DefaultNamespace,
@@ -154,8 +264,4 @@ func init() {
 		pkg.BuildTime(),
 		pkg.GoVersion(),
 	).Set(float64(time.Now().UnixNano() / 1e6))
-
-	governor.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
-		promhttp.Handler().ServeHTTP(w, r)
-	})
 }
@@ -16,43 +16,156 @@ package compound
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
 
 	registry2 "github.com/douyu/jupiter/pkg/registry"
 	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/util/xgo"
+	"github.com/douyu/jupiter/pkg/xlog"
 
 	"golang.org/x/sync/errgroup"
 )
 
-type compoundRegistry struct {
+var (
+	errNoRulePublisher = errors.New("no child registry supports rules")
+	errNoCatalog       = errors.New("no child registry supports catalog")
+)
+
+// Registry registers to all child registries and discovers from all of them,
+// child registries in front have higher priority when the same node or config
+// is found in more than one registry
+type Registry struct {
 	registries []registry2.Registry
+
+	mu     sync.RWMutex
+	health []Health
+	logger *xlog.Logger
 }
 
-// ListServices ...
-func (c compoundRegistry) ListServices(ctx context.Context, name string, scheme string) ([]*server.ServiceInfo, error) {
-	var eg errgroup.Group
+// Health health of child registry
+type Health struct {
+	Registry  string    `json:"registry"`
+	Healthy   bool      `json:"healthy"`
+	Error     string    `json:"error"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// New ...
+func New(registries ...registry2.Registry) *Registry {
+	reg := &Registry{
+		registries: registries,
+		health:     make([]Health, len(registries)),
+		logger:     xlog.JupiterLogger.With(xlog.FieldMod("registry.compound")),
+	}
+	for i, registry := range registries {
+		reg.health[i] = Health{Registry: fmt.Sprintf("%d:%T", i, registry), Healthy: true, UpdatedAt: time.Now()}
+	}
+	return reg
+}
+
+// Health returns health of child registries
+func (c *Registry) Health() []Health {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]Health{}, c.health...)
+}
+
+func (c *Registry) setHealth(idx int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	health := &c.health[idx]
+	if err != nil {
+		health.Healthy, health.Error = false, err.Error()
+		c.logger.Error("child registry unhealthy", xlog.String("registry", health.Registry), xlog.FieldErr(err))
+	} else {
+		if !health.Healthy {
+			c.logger.Info("child registry recovered", xlog.String("registry", health.Registry))
+		}
+		health.Healthy, health.Error = true, ""
+	}
+	health.UpdatedAt = time.Now()
+}
+
+// ListServices lists services of all child registries, deduplicated by address,
+// error is returned only if all child registries failed
+func (c *Registry) ListServices(ctx context.Context, name string, scheme string) ([]*server.ServiceInfo, error) {
+	var wg sync.WaitGroup
+	var results = make([][]*server.ServiceInfo, len(c.registries))
+	var errs = make([]error, len(c.registries))
+	for i, registry := range c.registries {
+		i, registry := i, registry
+		wg.Add(1)
+		xgo.Go(func() {
+			defer wg.Done()
+			results[i], errs[i] = registry.ListServices(ctx, name, scheme)
+			c.setHealth(i, errs[i])
+		})
+	}
+	wg.Wait()
+
 	var services = make([]*server.ServiceInfo, 0)
-	for _, registry := range c.registries {
-		registry := registry
-		eg.Go(func() error {
-			infos, err := registry.ListServices(ctx, name, scheme)
-			if err != nil {
-				return err
+	var seen = make(map[string]struct{})
+	var lastErr error
+	var succeeded int
+	for i, infos := range results {
+		if errs[i] != nil {
+			lastErr = errs[i]
+			continue
+		}
+		succeeded++
+		for _, info := range infos {
+			if _, ok := seen[info.Label()]; ok {
+				continue
 			}
-			services = append(services, infos...)
-			return nil
-		})
+			seen[info.Label()] = struct{}{}
+			services = append(services, info)
+		}
 	}
-	err := eg.Wait()
-	return services, err
+	if succeeded == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+	return services, nil
 }
 
-// WatchServices ...
-func (c compoundRegistry) WatchServices(ctx context.Context, s string, s2 string) (chan registry2.Endpoints, error) {
-	panic("compound registry doesn't support watch services")
+// WatchServices merges endpoints of all child registries, error is returned only
+// if none of child registries can be watched
+func (c *Registry) WatchServices(ctx context.Context, name string, scheme string) (chan registry2.Endpoints, error) {
+	var w = &watcher{
+		snapshots: make([]*registry2.Endpoints, len(c.registries)),
+		out:       make(chan registry2.Endpoints, 10),
+	}
+	var lastErr error
+	var started int
+	for i, registry := range c.registries {
+		i := i
+		ch, err := registry.WatchServices(ctx, name, scheme)
+		c.setHealth(i, err)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		started++
+		xgo.Go(func() {
+			for endpoints := range ch {
+				endpoints := endpoints
+				c.setHealth(i, nil)
+				w.update(i, &endpoints)
+			}
+			// 保留最后一次的节点, 避免注册中心异常时节点全部下线
+			c.setHealth(i, fmt.Errorf("watch %s closed", name))
+		})
+	}
+	if started == 0 {
+		return nil, lastErr
+	}
+	return w.out, nil
 }
 
 // RegisterService ...
-func (c compoundRegistry) RegisterService(ctx context.Context, bean *server.ServiceInfo) error {
+func (c *Registry) RegisterService(ctx context.Context, bean *server.ServiceInfo) error {
 	var eg errgroup.Group
 	for _, registry := range c.registries {
 		registry := registry
@@ -64,7 +177,7 @@ func (c compoundRegistry) RegisterService(ctx context.Context, bean *server.Serv
 }
 
 // UnregisterService ...
-func (c compoundRegistry) UnregisterService(ctx context.Context, bean *server.ServiceInfo) error {
+func (c *Registry) UnregisterService(ctx context.Context, bean *server.ServiceInfo) error {
 	var eg errgroup.Group
 	for _, registry := range c.registries {
 		registry := registry
@@ -75,8 +188,71 @@ func (c compoundRegistry) UnregisterService(ctx context.Context, bean *server.Se
 	return eg.Wait()
 }
 
+// GetRules returns rules from the first child registry supporting rules
+func (c *Registry) GetRules(ctx context.Context, name string) (registry2.Rules, error) {
+	for _, registry := range c.registries {
+		if publisher, ok := registry.(registry2.RulePublisher); ok {
+			return publisher.GetRules(ctx, name)
+		}
+	}
+	return registry2.Rules{}, errNoRulePublisher
+}
+
+// PublishRules publishes rules to all child registries supporting rules
+func (c *Registry) PublishRules(ctx context.Context, name string, rules registry2.Rules) error {
+	var eg errgroup.Group
+	var published bool
+	for _, registry := range c.registries {
+		publisher, ok := registry.(registry2.RulePublisher)
+		if !ok {
+			continue
+		}
+		published = true
+		eg.Go(func() error {
+			return publisher.PublishRules(ctx, name, rules)
+		})
+	}
+	if !published {
+		return errNoRulePublisher
+	}
+	return eg.Wait()
+}
+
+// Catalog lists services of all child registries supporting catalog, deduplicated by
+// name, kind and address, error is returned only if all of them failed
+func (c *Registry) Catalog(ctx context.Context) ([]*server.ServiceInfo, error) {
+	var services = make([]*server.ServiceInfo, 0)
+	var seen = make(map[string]struct{})
+	var lastErr = errNoCatalog
+	var succeeded int
+	for _, registry := range c.registries {
+		catalog, ok := registry.(registry2.Catalog)
+		if !ok {
+			continue
+		}
+		infos, err := catalog.Catalog(ctx)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		succeeded++
+		for _, info := range infos {
+			key := fmt.Sprintf("%s/%s/%s", info.Name, info.Kind, info.Label())
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			services = append(services, info)
+		}
+	}
+	if succeeded == 0 {
+		return nil, lastErr
+	}
+	return services, nil
+}
+
 // Close ...
-func (c compoundRegistry) Close() error {
+func (c *Registry) Close() error {
 	var eg errgroup.Group
 	for _, registry := range c.registries {
 		registry := registry
@@ -87,9 +263,72 @@ func (c compoundRegistry) Close() error {
 	return eg.Wait()
 }
 
-// New ...
-func New(registries ...registry2.Registry) registry2.Registry {
-	return compoundRegistry{
-		registries: registries,
+// watcher merges endpoints snapshots of child registries
+type watcher struct {
+	mu        sync.Mutex
+	snapshots []*registry2.Endpoints
+	out       chan registry2.Endpoints
+}
+
+func (w *watcher) update(idx int, endpoints *registry2.Endpoints) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.snapshots[idx] = endpoints
+	merged := merge(w.snapshots)
+	for {
+		select {
+		case w.out <- *merged:
+			return
+		default:
+			// 丢弃最旧的快照, 保证最新的快照被消费
+			select {
+			case <-w.out:
+			default:
+			}
+		}
+	}
+}
+
+// merge merges snapshots, snapshots in front have higher priority
+func merge(snapshots []*registry2.Endpoints) *registry2.Endpoints {
+	var merged = &registry2.Endpoints{
+		Nodes:           make(map[string]server.ServiceInfo),
+		RouteConfigs:    make(map[string]registry2.RouteConfig),
+		ConsumerConfigs: make(map[string]registry2.ConsumerConfig),
+		ProviderConfigs: make(map[string]registry2.ProviderConfig),
+	}
+	var seen = make(map[string]struct{})
+	for _, snapshot := range snapshots {
+		if snapshot == nil {
+			continue
+		}
+		for key, node := range snapshot.Nodes {
+			// 按地址去重
+			label := node.Label()
+			if node.Address == "" {
+				label = key
+			}
+			if _, ok := seen[label]; ok {
+				continue
+			}
+			seen[label] = struct{}{}
+			merged.Nodes[key] = node
+		}
+		for key, config := range snapshot.RouteConfigs {
+			if _, ok := merged.RouteConfigs[key]; !ok {
+				merged.RouteConfigs[key] = config
+			}
+		}
+		for key, config := range snapshot.ConsumerConfigs {
+			if _, ok := merged.ConsumerConfigs[key]; !ok {
+				merged.ConsumerConfigs[key] = config
+			}
+		}
+		for key, config := range snapshot.ProviderConfigs {
+			if _, ok := merged.ProviderConfigs[key]; !ok {
+				merged.ProviderConfigs[key] = config
+			}
+		}
 	}
+	return merged
 }
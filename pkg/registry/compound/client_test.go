@@ -0,0 +1,121 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package compound
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/server"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeRegistry struct {
+	services []*server.ServiceInfo
+	ch       chan registry.Endpoints
+	err      error
+}
+
+func (f *fakeRegistry) RegisterService(context.Context, *server.ServiceInfo) error { return nil }
+
+func (f *fakeRegistry) UnregisterService(context.Context, *server.ServiceInfo) error { return nil }
+
+func (f *fakeRegistry) ListServices(context.Context, string, string) ([]*server.ServiceInfo, error) {
+	return f.services, f.err
+}
+
+func (f *fakeRegistry) WatchServices(context.Context, string, string) (chan registry.Endpoints, error) {
+	return f.ch, f.err
+}
+
+func (f *fakeRegistry) Close() error { return nil }
+
+func newEndpoints(nodes ...server.ServiceInfo) registry.Endpoints {
+	endpoints := registry.Endpoints{
+		Nodes:           make(map[string]server.ServiceInfo),
+		RouteConfigs:    make(map[string]registry.RouteConfig),
+		ConsumerConfigs: make(map[string]registry.ConsumerConfig),
+		ProviderConfigs: make(map[string]registry.ProviderConfig),
+	}
+	for _, node := range nodes {
+		endpoints.Nodes[node.Label()] = node
+	}
+	return endpoints
+}
+
+func TestListServices(t *testing.T) {
+	primary := &fakeRegistry{services: []*server.ServiceInfo{
+		{Scheme: "grpc", Address: "127.0.0.1:9091", Zone: "primary"},
+	}}
+	secondary := &fakeRegistry{services: []*server.ServiceInfo{
+		{Scheme: "grpc", Address: "127.0.0.1:9091", Zone: "secondary"},
+		{Scheme: "grpc", Address: "127.0.0.1:9092"},
+	}}
+	broken := &fakeRegistry{err: errors.New("broken")}
+
+	services, err := New(primary, secondary, broken).ListServices(context.Background(), "demo", "grpc")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(services))
+	assert.Equal(t, "primary", services[0].Zone)
+
+	reg := New(broken)
+	_, err = reg.ListServices(context.Background(), "demo", "grpc")
+	assert.NotNil(t, err)
+	assert.False(t, reg.Health()[0].Healthy)
+}
+
+func TestWatchServices(t *testing.T) {
+	primary := &fakeRegistry{ch: make(chan registry.Endpoints, 1)}
+	secondary := &fakeRegistry{ch: make(chan registry.Endpoints, 1)}
+	reg := New(primary, secondary, &fakeRegistry{err: errors.New("broken")})
+
+	ch, err := reg.WatchServices(context.Background(), "demo", "grpc")
+	assert.Nil(t, err)
+
+	latest := func(n int) registry.Endpoints {
+		var endpoints registry.Endpoints
+		assert.Eventually(t, func() bool {
+			for {
+				select {
+				case endpoints = <-ch:
+				default:
+					return len(endpoints.Nodes) == n
+				}
+			}
+		}, time.Second, 10*time.Millisecond)
+		return endpoints
+	}
+
+	secondary.ch <- newEndpoints(
+		server.ServiceInfo{Scheme: "grpc", Address: "127.0.0.1:9091", Zone: "secondary"},
+		server.ServiceInfo{Scheme: "grpc", Address: "127.0.0.1:9092"},
+	)
+	latest(2)
+
+	primary.ch <- newEndpoints(server.ServiceInfo{Scheme: "grpc", Address: "127.0.0.1:9091", Zone: "primary"})
+	endpoints := latest(2)
+	assert.Equal(t, "primary", endpoints.Nodes["grpc://127.0.0.1:9091"].Zone)
+
+	// nodes of closed registry are kept
+	close(secondary.ch)
+	assert.Eventually(t, func() bool { return !reg.Health()[1].Healthy }, time.Second, 10*time.Millisecond)
+	primary.ch <- newEndpoints()
+	assert.Equal(t, "secondary", latest(2).Nodes["grpc://127.0.0.1:9091"].Zone)
+	assert.False(t, reg.Health()[2].Healthy)
+}
@@ -0,0 +1,190 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var errKeyNotFound = errors.New("consul: key not found")
+
+// client is a minimal client of consul http api
+type client struct {
+	address    string
+	token      string
+	datacenter string
+	http       *http.Client
+}
+
+type agentServiceCheck struct {
+	CheckID                        string `json:",omitempty"`
+	TTL                            string `json:",omitempty"`
+	HTTP                           string `json:",omitempty"`
+	GRPC                           string `json:",omitempty"`
+	Interval                       string `json:",omitempty"`
+	Timeout                        string `json:",omitempty"`
+	DeregisterCriticalServiceAfter string `json:",omitempty"`
+}
+
+type agentServiceRegistration struct {
+	ID      string
+	Name    string
+	Tags    []string           `json:",omitempty"`
+	Address string             `json:",omitempty"`
+	Port    int                `json:",omitempty"`
+	Meta    map[string]string  `json:",omitempty"`
+	Check   *agentServiceCheck `json:",omitempty"`
+}
+
+type serviceEntry struct {
+	Node struct {
+		Node       string
+		Address    string
+		Datacenter string
+	}
+	Service struct {
+		ID      string
+		Service string
+		Tags    []string
+		Address string
+		Port    int
+		Meta    map[string]string
+	}
+}
+
+type kvPair struct {
+	Key         string
+	ModifyIndex uint64
+	Value       []byte
+}
+
+func newClient(address, token, datacenter string) *client {
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	return &client{
+		address:    strings.TrimSuffix(address, "/"),
+		token:      token,
+		datacenter: datacenter,
+		http:       &http.Client{},
+	}
+}
+
+// do sends request to consul, decodes response into out and returns X-Consul-Index
+func (c *client) do(ctx context.Context, method, path string, query url.Values, in, out interface{}) (uint64, error) {
+	if query == nil {
+		query = url.Values{}
+	}
+	if c.datacenter != "" && method == http.MethodGet {
+		query.Set("dc", c.datacenter)
+	}
+
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return 0, err
+		}
+	}
+
+	endpoint := c.address + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, endpoint, &body)
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	index, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	switch {
+	case resp.StatusCode == http.StatusNotFound && strings.HasPrefix(path, "/v1/kv/"):
+		return index, errKeyNotFound
+	case resp.StatusCode != http.StatusOK:
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return index, fmt.Errorf("consul: %s %s: %d %s", method, path, resp.StatusCode, bytes.TrimSpace(msg))
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return index, errors.Wrap(err, "consul: decode response")
+		}
+	}
+	return index, nil
+}
+
+func (c *client) register(ctx context.Context, reg *agentServiceRegistration) error {
+	_, err := c.do(ctx, http.MethodPut, "/v1/agent/service/register", nil, reg, nil)
+	return err
+}
+
+func (c *client) deregister(ctx context.Context, id string) error {
+	_, err := c.do(ctx, http.MethodPut, "/v1/agent/service/deregister/"+url.PathEscape(id), nil, nil, nil)
+	return err
+}
+
+func (c *client) passTTL(ctx context.Context, checkID string) error {
+	_, err := c.do(ctx, http.MethodPut, "/v1/agent/check/pass/"+url.PathEscape(checkID), nil, nil, nil)
+	return err
+}
+
+// healthService lists passing instances of service with tag, blocking until index changes if index > 0
+func (c *client) healthService(ctx context.Context, name, tag string, index uint64, wait string) ([]serviceEntry, uint64, error) {
+	query := url.Values{"passing": []string{"true"}}
+	if tag != "" {
+		query.Set("tag", tag)
+	}
+	if index > 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", wait)
+	}
+	var entries []serviceEntry
+	index, err := c.do(ctx, http.MethodGet, "/v1/health/service/"+url.PathEscape(name), query, nil, &entries)
+	return entries, index, err
+}
+
+// listKV lists key-values under prefix, blocking until index changes if index > 0
+func (c *client) listKV(ctx context.Context, prefix string, index uint64, wait string) ([]kvPair, uint64, error) {
+	query := url.Values{"recurse": []string{"true"}}
+	if index > 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", wait)
+	}
+	var pairs []kvPair
+	index, err := c.do(ctx, http.MethodGet, "/v1/kv/"+prefix, query, nil, &pairs)
+	if err == errKeyNotFound {
+		return nil, index, nil
+	}
+	return pairs, index, err
+}
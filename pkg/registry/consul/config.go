@@ -0,0 +1,97 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"time"
+
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/registry"
+
+	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/xlog"
+)
+
+const (
+	// CheckTypeTTL service reports its health by heartbeat
+	CheckTypeTTL = "ttl"
+	// CheckTypeHTTP consul agent checks service by http request
+	CheckTypeHTTP = "http"
+	// CheckTypeGRPC consul agent checks service by grpc health protocol
+	CheckTypeGRPC = "grpc"
+)
+
+// StdConfig ...
+func StdConfig(name string) *Config {
+	return RawConfig("jupiter.registry." + name)
+}
+
+// RawConfig ...
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil {
+		xlog.Panic("unmarshal key", xlog.FieldMod(ecode.ModRegistryConsul), xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr), xlog.FieldErr(err), xlog.String("key", key), xlog.Any("config", config))
+	}
+	return config
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		Address:                 "http://127.0.0.1:8500",
+		Prefix:                  "jupiter",
+		ReadTimeout:             time.Second * 3,
+		WaitTime:                time.Second * 30,
+		CheckType:               CheckTypeTTL,
+		CheckTTL:                time.Second * 10,
+		CheckInterval:           time.Second * 10,
+		CheckTimeout:            time.Second * 3,
+		CheckHTTPPath:           "/health",
+		DeregisterCriticalAfter: time.Minute,
+		logger:                  xlog.JupiterLogger,
+	}
+}
+
+// Config ...
+type Config struct {
+	// Address consul agent http address
+	Address string
+	Token   string
+	// Datacenter datacenter to discover services from, empty for agent's datacenter
+	Datacenter string
+	// Prefix kv prefix of route/provider/consumer configurators
+	Prefix      string
+	ReadTimeout time.Duration
+	// WaitTime max wait time of blocking queries
+	WaitTime time.Duration
+
+	// CheckType one of ttl, http or grpc
+	CheckType     string
+	CheckTTL      time.Duration
+	CheckInterval time.Duration
+	CheckTimeout  time.Duration
+	// CheckHTTP http check url, default http://{address}{CheckHTTPPath}
+	CheckHTTP     string
+	CheckHTTPPath string
+	// DeregisterCriticalAfter consul deregisters service critical for this long
+	DeregisterCriticalAfter time.Duration
+
+	logger *xlog.Logger
+}
+
+// Build ...
+func (config Config) Build() registry.Registry {
+	return newConsulRegistry(&config)
+}
@@ -0,0 +1,551 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/constant"
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/util/xgo"
+	"github.com/douyu/jupiter/pkg/xlog"
+)
+
+// retryInterval interval of retrying failed blocking queries
+var retryInterval = time.Second
+
+const (
+	metaAppID      = "app_id"
+	metaAddressGW  = "address_gw"
+	metaWeight     = "weight"
+	metaEnable     = "enable"
+	metaRegion     = "region"
+	metaZone       = "zone"
+	metaKind       = "kind"
+	metaDeployment = "deployment"
+	metaGroup      = "group"
+	// metaTags tags of service, comma separated
+	metaTags = "tags"
+	// metaDatacenter datacenter of discovered service
+	metaDatacenter = "datacenter"
+)
+
+type consulRegistry struct {
+	*Config
+	client *client
+
+	mu       sync.Mutex
+	services map[string]*agentServiceRegistration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newConsulRegistry(config *Config) *consulRegistry {
+	if config.logger == nil {
+		config.logger = xlog.JupiterLogger
+	}
+	config.logger = config.logger.With(xlog.FieldMod(ecode.ModRegistryConsul), xlog.FieldAddr(config.Address))
+	reg := &consulRegistry{
+		Config:   config,
+		client:   newClient(config.Address, config.Token, config.Datacenter),
+		services: make(map[string]*agentServiceRegistration),
+	}
+	reg.ctx, reg.cancel = context.WithCancel(context.Background())
+	if config.CheckType == CheckTypeTTL && config.CheckTTL > 0 {
+		reg.wg.Add(1)
+		xgo.Go(reg.heartbeat)
+	}
+	return reg
+}
+
+// RegisterService registers service to consul agent
+func (reg *consulRegistry) RegisterService(ctx context.Context, info *server.ServiceInfo) error {
+	if info.Kind == constant.ServiceConsumer {
+		// 消费方不注册为consul服务, 避免被发现为服务节点
+		return nil
+	}
+	ctx, cancel := reg.withTimeout(ctx)
+	defer cancel()
+
+	registration, err := reg.registration(info)
+	if err != nil {
+		return err
+	}
+	if err := reg.client.register(ctx, registration); err != nil {
+		reg.logger.Error("register service", xlog.FieldErrKind(ecode.ErrKindRegisterErr), xlog.FieldErr(err), xlog.FieldKey(registration.ID), xlog.FieldValueAny(info))
+		return err
+	}
+	if registration.Check != nil && registration.Check.TTL != "" {
+		if err := reg.client.passTTL(ctx, registration.Check.CheckID); err != nil {
+			reg.logger.Error("pass ttl", xlog.FieldErrKind(ecode.ErrKindRegisterErr), xlog.FieldErr(err), xlog.FieldKey(registration.ID))
+		}
+	}
+
+	reg.mu.Lock()
+	reg.services[registration.ID] = registration
+	reg.mu.Unlock()
+	reg.logger.Info("register service", xlog.FieldKey(registration.ID), xlog.FieldValueAny(info))
+	return nil
+}
+
+// UnregisterService deregisters service from consul agent
+func (reg *consulRegistry) UnregisterService(ctx context.Context, info *server.ServiceInfo) error {
+	if info.Kind == constant.ServiceConsumer {
+		return nil
+	}
+	ctx, cancel := reg.withTimeout(ctx)
+	defer cancel()
+
+	id := serviceID(info)
+	reg.mu.Lock()
+	delete(reg.services, id)
+	reg.mu.Unlock()
+	return reg.client.deregister(ctx, id)
+}
+
+// ListServices lists passing instances of service with scheme
+func (reg *consulRegistry) ListServices(ctx context.Context, name string, scheme string) ([]*server.ServiceInfo, error) {
+	ctx, cancel := reg.withTimeout(ctx)
+	defer cancel()
+
+	entries, _, err := reg.client.healthService(ctx, name, scheme, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	services := make([]*server.ServiceInfo, 0, len(entries))
+	for _, entry := range entries {
+		info := toServiceInfo(entry, scheme)
+		services = append(services, &info)
+	}
+	return services, nil
+}
+
+// WatchServices watches passing instances by blocking query on health api,
+// and route/provider/consumer configurators by blocking query on kv api
+func (reg *consulRegistry) WatchServices(ctx context.Context, name string, scheme string) (chan registry.Endpoints, error) {
+	w := &watcher{
+		reg:       reg,
+		name:      name,
+		scheme:    scheme,
+		prefix:    fmt.Sprintf("%s/%s/configurators/", strings.Trim(reg.Prefix, "/"), name),
+		endpoints: newEndpoints(),
+		out:       make(chan registry.Endpoints, 10),
+	}
+
+	readCtx, cancel := reg.withTimeout(ctx)
+	defer cancel()
+	serviceIndex, err := w.updateServices(readCtx, 0)
+	if err != nil {
+		return nil, err
+	}
+	kvIndex, err := w.updateConfigs(readCtx, 0)
+	if err != nil {
+		return nil, err
+	}
+	w.push()
+
+	watchCtx, watchCancel := context.WithCancel(reg.ctx)
+	xgo.Go(func() {
+		select {
+		case <-ctx.Done():
+		case <-watchCtx.Done():
+		}
+		watchCancel()
+	})
+	reg.wg.Add(2)
+	xgo.Go(func() { w.loop(watchCtx, serviceIndex, w.updateServices) })
+	xgo.Go(func() { w.loop(watchCtx, kvIndex, w.updateConfigs) })
+	return w.out, nil
+}
+
+// Close stops watchers and heartbeat, deregisters services registered by this registry
+func (reg *consulRegistry) Close() error {
+	reg.cancel()
+
+	reg.mu.Lock()
+	ids := make([]string, 0, len(reg.services))
+	for id := range reg.services {
+		ids = append(ids, id)
+	}
+	reg.services = make(map[string]*agentServiceRegistration)
+	reg.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if err := reg.client.deregister(ctx, id); err != nil {
+				reg.logger.Error("unregister service", xlog.FieldErrKind(ecode.ErrKindRequestErr), xlog.FieldErr(err), xlog.FieldKey(id))
+			} else {
+				reg.logger.Info("unregister service", xlog.FieldKey(id))
+			}
+		}(id)
+	}
+	wg.Wait()
+	reg.wg.Wait()
+	return nil
+}
+
+func (reg *consulRegistry) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); !ok && reg.ReadTimeout > 0 {
+		return context.WithTimeout(ctx, reg.ReadTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// heartbeat passes ttl checks of registered services
+func (reg *consulRegistry) heartbeat() {
+	defer reg.wg.Done()
+	interval := reg.CheckTTL / 3
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-reg.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		reg.mu.Lock()
+		checks := make(map[string]*agentServiceRegistration, len(reg.services))
+		for id, registration := range reg.services {
+			checks[id] = registration
+		}
+		reg.mu.Unlock()
+
+		for id, registration := range checks {
+			ctx, cancel := context.WithTimeout(reg.ctx, interval)
+			err := reg.client.passTTL(ctx, registration.Check.CheckID)
+			cancel()
+			if err == nil {
+				continue
+			}
+			reg.logger.Error("pass ttl", xlog.FieldErrKind(ecode.ErrKindRegisterErr), xlog.FieldErr(err), xlog.FieldKey(id))
+			// service may be deregistered after critical for a long time, register it again
+			ctx, cancel = context.WithTimeout(reg.ctx, interval)
+			if err := reg.client.register(ctx, registration); err == nil {
+				_ = reg.client.passTTL(ctx, registration.Check.CheckID)
+			}
+			cancel()
+		}
+	}
+}
+
+func (reg *consulRegistry) registration(info *server.ServiceInfo) (*agentServiceRegistration, error) {
+	host, portStr, err := net.SplitHostPort(info.Address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, err
+	}
+
+	registration := &agentServiceRegistration{
+		ID:      serviceID(info),
+		Name:    info.Name,
+		Tags:    []string{info.Scheme},
+		Address: host,
+		Port:    port,
+		Meta:    make(map[string]string, len(info.Metadata)+9),
+	}
+	for key, val := range info.Metadata {
+		if key == metaTags {
+			for _, tag := range strings.Split(val, ",") {
+				if tag = strings.TrimSpace(tag); tag != "" && tag != info.Scheme {
+					registration.Tags = append(registration.Tags, tag)
+				}
+			}
+			continue
+		}
+		registration.Meta[key] = val
+	}
+	registration.Meta[metaAppID] = info.AppID
+	registration.Meta[metaAddressGW] = info.AddressGW
+	registration.Meta[metaWeight] = strconv.FormatFloat(info.Weight, 'f', -1, 64)
+	registration.Meta[metaEnable] = strconv.FormatBool(info.Enable)
+	registration.Meta[metaRegion] = info.Region
+	registration.Meta[metaZone] = info.Zone
+	registration.Meta[metaKind] = strconv.Itoa(int(info.Kind))
+	registration.Meta[metaDeployment] = info.Deployment
+	registration.Meta[metaGroup] = info.Group
+
+	check := &agentServiceCheck{
+		CheckID:                        "service:" + registration.ID,
+		DeregisterCriticalServiceAfter: durationString(reg.DeregisterCriticalAfter),
+	}
+	switch reg.CheckType {
+	case CheckTypeTTL:
+		check.TTL = durationString(reg.CheckTTL)
+	case CheckTypeHTTP:
+		check.HTTP = reg.CheckHTTP
+		if check.HTTP == "" {
+			check.HTTP = "http://" + info.Address + reg.CheckHTTPPath
+		}
+		check.Interval, check.Timeout = durationString(reg.CheckInterval), durationString(reg.CheckTimeout)
+	case CheckTypeGRPC:
+		check.GRPC = info.Address
+		check.Interval, check.Timeout = durationString(reg.CheckInterval), durationString(reg.CheckTimeout)
+	default:
+		check = nil
+	}
+	registration.Check = check
+	return registration, nil
+}
+
+// watcher keeps endpoints of a service up to date
+type watcher struct {
+	reg    *consulRegistry
+	name   string
+	scheme string
+	prefix string
+
+	mu        sync.Mutex
+	endpoints *registry.Endpoints
+	out       chan registry.Endpoints
+}
+
+func (w *watcher) loop(ctx context.Context, index uint64, update func(context.Context, uint64) (uint64, error)) {
+	defer w.reg.wg.Done()
+	for {
+		queryCtx, cancel := context.WithTimeout(ctx, w.reg.WaitTime+w.reg.ReadTimeout)
+		newIndex, err := update(queryCtx, index)
+		cancel()
+
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		if err != nil {
+			w.reg.logger.Error("watch services", xlog.FieldErrKind(ecode.ErrKindRequestErr), xlog.FieldErr(err), xlog.FieldName(w.name))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retryInterval):
+			}
+			continue
+		}
+
+		if newIndex != index {
+			w.push()
+		}
+		// consul index may go backwards after reset, restart blocking from scratch
+		if newIndex < index {
+			newIndex = 0
+		}
+		index = newIndex
+	}
+}
+
+func (w *watcher) updateServices(ctx context.Context, index uint64) (uint64, error) {
+	entries, newIndex, err := w.reg.client.healthService(ctx, w.name, w.scheme, index, durationString(w.reg.WaitTime))
+	if err != nil || newIndex == index {
+		return index, err
+	}
+
+	nodes := make(map[string]server.ServiceInfo, len(entries))
+	for _, entry := range entries {
+		info := toServiceInfo(entry, w.scheme)
+		nodes[info.Label()] = info
+	}
+	w.mu.Lock()
+	w.endpoints.Nodes = nodes
+	w.mu.Unlock()
+	return newIndex, nil
+}
+
+func (w *watcher) updateConfigs(ctx context.Context, index uint64) (uint64, error) {
+	pairs, newIndex, err := w.reg.client.listKV(ctx, w.prefix, index, durationString(w.reg.WaitTime))
+	if err != nil || newIndex == index {
+		return index, err
+	}
+
+	configs := newEndpoints()
+	for _, pair := range pairs {
+		updateConfigs(configs, w.prefix, w.scheme, pair)
+	}
+	w.mu.Lock()
+	w.endpoints.RouteConfigs = configs.RouteConfigs
+	w.endpoints.ProviderConfigs = configs.ProviderConfigs
+	w.endpoints.ConsumerConfigs = configs.ConsumerConfigs
+	w.mu.Unlock()
+	return newIndex, nil
+}
+
+// push sends snapshot of endpoints, drops the oldest one if receiver is slow
+func (w *watcher) push() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	snapshot := *w.endpoints.DeepCopy()
+	for {
+		select {
+		case w.out <- snapshot:
+			return
+		default:
+		}
+		select {
+		case <-w.out:
+		default:
+		}
+	}
+}
+
+// updateConfigs parses configurator kv, the key layout is same as etcdv3 registry:
+// {prefix}/{name}/configurators/{scheme}://{host}/{routes|providers|consumers}/{id}
+func updateConfigs(al *registry.Endpoints, prefix, scheme string, pair kvPair) {
+	addr := strings.TrimPrefix(pair.Key, prefix)
+	if !strings.HasPrefix(addr, scheme+"://") || len(pair.Value) == 0 {
+		return
+	}
+	uri, err := url.Parse(addr)
+	if err != nil {
+		xlog.Error("parse uri", xlog.FieldErrKind(ecode.ErrKindUriErr), xlog.FieldErr(err), xlog.FieldKey(pair.Key))
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(uri.Path, "/routes/"):
+		var routeConfig registry.RouteConfig
+		if err := json.Unmarshal(pair.Value, &routeConfig); err != nil {
+			xlog.Error("parse route config", xlog.FieldErrKind(ecode.ErrKindUriErr), xlog.FieldErr(err), xlog.FieldKey(pair.Key))
+			return
+		}
+		routeConfig.ID = strings.TrimPrefix(uri.Path, "/routes/")
+		routeConfig.Scheme = uri.Scheme
+		routeConfig.Host = uri.Host
+		al.RouteConfigs[uri.String()] = routeConfig
+	case strings.HasPrefix(uri.Path, "/providers/"):
+		var providerConfig registry.ProviderConfig
+		if err := json.Unmarshal(pair.Value, &providerConfig); err != nil {
+			xlog.Error("parse provider config", xlog.FieldErrKind(ecode.ErrKindUriErr), xlog.FieldErr(err), xlog.FieldKey(pair.Key))
+			return
+		}
+		providerConfig.ID = strings.TrimPrefix(uri.Path, "/providers/")
+		providerConfig.Scheme = uri.Scheme
+		providerConfig.Host = uri.Host
+		al.ProviderConfigs[uri.String()] = providerConfig
+	case strings.HasPrefix(uri.Path, "/consumers/"):
+		var consumerConfig registry.ConsumerConfig
+		if err := json.Unmarshal(pair.Value, &consumerConfig); err != nil {
+			xlog.Error("parse consumer config", xlog.FieldErrKind(ecode.ErrKindUriErr), xlog.FieldErr(err), xlog.FieldKey(pair.Key))
+			return
+		}
+		consumerConfig.ID = strings.TrimPrefix(uri.Path, "/consumers/")
+		consumerConfig.Scheme = uri.Scheme
+		consumerConfig.Host = uri.Host
+		al.ConsumerConfigs[uri.String()] = consumerConfig
+	}
+}
+
+func toServiceInfo(entry serviceEntry, scheme string) server.ServiceInfo {
+	service := entry.Service
+	address := service.Address
+	if address == "" {
+		address = entry.Node.Address
+	}
+
+	info := server.ServiceInfo{
+		Name:     service.Service,
+		Scheme:   scheme,
+		Address:  net.JoinHostPort(address, strconv.Itoa(service.Port)),
+		Weight:   100,
+		Enable:   true,
+		Healthy:  true,
+		Metadata: make(map[string]string, len(service.Meta)),
+		Kind:     constant.ServiceProvider,
+	}
+	for key, val := range service.Meta {
+		switch key {
+		case metaAppID:
+			info.AppID = val
+		case metaAddressGW:
+			info.AddressGW = val
+		case metaWeight:
+			if weight, err := strconv.ParseFloat(val, 64); err == nil {
+				info.Weight = weight
+			}
+		case metaEnable:
+			info.Enable = val != "false"
+		case metaRegion:
+			info.Region = val
+		case metaZone:
+			info.Zone = val
+		case metaKind:
+			if kind, err := strconv.Atoi(val); err == nil {
+				info.Kind = constant.ServiceKind(kind)
+			}
+		case metaDeployment:
+			info.Deployment = val
+		case metaGroup:
+			info.Group = val
+		default:
+			info.Metadata[key] = val
+		}
+	}
+
+	tags := make([]string, 0, len(service.Tags))
+	for _, tag := range service.Tags {
+		if tag != scheme {
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) > 0 {
+		info.Metadata[metaTags] = strings.Join(tags, ",")
+	}
+	if dc := entry.Node.Datacenter; dc != "" {
+		info.Metadata[metaDatacenter] = dc
+		if info.Region == "" {
+			info.Region = dc
+		}
+	}
+	return info
+}
+
+func serviceID(info *server.ServiceInfo) string {
+	return fmt.Sprintf("%s-%s-%s", info.Name, info.Scheme, info.Address)
+}
+
+func durationString(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+	return d.String()
+}
+
+func newEndpoints() *registry.Endpoints {
+	return &registry.Endpoints{
+		Nodes:           make(map[string]server.ServiceInfo),
+		RouteConfigs:    make(map[string]registry.RouteConfig),
+		ConsumerConfigs: make(map[string]registry.ConsumerConfig),
+		ProviderConfigs: make(map[string]registry.ProviderConfig),
+	}
+}
@@ -0,0 +1,377 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeConsul is an in-process fake of consul http api
+type fakeConsul struct {
+	mu       sync.Mutex
+	index    uint64
+	changed  chan struct{}
+	services map[string]*agentServiceRegistration
+	passing  map[string]bool
+	kvs      map[string][]byte
+	dcs      []string
+	tokens   []string
+}
+
+func newFakeConsul() *fakeConsul {
+	return &fakeConsul{
+		index:    1,
+		changed:  make(chan struct{}),
+		services: make(map[string]*agentServiceRegistration),
+		passing:  make(map[string]bool),
+		kvs:      make(map[string][]byte),
+	}
+}
+
+func (f *fakeConsul) bump() {
+	f.index++
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeConsul) putKV(key string, value []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.kvs[key] = value
+	f.bump()
+}
+
+func (f *fakeConsul) setPassing(id string, passing bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.passing[id] = passing
+	f.bump()
+}
+
+func (f *fakeConsul) service(id string) *agentServiceRegistration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.services[id]
+}
+
+// block waits until index changes or wait timeout as blocking query of consul
+func (f *fakeConsul) block(r *http.Request) {
+	index, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+	wait, _ := time.ParseDuration(r.URL.Query().Get("wait"))
+	f.mu.Lock()
+	if index == 0 || index != f.index {
+		f.mu.Unlock()
+		return
+	}
+	changed := f.changed
+	f.mu.Unlock()
+	select {
+	case <-changed:
+	case <-time.After(wait):
+	case <-r.Context().Done():
+	}
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.tokens = append(f.tokens, r.Header.Get("X-Consul-Token"))
+	if dc := r.URL.Query().Get("dc"); dc != "" {
+		f.dcs = append(f.dcs, dc)
+	}
+	f.mu.Unlock()
+
+	switch path := r.URL.Path; {
+	case path == "/v1/agent/service/register":
+		var reg agentServiceRegistration
+		if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		f.services[reg.ID] = &reg
+		f.passing[reg.ID] = reg.Check == nil || reg.Check.TTL == ""
+		f.bump()
+		f.mu.Unlock()
+	case strings.HasPrefix(path, "/v1/agent/service/deregister/"):
+		f.mu.Lock()
+		delete(f.services, strings.TrimPrefix(path, "/v1/agent/service/deregister/"))
+		f.bump()
+		f.mu.Unlock()
+	case strings.HasPrefix(path, "/v1/agent/check/pass/service:"):
+		id := strings.TrimPrefix(path, "/v1/agent/check/pass/service:")
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if _, ok := f.services[id]; !ok {
+			http.Error(w, "unknown check", http.StatusInternalServerError)
+			return
+		}
+		if !f.passing[id] {
+			f.passing[id] = true
+			f.bump()
+		}
+	case strings.HasPrefix(path, "/v1/health/service/"):
+		f.block(r)
+		name, tag := strings.TrimPrefix(path, "/v1/health/service/"), r.URL.Query().Get("tag")
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		entries := make([]serviceEntry, 0)
+		for id, reg := range f.services {
+			if reg.Name != name || !f.passing[id] || (tag != "" && !contains(reg.Tags, tag)) {
+				continue
+			}
+			var entry serviceEntry
+			entry.Node.Node, entry.Node.Address, entry.Node.Datacenter = "node1", "10.0.0.1", "dc1"
+			entry.Service.ID, entry.Service.Service, entry.Service.Tags = reg.ID, reg.Name, reg.Tags
+			entry.Service.Address, entry.Service.Port, entry.Service.Meta = reg.Address, reg.Port, reg.Meta
+			entries = append(entries, entry)
+		}
+		w.Header().Set("X-Consul-Index", strconv.FormatUint(f.index, 10))
+		_ = json.NewEncoder(w).Encode(entries)
+	case strings.HasPrefix(path, "/v1/kv/"):
+		f.block(r)
+		prefix := strings.TrimPrefix(path, "/v1/kv/")
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		w.Header().Set("X-Consul-Index", strconv.FormatUint(f.index, 10))
+		pairs := make([]kvPair, 0)
+		for key, value := range f.kvs {
+			if strings.HasPrefix(key, prefix) {
+				pairs = append(pairs, kvPair{Key: key, Value: value})
+			}
+		}
+		if len(pairs) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(pairs)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func contains(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func newTestRegistry(t *testing.T, fake *fakeConsul, opts ...func(*Config)) (*consulRegistry, func()) {
+	srv := httptest.NewServer(fake)
+	config := DefaultConfig()
+	config.Address = srv.URL
+	config.WaitTime = time.Second
+	for _, opt := range opts {
+		opt(config)
+	}
+	reg := newConsulRegistry(config)
+	return reg, func() {
+		_ = reg.Close()
+		srv.Close()
+	}
+}
+
+func testServiceInfo(address string) *server.ServiceInfo {
+	return &server.ServiceInfo{
+		Name:       "demo",
+		AppID:      "1001",
+		Scheme:     "grpc",
+		Address:    address,
+		Weight:     50,
+		Enable:     true,
+		Region:     "bj",
+		Zone:       "bj-1",
+		Deployment: "canary",
+		Group:      "red",
+		Metadata:   map[string]string{"appVersion": "v1", "tags": "blue,green"},
+	}
+}
+
+func TestRegisterService(t *testing.T) {
+	fake := newFakeConsul()
+	reg, closer := newTestRegistry(t, fake, func(config *Config) {
+		config.Token = "secret"
+		config.Datacenter = "dc1"
+	})
+	defer closer()
+
+	info := testServiceInfo("127.0.0.1:9091")
+	assert.Nil(t, reg.RegisterService(context.Background(), info))
+
+	registration := fake.service(serviceID(info))
+	if assert.NotNil(t, registration) {
+		assert.Equal(t, "demo", registration.Name)
+		assert.Equal(t, "127.0.0.1", registration.Address)
+		assert.Equal(t, 9091, registration.Port)
+		assert.Equal(t, []string{"grpc", "blue", "green"}, registration.Tags)
+		assert.Equal(t, "v1", registration.Meta["appVersion"])
+		assert.Equal(t, "50", registration.Meta[metaWeight])
+		assert.Equal(t, "10s", registration.Check.TTL)
+		assert.Equal(t, "1m0s", registration.Check.DeregisterCriticalServiceAfter)
+	}
+
+	services, err := reg.ListServices(context.Background(), "demo", "grpc")
+	assert.Nil(t, err)
+	if assert.Len(t, services, 1) {
+		got := services[0]
+		assert.Equal(t, "127.0.0.1:9091", got.Address)
+		assert.Equal(t, "1001", got.AppID)
+		assert.Equal(t, float64(50), got.Weight)
+		assert.Equal(t, "bj", got.Region)
+		assert.Equal(t, "bj-1", got.Zone)
+		assert.Equal(t, "canary", got.Deployment)
+		assert.Equal(t, "red", got.Group)
+		assert.Equal(t, "v1", got.Metadata["appVersion"])
+		assert.Equal(t, "blue,green", got.Metadata["tags"])
+		assert.Equal(t, "dc1", got.Metadata["datacenter"])
+	}
+
+	services, err = reg.ListServices(context.Background(), "demo", "http")
+	assert.Nil(t, err)
+	assert.Len(t, services, 0)
+
+	assert.Nil(t, reg.UnregisterService(context.Background(), info))
+	assert.Nil(t, fake.service(serviceID(info)))
+
+	fake.mu.Lock()
+	assert.Contains(t, fake.dcs, "dc1")
+	assert.Contains(t, fake.tokens, "secret")
+	fake.mu.Unlock()
+}
+
+func TestRegisterServiceHealthCheck(t *testing.T) {
+	fake := newFakeConsul()
+	httpReg, closer := newTestRegistry(t, fake, func(config *Config) { config.CheckType = CheckTypeHTTP })
+	defer closer()
+	grpcReg, closer := newTestRegistry(t, fake, func(config *Config) { config.CheckType = CheckTypeGRPC })
+	defer closer()
+
+	info := testServiceInfo("127.0.0.1:9091")
+	assert.Nil(t, httpReg.RegisterService(context.Background(), info))
+	check := fake.service(serviceID(info)).Check
+	assert.Equal(t, "http://127.0.0.1:9091/health", check.HTTP)
+	assert.Equal(t, "10s", check.Interval)
+	assert.Empty(t, check.TTL)
+
+	assert.Nil(t, grpcReg.RegisterService(context.Background(), info))
+	check = fake.service(serviceID(info)).Check
+	assert.Equal(t, "127.0.0.1:9091", check.GRPC)
+	assert.Empty(t, check.HTTP)
+}
+
+func TestTTLHeartbeat(t *testing.T) {
+	fake := newFakeConsul()
+	reg, closer := newTestRegistry(t, fake, func(config *Config) { config.CheckTTL = time.Millisecond * 300 })
+	defer closer()
+
+	info := testServiceInfo("127.0.0.1:9091")
+	assert.Nil(t, reg.RegisterService(context.Background(), info))
+
+	// service becomes critical, heartbeat passes it again
+	fake.setPassing(serviceID(info), false)
+	assert.Eventually(t, func() bool {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		return fake.passing[serviceID(info)]
+	}, time.Second*2, time.Millisecond*20)
+
+	// service deregistered by consul, heartbeat registers it again
+	fake.mu.Lock()
+	delete(fake.services, serviceID(info))
+	fake.mu.Unlock()
+	assert.Eventually(t, func() bool { return fake.service(serviceID(info)) != nil }, time.Second*2, time.Millisecond*20)
+
+	// close deregisters service
+	assert.Nil(t, reg.Close())
+	assert.Nil(t, fake.service(serviceID(info)))
+}
+
+func TestWatchServices(t *testing.T) {
+	fake := newFakeConsul()
+	reg, closer := newTestRegistry(t, fake)
+	defer closer()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := reg.WatchServices(ctx, "demo", "grpc")
+	assert.Nil(t, err)
+
+	endpoints := <-ch
+	assert.Len(t, endpoints.Nodes, 0)
+	assert.Len(t, endpoints.RouteConfigs, 0)
+
+	assert.Nil(t, reg.RegisterService(context.Background(), testServiceInfo("127.0.0.1:9091")))
+	assert.Nil(t, reg.RegisterService(context.Background(), testServiceInfo("127.0.0.1:9092")))
+	endpoints = waitEndpoints(t, ch, func(endpoints registry.Endpoints) bool { return len(endpoints.Nodes) == 2 })
+	assert.Equal(t, []string{"grpc://127.0.0.1:9091", "grpc://127.0.0.1:9092"}, nodeKeys(endpoints))
+
+	fake.putKV("jupiter/demo/configurators/grpc:///routes/1", []byte(`{"uri":"/hello","deployment":"canary","upstream":{"groups":{"red":100}}}`))
+	fake.putKV("jupiter/demo/configurators/grpc://127.0.0.1:9091/providers/1", []byte(`{"enable":false}`))
+	fake.putKV("jupiter/demo/configurators/http:///routes/1", []byte(`{"uri":"/ignored"}`))
+	endpoints = waitEndpoints(t, ch, func(endpoints registry.Endpoints) bool {
+		return len(endpoints.RouteConfigs) == 1 && len(endpoints.ProviderConfigs) == 1
+	})
+	route := endpoints.RouteConfigs["grpc:///routes/1"]
+	assert.Equal(t, "1", route.ID)
+	assert.Equal(t, "/hello", route.URI)
+	assert.Equal(t, "canary", route.Deployment)
+	assert.Equal(t, map[string]int{"red": 100}, route.Upstream.Groups)
+	provider := endpoints.ProviderConfigs["grpc://127.0.0.1:9091/providers/1"]
+	assert.Equal(t, "127.0.0.1:9091", provider.Host)
+	assert.Len(t, endpoints.Nodes, 2)
+
+	fake.setPassing(serviceID(testServiceInfo("127.0.0.1:9092")), false)
+	endpoints = waitEndpoints(t, ch, func(endpoints registry.Endpoints) bool { return len(endpoints.Nodes) == 1 })
+	assert.Equal(t, []string{"grpc://127.0.0.1:9091"}, nodeKeys(endpoints))
+	assert.Len(t, endpoints.RouteConfigs, 1)
+}
+
+func waitEndpoints(t *testing.T, ch chan registry.Endpoints, cond func(registry.Endpoints) bool) registry.Endpoints {
+	timeout := time.After(time.Second * 3)
+	for {
+		select {
+		case endpoints := <-ch:
+			if cond(endpoints) {
+				return endpoints
+			}
+		case <-timeout:
+			t.Fatal("wait endpoints timeout")
+		}
+	}
+}
+
+func nodeKeys(endpoints registry.Endpoints) []string {
+	keys := make([]string, 0, len(endpoints.Nodes))
+	for key := range endpoints.Nodes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
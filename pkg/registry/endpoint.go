@@ -16,6 +16,7 @@ package registry
 
 import (
 	"encoding/json"
+	"fmt"
 
 	"github.com/douyu/jupiter/pkg/server"
 )
@@ -80,7 +81,18 @@ type ProviderConfig struct {
 	Zone       string            `json:"zone"`
 	Deployment string            `json:"deployment"`
 	Metadata   map[string]string `json:"metadata"`
-	Enable     bool              `json:"enable"`
+	// 节点是否启用, 为空时启用, 只覆盖属性的配置不会禁用节点
+	Enable *bool `json:"enable"`
+}
+
+// Key returns key of provider config, {scheme}://{host}/providers/{id}
+func (config ProviderConfig) Key() string {
+	return fmt.Sprintf("%s://%s/providers/%s", config.Scheme, config.Host, config.ID)
+}
+
+// Enabled returns whether provider is enabled, which is true unless disabled explicitly
+func (config ProviderConfig) Enabled() bool {
+	return config.Enable == nil || *config.Enable
 }
 
 // ConsumerConfig config of consumer
@@ -100,6 +112,30 @@ type RouteConfig struct {
 	Deployment string   `json:"deployment"`
 	URI        string   `json:"uri"`
 	Upstream   Upstream `json:"upstream"`
+	// 匹配条件, 为空时匹配该URI的所有请求
+	Match RouteMatch `json:"match"`
+	// 同一URI存在多个路由时, 优先匹配优先级高的路由
+	Priority int `json:"priority"`
+}
+
+// Key returns key of route config, {scheme}://{host}/routes/{id}
+func (config RouteConfig) Key() string {
+	return fmt.Sprintf("%s://%s/routes/%s", config.Scheme, config.Host, config.ID)
+}
+
+// RouteMatch represents conditions of canary route, all conditions must be satisfied
+type RouteMatch struct {
+	// 请求元数据需包含的键值
+	Headers map[string]string `json:"headers"`
+	// 调用方应用ID(aid)列表
+	AIDs []string `json:"aids"`
+	// 按调用方应用ID哈希放量的百分比, 0表示不限制
+	Percent int `json:"percent"`
+}
+
+// Empty returns whether match has no condition
+func (m RouteMatch) Empty() bool {
+	return len(m.Headers) == 0 && len(m.AIDs) == 0 && m.Percent <= 0
 }
 
 // String ...
@@ -46,13 +46,18 @@ func RawConfig(key string) *Config {
 
 // DefaultConfig ...
 func DefaultConfig() *Config {
-	return &Config{
-		Config:      etcdv3.DefaultConfig(),
-		ReadTimeout: time.Second * 3,
-		Prefix:      "jupiter",
-		logger:      xlog.JupiterLogger,
-		ServiceTTL:  0,
+	config := &Config{
+		Config:           etcdv3.DefaultConfig(),
+		ReadTimeout:      time.Second * 3,
+		Prefix:           "jupiter",
+		logger:           xlog.JupiterLogger,
+		ServiceTTL:       time.Second * 10,
+		RetryInterval:    time.Second,
+		CoalesceInterval: time.Millisecond * 100,
 	}
+	// 注册中心不可用时, 客户端仍可从本地快照启动
+	config.Config.NonBlock = true
+	return config
 }
 
 // Config ...
@@ -62,6 +67,12 @@ type Config struct {
 	ConfigKey   string
 	Prefix      string
 	ServiceTTL  time.Duration
+	// 租约丢失后重新注册、watch中断后重新同步的间隔
+	RetryInterval time.Duration
+	// 合并该时间窗口内的变更, 只推送最新的服务列表
+	CoalesceInterval time.Duration
+	// 服务列表本地快照目录, 为空时不保存快照
+	SnapshotDir string
 	logger      *xlog.Logger
 }
 
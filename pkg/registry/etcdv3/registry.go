@@ -44,9 +44,11 @@ type etcdv3Registry struct {
 	client *etcdv3.Client
 	kvs    sync.Map
 	*Config
-	cancel   context.CancelFunc
-	rmu      *sync.RWMutex
-	sessions map[string]*concurrency.Session
+	ctx     context.Context
+	cancel  context.CancelFunc
+	rmu     *sync.RWMutex
+	session *concurrency.Session
+	wg      sync.WaitGroup
 }
 
 func newETCDRegistry(config *Config) *etcdv3Registry {
@@ -54,13 +56,16 @@ func newETCDRegistry(config *Config) *etcdv3Registry {
 		config.logger = xlog.JupiterLogger
 	}
 	config.logger = config.logger.With(xlog.FieldMod(ecode.ModRegistryETCD), xlog.FieldAddrAny(config.Config.Endpoints))
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = time.Second
+	}
 	reg := &etcdv3Registry{
-		client:   config.Config.Build(),
-		Config:   config,
-		kvs:      sync.Map{},
-		rmu:      &sync.RWMutex{},
-		sessions: make(map[string]*concurrency.Session),
+		client: config.Config.Build(),
+		Config: config,
+		kvs:    sync.Map{},
+		rmu:    &sync.RWMutex{},
 	}
+	reg.ctx, reg.cancel = context.WithCancel(context.Background())
 	return reg
 }
 
@@ -90,7 +95,7 @@ func (reg *etcdv3Registry) ListServices(ctx context.Context, name string, scheme
 	for _, kv := range getResp.Kvs {
 		var service server.ServiceInfo
 		if err := json.Unmarshal(kv.Value, &service); err != nil {
-			reg.logger.Warnf("invalid service", xlog.FieldErr(err))
+			reg.logger.Warn("invalid service", xlog.FieldErr(err), xlog.FieldKey(string(kv.Key)))
 			continue
 		}
 		services = append(services, &service)
@@ -99,54 +104,72 @@ func (reg *etcdv3Registry) ListServices(ctx context.Context, name string, scheme
 	return
 }
 
-// WatchServices watch service change event, then return address list
-func (reg *etcdv3Registry) WatchServices(ctx context.Context, name string, scheme string) (chan registry.Endpoints, error) {
-	prefix := fmt.Sprintf("/%s/%s/", reg.Prefix, name)
-	watch, err := reg.client.WatchPrefix(context.Background(), prefix)
+// Catalog lists providers and consumers of all services under prefix
+func (reg *etcdv3Registry) Catalog(ctx context.Context) ([]*server.ServiceInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, reg.ReadTimeout)
+	defer cancel()
+
+	prefix := fmt.Sprintf("/%s/", reg.Prefix)
+	resp, err := reg.client.Get(ctx, prefix, clientv3.WithPrefix())
 	if err != nil {
 		return nil, err
 	}
 
-	var addresses = make(chan registry.Endpoints, 10)
-	var al = &registry.Endpoints{
-		Nodes:           make(map[string]server.ServiceInfo),
-		RouteConfigs:    make(map[string]registry.RouteConfig),
-		ConsumerConfigs: make(map[string]registry.ConsumerConfig),
-		ProviderConfigs: make(map[string]registry.ProviderConfig),
+	var services = make([]*server.ServiceInfo, 0)
+	for _, kv := range resp.Kvs {
+		// 键格式: /{prefix}/{name}/{kind}/{scheme}://{address}
+		parts := strings.SplitN(strings.TrimPrefix(string(kv.Key), prefix), "/", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		var kind constant.ServiceKind
+		switch registry.ToKind(parts[1]) {
+		case registry.KindProvider:
+			kind = constant.ServiceProvider
+		case registry.KindConsumer:
+			kind = constant.ServiceConsumer
+		default:
+			continue
+		}
+		var service server.ServiceInfo
+		if err := json.Unmarshal(kv.Value, &service); err != nil {
+			reg.logger.Warn("invalid service", xlog.FieldErr(err), xlog.FieldKey(string(kv.Key)))
+			continue
+		}
+		service.Name, service.Kind = parts[0], kind
+		services = append(services, &service)
 	}
+	return services, nil
+}
 
-	for _, kv := range watch.IncipientKeyValues() {
-		updateAddrList(al, prefix, scheme, kv)
+// WatchServices watch service change event, then return address list.
+// Broken watch is restarted from the last revision, or fully resynced if the
+// revision has been compacted; bursty changes are coalesced into one snapshot.
+// If etcd is unavailable at startup, the last snapshot saved in SnapshotDir is used.
+func (reg *etcdv3Registry) WatchServices(ctx context.Context, name string, scheme string) (chan registry.Endpoints, error) {
+	w := newWatcher(reg, name, scheme)
+	if err := w.resync(ctx); err != nil {
+		if !w.loadSnapshot() {
+			return nil, err
+		}
+		reg.logger.Warn("watch services from snapshot", xlog.FieldErr(err), xlog.FieldName(name))
 	}
 
-	// var snapshot registry.Endpoints
-	// xstruct.CopyStruct(al, &snapshot)
-	addresses <- *al.DeepCopy()
-
+	ctx, cancel := context.WithCancel(ctx)
+	reg.wg.Add(2)
 	xgo.Go(func() {
-		for event := range watch.C() {
-			switch event.Type {
-			case mvccpb.PUT:
-				updateAddrList(al, prefix, scheme, event.Kv)
-			case mvccpb.DELETE:
-				deleteAddrList(al, prefix, scheme, event.Kv)
-			}
-
-			// var snapshot registry.Endpoints
-			// xstruct.CopyStruct(al, &snapshot)
-			out := al.DeepCopy()
-			fmt.Printf("al => %p\n", al.Nodes)
-			fmt.Printf("snapshot => %p\n", out.Nodes)
-			select {
-			// case addresses <- snapshot:
-			case addresses <- *out:
-			default:
-				xlog.Warnf("invalid")
-			}
+		defer reg.wg.Done()
+		select {
+		case <-ctx.Done():
+		case <-reg.ctx.Done():
 		}
+		cancel()
 	})
-
-	return addresses, nil
+	xgo.Go(func() {
+		defer reg.wg.Done()
+		w.run(ctx)
+	})
+	return w.out, nil
 }
 
 func (reg *etcdv3Registry) unregister(ctx context.Context, key string) error {
@@ -156,14 +179,9 @@ func (reg *etcdv3Registry) unregister(ctx context.Context, key string) error {
 		defer cancel()
 	}
 
-	if err := reg.delSession(key); err != nil {
-		return err
-	}
-
+	// 先从本地删除, 避免租约丢失后重新注册
+	reg.kvs.Delete(key)
 	_, err := reg.client.Delete(ctx, key)
-	if err == nil {
-		reg.kvs.Delete(key)
-	}
 	return err
 }
 
@@ -189,6 +207,18 @@ func (reg *etcdv3Registry) Close() error {
 		return true
 	})
 	wg.Wait()
+	reg.wg.Wait()
+
+	reg.rmu.Lock()
+	sess := reg.session
+	reg.session = nil
+	reg.rmu.Unlock()
+	if sess != nil {
+		// 撤销租约
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_, _ = reg.client.Revoke(ctx, sess.Lease())
+		cancel()
+	}
 	return nil
 }
 
@@ -198,101 +228,109 @@ func (reg *etcdv3Registry) registerMetric(ctx context.Context, info *server.Serv
 	}
 
 	metric := "/prometheus/job/%s/%s/%s"
+	key := fmt.Sprintf(metric, info.Name, pkg.HostName(), info.Address)
+	return reg.register(ctx, key, info.Address)
+}
+
+func (reg *etcdv3Registry) registerBiz(ctx context.Context, info *server.ServiceInfo) error {
+	return reg.register(ctx, reg.registerKey(info), reg.registerValue(info))
+}
 
+func (reg *etcdv3Registry) register(ctx context.Context, key, val string) error {
 	if _, ok := ctx.Deadline(); !ok {
 		var cancel context.CancelFunc
 		ctx, cancel = context.WithTimeout(ctx, reg.ReadTimeout)
 		defer cancel()
 	}
 
-	val := info.Address
-	key := fmt.Sprintf(metric, info.Name, pkg.HostName(), val)
-
-	opOptions := make([]clientv3.OpOption, 0)
-	// opOptions = append(opOptions, clientv3.WithSerializable())
-	if ttl := reg.Config.ServiceTTL.Seconds(); ttl > 0 {
-		//todo ctx without timeout for same as service life?
-		sess, err := reg.getSession(key, concurrency.WithTTL(int(ttl)))
-		if err != nil {
-			return err
-		}
-		opOptions = append(opOptions, clientv3.WithLease(sess.Lease()))
-	}
-	_, err := reg.client.Put(ctx, key, val, opOptions...)
-	if err != nil {
-		reg.logger.Error("register service", xlog.FieldErrKind(ecode.ErrKindRegisterErr), xlog.FieldErr(err), xlog.FieldKeyAny(key), xlog.FieldValueAny(info))
+	if err := reg.put(ctx, key, val); err != nil {
+		reg.logger.Error("register service", xlog.FieldErrKind(ecode.ErrKindRegisterErr), xlog.FieldErr(err), xlog.FieldKeyAny(key), xlog.FieldValueAny(val))
 		return err
 	}
-
 	reg.logger.Info("register service", xlog.FieldKeyAny(key), xlog.FieldValueAny(val))
 	reg.kvs.Store(key, val)
 	return nil
-
 }
-func (reg *etcdv3Registry) registerBiz(ctx context.Context, info *server.ServiceInfo) error {
-	var readCtx context.Context
-	var readCancel context.CancelFunc
-	if _, ok := ctx.Deadline(); !ok {
-		readCtx, readCancel = context.WithTimeout(ctx, reg.ReadTimeout)
-		defer readCancel()
-	}
-
-	key := reg.registerKey(info)
-	val := reg.registerValue(info)
 
+func (reg *etcdv3Registry) put(ctx context.Context, key, val string) error {
 	opOptions := make([]clientv3.OpOption, 0)
 	// opOptions = append(opOptions, clientv3.WithSerializable())
 	if ttl := reg.Config.ServiceTTL.Seconds(); ttl > 0 {
-		//todo ctx without timeout for same as service life?
-		sess, err := reg.getSession(key, concurrency.WithTTL(int(ttl)))
+		sess, err := reg.getSession(ctx)
 		if err != nil {
 			return err
 		}
 		opOptions = append(opOptions, clientv3.WithLease(sess.Lease()))
 	}
+	_, err := reg.client.Put(ctx, key, val, opOptions...)
+	return err
+}
 
-	_, err := reg.client.Put(readCtx, key, val, opOptions...)
-	if err != nil {
-		reg.logger.Error("register service", xlog.FieldErrKind(ecode.ErrKindRegisterErr), xlog.FieldErr(err), xlog.FieldKeyAny(key), xlog.FieldValueAny(info))
-		return err
+// getSession returns the lease session shared by all registered keys,
+// a new session is created if the previous one has expired
+func (reg *etcdv3Registry) getSession(ctx context.Context) (*concurrency.Session, error) {
+	reg.rmu.Lock()
+	defer reg.rmu.Unlock()
+	if reg.session != nil {
+		select {
+		case <-reg.session.Done():
+		default:
+			return reg.session, nil
+		}
 	}
-	reg.logger.Info("register service", xlog.FieldKeyAny(key), xlog.FieldValueAny(val))
-	reg.kvs.Store(key, val)
-	return nil
-}
 
-func (reg *etcdv3Registry) getSession(k string, opts ...concurrency.SessionOption) (*concurrency.Session, error) {
-	reg.rmu.RLock()
-	sess, ok := reg.sessions[k]
-	reg.rmu.RUnlock()
-	if ok {
-		return sess, nil
+	ttl := int(reg.Config.ServiceTTL.Seconds())
+	if ttl < 1 {
+		ttl = 1
 	}
-	sess, err := concurrency.NewSession(reg.client.Client)
+	lease, err := reg.client.Grant(ctx, int64(ttl))
 	if err != nil {
-		return sess, err
+		return nil, err
 	}
-	reg.rmu.Lock()
-	reg.sessions[k] = sess
-	reg.rmu.Unlock()
+	sess, err := concurrency.NewSession(reg.client.Client, concurrency.WithLease(lease.ID), concurrency.WithContext(reg.ctx))
+	if err != nil {
+		return nil, err
+	}
+	reg.session = sess
+	reg.wg.Add(1)
+	xgo.Go(func() {
+		defer reg.wg.Done()
+		reg.keepRegistered(sess)
+	})
 	return sess, nil
 }
 
-func (reg *etcdv3Registry) delSession(k string) error {
-	if ttl := reg.Config.ServiceTTL.Seconds(); ttl > 0 {
-		reg.rmu.RLock()
-		sess, ok := reg.sessions[k]
-		reg.rmu.RUnlock()
-		if ok {
-			reg.rmu.Lock()
-			delete(reg.sessions, k)
-			reg.rmu.Unlock()
-			if err := sess.Close(); err != nil {
-				return err
+// keepRegistered registers all keys again after lease of session is lost
+func (reg *etcdv3Registry) keepRegistered(sess *concurrency.Session) {
+	select {
+	case <-reg.ctx.Done():
+		return
+	case <-sess.Done():
+	}
+	reg.logger.Warn("lease lost, register services again", xlog.Int64("lease", int64(sess.Lease())))
+
+	for {
+		var failed bool
+		reg.kvs.Range(func(k, v interface{}) bool {
+			ctx, cancel := context.WithTimeout(reg.ctx, reg.ReadTimeout)
+			defer cancel()
+			if err := reg.put(ctx, k.(string), v.(string)); err != nil {
+				reg.logger.Error("register service", xlog.FieldErrKind(ecode.ErrKindRegisterErr), xlog.FieldErr(err), xlog.FieldKeyAny(k))
+				failed = true
+				return false
 			}
+			return true
+		})
+		if !failed {
+			return
+		}
+
+		select {
+		case <-reg.ctx.Done():
+			return
+		case <-time.After(reg.RetryInterval):
 		}
 	}
-	return nil
 }
 
 func (reg *etcdv3Registry) registerKey(info *server.ServiceInfo) string {
@@ -332,6 +370,8 @@ func deleteAddrList(al *registry.Endpoints, prefix, scheme string, kvs ...*mvccp
 				continue
 			}
 			delete(al.RouteConfigs, uri.String())
+			delete(al.ProviderConfigs, uri.String())
+			delete(al.ConsumerConfigs, uri.String())
 		}
 
 		if isIPPort(addr) {
@@ -17,6 +17,10 @@ package etcdv3
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
 	"testing"
 	"time"
 
@@ -28,8 +32,48 @@ import (
 	"github.com/douyu/jupiter/pkg/xlog"
 
 	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/embed"
 )
 
+func TestMain(m *testing.M) {
+	// 使用内嵌etcd运行测试, 端口被占用时使用已有的etcd
+	dir, _ := ioutil.TempDir("", "registry-etcd")
+	cfg := embed.NewConfig()
+	cfg.Dir = dir
+	cfg.LogLevel = "error"
+	if e, err := embed.StartEtcd(cfg); err == nil {
+		<-e.Server.ReadyNotify()
+		defer e.Close()
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func newTestRegistry(opts ...func(*Config)) *etcdv3Registry {
+	config := DefaultConfig()
+	config.Config.Endpoints = []string{"127.0.0.1:2379"}
+	config.logger = xlog.DefaultLogger
+	for _, opt := range opts {
+		opt(config)
+	}
+	return newETCDRegistry(config)
+}
+
+func waitEndpoints(t *testing.T, ch chan registry.Endpoints, cond func(registry.Endpoints) bool) registry.Endpoints {
+	timeout := time.After(time.Second * 10)
+	for {
+		select {
+		case endpoints := <-ch:
+			if cond(endpoints) {
+				return endpoints
+			}
+		case <-timeout:
+			t.Fatal("wait endpoints timeout")
+		}
+	}
+}
+
 func Test_etcdv3Registry(t *testing.T) {
 	etcdConfig := etcdv3.DefaultConfig()
 	etcdConfig.Endpoints = []string{"127.0.0.1:2379"}
@@ -133,3 +177,175 @@ func Test_etcdv3registry_UpdateAddressList(t *testing.T) {
 	_ = reg.Close()
 	time.Sleep(time.Second * 1)
 }
+
+func Test_etcdv3Registry_ReRegisterAfterLeaseLost(t *testing.T) {
+	reg := newTestRegistry(func(config *Config) {
+		config.ServiceTTL = time.Second * 2
+		config.RetryInterval = time.Millisecond * 100
+	})
+	defer reg.Close()
+
+	info := &server.ServiceInfo{Name: "service_lease", Scheme: "grpc", Address: "10.10.10.1:9091", Enable: true, Kind: constant.ServiceProvider}
+	assert.Nil(t, reg.RegisterService(context.Background(), info))
+
+	sess, err := reg.getSession(context.Background())
+	assert.Nil(t, err)
+	_, err = reg.client.Revoke(context.Background(), sess.Lease())
+	assert.Nil(t, err)
+
+	assert.Eventually(t, func() bool {
+		resp, err := reg.client.Get(context.Background(), reg.registerKey(info))
+		return err == nil && len(resp.Kvs) == 1 && resp.Kvs[0].Lease != int64(sess.Lease())
+	}, time.Second*5, time.Millisecond*50)
+
+	// 注销后不再重新注册
+	assert.Nil(t, reg.UnregisterService(context.Background(), info))
+	resp, err := reg.client.Get(context.Background(), reg.registerKey(info))
+	assert.Nil(t, err)
+	assert.Len(t, resp.Kvs, 0)
+}
+
+func Test_etcdv3Registry_WatchCoalesce(t *testing.T) {
+	reg := newTestRegistry(func(config *Config) {
+		config.CoalesceInterval = time.Millisecond * 200
+	})
+	defer reg.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := reg.WatchServices(ctx, "service_coalesce", "grpc")
+	assert.Nil(t, err)
+	waitEndpoints(t, ch, func(endpoints registry.Endpoints) bool { return len(endpoints.Nodes) == 0 })
+
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, reg.RegisterService(context.Background(), &server.ServiceInfo{
+			Name: "service_coalesce", Scheme: "grpc", Address: "10.10.10.1:" + strconv.Itoa(9000+i), Enable: true, Kind: constant.ServiceProvider,
+		}))
+	}
+
+	var count int
+	waitEndpoints(t, ch, func(endpoints registry.Endpoints) bool {
+		count++
+		return len(endpoints.Nodes) == 50
+	})
+	assert.True(t, count < 50, "updates should be coalesced, got %d snapshots", count)
+}
+
+func Test_etcdv3Registry_WatchResyncAfterCompact(t *testing.T) {
+	reg := newTestRegistry(func(config *Config) {
+		config.RetryInterval = time.Millisecond * 100
+		config.CoalesceInterval = 0
+	})
+	defer reg.Close()
+
+	w := newWatcher(reg, "service_compact", "grpc")
+	assert.Nil(t, w.resync(context.Background()))
+	<-w.notify
+
+	var resp, err = reg.client.Put(context.Background(), "/jupiter/service_compact/providers/grpc://10.10.10.1:9091", `{"name":"service_compact","address":"10.10.10.1:9091"}`)
+	assert.Nil(t, err)
+	_, err = reg.client.Put(context.Background(), "/jupiter/service_compact/providers/grpc://10.10.10.1:9092", `{"name":"service_compact","address":"10.10.10.1:9092"}`)
+	assert.Nil(t, err)
+	_, err = reg.client.Compact(context.Background(), resp.Header.Revision+1)
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.run(ctx)
+
+	endpoints := waitEndpoints(t, w.out, func(endpoints registry.Endpoints) bool { return len(endpoints.Nodes) == 2 })
+	assert.Contains(t, endpoints.Nodes, "grpc://10.10.10.1:9092")
+}
+
+func Test_etcdv3Registry_WatchFromSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry-snapshot")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	reg := newTestRegistry(func(config *Config) { config.SnapshotDir = dir })
+	defer reg.Close()
+	assert.Nil(t, reg.RegisterService(context.Background(), &server.ServiceInfo{
+		Name: "service_snapshot", Scheme: "grpc", Address: "10.10.10.1:9091", Enable: true, Kind: constant.ServiceProvider,
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := reg.WatchServices(ctx, "service_snapshot", "grpc")
+	assert.Nil(t, err)
+	waitEndpoints(t, ch, func(endpoints registry.Endpoints) bool { return len(endpoints.Nodes) == 1 })
+	assert.FileExists(t, filepath.Join(dir, "jupiter_service_snapshot_grpc.json"))
+
+	// etcd不可用时从快照启动
+	down := newTestRegistry(func(config *Config) {
+		config.Config.Endpoints = []string{"127.0.0.1:1"}
+		config.ReadTimeout = time.Millisecond * 300
+		config.SnapshotDir = dir
+	})
+	defer down.Close()
+	ch, err = down.WatchServices(ctx, "service_snapshot", "grpc")
+	assert.Nil(t, err)
+	endpoints := waitEndpoints(t, ch, func(endpoints registry.Endpoints) bool { return len(endpoints.Nodes) == 1 })
+	assert.Contains(t, endpoints.Nodes, "grpc://10.10.10.1:9091")
+
+	_, err = down.WatchServices(ctx, "service_unknown", "grpc")
+	assert.NotNil(t, err)
+}
+
+func Test_etcdv3Registry_Rules(t *testing.T) {
+	reg := newTestRegistry(func(config *Config) { config.Prefix = "jupiter_rules" })
+	defer reg.Close()
+
+	ctx := context.Background()
+	ch, err := reg.WatchServices(ctx, "rules", "grpc")
+	assert.Nil(t, err)
+
+	// consumer configs are kept after publishing
+	_, err = reg.client.Put(ctx, "/jupiter_rules/rules/configurators/grpc:///consumers/1", `{"id":"1","scheme":"grpc"}`)
+	assert.Nil(t, err)
+	assert.Nil(t, reg.PublishRules(ctx, "rules", registry.Rules{
+		Routes: []registry.RouteConfig{{ID: "stale", Scheme: "grpc", URI: "/hello"}},
+	}))
+
+	rules := registry.Rules{
+		Routes: []registry.RouteConfig{{
+			ID: "canary", Scheme: "grpc", URI: "/hello", Deployment: "canary", Priority: 1,
+			Match: registry.RouteMatch{Headers: map[string]string{"x-canary": "1"}, Percent: 10},
+		}},
+		Providers: []registry.ProviderConfig{{ID: "1", Scheme: "grpc", Host: "127.0.0.1:9091"}},
+	}
+	assert.Nil(t, reg.PublishRules(ctx, "rules", rules))
+
+	got, err := reg.GetRules(ctx, "rules")
+	assert.Nil(t, err)
+	assert.Len(t, got.Routes, 1)
+	assert.Equal(t, rules.Routes[0].Match, got.Routes[0].Match)
+	assert.Equal(t, 1, got.Routes[0].Priority)
+	assert.Len(t, got.Providers, 1)
+
+	al := waitEndpoints(t, ch, func(al registry.Endpoints) bool {
+		_, ok := al.RouteConfigs["grpc:///routes/canary"]
+		return ok && len(al.RouteConfigs) == 1
+	})
+	assert.Len(t, al.ConsumerConfigs, 1)
+	assert.True(t, al.ProviderConfigs["grpc://127.0.0.1:9091/providers/1"].Enabled())
+}
+
+func Test_etcdv3Registry_Catalog(t *testing.T) {
+	reg := newTestRegistry(func(config *Config) { config.Prefix = "jupiter_catalog" })
+	defer reg.Close()
+
+	ctx := context.Background()
+	assert.Nil(t, reg.RegisterService(ctx, &server.ServiceInfo{Name: "user", Scheme: "grpc", Address: "10.0.0.1:9091", Kind: constant.ServiceProvider}))
+	assert.Nil(t, reg.RegisterService(ctx, &server.ServiceInfo{Name: "user", Scheme: "grpc", Address: "10.0.0.2:100", Kind: constant.ServiceConsumer,
+		Metadata: map[string]string{registry.MetaConsumerApp: "order", registry.MetaConsumerMethods: "/user.User/Get"}}))
+
+	services, err := reg.Catalog(ctx)
+	assert.Nil(t, err)
+	graph := registry.BuildGraph(services)
+	assert.Len(t, graph.Nodes, 2)
+	assert.Equal(t, []registry.GraphEdge{{From: "order", To: "user", Methods: []string{"/user.User/Get"}, Consumers: 1}}, graph.Edges)
+
+	// consumers aren't discovered as providers
+	list, err := reg.ListServices(ctx, "user", "grpc")
+	assert.Nil(t, err)
+	assert.Len(t, list, 1)
+}
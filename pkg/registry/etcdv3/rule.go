@@ -0,0 +1,95 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdv3
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/douyu/jupiter/pkg/registry"
+	"go.etcd.io/etcd/clientv3"
+)
+
+// GetRules returns route and provider configs of service
+func (reg *etcdv3Registry) GetRules(ctx context.Context, name string) (registry.Rules, error) {
+	ctx, cancel := context.WithTimeout(ctx, reg.ReadTimeout)
+	defer cancel()
+
+	var rules = registry.Rules{Routes: make([]registry.RouteConfig, 0), Providers: make([]registry.ProviderConfig, 0)}
+	prefix := fmt.Sprintf("/%s/%s/", reg.Prefix, name)
+	resp, err := reg.client.Get(ctx, prefix+"configurators/", clientv3.WithPrefix())
+	if err != nil {
+		return rules, err
+	}
+
+	al := newEndpoints()
+	updateAddrList(al, prefix, "", resp.Kvs...)
+	for _, route := range al.RouteConfigs {
+		rules.Routes = append(rules.Routes, route)
+	}
+	for _, provider := range al.ProviderConfigs {
+		rules.Providers = append(rules.Providers, provider)
+	}
+	sort.Slice(rules.Routes, func(i, j int) bool { return rules.Routes[i].Key() < rules.Routes[j].Key() })
+	sort.Slice(rules.Providers, func(i, j int) bool { return rules.Providers[i].Key() < rules.Providers[j].Key() })
+	return rules, nil
+}
+
+// PublishRules replaces route and provider configs of service in one transaction
+func (reg *etcdv3Registry) PublishRules(ctx context.Context, name string, rules registry.Rules) error {
+	ctx, cancel := context.WithTimeout(ctx, reg.ReadTimeout)
+	defer cancel()
+
+	prefix := fmt.Sprintf("/%s/%s/configurators/", reg.Prefix, name)
+	resp, err := reg.client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil {
+		return err
+	}
+
+	var ops = make([]clientv3.Op, 0)
+	var keys = make(map[string]struct{})
+	for _, route := range rules.Routes {
+		val, err := json.Marshal(route)
+		if err != nil {
+			return err
+		}
+		keys[prefix+route.Key()] = struct{}{}
+		ops = append(ops, clientv3.OpPut(prefix+route.Key(), string(val)))
+	}
+	for _, provider := range rules.Providers {
+		val, err := json.Marshal(provider)
+		if err != nil {
+			return err
+		}
+		keys[prefix+provider.Key()] = struct{}{}
+		ops = append(ops, clientv3.OpPut(prefix+provider.Key(), string(val)))
+	}
+	// 删除不在新规则中的路由和节点配置, 消费者配置保持不变
+	for _, kv := range resp.Kvs {
+		key := string(kv.Key)
+		if _, ok := keys[key]; ok {
+			continue
+		}
+		if strings.Contains(key, "/routes/") || strings.Contains(key, "/providers/") {
+			ops = append(ops, clientv3.OpDelete(key))
+		}
+	}
+
+	_, err = reg.client.Txn(ctx).Then(ops...).Commit()
+	return err
+}
@@ -0,0 +1,249 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdv3
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/mvcc/mvccpb"
+)
+
+// watcher keeps endpoints of a service up to date
+type watcher struct {
+	reg    *etcdv3Registry
+	name   string
+	scheme string
+	prefix string
+
+	mu       sync.Mutex
+	al       *registry.Endpoints
+	revision int64
+
+	notify chan struct{}
+	out    chan registry.Endpoints
+}
+
+func newWatcher(reg *etcdv3Registry, name, scheme string) *watcher {
+	return &watcher{
+		reg:    reg,
+		name:   name,
+		scheme: scheme,
+		prefix: fmt.Sprintf("/%s/%s/", reg.Prefix, name),
+		al:     newEndpoints(),
+		notify: make(chan struct{}, 1),
+		out:    make(chan registry.Endpoints, 10),
+	}
+}
+
+// resync loads all keys of service, and replaces endpoints with them
+func (w *watcher) resync(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, w.reg.ReadTimeout)
+	defer cancel()
+	resp, err := w.reg.client.Get(ctx, w.prefix, clientv3.WithPrefix())
+	if err != nil {
+		return err
+	}
+
+	al := newEndpoints()
+	updateAddrList(al, w.prefix, w.scheme, resp.Kvs...)
+	w.mu.Lock()
+	w.al, w.revision = al, resp.Header.Revision
+	w.mu.Unlock()
+	w.changed()
+	return nil
+}
+
+func (w *watcher) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w.emit(ctx)
+	}()
+	defer wg.Wait()
+
+	for {
+		w.mu.Lock()
+		synced := w.revision > 0
+		w.mu.Unlock()
+		// 从快照启动时尚未同步, 需先全量同步
+		if synced {
+			w.watch(ctx)
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(w.reg.RetryInterval):
+			}
+			// 重新全量同步, 之后从新的revision继续watch
+			err := w.resync(ctx)
+			if err == nil {
+				break
+			}
+			w.reg.logger.Error("resync services", xlog.FieldErrKind(ecode.ErrKindRequestErr), xlog.FieldErr(err), xlog.FieldName(w.name))
+		}
+	}
+}
+
+// watch watches changes after the last revision until the watch is broken
+func (w *watcher) watch(ctx context.Context) {
+	w.mu.Lock()
+	revision := w.revision
+	w.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+	defer cancel()
+	rch := w.reg.client.Watch(ctx, w.prefix, clientv3.WithPrefix(), clientv3.WithRev(revision+1))
+	for resp := range rch {
+		if resp.CompactRevision > 0 {
+			w.reg.logger.Warn("watch revision compacted", xlog.Int64("revision", revision), xlog.Int64("compact", resp.CompactRevision), xlog.FieldName(w.name))
+			return
+		}
+		if err := resp.Err(); err != nil {
+			w.reg.logger.Error(ecode.MsgWatchRequestErr, xlog.FieldErrKind(ecode.ErrKindRequestErr), xlog.FieldErr(err), xlog.FieldName(w.name))
+			return
+		}
+		if len(resp.Events) == 0 {
+			continue
+		}
+
+		w.mu.Lock()
+		for _, event := range resp.Events {
+			switch event.Type {
+			case mvccpb.PUT:
+				updateAddrList(w.al, w.prefix, w.scheme, event.Kv)
+			case mvccpb.DELETE:
+				deleteAddrList(w.al, w.prefix, w.scheme, event.Kv)
+			}
+		}
+		w.revision = resp.Header.Revision
+		w.mu.Unlock()
+		w.changed()
+	}
+}
+
+func (w *watcher) changed() {
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+// emit sends the latest snapshot after changes, changes within CoalesceInterval are merged
+func (w *watcher) emit(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.notify:
+		}
+		if w.reg.CoalesceInterval > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(w.reg.CoalesceInterval):
+			}
+			// 丢弃窗口内的其他通知, 下面取到的快照已包含这些变更
+			select {
+			case <-w.notify:
+			default:
+			}
+		}
+
+		w.mu.Lock()
+		snapshot := *w.al.DeepCopy()
+		w.mu.Unlock()
+		w.saveSnapshot(snapshot)
+
+		select {
+		case <-ctx.Done():
+			return
+		case w.out <- snapshot:
+		}
+	}
+}
+
+func (w *watcher) snapshotPath() string {
+	name := strings.NewReplacer("/", "_", ":", "_").Replace(fmt.Sprintf("%s_%s_%s.json", w.reg.Prefix, w.name, w.scheme))
+	return filepath.Join(w.reg.SnapshotDir, name)
+}
+
+// saveSnapshot saves endpoints into SnapshotDir, so that clients can start without etcd
+func (w *watcher) saveSnapshot(al registry.Endpoints) {
+	if w.reg.SnapshotDir == "" {
+		return
+	}
+	err := func() error {
+		if err := os.MkdirAll(w.reg.SnapshotDir, 0755); err != nil {
+			return err
+		}
+		bs, err := json.Marshal(al)
+		if err != nil {
+			return err
+		}
+		tmp := w.snapshotPath() + ".tmp"
+		if err := ioutil.WriteFile(tmp, bs, 0644); err != nil {
+			return err
+		}
+		return os.Rename(tmp, w.snapshotPath())
+	}()
+	if err != nil {
+		w.reg.logger.Error("save snapshot", xlog.FieldErr(err), xlog.String("path", w.snapshotPath()))
+	}
+}
+
+// loadSnapshot loads endpoints from SnapshotDir
+func (w *watcher) loadSnapshot() bool {
+	if w.reg.SnapshotDir == "" {
+		return false
+	}
+	bs, err := ioutil.ReadFile(w.snapshotPath())
+	if err != nil {
+		return false
+	}
+	al := newEndpoints()
+	if err := json.Unmarshal(bs, al); err != nil {
+		w.reg.logger.Error("load snapshot", xlog.FieldErr(err), xlog.String("path", w.snapshotPath()))
+		return false
+	}
+	w.mu.Lock()
+	w.al = al
+	w.mu.Unlock()
+	w.changed()
+	return true
+}
+
+func newEndpoints() *registry.Endpoints {
+	return &registry.Endpoints{
+		Nodes:           make(map[string]server.ServiceInfo),
+		RouteConfigs:    make(map[string]registry.RouteConfig),
+		ConsumerConfigs: make(map[string]registry.ConsumerConfig),
+		ProviderConfigs: make(map[string]registry.ProviderConfig),
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/douyu/jupiter/pkg/constant"
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/server/governor"
+	jsoniter "github.com/json-iterator/go"
+)
+
+const (
+	// MetaConsumerApp metadata key of consumer record, name of calling application
+	MetaConsumerApp = "app"
+	// MetaConsumerTarget metadata key of consumer record, dial target of client
+	MetaConsumerTarget = "target"
+	// MetaConsumerMethods metadata key of consumer record, methods called, separated by comma
+	MetaConsumerMethods = "methods"
+)
+
+// Catalog is implemented by registries which can list providers and consumers of all services
+type Catalog interface {
+	Catalog(ctx context.Context) ([]*server.ServiceInfo, error)
+}
+
+// Graph dependency graph of services, built from providers and consumers in registry
+type Graph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+// GraphNode service in graph
+type GraphNode struct {
+	Name string `json:"name"`
+	// 服务提供方实例数, 仅作为调用方的应用为0
+	Providers int      `json:"providers"`
+	Schemes   []string `json:"schemes"`
+}
+
+// GraphEdge caller calls callee
+type GraphEdge struct {
+	From    string   `json:"from"`
+	To      string   `json:"to"`
+	Methods []string `json:"methods"`
+	// 调用方实例数
+	Consumers int `json:"consumers"`
+}
+
+// BuildGraph builds dependency graph of services
+func BuildGraph(services []*server.ServiceInfo) *Graph {
+	var nodes = make(map[string]*GraphNode)
+	var node = func(name string) *GraphNode {
+		if n, ok := nodes[name]; ok {
+			return n
+		}
+		nodes[name] = &GraphNode{Name: name, Schemes: make([]string, 0)}
+		return nodes[name]
+	}
+	var edges = make(map[[2]string]*GraphEdge)
+	var methods = make(map[[2]string]map[string]struct{})
+
+	for _, info := range services {
+		switch info.Kind {
+		case constant.ServiceProvider:
+			n := node(info.Name)
+			n.Providers++
+			if !contains(n.Schemes, info.Scheme) {
+				n.Schemes = append(n.Schemes, info.Scheme)
+			}
+		case constant.ServiceConsumer:
+			from := info.Metadata[MetaConsumerApp]
+			if from == "" {
+				from = info.AppID
+			}
+			if from == "" {
+				continue
+			}
+			node(from)
+			node(info.Name)
+			key := [2]string{from, info.Name}
+			if _, ok := edges[key]; !ok {
+				edges[key] = &GraphEdge{From: from, To: info.Name}
+				methods[key] = make(map[string]struct{})
+			}
+			edges[key].Consumers++
+			for _, method := range strings.Split(info.Metadata[MetaConsumerMethods], ",") {
+				if method != "" {
+					methods[key][method] = struct{}{}
+				}
+			}
+		}
+	}
+
+	var graph = &Graph{Nodes: make([]GraphNode, 0, len(nodes)), Edges: make([]GraphEdge, 0, len(edges))}
+	for _, n := range nodes {
+		sort.Strings(n.Schemes)
+		graph.Nodes = append(graph.Nodes, *n)
+	}
+	for key, edge := range edges {
+		edge.Methods = make([]string, 0, len(methods[key]))
+		for method := range methods[key] {
+			edge.Methods = append(edge.Methods, method)
+		}
+		sort.Strings(edge.Methods)
+		graph.Edges = append(graph.Edges, *edge)
+	}
+	sort.Slice(graph.Nodes, func(i, j int) bool { return graph.Nodes[i].Name < graph.Nodes[j].Name })
+	sort.Slice(graph.Edges, func(i, j int) bool {
+		if graph.Edges[i].From != graph.Edges[j].From {
+			return graph.Edges[i].From < graph.Edges[j].From
+		}
+		return graph.Edges[i].To < graph.Edges[j].To
+	})
+	return graph
+}
+
+// DOT returns graph in graphviz dot language
+func (graph *Graph) DOT() string {
+	var buf bytes.Buffer
+	buf.WriteString("digraph jupiter {\n")
+	for _, node := range graph.Nodes {
+		label := node.Name
+		if node.Providers > 0 {
+			label = fmt.Sprintf("%s\n%d providers", node.Name, node.Providers)
+		}
+		fmt.Fprintf(&buf, "\t%s [label=%s];\n", strconv.Quote(node.Name), strconv.Quote(label))
+	}
+	for _, edge := range graph.Edges {
+		label := strings.Join(edge.Methods, "\n")
+		fmt.Fprintf(&buf, "\t%s -> %s [label=%s];\n", strconv.Quote(edge.From), strconv.Quote(edge.To), strconv.Quote(label))
+	}
+	buf.WriteString("}\n")
+	return buf.String()
+}
+
+func init() {
+	governor.HandleFunc("/debug/registry/graph", func(w http.ResponseWriter, r *http.Request) {
+		catalog, ok := DefaultRegisterer.(Catalog)
+		if !ok {
+			http.Error(w, "registry doesn't support catalog", http.StatusNotImplemented)
+			return
+		}
+		services, err := catalog.Catalog(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		graph := BuildGraph(services)
+		if r.URL.Query().Get("format") == "dot" {
+			w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+			_, _ = w.Write([]byte(graph.DOT()))
+			return
+		}
+		_ = jsoniter.NewEncoder(w).Encode(graph)
+	})
+}
+
+func contains(vals []string, val string) bool {
+	for _, v := range vals {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
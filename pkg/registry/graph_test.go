@@ -0,0 +1,54 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"testing"
+
+	"github.com/douyu/jupiter/pkg/constant"
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildGraph(t *testing.T) {
+	consumer := func(name, app, addr, methods string) *server.ServiceInfo {
+		return &server.ServiceInfo{Name: name, Scheme: "grpc", Address: addr, Kind: constant.ServiceConsumer, Metadata: map[string]string{
+			MetaConsumerApp:     app,
+			MetaConsumerMethods: methods,
+		}}
+	}
+	graph := BuildGraph([]*server.ServiceInfo{
+		{Name: "user", Scheme: "grpc", Address: "10.0.0.1:9091", Kind: constant.ServiceProvider},
+		{Name: "user", Scheme: "http", Address: "10.0.0.1:9090", Kind: constant.ServiceProvider},
+		{Name: "order", Scheme: "grpc", Address: "10.0.0.2:9091", Kind: constant.ServiceProvider},
+		consumer("user", "order", "10.0.0.2:100", "/user.User/Get"),
+		consumer("user", "order", "10.0.0.3:100", "/user.User/Get,/user.User/List"),
+		consumer("order", "gateway", "10.0.0.4:100", ""),
+	})
+
+	assert.Equal(t, []GraphNode{
+		{Name: "gateway", Providers: 0, Schemes: []string{}},
+		{Name: "order", Providers: 1, Schemes: []string{"grpc"}},
+		{Name: "user", Providers: 2, Schemes: []string{"grpc", "http"}},
+	}, graph.Nodes)
+	assert.Equal(t, []GraphEdge{
+		{From: "gateway", To: "order", Methods: []string{}, Consumers: 1},
+		{From: "order", To: "user", Methods: []string{"/user.User/Get", "/user.User/List"}, Consumers: 2},
+	}, graph.Edges)
+
+	dot := graph.DOT()
+	assert.Contains(t, dot, `"order" -> "user" [label="/user.User/Get\n/user.User/List"];`)
+	assert.Contains(t, dot, `"user" [label="user\n2 providers"];`)
+}
@@ -0,0 +1,113 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubernetes
+
+import (
+	"os"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// RegisterModeNone RegisterService does nothing, pods are registered by kubernetes itself
+	RegisterModeNone = "none"
+	// RegisterModeAnnotation RegisterService patches service info into annotations of current pod
+	RegisterModeAnnotation = "annotation"
+)
+
+// StdConfig ...
+func StdConfig(name string) *Config {
+	return RawConfig("jupiter.registry." + name)
+}
+
+// RawConfig ...
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil {
+		xlog.Panic("unmarshal key", xlog.FieldMod(ecode.ModRegistryKubernetes), xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr), xlog.FieldErr(err), xlog.String("key", key), xlog.Any("config", config))
+	}
+	return config
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	namespace := os.Getenv("POD_NAMESPACE")
+	if namespace == "" {
+		namespace = "default"
+	}
+	podName := os.Getenv("POD_NAME")
+	if podName == "" {
+		podName, _ = os.Hostname()
+	}
+	return &Config{
+		Namespace:        namespace,
+		PodName:          podName,
+		RegisterMode:     RegisterModeNone,
+		AnnotationPrefix: "jupiter.douyu.io/",
+		ReadTimeout:      time.Second * 3,
+		ResyncPeriod:     time.Minute * 10,
+		logger:           xlog.JupiterLogger,
+	}
+}
+
+// Config config of kubernetes registry, services are discovered from EndpointSlices on kubernetes 1.17 ~ 1.24,
+// and from Endpoints on kubernetes 1.25+, where region and zone are only read from pod annotations.
+// Service account needs list and watch permissions of them and pods in Namespace
+type Config struct {
+	// KubeConfig path of kubeconfig file, empty for in-cluster config
+	KubeConfig string
+	// Namespace namespace of services to discover, default $POD_NAMESPACE
+	Namespace string
+	// PodName name of current pod patched in annotation mode, default $POD_NAME or hostname
+	PodName string
+	// RegisterMode one of none or annotation
+	RegisterMode string
+	// AnnotationPrefix prefix of pod annotations mapped to service info, e.g. jupiter.douyu.io/weight
+	AnnotationPrefix string
+	ReadTimeout      time.Duration
+	ResyncPeriod     time.Duration
+
+	logger *xlog.Logger
+}
+
+// Build ...
+func (config Config) Build() registry.Registry {
+	if config.logger == nil {
+		config.logger = xlog.JupiterLogger
+	}
+	restConfig, err := config.restConfig()
+	if err != nil {
+		config.logger.Panic("build kubernetes config", xlog.FieldMod(ecode.ModRegistryKubernetes), xlog.FieldErrKind(ecode.ErrKindAny), xlog.FieldErr(err))
+	}
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		config.logger.Panic("build kubernetes clientset", xlog.FieldMod(ecode.ModRegistryKubernetes), xlog.FieldErrKind(ecode.ErrKindAny), xlog.FieldErr(err))
+	}
+	return newKubernetesRegistry(&config, clientset)
+}
+
+func (config Config) restConfig() (*rest.Config, error) {
+	if config.KubeConfig == "" {
+		return rest.InClusterConfig()
+	}
+	return clientcmd.BuildConfigFromFlags("", config.KubeConfig)
+}
@@ -0,0 +1,504 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/douyu/jupiter/pkg/constant"
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/util/xgo"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1beta1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	annotationAppID      = "app-id"
+	annotationWeight     = "weight"
+	annotationEnable     = "enable"
+	annotationRegion     = "region"
+	annotationZone       = "zone"
+	annotationDeployment = "deployment"
+	annotationGroup      = "group"
+
+	labelTopologyRegion = "topology.kubernetes.io/region"
+	labelTopologyZone   = "topology.kubernetes.io/zone"
+)
+
+// kubernetesRegistry discovers providers from discovery.k8s.io/v1beta1 EndpointSlices if served,
+// e.g. kubernetes 1.17 ~ 1.24, or from core/v1 Endpoints otherwise, e.g. kubernetes 1.25+,
+// service info fields are read from pod annotations with AnnotationPrefix
+type kubernetesRegistry struct {
+	*Config
+	client kubernetes.Interface
+
+	once            sync.Once
+	synced          int32
+	factory         informers.SharedInformerFactory
+	sliceLister     discoverylisters.EndpointSliceLister
+	endpointsLister corelisters.EndpointsLister
+	podLister       corelisters.PodLister
+
+	mu       sync.Mutex
+	watchers map[*watcher]struct{}
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newKubernetesRegistry(config *Config, client kubernetes.Interface) *kubernetesRegistry {
+	if config.logger == nil {
+		config.logger = xlog.JupiterLogger
+	}
+	config.logger = config.logger.With(xlog.FieldMod(ecode.ModRegistryKubernetes), xlog.String("namespace", config.Namespace))
+	return &kubernetesRegistry{
+		Config:   config,
+		client:   client,
+		watchers: make(map[*watcher]struct{}),
+		stop:     make(chan struct{}),
+	}
+}
+
+// RegisterService patches service info into annotations of current pod in annotation mode
+func (reg *kubernetesRegistry) RegisterService(ctx context.Context, info *server.ServiceInfo) error {
+	if reg.RegisterMode != RegisterModeAnnotation || info.Kind == constant.ServiceConsumer {
+		return nil
+	}
+	annotations := map[string]string{
+		annotationAppID:      info.AppID,
+		annotationWeight:     strconv.FormatFloat(info.Weight, 'f', -1, 64),
+		annotationEnable:     strconv.FormatBool(info.Enable),
+		annotationRegion:     info.Region,
+		annotationZone:       info.Zone,
+		annotationDeployment: info.Deployment,
+		annotationGroup:      info.Group,
+	}
+	for key, val := range info.Metadata {
+		if _, ok := annotations[key]; !ok {
+			annotations[key] = val
+		}
+	}
+	return reg.patchAnnotations(annotations)
+}
+
+// UnregisterService disables current pod in annotation mode, so that clients stop
+// sending requests to it before the pod is removed from endpoints
+func (reg *kubernetesRegistry) UnregisterService(ctx context.Context, info *server.ServiceInfo) error {
+	if reg.RegisterMode != RegisterModeAnnotation || info.Kind == constant.ServiceConsumer {
+		return nil
+	}
+	return reg.patchAnnotations(map[string]string{annotationEnable: "false"})
+}
+
+// ListServices lists ready endpoints of kubernetes service with port named scheme
+func (reg *kubernetesRegistry) ListServices(ctx context.Context, name string, scheme string) ([]*server.ServiceInfo, error) {
+	if err := reg.start(); err != nil {
+		return nil, err
+	}
+	nodes, err := reg.nodes(name, scheme)
+	if err != nil {
+		return nil, err
+	}
+	services := make([]*server.ServiceInfo, 0, len(nodes))
+	for _, info := range nodes {
+		info := info
+		services = append(services, &info)
+	}
+	return services, nil
+}
+
+// WatchServices watches EndpointSlices or Endpoints of kubernetes service and pods backing them,
+// bursty changes are coalesced into one snapshot
+func (reg *kubernetesRegistry) WatchServices(ctx context.Context, name string, scheme string) (chan registry.Endpoints, error) {
+	if err := reg.start(); err != nil {
+		return nil, err
+	}
+
+	w := &watcher{
+		name:   name,
+		scheme: scheme,
+		notify: make(chan struct{}, 1),
+		out:    make(chan registry.Endpoints, 10),
+	}
+	w.notify <- struct{}{}
+	reg.mu.Lock()
+	reg.watchers[w] = struct{}{}
+	reg.mu.Unlock()
+
+	reg.wg.Add(1)
+	xgo.Go(func() {
+		defer reg.wg.Done()
+		defer func() {
+			reg.mu.Lock()
+			delete(reg.watchers, w)
+			reg.mu.Unlock()
+		}()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-reg.stop:
+				return
+			case <-w.notify:
+			}
+			nodes, err := reg.nodes(name, scheme)
+			if err != nil {
+				reg.logger.Error("list endpoints", xlog.FieldErrKind(ecode.ErrKindRequestErr), xlog.FieldErr(err), xlog.FieldName(name))
+				continue
+			}
+			endpoints := newEndpoints()
+			endpoints.Nodes = nodes
+			w.push(*endpoints)
+		}
+	})
+	return w.out, nil
+}
+
+// Close stops informers and watchers
+func (reg *kubernetesRegistry) Close() error {
+	select {
+	case <-reg.stop:
+	default:
+		close(reg.stop)
+	}
+	reg.wg.Wait()
+	return nil
+}
+
+// start starts informers of EndpointSlices or Endpoints and pods once, and waits for cache sync until synced
+func (reg *kubernetesRegistry) start() error {
+	reg.once.Do(func() {
+		reg.factory = informers.NewSharedInformerFactoryWithOptions(reg.client, reg.ResyncPeriod, informers.WithNamespace(reg.Namespace))
+		podInformer := reg.factory.Core().V1().Pods()
+		reg.podLister = podInformer.Lister()
+		handler := cache.ResourceEventHandlerFuncs{
+			AddFunc:    reg.onEndpointsEvent,
+			UpdateFunc: func(_, obj interface{}) { reg.onEndpointsEvent(obj) },
+			DeleteFunc: reg.onEndpointsEvent,
+		}
+		if reg.sliceServed() {
+			sliceInformer := reg.factory.Discovery().V1beta1().EndpointSlices()
+			reg.sliceLister = sliceInformer.Lister()
+			sliceInformer.Informer().AddEventHandler(handler)
+			reg.logger.Info("discover services from endpointslices")
+		} else {
+			endpointsInformer := reg.factory.Core().V1().Endpoints()
+			reg.endpointsLister = endpointsInformer.Lister()
+			endpointsInformer.Informer().AddEventHandler(handler)
+			reg.logger.Info("discover services from endpoints")
+		}
+		podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(interface{}) { reg.notifyAll("") },
+			UpdateFunc: func(old, obj interface{}) {
+				oldPod, ok1 := old.(*corev1.Pod)
+				pod, ok2 := obj.(*corev1.Pod)
+				// only labels and annotations of pods are mapped into service info
+				if ok1 && ok2 && labels.Equals(oldPod.Labels, pod.Labels) && labels.Equals(oldPod.Annotations, pod.Annotations) {
+					return
+				}
+				reg.notifyAll("")
+			},
+		})
+
+		reg.factory.Start(reg.stop)
+	})
+
+	if atomic.LoadInt32(&reg.synced) == 1 {
+		return nil
+	}
+	// informers keep syncing in background, so a timeout here is retried by the next call
+	ctx, cancel := context.WithTimeout(context.Background(), reg.ReadTimeout)
+	defer cancel()
+	for typ, synced := range reg.factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return fmt.Errorf("kubernetes: wait for %v cache sync timeout", typ)
+		}
+	}
+	atomic.StoreInt32(&reg.synced, 1)
+	return nil
+}
+
+// sliceServed returns whether discovery.k8s.io/v1beta1 EndpointSlices are served, which are removed since kubernetes 1.25
+func (reg *kubernetesRegistry) sliceServed() bool {
+	resources, err := reg.client.Discovery().ServerResourcesForGroupVersion(discovery.SchemeGroupVersion.String())
+	if err != nil {
+		return false
+	}
+	for _, resource := range resources.APIResources {
+		if resource.Name == "endpointslices" {
+			return true
+		}
+	}
+	return false
+}
+
+func (reg *kubernetesRegistry) onEndpointsEvent(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	switch obj := obj.(type) {
+	case *discovery.EndpointSlice:
+		reg.notifyAll(obj.Labels[discovery.LabelServiceName])
+	case *corev1.Endpoints:
+		reg.notifyAll(obj.Name)
+	}
+}
+
+// notifyAll notifies watchers of service name, or all watchers if name is empty
+func (reg *kubernetesRegistry) notifyAll(name string) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	for w := range reg.watchers {
+		if name != "" && w.name != name {
+			continue
+		}
+		select {
+		case w.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (reg *kubernetesRegistry) nodes(name, scheme string) (map[string]server.ServiceInfo, error) {
+	var endpoints []endpoint
+	var err error
+	if reg.sliceLister != nil {
+		endpoints, err = reg.sliceEndpoints(name, scheme)
+	} else {
+		endpoints, err = reg.coreEndpoints(name, scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make(map[string]server.ServiceInfo)
+	for _, endpoint := range endpoints {
+		var pod *corev1.Pod
+		if ref := endpoint.targetRef; ref != nil && ref.Kind == "Pod" {
+			pod, _ = reg.podLister.Pods(reg.Namespace).Get(ref.Name)
+		}
+		info := reg.toServiceInfo(name, scheme, endpoint, pod)
+		if !info.Enable {
+			continue
+		}
+		nodes[info.Label()] = info
+	}
+	return nodes, nil
+}
+
+// endpoint ready address of service, topology is only known from EndpointSlices
+type endpoint struct {
+	address   string
+	topology  map[string]string
+	targetRef *corev1.ObjectReference
+}
+
+func (reg *kubernetesRegistry) sliceEndpoints(name, scheme string) ([]endpoint, error) {
+	selector := labels.SelectorFromSet(labels.Set{discovery.LabelServiceName: name})
+	slices, err := reg.sliceLister.EndpointSlices(reg.Namespace).List(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	var endpoints []endpoint
+	for _, slice := range slices {
+		port, ok := schemePort(slice.Ports, scheme)
+		if !ok {
+			continue
+		}
+		for _, ep := range slice.Endpoints {
+			if ep.Conditions.Ready != nil && !*ep.Conditions.Ready {
+				continue
+			}
+			for _, addr := range ep.Addresses {
+				endpoints = append(endpoints, endpoint{
+					address:   net.JoinHostPort(addr, strconv.Itoa(int(port))),
+					topology:  ep.Topology,
+					targetRef: ep.TargetRef,
+				})
+			}
+		}
+	}
+	return endpoints, nil
+}
+
+// coreEndpoints returns ready addresses of Endpoints, not ready ones are listed separately by kubernetes
+func (reg *kubernetesRegistry) coreEndpoints(name, scheme string) ([]endpoint, error) {
+	eps, err := reg.endpointsLister.Endpoints(reg.Namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var endpoints []endpoint
+	for _, subset := range eps.Subsets {
+		ports := make([]discovery.EndpointPort, 0, len(subset.Ports))
+		for _, port := range subset.Ports {
+			port := port
+			ports = append(ports, discovery.EndpointPort{Name: &port.Name, Port: &port.Port})
+		}
+		port, ok := schemePort(ports, scheme)
+		if !ok {
+			continue
+		}
+		for _, addr := range subset.Addresses {
+			endpoints = append(endpoints, endpoint{
+				address:   net.JoinHostPort(addr.IP, strconv.Itoa(int(port))),
+				targetRef: addr.TargetRef,
+			})
+		}
+	}
+	return endpoints, nil
+}
+
+func (reg *kubernetesRegistry) toServiceInfo(name, scheme string, endpoint endpoint, pod *corev1.Pod) server.ServiceInfo {
+	info := server.ServiceInfo{
+		Name:     name,
+		Scheme:   scheme,
+		Address:  endpoint.address,
+		Weight:   100,
+		Enable:   true,
+		Healthy:  true,
+		Metadata: make(map[string]string),
+		Region:   endpoint.topology[labelTopologyRegion],
+		Zone:     endpoint.topology[labelTopologyZone],
+		Kind:     constant.ServiceProvider,
+	}
+	if pod == nil {
+		return info
+	}
+
+	for key, val := range pod.Labels {
+		info.Metadata[key] = val
+	}
+	for key, val := range pod.Annotations {
+		if !strings.HasPrefix(key, reg.AnnotationPrefix) {
+			continue
+		}
+		switch key = strings.TrimPrefix(key, reg.AnnotationPrefix); key {
+		case annotationAppID:
+			info.AppID = val
+		case annotationWeight:
+			if weight, err := strconv.ParseFloat(val, 64); err == nil {
+				info.Weight = weight
+			}
+		case annotationEnable:
+			info.Enable = val != "false"
+		case annotationRegion:
+			if val != "" {
+				info.Region = val
+			}
+		case annotationZone:
+			if val != "" {
+				info.Zone = val
+			}
+		case annotationDeployment:
+			info.Deployment = val
+		case annotationGroup:
+			info.Group = val
+		default:
+			info.Metadata[key] = val
+		}
+	}
+	return info
+}
+
+func (reg *kubernetesRegistry) patchAnnotations(annotations map[string]string) error {
+	if reg.PodName == "" {
+		return errors.New("kubernetes: pod name is required in annotation mode")
+	}
+	prefixed := make(map[string]string, len(annotations))
+	for key, val := range annotations {
+		prefixed[reg.AnnotationPrefix+key] = val
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": prefixed},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = reg.client.CoreV1().Pods(reg.Namespace).Patch(reg.PodName, types.MergePatchType, patch)
+	if err != nil {
+		reg.logger.Error("patch pod annotations", xlog.FieldErrKind(ecode.ErrKindRegisterErr), xlog.FieldErr(err), xlog.String("pod", reg.PodName))
+		return err
+	}
+	reg.logger.Info("patch pod annotations", xlog.String("pod", reg.PodName), xlog.Any("annotations", prefixed))
+	return nil
+}
+
+// schemePort returns port named scheme, or the only port if it is unnamed
+func schemePort(ports []discovery.EndpointPort, scheme string) (int32, bool) {
+	for _, port := range ports {
+		if port.Port != nil && port.Name != nil && *port.Name == scheme {
+			return *port.Port, true
+		}
+	}
+	if len(ports) == 1 && ports[0].Port != nil && (ports[0].Name == nil || *ports[0].Name == "") {
+		return *ports[0].Port, true
+	}
+	return 0, false
+}
+
+type watcher struct {
+	name   string
+	scheme string
+	notify chan struct{}
+	out    chan registry.Endpoints
+}
+
+// push sends snapshot of endpoints, drops the oldest one if receiver is slow
+func (w *watcher) push(endpoints registry.Endpoints) {
+	for {
+		select {
+		case w.out <- endpoints:
+			return
+		default:
+		}
+		select {
+		case <-w.out:
+		default:
+		}
+	}
+}
+
+func newEndpoints() *registry.Endpoints {
+	return &registry.Endpoints{
+		Nodes:           make(map[string]server.ServiceInfo),
+		RouteConfigs:    make(map[string]registry.RouteConfig),
+		ConsumerConfigs: make(map[string]registry.ConsumerConfig),
+		ProviderConfigs: make(map[string]registry.ProviderConfig),
+	}
+}
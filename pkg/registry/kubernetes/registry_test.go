@@ -0,0 +1,294 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newTestRegistry(objects ...interface{}) (*kubernetesRegistry, *fake.Clientset) {
+	client := fake.NewSimpleClientset()
+	for _, obj := range objects {
+		switch obj := obj.(type) {
+		case *corev1.Pod:
+			_, _ = client.CoreV1().Pods(obj.Namespace).Create(obj)
+		case *discovery.EndpointSlice:
+			_, _ = client.DiscoveryV1beta1().EndpointSlices(obj.Namespace).Create(obj)
+		case *corev1.Endpoints:
+			_, _ = client.CoreV1().Endpoints(obj.Namespace).Create(obj)
+		}
+	}
+	// EndpointSlices are served by discovery of fake clientset
+	client.Resources = []*metav1.APIResourceList{{
+		GroupVersion: discovery.SchemeGroupVersion.String(),
+		APIResources: []metav1.APIResource{{Name: "endpointslices", Namespaced: true, Kind: "EndpointSlice"}},
+	}}
+	config := DefaultConfig()
+	config.Namespace = "test"
+	config.PodName = "demo-0"
+	return newKubernetesRegistry(config, client), client
+}
+
+func testPod(name string, annotations map[string]string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "test",
+			Labels:      map[string]string{"app": "demo"},
+			Annotations: annotations,
+		},
+	}
+}
+
+func testSlice(name string, ready bool, pods ...string) *discovery.EndpointSlice {
+	grpcName, httpName := "grpc", "http"
+	grpcPort, httpPort := int32(9091), int32(9090)
+	slice := &discovery.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "test",
+			Labels:    map[string]string{discovery.LabelServiceName: "demo"},
+		},
+		AddressType: discovery.AddressTypeIPv4,
+		Ports: []discovery.EndpointPort{
+			{Name: &grpcName, Port: &grpcPort},
+			{Name: &httpName, Port: &httpPort},
+		},
+	}
+	for i, pod := range pods {
+		slice.Endpoints = append(slice.Endpoints, discovery.Endpoint{
+			Addresses:  []string{"10.0.0." + strconv.Itoa(i+1)},
+			Conditions: discovery.EndpointConditions{Ready: &ready},
+			TargetRef:  &corev1.ObjectReference{Kind: "Pod", Namespace: "test", Name: pod},
+			Topology:   map[string]string{labelTopologyZone: "zone-a", labelTopologyRegion: "region-a"},
+		})
+	}
+	return slice
+}
+
+func TestListServices(t *testing.T) {
+	reg, _ := newTestRegistry(
+		testPod("demo-0", map[string]string{
+			"jupiter.douyu.io/weight":     "50",
+			"jupiter.douyu.io/deployment": "canary",
+			"jupiter.douyu.io/zone":       "zone-b",
+			"jupiter.douyu.io/appVersion": "v1",
+			"other.io/ignored":            "true",
+		}),
+		testPod("demo-1", nil),
+		testSlice("demo-abc", true, "demo-0", "demo-1"),
+		testSlice("demo-def", false, "demo-2"),
+	)
+	defer reg.Close()
+
+	services, err := reg.ListServices(context.Background(), "demo", "grpc")
+	assert.Nil(t, err)
+	assert.Len(t, services, 2)
+
+	byAddr := make(map[string]*server.ServiceInfo)
+	for _, info := range services {
+		byAddr[info.Address] = info
+	}
+	if info := byAddr["10.0.0.1:9091"]; assert.NotNil(t, info) {
+		assert.Equal(t, "demo", info.Name)
+		assert.Equal(t, "grpc", info.Scheme)
+		assert.Equal(t, float64(50), info.Weight)
+		assert.Equal(t, "canary", info.Deployment)
+		assert.Equal(t, "zone-b", info.Zone)
+		assert.Equal(t, "region-a", info.Region)
+		assert.Equal(t, "v1", info.Metadata["appVersion"])
+		assert.Equal(t, "demo", info.Metadata["app"])
+		assert.NotContains(t, info.Metadata, "other.io/ignored")
+	}
+	if info := byAddr["10.0.0.2:9091"]; assert.NotNil(t, info) {
+		assert.Equal(t, float64(100), info.Weight)
+		assert.Equal(t, "zone-a", info.Zone)
+	}
+
+	services, err = reg.ListServices(context.Background(), "demo", "http")
+	assert.Nil(t, err)
+	assert.Len(t, services, 2)
+	services, err = reg.ListServices(context.Background(), "demo", "unknown")
+	assert.Nil(t, err)
+	assert.Len(t, services, 0)
+}
+
+func testEndpoints(name string, pods ...string) *corev1.Endpoints {
+	subset := corev1.EndpointSubset{
+		Ports: []corev1.EndpointPort{{Name: "grpc", Port: 9091}, {Name: "http", Port: 9090}},
+	}
+	for i, pod := range pods {
+		subset.Addresses = append(subset.Addresses, corev1.EndpointAddress{
+			IP:        "10.0.0." + strconv.Itoa(i+1),
+			TargetRef: &corev1.ObjectReference{Kind: "Pod", Namespace: "test", Name: pod},
+		})
+	}
+	subset.NotReadyAddresses = []corev1.EndpointAddress{{IP: "10.0.1.1"}}
+	return &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test"},
+		Subsets:    []corev1.EndpointSubset{subset},
+	}
+}
+
+// EndpointSlices of discovery.k8s.io/v1beta1 are not served since kubernetes 1.25
+func TestListServices_Endpoints(t *testing.T) {
+	reg, client := newTestRegistry(
+		testPod("demo-0", map[string]string{"jupiter.douyu.io/zone": "zone-b"}),
+		testPod("demo-1", map[string]string{"jupiter.douyu.io/enable": "false"}),
+		testEndpoints("demo", "demo-0", "demo-1", "demo-2"),
+		testEndpoints("other", "demo-0"),
+	)
+	client.Resources = nil
+	defer reg.Close()
+
+	services, err := reg.ListServices(context.Background(), "demo", "grpc")
+	assert.Nil(t, err)
+	assert.Len(t, services, 2)
+	byAddr := make(map[string]*server.ServiceInfo)
+	for _, info := range services {
+		byAddr[info.Address] = info
+	}
+	if info := byAddr["10.0.0.1:9091"]; assert.NotNil(t, info) {
+		assert.Equal(t, "zone-b", info.Zone)
+		assert.Equal(t, "demo", info.Metadata["app"])
+	}
+	assert.Contains(t, byAddr, "10.0.0.3:9091")
+
+	services, err = reg.ListServices(context.Background(), "unknown", "grpc")
+	assert.Nil(t, err)
+	assert.Len(t, services, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := reg.WatchServices(ctx, "demo", "http")
+	assert.Nil(t, err)
+	waitEndpoints(t, ch, func(endpoints registry.Endpoints) bool { return len(endpoints.Nodes) == 2 })
+	_, err = client.CoreV1().Endpoints("test").Update(testEndpoints("demo", "demo-0"))
+	assert.Nil(t, err)
+	endpoints := waitEndpoints(t, ch, func(endpoints registry.Endpoints) bool { return len(endpoints.Nodes) == 1 })
+	assert.Contains(t, endpoints.Nodes, "http://10.0.0.1:9090")
+}
+
+func TestListServices_SyncRetry(t *testing.T) {
+	reg, client := newTestRegistry(testPod("demo-0", nil), testSlice("demo-abc", true, "demo-0"))
+	defer reg.Close()
+
+	var unavailable int32 = 1
+	client.PrependReactor("list", "endpointslices", func(k8stesting.Action) (bool, runtime.Object, error) {
+		if atomic.LoadInt32(&unavailable) == 1 {
+			return true, nil, errors.New("apiserver unavailable")
+		}
+		return false, nil, nil
+	})
+
+	reg.ReadTimeout = 100 * time.Millisecond
+	_, err := reg.ListServices(context.Background(), "demo", "grpc")
+	assert.NotNil(t, err)
+
+	// informers keep retrying, a failed sync is not memoized
+	atomic.StoreInt32(&unavailable, 0)
+	reg.ReadTimeout = 5 * time.Second
+	services, err := reg.ListServices(context.Background(), "demo", "grpc")
+	assert.Nil(t, err)
+	assert.Len(t, services, 1)
+}
+
+func TestWatchServices(t *testing.T) {
+	reg, client := newTestRegistry(testPod("demo-0", nil), testSlice("demo-abc", true, "demo-0"))
+	defer reg.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := reg.WatchServices(ctx, "demo", "grpc")
+	assert.Nil(t, err)
+	endpoints := waitEndpoints(t, ch, func(endpoints registry.Endpoints) bool { return len(endpoints.Nodes) == 1 })
+	assert.Contains(t, endpoints.Nodes, "grpc://10.0.0.1:9091")
+
+	// new pod scaled up
+	_, _ = client.CoreV1().Pods("test").Create(testPod("demo-1", nil))
+	_, err = client.DiscoveryV1beta1().EndpointSlices("test").Update(testSlice("demo-abc", true, "demo-0", "demo-1"))
+	assert.Nil(t, err)
+	waitEndpoints(t, ch, func(endpoints registry.Endpoints) bool { return len(endpoints.Nodes) == 2 })
+
+	// pod disabled by annotation
+	_, err = client.CoreV1().Pods("test").Update(testPod("demo-1", map[string]string{"jupiter.douyu.io/enable": "false"}))
+	assert.Nil(t, err)
+	endpoints = waitEndpoints(t, ch, func(endpoints registry.Endpoints) bool { return len(endpoints.Nodes) == 1 })
+	assert.Contains(t, endpoints.Nodes, "grpc://10.0.0.1:9091")
+
+	// slice of service removed
+	assert.Nil(t, client.DiscoveryV1beta1().EndpointSlices("test").Delete("demo-abc", nil))
+	waitEndpoints(t, ch, func(endpoints registry.Endpoints) bool { return len(endpoints.Nodes) == 0 })
+}
+
+func TestRegisterService(t *testing.T) {
+	reg, client := newTestRegistry(testPod("demo-0", nil))
+	defer reg.Close()
+
+	info := &server.ServiceInfo{
+		Name:       "demo",
+		Weight:     30,
+		Enable:     true,
+		Deployment: "canary",
+		Metadata:   map[string]string{"appVersion": "v2"},
+	}
+
+	// none mode does nothing
+	assert.Nil(t, reg.RegisterService(context.Background(), info))
+	pod, _ := client.CoreV1().Pods("test").Get("demo-0", metav1.GetOptions{})
+	assert.Len(t, pod.Annotations, 0)
+
+	reg.RegisterMode = RegisterModeAnnotation
+	assert.Nil(t, reg.RegisterService(context.Background(), info))
+	pod, _ = client.CoreV1().Pods("test").Get("demo-0", metav1.GetOptions{})
+	assert.Equal(t, "30", pod.Annotations["jupiter.douyu.io/weight"])
+	assert.Equal(t, "true", pod.Annotations["jupiter.douyu.io/enable"])
+	assert.Equal(t, "canary", pod.Annotations["jupiter.douyu.io/deployment"])
+	assert.Equal(t, "v2", pod.Annotations["jupiter.douyu.io/appVersion"])
+
+	assert.Nil(t, reg.UnregisterService(context.Background(), info))
+	pod, _ = client.CoreV1().Pods("test").Get("demo-0", metav1.GetOptions{})
+	assert.Equal(t, "false", pod.Annotations["jupiter.douyu.io/enable"])
+	assert.Equal(t, "30", pod.Annotations["jupiter.douyu.io/weight"])
+}
+
+func waitEndpoints(t *testing.T, ch chan registry.Endpoints, cond func(registry.Endpoints) bool) registry.Endpoints {
+	timeout := time.After(time.Second * 3)
+	for {
+		select {
+		case endpoints := <-ch:
+			if cond(endpoints) {
+				return endpoints
+			}
+		case <-timeout:
+			t.Fatal("wait endpoints timeout")
+		}
+	}
+}
@@ -0,0 +1,383 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/douyu/jupiter/pkg/constant"
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/server"
+)
+
+// DefaultStore store shared by registries created with nil store in the same process
+var DefaultStore = NewStore()
+
+// Store keeps services and configurators in memory, and notifies watchers on change
+type Store struct {
+	mu        sync.RWMutex
+	endpoints map[string]*registry.Endpoints
+	watchers  map[string]map[*watcher]struct{}
+	// consumers of service, keyed by label, they are not discovered by watchers
+	consumers map[string]map[string]server.ServiceInfo
+}
+
+// NewStore ...
+func NewStore() *Store {
+	return &Store{
+		endpoints: make(map[string]*registry.Endpoints),
+		watchers:  make(map[string]map[*watcher]struct{}),
+		consumers: make(map[string]map[string]server.ServiceInfo),
+	}
+}
+
+// Put puts service node, it's used to inject provider events in tests
+func (s *Store) Put(info *server.ServiceInfo) {
+	if info.Kind == constant.ServiceConsumer {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.consumers[info.Name] == nil {
+			s.consumers[info.Name] = make(map[string]server.ServiceInfo)
+		}
+		s.consumers[info.Name][info.Label()] = *info
+		return
+	}
+	s.update(info.Name, func(al *registry.Endpoints) {
+		al.Nodes[info.Label()] = *info
+	})
+}
+
+// Delete deletes service node
+func (s *Store) Delete(info *server.ServiceInfo) {
+	if info.Kind == constant.ServiceConsumer {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.consumers[info.Name], info.Label())
+		return
+	}
+	s.update(info.Name, func(al *registry.Endpoints) {
+		delete(al.Nodes, info.Label())
+	})
+}
+
+// PutRouteConfig puts route config of service, keyed by {scheme}://{host}/routes/{id}
+func (s *Store) PutRouteConfig(name string, config registry.RouteConfig) {
+	s.update(name, func(al *registry.Endpoints) {
+		al.RouteConfigs[config.Key()] = config
+	})
+}
+
+// PutProviderConfig puts provider config of service, keyed by {scheme}://{host}/providers/{id}
+func (s *Store) PutProviderConfig(name string, config registry.ProviderConfig) {
+	s.update(name, func(al *registry.Endpoints) {
+		al.ProviderConfigs[config.Key()] = config
+	})
+}
+
+// PutConsumerConfig puts consumer config of service, keyed by {scheme}://{host}/consumers/{id}
+func (s *Store) PutConsumerConfig(name string, config registry.ConsumerConfig) {
+	s.update(name, func(al *registry.Endpoints) {
+		al.ConsumerConfigs[configKey(config.Scheme, config.Host, "consumers", config.ID)] = config
+	})
+}
+
+// DeleteConfig deletes route/provider/consumer config of service by key
+func (s *Store) DeleteConfig(name string, key string) {
+	s.update(name, func(al *registry.Endpoints) {
+		delete(al.RouteConfigs, key)
+		delete(al.ProviderConfigs, key)
+		delete(al.ConsumerConfigs, key)
+	})
+}
+
+// Rules returns route and provider configs of service
+func (s *Store) Rules(name string) registry.Rules {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return sortedRules(s.endpoints[name])
+}
+
+// PublishRules replaces route and provider configs of service
+func (s *Store) PublishRules(name string, rules registry.Rules) {
+	s.update(name, func(al *registry.Endpoints) {
+		al.RouteConfigs = make(map[string]registry.RouteConfig, len(rules.Routes))
+		for _, route := range rules.Routes {
+			al.RouteConfigs[route.Key()] = route
+		}
+		al.ProviderConfigs = make(map[string]registry.ProviderConfig, len(rules.Providers))
+		for _, provider := range rules.Providers {
+			al.ProviderConfigs[provider.Key()] = provider
+		}
+	})
+}
+
+// Catalog returns providers and consumers of all services
+func (s *Store) Catalog() []*server.ServiceInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var services = make([]*server.ServiceInfo, 0)
+	for _, al := range s.endpoints {
+		for _, info := range al.Nodes {
+			info := info
+			info.Kind = constant.ServiceProvider
+			services = append(services, &info)
+		}
+	}
+	for _, consumers := range s.consumers {
+		for _, info := range consumers {
+			info := info
+			services = append(services, &info)
+		}
+	}
+	return services
+}
+
+// Reset removes all services and configs, watchers receive empty endpoints
+func (s *Store) Reset() {
+	s.mu.Lock()
+	s.consumers = make(map[string]map[string]server.ServiceInfo)
+	names := make([]string, 0, len(s.endpoints))
+	for name := range s.endpoints {
+		names = append(names, name)
+	}
+	s.mu.Unlock()
+	for _, name := range names {
+		s.update(name, func(al *registry.Endpoints) {
+			*al = *newEndpoints()
+		})
+	}
+}
+
+// Endpoints returns snapshot of service endpoints with scheme
+func (s *Store) Endpoints(name, scheme string) registry.Endpoints {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return filter(s.endpoints[name], scheme)
+}
+
+func (s *Store) update(name string, fn func(al *registry.Endpoints)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	al, ok := s.endpoints[name]
+	if !ok {
+		al = newEndpoints()
+		s.endpoints[name] = al
+	}
+	fn(al)
+	for w := range s.watchers[name] {
+		w.push(filter(al, w.scheme))
+	}
+}
+
+func (s *Store) watch(name, scheme string) *watcher {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w := &watcher{scheme: scheme, out: make(chan registry.Endpoints, 10)}
+	if s.watchers[name] == nil {
+		s.watchers[name] = make(map[*watcher]struct{})
+	}
+	s.watchers[name][w] = struct{}{}
+	w.push(filter(s.endpoints[name], scheme))
+	return w
+}
+
+func (s *Store) unwatch(name string, w *watcher) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.watchers[name], w)
+}
+
+// Registry registers services into store, and discovers services from it
+type Registry struct {
+	store *Store
+
+	mu         sync.Mutex
+	registered map[string]*server.ServiceInfo
+	ctx        context.Context
+	cancel     context.CancelFunc
+}
+
+// New creates registry backed by store, DefaultStore is used if store is nil
+func New(store *Store) *Registry {
+	if store == nil {
+		store = DefaultStore
+	}
+	reg := &Registry{
+		store:      store,
+		registered: make(map[string]*server.ServiceInfo),
+	}
+	reg.ctx, reg.cancel = context.WithCancel(context.Background())
+	return reg
+}
+
+// Store returns store of registry
+func (reg *Registry) Store() *Store {
+	return reg.store
+}
+
+// RegisterService ...
+func (reg *Registry) RegisterService(ctx context.Context, info *server.ServiceInfo) error {
+	reg.mu.Lock()
+	reg.registered[info.Name+"/"+info.Label()] = info
+	reg.mu.Unlock()
+	reg.store.Put(info)
+	return nil
+}
+
+// UnregisterService ...
+func (reg *Registry) UnregisterService(ctx context.Context, info *server.ServiceInfo) error {
+	reg.mu.Lock()
+	delete(reg.registered, info.Name+"/"+info.Label())
+	reg.mu.Unlock()
+	reg.store.Delete(info)
+	return nil
+}
+
+// ListServices ...
+func (reg *Registry) ListServices(ctx context.Context, name string, scheme string) ([]*server.ServiceInfo, error) {
+	al := reg.store.Endpoints(name, scheme)
+	services := make([]*server.ServiceInfo, 0, len(al.Nodes))
+	for _, info := range al.Nodes {
+		info := info
+		services = append(services, &info)
+	}
+	return services, nil
+}
+
+// WatchServices returns channel receiving endpoints on every change, the channel
+// stops receiving after ctx is done or registry is closed
+func (reg *Registry) WatchServices(ctx context.Context, name string, scheme string) (chan registry.Endpoints, error) {
+	w := reg.store.watch(name, scheme)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-reg.ctx.Done():
+		}
+		reg.store.unwatch(name, w)
+	}()
+	return w.out, nil
+}
+
+// GetRules ...
+func (reg *Registry) GetRules(ctx context.Context, name string) (registry.Rules, error) {
+	return reg.store.Rules(name), nil
+}
+
+// PublishRules ...
+func (reg *Registry) PublishRules(ctx context.Context, name string, rules registry.Rules) error {
+	reg.store.PublishRules(name, rules)
+	return nil
+}
+
+// Catalog ...
+func (reg *Registry) Catalog(ctx context.Context) ([]*server.ServiceInfo, error) {
+	return reg.store.Catalog(), nil
+}
+
+// Close unregisters services registered by this registry
+func (reg *Registry) Close() error {
+	reg.cancel()
+	reg.mu.Lock()
+	registered := reg.registered
+	reg.registered = make(map[string]*server.ServiceInfo)
+	reg.mu.Unlock()
+	for _, info := range registered {
+		reg.store.Delete(info)
+	}
+	return nil
+}
+
+type watcher struct {
+	scheme string
+	out    chan registry.Endpoints
+}
+
+// push sends snapshot of endpoints, drops the oldest one if receiver is slow
+func (w *watcher) push(al registry.Endpoints) {
+	for {
+		select {
+		case w.out <- al:
+			return
+		default:
+		}
+		select {
+		case <-w.out:
+		default:
+		}
+	}
+}
+
+// filter returns copy of endpoints with scheme
+func filter(al *registry.Endpoints, scheme string) registry.Endpoints {
+	out := newEndpoints()
+	if al == nil {
+		return *out
+	}
+	for key, info := range al.Nodes {
+		if info.Scheme == scheme {
+			out.Nodes[key] = info
+		}
+	}
+	prefix := scheme + "://"
+	for key, config := range al.RouteConfigs {
+		if strings.HasPrefix(key, prefix) {
+			out.RouteConfigs[key] = config
+		}
+	}
+	for key, config := range al.ProviderConfigs {
+		if strings.HasPrefix(key, prefix) {
+			out.ProviderConfigs[key] = config
+		}
+	}
+	for key, config := range al.ConsumerConfigs {
+		if strings.HasPrefix(key, prefix) {
+			out.ConsumerConfigs[key] = config
+		}
+	}
+	return *out
+}
+
+func configKey(scheme, host, kind, id string) string {
+	return fmt.Sprintf("%s://%s/%s/%s", scheme, host, kind, id)
+}
+
+func sortedRules(al *registry.Endpoints) registry.Rules {
+	var rules = registry.Rules{Routes: make([]registry.RouteConfig, 0), Providers: make([]registry.ProviderConfig, 0)}
+	if al == nil {
+		return rules
+	}
+	for _, route := range al.RouteConfigs {
+		rules.Routes = append(rules.Routes, route)
+	}
+	for _, provider := range al.ProviderConfigs {
+		rules.Providers = append(rules.Providers, provider)
+	}
+	sort.Slice(rules.Routes, func(i, j int) bool { return rules.Routes[i].Key() < rules.Routes[j].Key() })
+	sort.Slice(rules.Providers, func(i, j int) bool { return rules.Providers[i].Key() < rules.Providers[j].Key() })
+	return rules
+}
+
+func newEndpoints() *registry.Endpoints {
+	return &registry.Endpoints{
+		Nodes:           make(map[string]server.ServiceInfo),
+		RouteConfigs:    make(map[string]registry.RouteConfig),
+		ConsumerConfigs: make(map[string]registry.ConsumerConfig),
+		ProviderConfigs: make(map[string]registry.ProviderConfig),
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func testServiceInfo(scheme, address string) *server.ServiceInfo {
+	return &server.ServiceInfo{Name: "demo", Scheme: scheme, Address: address, Weight: 100, Enable: true}
+}
+
+func recvEndpoints(t *testing.T, ch chan registry.Endpoints) registry.Endpoints {
+	select {
+	case al := <-ch:
+		return al
+	case <-time.After(time.Second):
+		t.Fatal("receive endpoints timeout")
+	}
+	return registry.Endpoints{}
+}
+
+func TestRegistry(t *testing.T) {
+	store := NewStore()
+	provider, consumer := New(store), New(store)
+	defer consumer.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := consumer.WatchServices(ctx, "demo", "grpc")
+	assert.Nil(t, err)
+	assert.Len(t, recvEndpoints(t, ch).Nodes, 0)
+
+	assert.Nil(t, provider.RegisterService(ctx, testServiceInfo("grpc", "127.0.0.1:9091")))
+	assert.Len(t, recvEndpoints(t, ch).Nodes, 1)
+	assert.Nil(t, provider.RegisterService(ctx, testServiceInfo("http", "127.0.0.1:9090")))
+	assert.Len(t, recvEndpoints(t, ch).Nodes, 1)
+
+	services, err := consumer.ListServices(ctx, "demo", "http")
+	assert.Nil(t, err)
+	assert.Len(t, services, 1)
+	assert.Equal(t, "127.0.0.1:9090", services[0].Address)
+
+	store.PutRouteConfig("demo", registry.RouteConfig{ID: "1", Scheme: "grpc", URI: "/hello", Deployment: "canary"})
+	store.PutProviderConfig("demo", registry.ProviderConfig{ID: "1", Scheme: "http", Host: "127.0.0.1:9090"})
+	al := recvEndpoints(t, ch)
+	assert.Equal(t, "/hello", al.RouteConfigs["grpc:///routes/1"].URI)
+	assert.Len(t, recvEndpoints(t, ch).ProviderConfigs, 0)
+
+	store.DeleteConfig("demo", "grpc:///routes/1")
+	assert.Len(t, recvEndpoints(t, ch).RouteConfigs, 0)
+
+	// closing provider unregisters its services
+	assert.Nil(t, provider.Close())
+	assert.Len(t, recvEndpoints(t, ch).Nodes, 0)
+	services, err = consumer.ListServices(ctx, "demo", "http")
+	assert.Nil(t, err)
+	assert.Len(t, services, 0)
+
+	// watcher stops after ctx is done
+	cancel()
+	assert.Eventually(t, func() bool {
+		store.mu.RLock()
+		defer store.mu.RUnlock()
+		return len(store.watchers["demo"]) == 0
+	}, time.Second, time.Millisecond*10)
+}
+
+func TestDefaultStore(t *testing.T) {
+	defer DefaultStore.Reset()
+	reg := New(nil)
+	assert.Equal(t, DefaultStore, reg.Store())
+
+	DefaultStore.Put(testServiceInfo("grpc", "127.0.0.1:9091"))
+	services, err := New(nil).ListServices(context.Background(), "demo", "grpc")
+	assert.Nil(t, err)
+	assert.Len(t, services, 1)
+}
+
+func TestRegistry_Rules(t *testing.T) {
+	store := NewStore()
+	reg := New(store)
+	defer reg.Close()
+
+	ctx := context.Background()
+	ch, err := reg.WatchServices(ctx, "demo", "grpc")
+	assert.Nil(t, err)
+	recvEndpoints(t, ch)
+
+	store.PutRouteConfig("demo", registry.RouteConfig{ID: "stale", Scheme: "grpc", URI: "/hello"})
+	recvEndpoints(t, ch)
+	rules := registry.Rules{
+		Routes:    []registry.RouteConfig{{ID: "canary", Scheme: "grpc", URI: "/hello", Deployment: "canary", Match: registry.RouteMatch{AIDs: []string{"1"}}}},
+		Providers: []registry.ProviderConfig{{ID: "1", Scheme: "grpc", Host: "127.0.0.1:9091"}},
+	}
+	assert.Nil(t, reg.PublishRules(ctx, "demo", rules))
+
+	al := recvEndpoints(t, ch)
+	assert.Len(t, al.RouteConfigs, 1)
+	assert.Equal(t, []string{"1"}, al.RouteConfigs["grpc:///routes/canary"].Match.AIDs)
+	assert.Len(t, al.ProviderConfigs, 1)
+
+	got, err := reg.GetRules(ctx, "demo")
+	assert.Nil(t, err)
+	assert.Equal(t, rules, got)
+}
@@ -0,0 +1,74 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package multicast
+
+import (
+	"time"
+
+	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/xlog"
+)
+
+// StdConfig ...
+func StdConfig(name string) *Config {
+	return RawConfig("jupiter.registry." + name)
+}
+
+// RawConfig ...
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil {
+		xlog.Panic("unmarshal key", xlog.FieldMod(ecode.ModRegistryMulticast), xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr), xlog.FieldErr(err), xlog.String("key", key), xlog.Any("config", config))
+	}
+	return config
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		Address:  "239.255.77.77:7777",
+		Interval: time.Second * 2,
+		TTL:      time.Second * 6,
+		logger:   xlog.JupiterLogger,
+	}
+}
+
+// Config ...
+type Config struct {
+	// Address multicast group address
+	Address string
+	// Interface network interface to join multicast group, empty for system default
+	Interface string
+	// Interval interval of announcing registered services
+	Interval time.Duration
+	// TTL discovered services expire after TTL without announcement
+	TTL time.Duration
+
+	logger *xlog.Logger
+}
+
+// Build ...
+func (config Config) Build() registry.Registry {
+	if config.logger == nil {
+		config.logger = xlog.JupiterLogger
+	}
+	reg, err := newMulticastRegistry(&config)
+	if err != nil {
+		config.logger.Panic("build multicast registry", xlog.FieldMod(ecode.ModRegistryMulticast), xlog.FieldErrKind(ecode.ErrKindListenErr), xlog.FieldErr(err), xlog.FieldAddr(config.Address))
+	}
+	return reg
+}
@@ -0,0 +1,377 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package multicast
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/constant"
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/util/xgo"
+	"github.com/douyu/jupiter/pkg/xlog"
+)
+
+const (
+	opAnnounce = "announce"
+	opBye      = "bye"
+	opQuery    = "query"
+
+	maxPacketSize = 65507
+)
+
+// message is sent to multicast group as json
+type message struct {
+	Op       string               `json:"op"`
+	Instance string               `json:"instance"`
+	Services []server.ServiceInfo `json:"services,omitempty"`
+}
+
+type peerService struct {
+	info     server.ServiceInfo
+	expireAt time.Time
+}
+
+// multicastRegistry announces registered services to multicast group periodically,
+// and discovers services announced by other processes in the LAN
+type multicastRegistry struct {
+	*Config
+	instance string
+	group    *net.UDPAddr
+	listener *net.UDPConn
+	sender   *net.UDPConn
+
+	mu       sync.Mutex
+	local    map[string]server.ServiceInfo
+	peers    map[string]peerService
+	watchers map[*watcher]struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newMulticastRegistry(config *Config) (*multicastRegistry, error) {
+	if config.logger == nil {
+		config.logger = xlog.JupiterLogger
+	}
+	config.logger = config.logger.With(xlog.FieldMod(ecode.ModRegistryMulticast), xlog.FieldAddr(config.Address))
+	if config.TTL < config.Interval {
+		config.TTL = config.Interval * 3
+	}
+
+	group, err := net.ResolveUDPAddr("udp4", config.Address)
+	if err != nil {
+		return nil, err
+	}
+	var ifi *net.Interface
+	if config.Interface != "" {
+		if ifi, err = net.InterfaceByName(config.Interface); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.ListenMulticastUDP("udp4", ifi, group)
+	if err != nil {
+		return nil, err
+	}
+	sender, err := net.DialUDP("udp4", nil, group)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	reg := &multicastRegistry{
+		Config:   config,
+		instance: hex.EncodeToString(id),
+		group:    group,
+		listener: listener,
+		sender:   sender,
+		local:    make(map[string]server.ServiceInfo),
+		peers:    make(map[string]peerService),
+		watchers: make(map[*watcher]struct{}),
+	}
+	reg.ctx, reg.cancel = context.WithCancel(context.Background())
+	reg.wg.Add(2)
+	xgo.Go(reg.receive)
+	xgo.Go(reg.tick)
+	return reg, nil
+}
+
+// RegisterService announces service to multicast group
+func (reg *multicastRegistry) RegisterService(ctx context.Context, info *server.ServiceInfo) error {
+	if info.Kind == constant.ServiceConsumer {
+		// 只广播服务提供方
+		return nil
+	}
+	reg.mu.Lock()
+	reg.local[serviceKey(*info)] = *info
+	reg.mu.Unlock()
+	reg.notify(info.Name)
+	return reg.send(message{Op: opAnnounce, Services: []server.ServiceInfo{*info}})
+}
+
+// UnregisterService tells other processes to remove service
+func (reg *multicastRegistry) UnregisterService(ctx context.Context, info *server.ServiceInfo) error {
+	if info.Kind == constant.ServiceConsumer {
+		return nil
+	}
+	reg.mu.Lock()
+	delete(reg.local, serviceKey(*info))
+	reg.mu.Unlock()
+	reg.notify(info.Name)
+	return reg.send(message{Op: opBye, Services: []server.ServiceInfo{*info}})
+}
+
+// ListServices lists services discovered so far
+func (reg *multicastRegistry) ListServices(ctx context.Context, name string, scheme string) ([]*server.ServiceInfo, error) {
+	al := reg.endpoints(name, scheme)
+	services := make([]*server.ServiceInfo, 0, len(al.Nodes))
+	for _, info := range al.Nodes {
+		info := info
+		services = append(services, &info)
+	}
+	return services, nil
+}
+
+// WatchServices queries the multicast group for services, and watches announcements
+func (reg *multicastRegistry) WatchServices(ctx context.Context, name string, scheme string) (chan registry.Endpoints, error) {
+	w := &watcher{name: name, scheme: scheme, out: make(chan registry.Endpoints, 10)}
+	reg.mu.Lock()
+	reg.watchers[w] = struct{}{}
+	reg.mu.Unlock()
+	w.push(func() registry.Endpoints { return reg.endpoints(name, scheme) })
+
+	if err := reg.send(message{Op: opQuery}); err != nil {
+		reg.logger.Error("query services", xlog.FieldErrKind(ecode.ErrKindRequestErr), xlog.FieldErr(err), xlog.FieldName(name))
+	}
+
+	xgo.Go(func() {
+		select {
+		case <-ctx.Done():
+		case <-reg.ctx.Done():
+		}
+		reg.mu.Lock()
+		delete(reg.watchers, w)
+		reg.mu.Unlock()
+	})
+	return w.out, nil
+}
+
+// Close says bye for registered services, and stops announcing
+func (reg *multicastRegistry) Close() error {
+	reg.mu.Lock()
+	services := make([]server.ServiceInfo, 0, len(reg.local))
+	for _, info := range reg.local {
+		services = append(services, info)
+	}
+	reg.local = make(map[string]server.ServiceInfo)
+	reg.mu.Unlock()
+	if len(services) > 0 {
+		_ = reg.send(message{Op: opBye, Services: services})
+	}
+
+	reg.cancel()
+	_ = reg.listener.Close()
+	reg.wg.Wait()
+	return reg.sender.Close()
+}
+
+func (reg *multicastRegistry) send(msg message) error {
+	msg.Instance = reg.instance
+	bs, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = reg.sender.Write(bs)
+	return err
+}
+
+func (reg *multicastRegistry) receive() {
+	defer reg.wg.Done()
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, _, err := reg.listener.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-reg.ctx.Done():
+				return
+			default:
+			}
+			reg.logger.Error("read multicast packet", xlog.FieldErrKind(ecode.ErrKindRequestErr), xlog.FieldErr(err))
+			continue
+		}
+
+		var msg message
+		if err := json.Unmarshal(buf[:n], &msg); err != nil {
+			reg.logger.Warn("invalid multicast packet", xlog.FieldErr(err))
+			continue
+		}
+		if msg.Instance == reg.instance {
+			continue
+		}
+		reg.handle(msg)
+	}
+}
+
+func (reg *multicastRegistry) handle(msg message) {
+	switch msg.Op {
+	case opQuery:
+		reg.announce()
+		return
+	case opAnnounce, opBye:
+	default:
+		return
+	}
+
+	names := make(map[string]struct{})
+	reg.mu.Lock()
+	for _, info := range msg.Services {
+		names[info.Name] = struct{}{}
+		if msg.Op == opBye {
+			delete(reg.peers, serviceKey(info))
+			continue
+		}
+		reg.peers[serviceKey(info)] = peerService{info: info, expireAt: time.Now().Add(reg.TTL)}
+	}
+	reg.mu.Unlock()
+	for name := range names {
+		reg.notify(name)
+	}
+}
+
+func (reg *multicastRegistry) tick() {
+	defer reg.wg.Done()
+	ticker := time.NewTicker(reg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-reg.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		reg.announce()
+		reg.expire()
+	}
+}
+
+func (reg *multicastRegistry) announce() {
+	reg.mu.Lock()
+	services := make([]server.ServiceInfo, 0, len(reg.local))
+	for _, info := range reg.local {
+		services = append(services, info)
+	}
+	reg.mu.Unlock()
+	if len(services) == 0 {
+		return
+	}
+	if err := reg.send(message{Op: opAnnounce, Services: services}); err != nil {
+		reg.logger.Error("announce services", xlog.FieldErrKind(ecode.ErrKindRegisterErr), xlog.FieldErr(err))
+	}
+}
+
+// expire removes services not announced within TTL
+func (reg *multicastRegistry) expire() {
+	now := time.Now()
+	names := make(map[string]struct{})
+	reg.mu.Lock()
+	for key, peer := range reg.peers {
+		if now.After(peer.expireAt) {
+			delete(reg.peers, key)
+			names[peer.info.Name] = struct{}{}
+		}
+	}
+	reg.mu.Unlock()
+	for name := range names {
+		reg.notify(name)
+	}
+}
+
+func (reg *multicastRegistry) notify(name string) {
+	reg.mu.Lock()
+	watchers := make([]*watcher, 0)
+	for w := range reg.watchers {
+		if w.name == name {
+			watchers = append(watchers, w)
+		}
+	}
+	reg.mu.Unlock()
+	for _, w := range watchers {
+		w := w
+		w.push(func() registry.Endpoints { return reg.endpoints(w.name, w.scheme) })
+	}
+}
+
+// endpoints returns services registered by this process and discovered from others
+func (reg *multicastRegistry) endpoints(name, scheme string) registry.Endpoints {
+	al := registry.Endpoints{
+		Nodes:           make(map[string]server.ServiceInfo),
+		RouteConfigs:    make(map[string]registry.RouteConfig),
+		ConsumerConfigs: make(map[string]registry.ConsumerConfig),
+		ProviderConfigs: make(map[string]registry.ProviderConfig),
+	}
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	for _, peer := range reg.peers {
+		if peer.info.Name == name && peer.info.Scheme == scheme {
+			al.Nodes[peer.info.Label()] = peer.info
+		}
+	}
+	for _, info := range reg.local {
+		if info.Name == name && info.Scheme == scheme {
+			al.Nodes[info.Label()] = info
+		}
+	}
+	return al
+}
+
+func serviceKey(info server.ServiceInfo) string {
+	return info.Name + "/" + info.Label()
+}
+
+type watcher struct {
+	name   string
+	scheme string
+
+	mu  sync.Mutex
+	out chan registry.Endpoints
+}
+
+// push sends snapshot of endpoints, drops the oldest one if receiver is slow,
+// snapshot is taken under lock of watcher so that snapshots are sent in order
+func (w *watcher) push(snapshot func() registry.Endpoints) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	al := snapshot()
+	for {
+		select {
+		case w.out <- al:
+			return
+		default:
+		}
+		select {
+		case <-w.out:
+		default:
+		}
+	}
+}
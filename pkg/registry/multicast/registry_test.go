@@ -0,0 +1,93 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package multicast
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRegistry(t *testing.T) *multicastRegistry {
+	config := DefaultConfig()
+	config.Address = "239.255.77.78:17778"
+	config.Interval = time.Millisecond * 100
+	config.TTL = time.Millisecond * 300
+	reg, err := newMulticastRegistry(config)
+	if err != nil {
+		t.Skipf("multicast unavailable: %v", err)
+	}
+	return reg
+}
+
+func waitEndpoints(t *testing.T, ch chan registry.Endpoints, cond func(registry.Endpoints) bool) registry.Endpoints {
+	timeout := time.After(time.Second * 3)
+	for {
+		select {
+		case al := <-ch:
+			if cond(al) {
+				return al
+			}
+		case <-timeout:
+			t.Fatal("wait endpoints timeout")
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	provider := newTestRegistry(t)
+	defer provider.Close()
+	consumer := newTestRegistry(t)
+	defer consumer.Close()
+
+	info := &server.ServiceInfo{Name: "demo", Scheme: "grpc", Address: "127.0.0.1:9091", Weight: 100, Enable: true, Zone: "z1"}
+	assert.Nil(t, provider.RegisterService(context.Background(), info))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := consumer.WatchServices(ctx, "demo", "grpc")
+	assert.Nil(t, err)
+	al := waitEndpoints(t, ch, func(al registry.Endpoints) bool { return len(al.Nodes) == 1 })
+	assert.Equal(t, "z1", al.Nodes["grpc://127.0.0.1:9091"].Zone)
+
+	services, err := consumer.ListServices(ctx, "demo", "grpc")
+	assert.Nil(t, err)
+	assert.Len(t, services, 1)
+
+	// bye removes service immediately
+	assert.Nil(t, provider.UnregisterService(context.Background(), info))
+	waitEndpoints(t, ch, func(al registry.Endpoints) bool { return len(al.Nodes) == 0 })
+
+	// service expires if provider stops announcing
+	assert.Nil(t, provider.RegisterService(context.Background(), info))
+	waitEndpoints(t, ch, func(al registry.Endpoints) bool { return len(al.Nodes) == 1 })
+	provider.cancel()
+	waitEndpoints(t, ch, func(al registry.Endpoints) bool { return len(al.Nodes) == 0 })
+}
+
+func TestLocalServices(t *testing.T) {
+	reg := newTestRegistry(t)
+	defer reg.Close()
+
+	info := &server.ServiceInfo{Name: "local", Scheme: "grpc", Address: "127.0.0.1:9091", Enable: true}
+	assert.Nil(t, reg.RegisterService(context.Background(), info))
+	services, err := reg.ListServices(context.Background(), "local", "grpc")
+	assert.Nil(t, err)
+	assert.Len(t, services, 1)
+}
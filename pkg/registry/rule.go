@@ -0,0 +1,107 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/douyu/jupiter/pkg/server/governor"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// DefaultRegisterer registry used by application, rules are read through it
+var DefaultRegisterer Registry = Nop{}
+
+// Rules traffic governance rules of service
+type Rules struct {
+	// 路由规则: 金丝雀、权重分流
+	Routes []RouteConfig `json:"routes"`
+	// 节点规则: 节点禁用、属性覆盖
+	Providers []ProviderConfig `json:"providers"`
+}
+
+// RulePublisher is implemented by registries which support publishing traffic rules
+type RulePublisher interface {
+	// GetRules returns rules of service
+	GetRules(ctx context.Context, name string) (Rules, error)
+	// PublishRules replaces rules of service with rules
+	PublishRules(ctx context.Context, name string, rules Rules) error
+}
+
+func init() {
+	// 只读接口, 规则通过 jupiter rule publish 直接发布到注册中心
+	governor.HandleFunc("/debug/registry/rules", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		publisher, ok := DefaultRegisterer.(RulePublisher)
+		if !ok {
+			http.Error(w, "registry doesn't support rules", http.StatusNotImplemented)
+			return
+		}
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			http.Error(w, "name is required", http.StatusBadRequest)
+			return
+		}
+
+		rules, err := publisher.GetRules(r.Context(), name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_ = jsoniter.NewEncoder(w).Encode(rules)
+	})
+}
+
+// Validate checks rules, scheme of rules is grpc if empty
+func (rules *Rules) Validate() error {
+	for i := range rules.Routes {
+		route := &rules.Routes[i]
+		if route.Scheme == "" {
+			route.Scheme = "grpc"
+		}
+		if route.ID == "" {
+			return fmt.Errorf("routes[%d]: id is required", i)
+		}
+		if route.URI == "" {
+			return fmt.Errorf("routes[%d]: uri is required", i)
+		}
+		if route.Match.Percent < 0 || route.Match.Percent > 100 {
+			return fmt.Errorf("routes[%d]: percent should be in [0, 100]", i)
+		}
+	}
+	for i := range rules.Providers {
+		provider := &rules.Providers[i]
+		if provider.Scheme == "" {
+			provider.Scheme = "grpc"
+		}
+		if provider.Host == "" {
+			return fmt.Errorf("providers[%d]: host is required", i)
+		}
+		if provider.ID == "" {
+			provider.ID = "1"
+		}
+		// 显式保存启用状态, 避免与未配置混淆
+		if provider.Enable == nil {
+			enable := true
+			provider.Enable = &enable
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/douyu/jupiter/pkg/server/governor"
+	"github.com/stretchr/testify/assert"
+)
+
+type testPublisher struct {
+	Nop
+	rules Rules
+}
+
+func (p *testPublisher) GetRules(ctx context.Context, name string) (Rules, error) {
+	return p.rules, nil
+}
+
+func (p *testPublisher) PublishRules(ctx context.Context, name string, rules Rules) error {
+	p.rules = rules
+	return nil
+}
+
+func TestRulesHandler(t *testing.T) {
+	publisher := &testPublisher{rules: Rules{Providers: []ProviderConfig{{ID: "1", Scheme: "grpc", Host: "127.0.0.1:9091"}}}}
+	defer func(registerer Registry) { DefaultRegisterer = registerer }(DefaultRegisterer)
+	DefaultRegisterer = publisher
+
+	w := httptest.NewRecorder()
+	governor.DefaultServeMux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/registry/rules?name=demo", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "127.0.0.1:9091")
+
+	// rules are not writable through governor
+	w = httptest.NewRecorder()
+	governor.DefaultServeMux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/debug/registry/rules?name=demo", strings.NewReader(`{"providers":[]}`)))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Len(t, publisher.rules.Providers, 1)
+}
+
+func TestRules_Validate(t *testing.T) {
+	disable := false
+	rules := Rules{Providers: []ProviderConfig{
+		{Host: "127.0.0.1:9091", Zone: "z1"},
+		{Host: "127.0.0.1:9092", Enable: &disable},
+	}}
+	assert.Nil(t, rules.Validate())
+	// enable is saved explicitly, nodes are only disabled on purpose
+	assert.True(t, *rules.Providers[0].Enable)
+	assert.False(t, rules.Providers[1].Enabled())
+	assert.Equal(t, "grpc://127.0.0.1:9091/providers/1", rules.Providers[0].Key())
+
+	rules = Rules{Providers: []ProviderConfig{{Zone: "z1"}}}
+	assert.NotNil(t, rules.Validate())
+}
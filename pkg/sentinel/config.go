@@ -91,3 +91,18 @@ func (config *Config) Build() error {
 func Entry(resource string) (*base.SentinelEntry, *base.BlockError) {
 	return sentinel.Entry(resource)
 }
+
+// EntryInbound enters an inbound rpc resource,
+// args are passed to hotspot rules, e.g. caller aid
+func EntryInbound(resource string, args ...interface{}) (*base.SentinelEntry, *base.BlockError) {
+	return sentinel.Entry(resource,
+		sentinel.WithResourceType(base.ResTypeRPC),
+		sentinel.WithTrafficType(base.Inbound),
+		sentinel.WithArgs(args...),
+	)
+}
+
+// TraceError records business error to entry, which is used by circuit breaker rules
+func TraceError(entry *base.SentinelEntry, err error) {
+	sentinel.TraceError(entry, err)
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/douyu/jupiter/pkg/conf"
 	"github.com/douyu/jupiter/pkg/util/xnet"
+	"github.com/douyu/jupiter/pkg/util/xtls"
 	"github.com/douyu/jupiter/pkg/xlog"
 )
 
@@ -21,6 +22,8 @@ type Config struct {
 
 	// ServiceAddress service address in registry info, default to 'Host:Port'
 	ServiceAddress string
+	// TLS tls config, plaintext if nil or not enabled
+	TLS *xtls.Config
 }
 
 // StdConfig represents Standard gRPC Server config
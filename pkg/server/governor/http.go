@@ -19,6 +19,8 @@ import (
 	"net/http"
 	"net/http/pprof"
 	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 var (
@@ -39,6 +41,11 @@ func init() {
 	HandleFunc("/debug/pprof/symbol", pprof.Symbol)
 	HandleFunc("/debug/pprof/trace", pprof.Trace)
 
+	// metrics of default prometheus registry, which pkg/metric registers to
+	HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		promhttp.Handler().ServeHTTP(w, r)
+	})
+
 	if info, ok := debug.ReadBuildInfo(); ok {
 		HandleFunc("/modInfo", func(w http.ResponseWriter, r *http.Request) {
 			encoder := json.NewEncoder(w)
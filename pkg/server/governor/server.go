@@ -2,6 +2,7 @@ package governor
 
 import (
 	"context"
+	"crypto/tls"
 	"net"
 	"net/http"
 
@@ -22,6 +23,13 @@ func newServer(config *Config) *Server {
 	if err != nil {
 		xlog.Panic("governor start error", xlog.FieldErr(err))
 	}
+	if config.TLS != nil && config.TLS.Enable {
+		tlsConfig, err := config.TLS.ServerTLSConfig()
+		if err != nil {
+			xlog.Panic("governor tls config error", xlog.FieldErr(err))
+		}
+		listener = tls.NewListener(listener, tlsConfig)
+	}
 
 	return &Server{
 		Server: &http.Server{
@@ -60,11 +68,16 @@ func (s *Server) Info() *server.ServiceInfo {
 		serviceAddr = s.Config.ServiceAddress
 	}
 
-	info := server.ApplyOptions(
+	var options = []server.Option{
 		server.WithScheme("http"),
 		server.WithAddress(serviceAddr),
 		server.WithKind(constant.ServiceGovernor),
-	)
+	}
+	if s.Config.TLS != nil && s.Config.TLS.Enable {
+		options = append(options, server.WithMetaData("tls", "true"))
+	}
+
+	info := server.ApplyOptions(options...)
 	// info.Name = info.Name + "." + ModName
 	return &info
 }
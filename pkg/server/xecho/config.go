@@ -16,10 +16,13 @@ package xecho
 
 import (
 	"fmt"
+	"net"
 
 	"github.com/douyu/jupiter/pkg/conf"
 	"github.com/douyu/jupiter/pkg/constant"
 	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/server/xhttp"
+	"github.com/douyu/jupiter/pkg/util/xtls"
 	"github.com/douyu/jupiter/pkg/flag"
 	"github.com/douyu/jupiter/pkg/xlog"
 
@@ -37,12 +40,28 @@ type Config struct {
 	Debug         bool
 	DisableMetric bool
 	DisableTrace  bool
+	// MetricLabels labels of server_http_handle metrics among method, route, peer, code and code_class,
+	// method, route and code_class by default
+	MetricLabels []string
 	// ServiceAddress service address in registry info, default to 'Host:Port'
 	ServiceAddress string
 
 	SlowQueryThresholdInMilli int64
-
-	logger *xlog.Logger
+	// TLS tls config, plaintext if nil or not enabled
+	TLS *xtls.Config
+	// CORS cors policy, disabled if nil or not enabled
+	CORS *xhttp.CORSConfig
+	// MaxBodySize max bytes of request body, unlimited if 0
+	MaxBodySize int64
+	// RateLimit token bucket rate limit of routes, disabled if nil or not enabled
+	RateLimit *xhttp.RateLimitConfig
+	// Compress response compression, disabled if nil or not enabled
+	Compress *xhttp.CompressConfig
+	// Secure security headers like HSTS and CSP, disabled if nil or not enabled
+	Secure *xhttp.SecureConfig
+
+	listener net.Listener
+	logger   *xlog.Logger
 }
 
 // DefaultConfig ...
@@ -72,6 +91,12 @@ func RawConfig(key string) *Config {
 	return config
 }
 
+// WithListener serves on listener instead of listening on Address, e.g. listener of xmux.Server
+func (config *Config) WithListener(listener net.Listener) *Config {
+	config.listener = listener
+	return config
+}
+
 // WithLogger ...
 func (config *Config) WithLogger(logger *xlog.Logger) *Config {
 	config.logger = logger
@@ -93,15 +118,7 @@ func (config *Config) WithPort(port int) *Config {
 // Build create server instance, then initialize it with necessary interceptor
 func (config *Config) Build() *Server {
 	server := newServer(config)
-	server.Use(recoverMiddleware(config.logger, config.SlowQueryThresholdInMilli))
-
-	if !config.DisableMetric {
-		server.Use(metricServerInterceptor())
-	}
-
-	if !config.DisableTrace {
-		server.Use(traceServerInterceptor())
-	}
+	server.Use(Middleware(xhttp.Chain(defaultMiddlewares(config)...)))
 	return server
 }
 
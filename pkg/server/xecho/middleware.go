@@ -15,101 +15,135 @@
 package xecho
 
 import (
-	"fmt"
+	"bufio"
+	"net"
 	"net/http"
-	"runtime"
+	"reflect"
 	"time"
 
-	"github.com/douyu/jupiter/pkg/metric"
-	"github.com/douyu/jupiter/pkg/trace"
-
-	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/server/xhttp"
 
 	"github.com/labstack/echo/v4"
-	"go.uber.org/zap"
 )
 
-func extractAID(c echo.Context) string {
-	return c.Request().Header.Get("AID")
+// defaultMiddlewares returns middlewares of config, shared by all http servers
+func defaultMiddlewares(config *Config) []xhttp.Middleware {
+	var mws = []xhttp.Middleware{
+		xhttp.RequestIDMiddleware(),
+		xhttp.AccessMiddleware(config.logger, time.Duration(config.SlowQueryThresholdInMilli)*time.Millisecond),
+		xhttp.RecoveryMiddleware(),
+	}
+	if !config.DisableMetric {
+		mws = append(mws, xhttp.MetricMiddleware(config.MetricLabels...))
+	}
+	if !config.DisableTrace {
+		mws = append(mws, xhttp.TraceMiddleware())
+	}
+	if config.Secure != nil && config.Secure.Enable {
+		mws = append(mws, xhttp.SecureMiddleware(config.Secure))
+	}
+	if config.CORS != nil && config.CORS.Enable {
+		mws = append(mws, xhttp.CORSMiddleware(config.CORS))
+	}
+	if config.RateLimit != nil && config.RateLimit.Enable {
+		mws = append(mws, xhttp.RateLimitMiddleware(config.RateLimit))
+	}
+	if config.MaxBodySize > 0 {
+		mws = append(mws, xhttp.BodyLimitMiddleware(config.MaxBodySize))
+	}
+	if config.Compress != nil && config.Compress.Enable {
+		mws = append(mws, xhttp.CompressMiddleware(config.Compress))
+	}
+	return mws
 }
 
-// RecoverMiddleware ...
-func recoverMiddleware(logger *xlog.Logger, slowQueryThresholdInMilli int64) echo.MiddlewareFunc {
+// Middleware adapts net/http middleware to echo, error returned by handler
+// is handled by echo before returning to mw, so that its status is recorded,
+// route of request is the matched route template, or empty if no route matches
+func Middleware(mw xhttp.Middleware) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
-		return func(ctx echo.Context) (err error) {
-			var beg = time.Now()
-			var fields = make([]xlog.Field, 0, 8)
-
-			defer func() {
-				fields = append(fields, zap.Float64("cost", time.Since(beg).Seconds()))
-				if rec := recover(); rec != nil {
-					switch rec := rec.(type) {
-					case error:
-						err = rec
-					default:
-						err = fmt.Errorf("%v", rec)
-					}
-
-					stack := make([]byte, 4096)
-					length := runtime.Stack(stack, true)
-					fields = append(fields, zap.ByteString("stack", stack[:length]))
+		return func(c echo.Context) error {
+			var response = c.Response()
+			var rw = &responseWriter{response: response, writer: response.Writer}
+			mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				c.SetRequest(r)
+				// writer is replaced by mw, e.g. compression
+				if w != http.ResponseWriter(rw) {
+					response.Writer = w
+					defer func() { response.Writer = rw.writer }()
 				}
-				fields = append(fields,
-					zap.String("method", ctx.Request().Method),
-					zap.Int("code", ctx.Response().Status),
-					zap.String("host", ctx.Request().Host),
-					zap.String("path", ctx.Request().URL.Path),
-				)
-				if slowQueryThresholdInMilli > 0 {
-					if cost := int64(time.Since(beg)) / 1e6; cost > slowQueryThresholdInMilli {
-						fields = append(fields, zap.Int64("slow", cost))
-					}
+				if err := next(c); err != nil {
+					xhttp.SetError(r, err)
+					c.Error(err)
 				}
-				if err != nil {
-					fields = append(fields, zap.String("err", err.Error()))
-					logger.Error("access", fields...)
-					return
-				}
-				logger.Info("access", fields...)
-			}()
-
-			return next(ctx)
+			})).ServeHTTP(rw, xhttp.WithRoute(c.Request(), routeOf(c)))
+			return nil
 		}
 	}
 }
 
-func metricServerInterceptor() echo.MiddlewareFunc {
-	return func(next echo.HandlerFunc) echo.HandlerFunc {
-		return func(c echo.Context) (err error) {
-			beg := time.Now()
-			err = next(c)
-			method := c.Request().Method + "_" + c.Path()
-			peer := c.RealIP()
-			if aid := extractAID(c); aid != "" {
-				peer += "?aid=" + aid
-			}
-			metric.ServerHandleHistogram.Observe(time.Since(beg).Seconds(), metric.TypeHTTP, method, peer)
-			metric.ServerHandleCounter.Inc(metric.TypeHTTP, method, peer, http.StatusText(c.Response().Status))
-			return err
-		}
+// routeOf returns route template matched by request, echo sets path of
+// context to path of request if no route matches
+func routeOf(c echo.Context) string {
+	if reflect.ValueOf(c.Handler()).Pointer() == reflect.ValueOf(echo.NotFoundHandler).Pointer() {
+		return ""
 	}
+	return c.Path()
 }
-func traceServerInterceptor() echo.MiddlewareFunc {
-	return func(next echo.HandlerFunc) echo.HandlerFunc {
-		return func(c echo.Context) (err error) {
-			span, ctx := trace.StartSpanFromContext(
-				c.Request().Context(),
-				c.Request().Method+" "+c.Path(),
-				trace.TagComponent("http"),
-				trace.TagSpanKind("server"),
-				trace.HeaderExtractor(c.Request().Header),
-				trace.CustomTag("http.url", c.Path()),
-				trace.CustomTag("http.method", c.Request().Method),
-				trace.CustomTag("peer.ipv4", c.RealIP()),
-			)
-			c.SetRequest(c.Request().WithContext(ctx))
-			defer span.Finish()
-			return next(c)
-		}
+
+// responseWriter implements xhttp.ResponseWriter with echo.Response, response
+// written by handler through echo.Response is recorded by echo.Response, while
+// response written by middlewares is sent to writer directly
+type responseWriter struct {
+	response *echo.Response
+	writer   http.ResponseWriter
+	written  bool
+	size     int
+}
+
+func (w *responseWriter) Header() http.Header { return w.writer.Header() }
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.written {
+		return
+	}
+	w.written = true
+	if !w.response.Committed {
+		w.response.Status = status
+		w.response.Committed = true
+	}
+	w.writer.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(data []byte) (int, error) {
+	if !w.written && !w.response.Committed {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.writer.Write(data)
+	w.size += n
+	return n, err
+}
+
+func (w *responseWriter) Status() int {
+	if w.response.Status == 0 {
+		return http.StatusOK
+	}
+	return w.response.Status
+}
+
+func (w *responseWriter) Size() int {
+	if w.written {
+		return w.size
 	}
+	return int(w.response.Size)
+}
+
+func (w *responseWriter) Written() bool { return w.written || w.response.Committed }
+
+// Flush implements http.Flusher
+func (w *responseWriter) Flush() { w.response.Flush() }
+
+// Hijack implements http.Hijacker
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.response.Hijack()
 }
@@ -0,0 +1,115 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xecho
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/douyu/jupiter/pkg/server/xhttp"
+	"github.com/douyu/jupiter/pkg/xlog"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestMiddleware(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	config := DefaultConfig()
+	config.logger = xlog.Config{Core: core}.Build()
+
+	e := echo.New()
+	e.Use(Middleware(xhttp.Chain(defaultMiddlewares(config)...)))
+	e.GET("/panic", func(c echo.Context) error {
+		panic("not an error")
+	})
+	e.GET("/users/:id", func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusNotFound, "user not found")
+	})
+
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/panic", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "recover", logs.TakeAll()[0].ContextMap()["event"])
+
+	w = httptest.NewRecorder()
+	e.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users/1", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	fields := logs.TakeAll()[0].ContextMap()
+	assert.Equal(t, int64(http.StatusNotFound), fields["code"])
+	assert.Equal(t, "/users/:id", fields["route"])
+	assert.Contains(t, fields["err"], "user not found")
+}
+
+func TestMiddleware_Compress(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	config := DefaultConfig()
+	config.logger = xlog.Config{Core: core}.Build()
+	config.Compress = &xhttp.CompressConfig{Enable: true, MinLength: 8}
+	config.RateLimit = &xhttp.RateLimitConfig{Enable: true, Rate: 0.001, Burst: 1}
+
+	e := echo.New()
+	e.Use(Middleware(xhttp.Chain(defaultMiddlewares(config)...)))
+	e.GET("/users/:id", func(c echo.Context) error {
+		return c.JSON(http.StatusAccepted, map[string]string{"name": strings.Repeat("jupiter", 10)})
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	reader, err := gzip.NewReader(w.Body)
+	assert.Nil(t, err)
+	data, err := ioutil.ReadAll(reader)
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), strings.Repeat("jupiter", 10))
+	assert.Equal(t, int64(http.StatusAccepted), logs.TakeAll()[0].ContextMap()["code"])
+
+	// rejected by rate limit
+	w = httptest.NewRecorder()
+	e.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users/1", nil))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	fields := logs.TakeAll()[0].ContextMap()
+	assert.Equal(t, int64(http.StatusTooManyRequests), fields["code"])
+	assert.Equal(t, int64(w.Body.Len()), fields["size"])
+}
+
+func TestMiddleware_Route(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	config := DefaultConfig()
+	config.logger = xlog.Config{Core: core}.Build()
+
+	e := echo.New()
+	e.Use(Middleware(xhttp.Chain(defaultMiddlewares(config)...)))
+	e.GET("/users/:id", func(c echo.Context) error {
+		return c.String(http.StatusOK, "hello")
+	})
+
+	for path, route := range map[string]string{"/users/1": "/users/:id", "/rooms/1": xhttp.UnmatchedRoute} {
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		fields := logs.TakeAll()[0].ContextMap()
+		assert.Equal(t, route, fields["route"], path)
+		assert.Equal(t, path, fields["path"], path)
+	}
+}
@@ -15,177 +15,117 @@
 package xgin
 
 import (
-	"bytes"
-	"fmt"
-	"io/ioutil"
+	"bufio"
+	"errors"
 	"net"
 	"net/http"
-	"os"
-	"runtime"
-	"strings"
 	"time"
 
-	"github.com/gin-gonic/gin"
-
-	"github.com/douyu/jupiter/pkg/metric"
-	"github.com/douyu/jupiter/pkg/trace"
-	"github.com/douyu/jupiter/pkg/xlog"
-
-	"go.uber.org/zap"
-)
+	"github.com/douyu/jupiter/pkg/server/xhttp"
 
-var (
-	dunno     = []byte("???")
-	centerDot = []byte("·")
-	dot       = []byte(".")
-	slash     = []byte("/")
+	"github.com/gin-gonic/gin"
 )
 
-func extractAID(ctx *gin.Context) string {
-	return ctx.Request.Header.Get("AID")
+// defaultMiddlewares returns middlewares of config, shared by all http servers
+func defaultMiddlewares(config *Config) []xhttp.Middleware {
+	var mws = []xhttp.Middleware{
+		xhttp.RequestIDMiddleware(),
+		xhttp.AccessMiddleware(config.logger, time.Duration(config.SlowQueryThresholdInMilli)*time.Millisecond),
+		xhttp.RecoveryMiddleware(),
+	}
+	if !config.DisableMetric {
+		mws = append(mws, xhttp.MetricMiddleware(config.MetricLabels...))
+	}
+	if !config.DisableTrace {
+		mws = append(mws, xhttp.TraceMiddleware())
+	}
+	if config.Secure != nil && config.Secure.Enable {
+		mws = append(mws, xhttp.SecureMiddleware(config.Secure))
+	}
+	if config.CORS != nil && config.CORS.Enable {
+		mws = append(mws, xhttp.CORSMiddleware(config.CORS))
+	}
+	if config.RateLimit != nil && config.RateLimit.Enable {
+		mws = append(mws, xhttp.RateLimitMiddleware(config.RateLimit))
+	}
+	if config.MaxBodySize > 0 {
+		mws = append(mws, xhttp.BodyLimitMiddleware(config.MaxBodySize))
+	}
+	if config.Compress != nil && config.Compress.Enable {
+		mws = append(mws, xhttp.CompressMiddleware(config.Compress))
+	}
+	return mws
 }
 
-func recoverMiddleware(logger *xlog.Logger, slowQueryThresholdInMilli int64) gin.HandlerFunc {
+// Middleware adapts net/http middleware to gin, the chain is aborted if mw doesn't call next handler
+// or a handler panics, route of request is the matched route template, or empty if no route matches
+func Middleware(mw xhttp.Middleware) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var beg = time.Now()
-		var fields = make([]xlog.Field, 0, 8)
-		var brokenPipe bool
-		defer func() {
-			//Latency
-			fields = append(fields, zap.Float64("cost", time.Since(beg).Seconds()))
-			if slowQueryThresholdInMilli > 0 {
-				if cost := int64(time.Since(beg)) / 1e6; cost > slowQueryThresholdInMilli {
-					fields = append(fields, zap.Int64("slow", cost))
-				}
+		var called bool
+		var writer = c.Writer
+		mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+			c.Request = r
+			// writer is replaced by mw, e.g. compression
+			if w != http.ResponseWriter(writer) {
+				c.Writer = &responseWriter{ResponseWriter: writer, writer: xhttp.NewResponseWriter(w)}
+				defer func() { c.Writer = writer }()
 			}
-			if rec := recover(); rec != nil {
-				if ne, ok := rec.(*net.OpError); ok {
-					if se, ok := ne.Err.(*os.SyscallError); ok {
-						if strings.Contains(strings.ToLower(se.Error()), "broken pipe") || strings.Contains(strings.ToLower(se.Error()), "connection reset by peer") {
-							brokenPipe = true
-						}
-					}
-				}
-				var err = rec.(error)
-				fields = append(fields, zap.ByteString("stack", stack(3)))
-				fields = append(fields, zap.String("err", err.Error()))
-				logger.Error("access", fields...)
-				// If the connection is dead, we can't write a status to it.
-				if brokenPipe {
-					c.Error(err) // nolint: errcheck
+			// panic may be recovered by mw, handlers after the panicking one must not run
+			defer func() {
+				if rec := recover(); rec != nil {
 					c.Abort()
-					return
+					panic(rec)
 				}
-				c.AbortWithStatus(http.StatusInternalServerError)
-				return
-			}
-			// httpRequest, _ := httputil.DumpRequest(c.Request, false)
-			// fields = append(fields, zap.ByteString("request", httpRequest))
-			fields = append(fields,
-				zap.String("method", c.Request.Method),
-				zap.Int("code", c.Writer.Status()),
-				zap.Int("size", c.Writer.Size()),
-				zap.String("host", c.Request.Host),
-				zap.String("path", c.Request.URL.Path),
-				zap.String("ip", c.ClientIP()),
-				zap.String("err", c.Errors.ByType(gin.ErrorTypePrivate).String()),
-			)
-			logger.Info("access", fields...)
-		}()
-		c.Next()
-	}
-}
-
-// stack returns a nicely formatted stack frame, skipping skip frames.
-func stack(skip int) []byte {
-	buf := new(bytes.Buffer) // the returned data
-	// As we loop, we open files and read them. These variables record the currently
-	// loaded file.
-	var lines [][]byte
-	var lastFile string
-	for i := skip; ; i++ { // Skip the expected number of frames
-		pc, file, line, ok := runtime.Caller(i)
-		if !ok {
-			break
-		}
-		// Print this much at least.  If we can't find the source, it won't show.
-		fmt.Fprintf(buf, "%s:%d (0x%x)\n", file, line, pc)
-		if file != lastFile {
-			data, err := ioutil.ReadFile(file)
-			if err != nil {
-				continue
+			}()
+			c.Next()
+			if err := c.Errors.ByType(gin.ErrorTypePrivate).Last(); err != nil {
+				xhttp.SetError(r, err)
 			}
-			lines = bytes.Split(data, []byte{'\n'})
-			lastFile = file
+		})).ServeHTTP(writer, xhttp.WithRoute(c.Request, c.FullPath()))
+		if !called {
+			c.Abort()
 		}
-		fmt.Fprintf(buf, "\t%s: %s\n", function(pc), source(lines, line))
 	}
-	return buf.Bytes()
 }
 
-// source returns a space-trimmed slice of the n'th line.
-func source(lines [][]byte, n int) []byte {
-	n-- // in stack trace, lines are 1-indexed but our array is 0-indexed
-	if n < 0 || n >= len(lines) {
-		return dunno
-	}
-	return bytes.TrimSpace(lines[n])
+// responseWriter implements gin.ResponseWriter with writer replaced by middleware
+type responseWriter struct {
+	gin.ResponseWriter
+	writer xhttp.ResponseWriter
 }
 
-// function returns, if possible, the name of the function containing the PC.
-func function(pc uintptr) []byte {
-	fn := runtime.FuncForPC(pc)
-	if fn == nil {
-		return dunno
-	}
-	name := []byte(fn.Name())
-	// The name includes the path name to the package, which is unnecessary
-	// since the file name is already included.  Plus, it has center dots.
-	// That is, we see
-	//	runtime/debug.*T·ptrmethod
-	// and want
-	//	*T.ptrmethod
-	// Also the package path might contains dot (e.g. code.google.com/...),
-	// so first eliminate the path prefix
-	if lastSlash := bytes.LastIndex(name, slash); lastSlash >= 0 {
-		name = name[lastSlash+1:]
-	}
-	if period := bytes.Index(name, dot); period >= 0 {
-		name = name[period+1:]
+func (w *responseWriter) Header() http.Header { return w.writer.Header() }
+
+func (w *responseWriter) WriteHeader(status int) { w.writer.WriteHeader(status) }
+
+func (w *responseWriter) WriteHeaderNow() {
+	if !w.writer.Written() {
+		w.writer.WriteHeader(w.writer.Status())
 	}
-	name = bytes.Replace(name, centerDot, dot, -1)
-	return name
 }
 
-func timeFormat(t time.Time) string {
-	timeString := t.Format("2006/01/02 - 15:04:05")
-	return timeString
-}
+func (w *responseWriter) Write(data []byte) (int, error) { return w.writer.Write(data) }
 
-func metricServerInterceptor() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		beg := time.Now()
-		c.Next()
-		metric.ServerHandleHistogram.Observe(time.Since(beg).Seconds(), metric.TypeHTTP, c.Request.Method+"."+c.Request.URL.Path, extractAID(c))
-		metric.ServerHandleCounter.Inc(metric.TypeHTTP, c.Request.Method+"."+c.Request.URL.Path, extractAID(c), http.StatusText(c.Writer.Status()))
-		return
+func (w *responseWriter) WriteString(s string) (int, error) { return w.writer.Write([]byte(s)) }
+
+func (w *responseWriter) Status() int { return w.writer.Status() }
+
+func (w *responseWriter) Size() int { return w.writer.Size() }
+
+func (w *responseWriter) Written() bool { return w.writer.Written() }
+
+// Flush implements http.Flusher
+func (w *responseWriter) Flush() {
+	if flusher, ok := w.writer.(http.Flusher); ok {
+		flusher.Flush()
 	}
 }
 
-func traceServerInterceptor() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		span, ctx := trace.StartSpanFromContext(
-			c.Request.Context(),
-			c.Request.Method+" "+c.Request.URL.Path,
-			trace.TagComponent("http"),
-			trace.TagSpanKind("server"),
-			trace.HeaderExtractor(c.Request.Header),
-			trace.CustomTag("http.url", c.Request.URL.Path),
-			trace.CustomTag("http.method", c.Request.Method),
-			trace.CustomTag("peer.ipv4", c.ClientIP()),
-		)
-		c.Request = c.Request.WithContext(ctx)
-		defer span.Finish()
-		c.Next()
+// Hijack implements http.Hijacker
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := w.writer.(http.Hijacker); ok {
+		return hijacker.Hijack()
 	}
+	return nil, nil, errors.New("response writer doesn't implement http.Hijacker")
 }
@@ -0,0 +1,159 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xgin
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/douyu/jupiter/pkg/server/xhttp"
+	"github.com/douyu/jupiter/pkg/xlog"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestMiddleware(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	config := DefaultConfig()
+	config.logger = xlog.Config{Core: core}.Build()
+
+	engine := gin.New()
+	engine.Use(Middleware(xhttp.Chain(defaultMiddlewares(config)...)))
+	engine.GET("/panic", func(c *gin.Context) {
+		panic("not an error")
+	})
+	engine.GET("/error", func(c *gin.Context) {
+		_ = c.Error(http.ErrNoLocation)
+		c.AbortWithStatus(http.StatusBadRequest)
+	})
+	engine.GET("/users/:id", func(c *gin.Context) {
+		c.String(http.StatusOK, "hello")
+	})
+
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/panic", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "recover", logs.TakeAll()[0].ContextMap()["event"])
+
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/error", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	fields := logs.TakeAll()[0].ContextMap()
+	assert.Equal(t, int64(http.StatusBadRequest), fields["code"])
+	assert.Equal(t, http.ErrNoLocation.Error(), fields["err"])
+
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users/1", nil))
+	fields = logs.TakeAll()[0].ContextMap()
+	assert.Equal(t, int64(http.StatusOK), fields["code"])
+	assert.Equal(t, int64(5), fields["size"])
+	assert.NotEmpty(t, w.Header().Get(xhttp.HeaderRequestID))
+
+	// request is aborted if net/http middleware doesn't call next handler
+	engine = gin.New()
+	engine.Use(Middleware(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTooManyRequests)
+		})
+	}))
+	engine.GET("/users/:id", func(c *gin.Context) {
+		t.Fatal("handler should not be called")
+	})
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users/1", nil))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+}
+
+func TestMiddleware_Panic(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	config := DefaultConfig()
+	config.logger = xlog.Config{Core: core}.Build()
+
+	engine := gin.New()
+	engine.Use(Middleware(xhttp.Chain(defaultMiddlewares(config)...)))
+	engine.Use(func(c *gin.Context) {
+		panic("not an error")
+	})
+	engine.GET("/x", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/x", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Empty(t, w.Body.String())
+	fields := logs.TakeAll()[0].ContextMap()
+	assert.Equal(t, int64(http.StatusInternalServerError), fields["code"])
+	assert.Equal(t, "recover", fields["event"])
+}
+
+func TestMiddleware_Compress(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	config := DefaultConfig()
+	config.logger = xlog.Config{Core: core}.Build()
+	config.Compress = &xhttp.CompressConfig{Enable: true, MinLength: 8}
+	config.Secure = &xhttp.SecureConfig{Enable: true, ContentTypeNosniff: true}
+
+	engine := gin.New()
+	engine.Use(Middleware(xhttp.Chain(defaultMiddlewares(config)...)))
+	engine.GET("/users/:id", func(c *gin.Context) {
+		c.JSON(http.StatusAccepted, gin.H{"name": strings.Repeat("jupiter", 10)})
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+	size := w.Body.Len()
+	reader, err := gzip.NewReader(w.Body)
+	assert.Nil(t, err)
+	data, err := ioutil.ReadAll(reader)
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), strings.Repeat("jupiter", 10))
+
+	fields := logs.TakeAll()[0].ContextMap()
+	assert.Equal(t, int64(http.StatusAccepted), fields["code"])
+	assert.Equal(t, int64(size), fields["size"])
+}
+
+func TestMiddleware_Route(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	config := DefaultConfig()
+	config.logger = xlog.Config{Core: core}.Build()
+
+	engine := gin.New()
+	engine.Use(Middleware(xhttp.Chain(defaultMiddlewares(config)...)))
+	engine.GET("/users/:id", func(c *gin.Context) {
+		c.String(http.StatusOK, "hello")
+	})
+
+	for path, route := range map[string]string{"/users/1": "/users/:id", "/rooms/1": xhttp.UnmatchedRoute} {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		fields := logs.TakeAll()[0].ContextMap()
+		assert.Equal(t, route, fields["route"], path)
+		assert.Equal(t, path, fields["path"], path)
+	}
+}
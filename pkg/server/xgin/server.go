@@ -16,6 +16,7 @@ package xgin
 
 import (
 	"context"
+	"crypto/tls"
 	"net/http"
 
 	"net"
@@ -37,11 +38,22 @@ type Server struct {
 }
 
 func newServer(config *Config) *Server {
-	listener, err := net.Listen("tcp", config.Address())
-	if err != nil {
-		config.logger.Panic("new xgin server err", xlog.FieldErrKind(ecode.ErrKindListenErr), xlog.FieldErr(err))
+	var listener = config.listener
+	if listener == nil {
+		var err error
+		listener, err = net.Listen("tcp", config.Address())
+		if err != nil {
+			config.logger.Panic("new xgin server err", xlog.FieldErrKind(ecode.ErrKindListenErr), xlog.FieldErr(err))
+		}
 	}
 	config.Port = listener.Addr().(*net.TCPAddr).Port
+	if config.TLS != nil && config.TLS.Enable {
+		tlsConfig, err := config.TLS.ServerTLSConfig()
+		if err != nil {
+			config.logger.Panic("new xgin server tls config err", xlog.FieldErrKind(ecode.ErrKindTLSErr), xlog.FieldErr(err))
+		}
+		listener = tls.NewListener(listener, tlsConfig)
+	}
 	gin.SetMode(config.Mode)
 	return &Server{
 		Engine:   gin.New(),
@@ -95,11 +107,16 @@ func (s *Server) Info() *server.ServiceInfo {
 		serviceAddr = s.config.ServiceAddress
 	}
 
-	info := server.ApplyOptions(
+	var options = []server.Option{
 		server.WithScheme("http"),
 		server.WithAddress(serviceAddr),
 		server.WithKind(constant.ServiceProvider),
-	)
+	}
+	if s.config.TLS != nil && s.config.TLS.Enable {
+		options = append(options, server.WithMetaData("tls", "true"))
+	}
+
+	info := server.ApplyOptions(options...)
 	// info.Name = info.Name + "." + ModName
 	return &info
 }
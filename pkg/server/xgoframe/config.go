@@ -19,8 +19,11 @@ import (
 
 	"github.com/douyu/jupiter/pkg/conf"
 	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/server/xhttp"
+	"github.com/douyu/jupiter/pkg/util/xtls"
 	"github.com/douyu/jupiter/pkg/xlog"
 
+	"github.com/gogf/gf/net/ghttp"
 	"github.com/pkg/errors"
 )
 
@@ -34,10 +37,15 @@ type Config struct {
 	Debug         bool
 	DisableMetric bool
 	DisableTrace  bool
+	// MetricLabels labels of server_http_handle metrics among method, route, peer, code and code_class,
+	// method, route and code_class by default
+	MetricLabels []string
 	// ServiceAddress service address in registry info, default to 'Host:Port'
 	ServiceAddress string
 
 	SlowQueryThresholdInMilli int64
+	// TLS tls config, plaintext if nil or not enabled
+	TLS *xtls.Config
 
 	logger *xlog.Logger
 }
@@ -89,15 +97,12 @@ func (config *Config) WithPort(port int) *Config {
 // Build create server instance, then initialize it with necessary interceptor
 func (config *Config) Build() *Server {
 	serve := newServer(config)
-
-	serve.Use(recoverMiddleware(config.logger, config.SlowQueryThresholdInMilli))
-	//
-	if !config.DisableMetric {
-		serve.Use(metricServerInterceptor())
-	}
-	if !config.DisableTrace {
-		serve.Use(traceServerInterceptor())
-	}
+	// 默认允许跨域
+	serve.Use(func(r *ghttp.Request) {
+		r.Response.CORSDefault()
+		r.Middleware.Next()
+	})
+	serve.Use(Middleware(xhttp.Chain(defaultMiddlewares(config)...)))
 	return serve
 }
 
@@ -18,70 +18,73 @@ import (
 	"net/http"
 	"time"
 
-	"github.com/douyu/jupiter/pkg/metric"
-	"github.com/douyu/jupiter/pkg/trace"
-	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/server/xhttp"
 
 	"github.com/gogf/gf/net/ghttp"
-	"go.uber.org/zap"
 )
 
-// recoverMiddleware ...
-func recoverMiddleware(logger *xlog.Logger, slowQueryThresholdInMilli int64) ghttp.HandlerFunc {
-	return func(r *ghttp.Request) {
-		defer func() {
-			var beg = time.Now()
-			var fields = make([]xlog.Field, 0, 8)
-
-			fields = append(fields, zap.Float64("cost", time.Since(beg).Seconds()))
+// defaultMiddlewares returns middlewares of config, shared by all http servers
+func defaultMiddlewares(config *Config) []xhttp.Middleware {
+	var mws = []xhttp.Middleware{
+		xhttp.RequestIDMiddleware(),
+		xhttp.AccessMiddleware(config.logger, time.Duration(config.SlowQueryThresholdInMilli)*time.Millisecond),
+		xhttp.RecoveryMiddleware(),
+	}
+	if !config.DisableMetric {
+		mws = append(mws, xhttp.MetricMiddleware(config.MetricLabels...))
+	}
+	if !config.DisableTrace {
+		mws = append(mws, xhttp.TraceMiddleware())
+	}
+	return mws
+}
 
-			if slowQueryThresholdInMilli > 0 {
-				if cost := int64(time.Since(beg)) / 1e6; cost > slowQueryThresholdInMilli {
-					fields = append(fields, zap.Int64("slow", cost))
-				}
-			}
+// Middleware adapts net/http middleware to goframe, panic of handler is recovered
+// by goframe, and recorded as error of request. Routes of goframe are matched
+// after global middlewares, so route of request is set once handler returns.
+func Middleware(mw xhttp.Middleware) ghttp.HandlerFunc {
+	return func(r *ghttp.Request) {
+		var called bool
+		mw(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			called = true
+			r.Request = req
+			r.Middleware.Next()
+			xhttp.SetRoute(req, routeOf(r))
+			xhttp.SetError(req, r.GetError())
+		})).ServeHTTP(responseWriter{ResponseWriter: r.Response.ResponseWriter, response: r.Response}, xhttp.WithRoute(r.Request, ""))
+		if !called {
+			r.ExitAll()
+		}
+	}
+}
 
-			fields = append(fields,
-				zap.String("method", r.Method),
-				zap.Int("code", r.Response.Status),
-				zap.Int("size", r.Response.BufferLength()),
-				zap.String("host", r.Host),
-				zap.String("path", r.URL.Path),
-				zap.String("ip", r.GetClientIp()),
-				zap.String("remote_addr", r.RemoteAddr),
-			)
+// middlewarePattern route pattern of global middlewares
+const middlewarePattern = "/*"
 
-			logger.Info("access", fields...)
-			return
-		}()
-		r.Middleware.Next()
+// routeOf returns route template matched by request, router of request switches
+// from router of global middlewares to router of handler once handler is called
+func routeOf(r *ghttp.Request) string {
+	if r.Router == nil || r.Router.Uri == middlewarePattern {
+		return ""
 	}
+	return r.Router.Uri
 }
 
-func metricServerInterceptor() ghttp.HandlerFunc {
-	return func(r *ghttp.Request) {
-		r.Response.CORSDefault()
-		beg := time.Now()
-		r.Middleware.Next()
+// responseWriter implements xhttp.ResponseWriter with buffered ghttp.Response
+type responseWriter struct {
+	*ghttp.ResponseWriter
+	response *ghttp.Response
+}
 
-		metric.ServerHandleHistogram.Observe(time.Since(beg).Seconds(), metric.TypeHTTP, r.Method+"."+r.URL.Path, r.Header.Get("AID"))
-		metric.ServerHandleCounter.Inc(metric.TypeHTTP, r.Method+"."+r.URL.Path, r.Header.Get("AID"), http.StatusText(r.Response.Status))
+func (w responseWriter) Status() int {
+	if w.response.Status == 0 {
+		return http.StatusOK
 	}
+	return w.response.Status
 }
-func traceServerInterceptor() ghttp.HandlerFunc {
-	return func(r *ghttp.Request) {
-		span, ctx := trace.StartSpanFromContext(
-			r.Context(),
-			r.Method+" "+r.URL.Path,
-			trace.TagComponent("http"),
-			trace.TagSpanKind("server"),
-			trace.HeaderExtractor(r.Header),
-			trace.CustomTag("http.url", r.URL.Path),
-			trace.CustomTag("http.method", r.Method),
-			trace.CustomTag("peer.ipv4", r.GetClientIp()),
-		)
-		r.Request = r.WithContext(ctx)
-		defer span.Finish()
-		r.Middleware.Next()
-	}
+
+func (w responseWriter) Size() int { return w.response.BufferLength() }
+
+func (w responseWriter) Written() bool {
+	return w.response.Status != 0 || w.response.BufferLength() > 0
 }
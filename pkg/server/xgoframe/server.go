@@ -16,12 +16,12 @@ package xgoframe
 
 import (
 	"context"
+	"crypto/tls"
 
 	"github.com/douyu/jupiter/pkg/constant"
+	"github.com/douyu/jupiter/pkg/ecode"
 	"github.com/douyu/jupiter/pkg/xlog"
 
-	//"github.com/douyu/jupiter/pkg/ecode"
-	//"github.com/douyu/jupiter/pkg/xlog"
 	"github.com/douyu/jupiter/pkg/server"
 
 	"github.com/gogf/gf/frame/g"
@@ -38,6 +38,22 @@ func newServer(config *Config) *Server {
 	s := new(Server)
 	serve := g.Server()
 	serve.SetAddr(config.Address())
+	if config.TLS != nil && config.TLS.Enable {
+		tlsConfig, err := config.TLS.ServerTLSConfig()
+		if err != nil {
+			config.logger.Panic("new xgoframe server tls config err", xlog.FieldErrKind(ecode.ErrKindTLSErr), xlog.FieldErr(err))
+		}
+		// goframe loads a static certificate into tlsConfig.Certificates, which takes precedence
+		// over GetCertificate for clients without SNI, so every handshake is served by a config
+		// without static certificates to make sure the reloaded certificate is always used
+		tlsConfig.NextProtos = []string{"http/1.1"}
+		dynamicConfig := tlsConfig.Clone()
+		tlsConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return dynamicConfig, nil
+		}
+		// goframe serves https on Address if tls enabled
+		serve.EnableHTTPS(config.TLS.CertFile, config.TLS.KeyFile, tlsConfig)
+	}
 
 	s.Server = serve
 	s.config = config
@@ -74,10 +90,15 @@ func (s *Server) Info() *server.ServiceInfo {
 		serviceAddr = s.config.ServiceAddress
 	}
 
-	info := server.ApplyOptions(
+	var options = []server.Option{
 		server.WithScheme("http"),
 		server.WithAddress(serviceAddr),
 		server.WithKind(constant.ServiceProvider),
-	)
+	}
+	if s.config.TLS != nil && s.config.TLS.Enable {
+		options = append(options, server.WithMetaData("tls", "true"))
+	}
+
+	info := server.ApplyOptions(options...)
 	return &info
 }
@@ -17,10 +17,15 @@ package xgrpc
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/douyu/jupiter/pkg/constant"
 	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/douyu/jupiter/pkg/util/xtls"
 	"github.com/douyu/jupiter/pkg/xlog"
 	"github.com/grpc-ecosystem/grpc-gateway/runtime"
 
@@ -43,10 +48,33 @@ type Config struct {
 	DisableTrace bool
 	// DisableMetric disable Metric Interceptor, false by default
 	DisableMetric bool
+	// EnableSentinel enable sentinel flow control Interceptor keyed by full method and caller aid, false by default
+	EnableSentinel bool
 	// SlowQueryThresholdInMilli, request will be colored if cost over this threshold value
 	SlowQueryThresholdInMilli int64
 	// ServiceAddress service address in registry info, default to 'Host:Port'
 	ServiceAddress string
+	// DisableHealth disable grpc.health.v1.Health service registration, false by default
+	DisableHealth bool
+	// EnableReflection enable server reflection service registration, false by default
+	EnableReflection bool
+	// DrainTimeout, after health status flips to NOT_SERVING, server waits DrainTimeout before GracefulStop
+	DrainTimeout time.Duration
+	// TLS tls config, plaintext if nil or not enabled
+	TLS *xtls.Config
+	// AccessLog access log policy: payload truncation and redaction, sampling and levels
+	AccessLog *xlog.AccessConfig
+	// GatewaySharePort serve gateway on the same port of grpc server, GatewayPort is ignored.
+	// With TLS, connections offering only h2 in ALPN are served by grpc, others by gateway
+	GatewaySharePort bool
+	// OpenAPIFile openapi(swagger) spec file served by gateway at OpenAPIPath, generated by protoc-gen-swagger
+	OpenAPIFile string
+	// EnableSwaggerUI serve swagger ui at SwaggerPath, OpenAPIFile is required
+	EnableSwaggerUI bool
+	// SwaggerAssetsURL base url of swagger-ui-dist assets
+	SwaggerAssetsURL string
+	// Methods per method config, keyed by full method (e.g. "/helloworld.Greeter/SayHello") or method name
+	Methods map[string]MethodConfig
 
 	serverOptions      []grpc.ServerOption
 	streamInterceptors []grpc.StreamServerInterceptor
@@ -54,7 +82,14 @@ type Config struct {
 	gwRegister         func(ctx context.Context, mux *runtime.ServeMux, endpoint string, opts []grpc.DialOption) (err error)
 	withMetadataFunc   func(context.Context, *http.Request) metadata.MD
 
-	logger *xlog.Logger
+	listener net.Listener
+	logger   *xlog.Logger
+}
+
+// MethodConfig represents per method config
+type MethodConfig struct {
+	// Timeout server side timeout of method, incoming deadline is capped by Timeout
+	Timeout time.Duration
 }
 
 // StdConfig represents Standard gRPC Server config
@@ -88,6 +123,12 @@ func DefaultConfig() *Config {
 		DisableMetric:             false,
 		DisableTrace:              false,
 		SlowQueryThresholdInMilli: 500,
+		DisableHealth:             false,
+		EnableReflection:          false,
+		DrainTimeout:              xtime.Duration("3s"),
+		AccessLog:                 xlog.DefaultAccessConfig(),
+		Methods:                   make(map[string]MethodConfig),
+		SwaggerAssetsURL:          "https://unpkg.com/swagger-ui-dist@3",
 		logger:                    xlog.JupiterLogger.With(xlog.FieldMod("server.grpc")),
 		serverOptions:             []grpc.ServerOption{},
 		streamInterceptors:        []grpc.StreamServerInterceptor{},
@@ -149,9 +190,30 @@ func (config *Config) Build() *Server {
 		config.streamInterceptors = append(config.streamInterceptors, prometheusStreamServerInterceptor)
 	}
 
+	if config.EnableSentinel {
+		config.unaryInterceptors = append(config.unaryInterceptors, sentinelUnaryServerInterceptor)
+		config.streamInterceptors = append(config.streamInterceptors, sentinelStreamServerInterceptor)
+	}
+
 	return newServer(config)
 }
 
+// methodConfig returns config of full method, which is looked up by full method first and then method name
+func (config *Config) methodConfig(fullMethod string) (MethodConfig, bool) {
+	if mc, ok := config.Methods[fullMethod]; ok {
+		return mc, true
+	}
+	sli := strings.Split(fullMethod, "/")
+	mc, ok := config.Methods[sli[len(sli)-1]]
+	return mc, ok
+}
+
+// WithListener serves on listener instead of listening on Address, e.g. listener of xmux.Server
+func (config *Config) WithListener(listener net.Listener) *Config {
+	config.listener = listener
+	return config
+}
+
 // WithLogger ...
 func (config *Config) WithLogger(logger *xlog.Logger) *Config {
 	config.logger = logger
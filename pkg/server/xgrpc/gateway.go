@@ -0,0 +1,242 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xgrpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/metric"
+	"github.com/douyu/jupiter/pkg/server/xhttp"
+	"github.com/douyu/jupiter/pkg/trace"
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// SwaggerPath path of swagger ui served by gateway
+	SwaggerPath = "/swagger/"
+	// OpenAPIPath path of openapi spec served by gateway
+	OpenAPIPath = "/swagger/doc.json"
+)
+
+// GatewayError is the uniform json error envelope of gateway,
+// which is the same as the one of xecho/xgin grpc proxy
+type GatewayError struct {
+	Error   int32             `json:"error"`
+	Message string            `json:"msg"`
+	Data    []json.RawMessage `json:"data"`
+}
+
+// HTTPStatusFromCode maps ecode to http status, system codes are mapped
+// as grpc codes, while business codes are carried in the envelope with 200 OK
+func HTTPStatusFromCode(code int32) int {
+	if code > ecode.EcodeNum {
+		return http.StatusOK
+	}
+	return runtime.HTTPStatusFromCode(codes.Code(code))
+}
+
+// gatewayErrorHandler writes grpc error as GatewayError envelope
+func gatewayErrorHandler(ctx context.Context, mux *runtime.ServeMux, marshaler runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+	var httpStatus int
+	s, ok := status.FromError(err)
+	if !ok {
+		s = status.New(codes.Unknown, err.Error())
+	}
+	if err == runtime.ErrUnknownURI {
+		httpStatus = http.StatusNotFound
+	} else {
+		httpStatus = HTTPStatusFromCode(int32(s.Code()))
+	}
+
+	var envelope = GatewayError{
+		Error:   int32(s.Code()),
+		Message: s.Message(),
+		Data:    make([]json.RawMessage, 0),
+	}
+	for _, detail := range s.Proto().GetDetails() {
+		if bs, err := marshaler.Marshal(detail); err == nil {
+			envelope.Data = append(envelope.Data, bs)
+		}
+	}
+
+	if md, ok := runtime.ServerMetadataFromContext(ctx); ok {
+		for k, vs := range md.HeaderMD {
+			for _, v := range vs {
+				w.Header().Add(fmt.Sprintf("%s%s", runtime.MetadataHeaderPrefix, k), v)
+			}
+		}
+	}
+	w.Header().Del("Trailer")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	_ = json.NewEncoder(w).Encode(envelope)
+}
+
+// gatewayMetadata propagates aid and trace of http request to grpc server,
+// and merges metadata returned by user defined metadata func
+func gatewayMetadata(withMetadataFunc func(context.Context, *http.Request) metadata.MD) func(context.Context, *http.Request) metadata.MD {
+	return func(ctx context.Context, r *http.Request) metadata.MD {
+		var md = metadata.MD{}
+		if aid := r.Header.Get("aid"); aid != "" {
+			md.Set("aid", aid)
+		}
+		if span := trace.SpanFromContext(r.Context()); span != nil {
+			trace.MetadataInjector(r.Context(), md)
+		}
+		if withMetadataFunc != nil {
+			md = metadata.Join(md, withMetadataFunc(ctx, r))
+		}
+		return md
+	}
+}
+
+// gatewayMethodKey is context key of gatewayMethod
+type gatewayMethodKey struct{}
+
+// gatewayMethod records grpc method called by gateway for http request, which labels
+// metric and trace instead of path of request, e.g. /v1/users/123
+type gatewayMethod struct {
+	method string
+}
+
+// gatewayMethodOf returns grpc method called for request, or xhttp.UnmatchedRoute if
+// request matches no gateway pattern
+func gatewayMethodOf(ctx context.Context) string {
+	if gm, ok := ctx.Value(gatewayMethodKey{}).(*gatewayMethod); ok && gm.method != "" {
+		return gm.method
+	}
+	return xhttp.UnmatchedRoute
+}
+
+// gatewayUnaryClientInterceptor records method of grpc call made by gateway
+func gatewayUnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if gm, ok := ctx.Value(gatewayMethodKey{}).(*gatewayMethod); ok {
+		gm.method = method
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+// gatewayStreamClientInterceptor records method of grpc stream made by gateway
+func gatewayStreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	if gm, ok := ctx.Value(gatewayMethodKey{}).(*gatewayMethod); ok {
+		gm.method = method
+	}
+	return streamer(ctx, desc, cc, method, opts...)
+}
+
+// gatewayHandler wraps gateway mux with trace and metric,
+// and serves openapi spec and swagger ui if configured
+func gatewayHandler(config *Config, mux *runtime.ServeMux) http.Handler {
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var beg = time.Now()
+		r = r.WithContext(context.WithValue(r.Context(), gatewayMethodKey{}, &gatewayMethod{}))
+		if !config.DisableTrace {
+			span, ctx := trace.StartSpanFromContext(
+				r.Context(),
+				r.Method+" "+xhttp.UnmatchedRoute,
+				trace.TagComponent("grpc-gateway"),
+				trace.TagSpanKind("server"),
+				trace.HeaderExtractor(r.Header),
+				trace.CustomTag("http.url", r.URL.Path),
+				trace.CustomTag("http.method", r.Method),
+			)
+			// span is named after grpc method once request is served
+			defer func() {
+				span.SetOperationName(r.Method + " " + gatewayMethodOf(ctx))
+				span.Finish()
+			}()
+			r = r.WithContext(ctx)
+		}
+
+		var sw = &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		mux.ServeHTTP(sw, r)
+
+		if !config.DisableMetric {
+			var aid, method = r.Header.Get("aid"), r.Method + "." + gatewayMethodOf(r.Context())
+			metric.ServerHandleHistogram.Observe(time.Since(beg).Seconds(), metric.TypeGRPCGateway, method, aid)
+			metric.ServerHandleCounter.Inc(metric.TypeGRPCGateway, method, aid, http.StatusText(sw.status))
+		}
+	})
+
+	if config.OpenAPIFile == "" {
+		return handler
+	}
+
+	var serveMux = http.NewServeMux()
+	serveMux.Handle("/", handler)
+	serveMux.HandleFunc(OpenAPIPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		http.ServeFile(w, r, config.OpenAPIFile)
+	})
+	if config.EnableSwaggerUI {
+		serveMux.HandleFunc(SwaggerPath, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_ = swaggerTemplate.Execute(w, map[string]string{
+				"AssetsURL": config.SwaggerAssetsURL,
+				"SpecURL":   OpenAPIPath,
+			})
+		})
+	}
+	return serveMux
+}
+
+// statusWriter records status code written by handler
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader implements http.ResponseWriter
+func (sw *statusWriter) WriteHeader(code int) {
+	sw.status = code
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+// Flush implements http.Flusher, used by gateway server streaming
+func (sw *statusWriter) Flush() {
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+var swaggerTemplate = template.Must(template.New("swagger").Parse(`<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>Swagger UI</title>
+  <link rel="stylesheet" href="{{.AssetsURL}}/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="{{.AssetsURL}}/swagger-ui-bundle.js"></script>
+<script>
+  window.onload = function () {
+    window.ui = SwaggerUIBundle({url: "{{.SpecURL}}", dom_id: "#swagger-ui"});
+  };
+</script>
+</body>
+</html>
+`))
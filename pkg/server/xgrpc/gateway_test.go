@@ -0,0 +1,267 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xgrpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/metric"
+	"github.com/douyu/jupiter/pkg/server/xhttp"
+	"github.com/douyu/jupiter/pkg/util/xtls"
+
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/peer"
+)
+
+// registerHealthGateway registers GET /health to grpc.health.v1.Health/Check,
+// as what generated RegisterXXXHandlerFromEndpoint does
+func registerHealthGateway(ctx context.Context, mux *runtime.ServeMux, endpoint string, opts []grpc.DialOption) error {
+	conn, err := grpc.DialContext(ctx, endpoint, opts...)
+	if err != nil {
+		return err
+	}
+	client := healthpb.NewHealthClient(conn)
+	pattern := runtime.MustPattern(runtime.NewPattern(1, []int{2, 0}, []string{"health"}, ""))
+	mux.Handle("GET", pattern, func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+		ctx, err := runtime.AnnotateContext(r.Context(), mux, r)
+		_, outboundMarshaler := runtime.MarshalerForRequest(mux, r)
+		if err != nil {
+			runtime.HTTPError(ctx, mux, outboundMarshaler, w, r, err)
+			return
+		}
+		resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: r.URL.Query().Get("service")})
+		if err != nil {
+			runtime.HTTPError(ctx, mux, outboundMarshaler, w, r, err)
+			return
+		}
+		runtime.ForwardResponseMessage(ctx, mux, outboundMarshaler, w, r, resp)
+	})
+	return nil
+}
+
+func TestGateway_SharePort(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gateway")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	specFile := filepath.Join(dir, "api.swagger.json")
+	_ = ioutil.WriteFile(specFile, []byte(`{"swagger":"2.0"}`), 0644)
+
+	config := DefaultConfig()
+	config.Port = 0
+	config.GatewaySharePort = true
+	config.OpenAPIFile = specFile
+	config.EnableSwaggerUI = true
+	config.WithGatewayRegister(registerHealthGateway, nil)
+	ns := newServer(config)
+	go func() {
+		_ = ns.Serve()
+	}()
+	defer ns.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	var baseURL = "http://" + config.Address()
+	convey.Convey("test gateway and grpc share port", t, func() {
+		convey.So(config.GatewayPort, convey.ShouldEqual, config.Port)
+
+		conn, err := grpc.Dial(config.Address(), grpc.WithInsecure())
+		convey.So(err, convey.ShouldBeNil)
+		defer conn.Close()
+		resp, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(resp.Status, convey.ShouldEqual, healthpb.HealthCheckResponse_SERVING)
+
+		httpResp, err := http.Get(baseURL + "/health")
+		convey.So(err, convey.ShouldBeNil)
+		defer httpResp.Body.Close()
+		convey.So(httpResp.StatusCode, convey.ShouldEqual, http.StatusOK)
+	})
+
+	convey.Convey("test gateway error envelope", t, func() {
+		httpResp, err := http.Get(baseURL + "/health?service=unknown")
+		convey.So(err, convey.ShouldBeNil)
+		defer httpResp.Body.Close()
+		convey.So(httpResp.StatusCode, convey.ShouldEqual, http.StatusNotFound)
+		var envelope GatewayError
+		convey.So(json.NewDecoder(httpResp.Body).Decode(&envelope), convey.ShouldBeNil)
+		convey.So(envelope.Error, convey.ShouldEqual, int32(codes.NotFound))
+		convey.So(envelope.Message, convey.ShouldEqual, "unknown service")
+
+		httpResp, err = http.Get(baseURL + "/unknown")
+		convey.So(err, convey.ShouldBeNil)
+		defer httpResp.Body.Close()
+		convey.So(httpResp.StatusCode, convey.ShouldEqual, http.StatusNotFound)
+	})
+
+	convey.Convey("test gateway serves openapi spec and swagger ui", t, func() {
+		httpResp, err := http.Get(baseURL + OpenAPIPath)
+		convey.So(err, convey.ShouldBeNil)
+		defer httpResp.Body.Close()
+		bs, _ := ioutil.ReadAll(httpResp.Body)
+		convey.So(string(bs), convey.ShouldEqual, `{"swagger":"2.0"}`)
+
+		httpResp, err = http.Get(baseURL + SwaggerPath)
+		convey.So(err, convey.ShouldBeNil)
+		defer httpResp.Body.Close()
+		bs, _ = ioutil.ReadAll(httpResp.Body)
+		convey.So(string(bs), convey.ShouldContainSubstring, "doc.json")
+	})
+}
+
+func writeTestCert(t *testing.T, dir string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "jupiter"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	certFile = filepath.Join(dir, "tls.crt")
+	keyFile = filepath.Join(dir, "tls.key")
+	_ = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	_ = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return
+}
+
+func TestGateway_SharePortTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gateway")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeTestCert(t, dir)
+	tlsConfig := &xtls.Config{
+		Enable:        true,
+		CertFile:      certFile,
+		KeyFile:       keyFile,
+		CAFile:        certFile,
+		ClientAuth:    "require_and_verify",
+		ServerName:    "localhost",
+		DisableReload: true,
+	}
+
+	var authInfo = make(chan credentials.AuthInfo, 10)
+	config := DefaultConfig()
+	config.Port = 0
+	config.GatewaySharePort = true
+	config.TLS = tlsConfig
+	config.WithGatewayRegister(registerHealthGateway, nil)
+	config.WithUnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if p, ok := peer.FromContext(ctx); ok {
+			authInfo <- p.AuthInfo
+		}
+		return handler(ctx, req)
+	})
+	ns := newServer(config)
+	go func() {
+		_ = ns.Serve()
+	}()
+	defer ns.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	clientTLS, err := tlsConfig.ClientTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	convey.Convey("test grpc handler sees tls info when sharing port", t, func() {
+		conn, err := grpc.Dial(config.Address(), grpc.WithTransportCredentials(credentials.NewTLS(clientTLS)))
+		convey.So(err, convey.ShouldBeNil)
+		defer conn.Close()
+		_, err = healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+		convey.So(err, convey.ShouldBeNil)
+		info, ok := (<-authInfo).(credentials.TLSInfo)
+		convey.So(ok, convey.ShouldBeTrue)
+		convey.So(info.State.PeerCertificates, convey.ShouldNotBeEmpty)
+	})
+
+	convey.Convey("test https gateway when sharing port", t, func() {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+		httpResp, err := client.Get("https://localhost:" + strconv.Itoa(config.Port) + "/health")
+		convey.So(err, convey.ShouldBeNil)
+		defer httpResp.Body.Close()
+		convey.So(httpResp.StatusCode, convey.ShouldEqual, http.StatusOK)
+	})
+}
+
+func TestGatewayHandler_Method(t *testing.T) {
+	config := DefaultConfig()
+	mux := runtime.NewServeMux()
+	pattern := runtime.MustPattern(runtime.NewPattern(1, []int{2, 0, 1, 0, 4, 1, 5, 1}, []string{"users", "id"}, ""))
+	mux.Handle("GET", pattern, func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+		var reply healthpb.HealthCheckResponse
+		_ = gatewayUnaryClientInterceptor(r.Context(), "/test.User/Get", nil, &reply, nil,
+			func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				return nil
+			})
+	})
+	handler := gatewayHandler(config, mux)
+	unmatched := metric.ServerHandleCounter.WithLabelValues(metric.TypeGRPCGateway, "GET."+xhttp.UnmatchedRoute, "", "Not Found")
+	before := testutil.ToFloat64(unmatched)
+	for _, path := range []string{"/users/1", "/users/2", "/unknown"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	convey.Convey("test gateway metrics are labeled with grpc method", t, func() {
+		convey.So(testutil.ToFloat64(metric.ServerHandleCounter.WithLabelValues(
+			metric.TypeGRPCGateway, "GET./test.User/Get", "", "OK")), convey.ShouldEqual, 2)
+		convey.So(testutil.ToFloat64(unmatched)-before, convey.ShouldEqual, 1)
+	})
+}
+
+func TestHTTPStatusFromCode(t *testing.T) {
+	convey.Convey("test ecode to http status", t, func() {
+		convey.So(HTTPStatusFromCode(int32(codes.NotFound)), convey.ShouldEqual, http.StatusNotFound)
+		convey.So(HTTPStatusFromCode(int32(codes.Unavailable)), convey.ShouldEqual, http.StatusServiceUnavailable)
+		convey.So(HTTPStatusFromCode(10001), convey.ShouldEqual, http.StatusOK)
+	})
+}
@@ -16,7 +16,6 @@ package xgrpc
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"net"
 	"runtime"
@@ -24,10 +23,11 @@ import (
 	"time"
 
 	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/sentinel"
 	"github.com/douyu/jupiter/pkg/trace"
-	"github.com/douyu/jupiter/pkg/util/xstring"
 	"github.com/douyu/jupiter/pkg/xlog"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/opentracing/opentracing-go/ext"
 	"go.uber.org/zap"
 	"google.golang.org/grpc/codes"
@@ -51,10 +51,17 @@ func prometheusUnaryServerInterceptor(ctx context.Context, req interface{}, info
 
 func prometheusStreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
 	startTime := time.Now()
-	err := handler(srv, ss)
+	var kind, aid = streamType(info), extractAID(ss.Context())
+	err := handler(srv, &statsServerStream{
+		ServerStream: ss,
+		onMsg: func(direction string, size int) {
+			metric.ServerStreamMsgCounter.Inc(kind, info.FullMethod, aid, direction)
+			metric.ServerStreamMsgBytesHistogram.Observe(float64(size), kind, info.FullMethod, aid, direction)
+		},
+	})
 	code := ecode.ExtractCodes(err)
-	metric.ServerHandleHistogram.Observe(time.Since(startTime).Seconds(), metric.TypeGRPCStream, info.FullMethod, extractAID(ss.Context()))
-	metric.ServerHandleCounter.Inc(metric.TypeGRPCStream, info.FullMethod, extractAID(ss.Context()), code.GetMessage())
+	metric.ServerHandleHistogram.Observe(time.Since(startTime).Seconds(), kind, info.FullMethod, aid)
+	metric.ServerHandleCounter.Inc(kind, info.FullMethod, aid, code.GetMessage())
 	return err
 }
 
@@ -101,13 +108,146 @@ func traceStreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *g
 		trace.TagComponent("gRPC"),
 		trace.TagSpanKind("server.stream"),
 		trace.CustomTag("isServerStream", info.IsServerStream),
+		trace.CustomTag("streamType", streamType(info)),
 	)
 	defer span.Finish()
 
-	return handler(srv, contextedServerStream{
+	err := handler(srv, contextedServerStream{
 		ServerStream: ss,
 		ctx:          ctx,
 	})
+	if err != nil {
+		code := codes.Unknown
+		if s, ok := status.FromError(err); ok {
+			code = s.Code()
+		}
+		span.SetTag("code", code)
+		ext.Error.Set(span, true)
+		span.LogFields(trace.String("event", "error"), trace.String("message", err.Error()))
+	}
+	return err
+}
+
+// statsServerStream calls onMsg after each message is sent or received successfully
+type statsServerStream struct {
+	grpc.ServerStream
+	onMsg func(direction string, size int)
+}
+
+// SendMsg ...
+func (ss *statsServerStream) SendMsg(m interface{}) error {
+	err := ss.ServerStream.SendMsg(m)
+	if err == nil {
+		ss.onMsg("sent", msgSize(m))
+	}
+	return err
+}
+
+// RecvMsg ...
+func (ss *statsServerStream) RecvMsg(m interface{}) error {
+	err := ss.ServerStream.RecvMsg(m)
+	if err == nil {
+		ss.onMsg("received", msgSize(m))
+	}
+	return err
+}
+
+func msgSize(m interface{}) int {
+	if msg, ok := m.(proto.Message); ok {
+		return proto.Size(msg)
+	}
+	return 0
+}
+
+func streamType(info *grpc.StreamServerInfo) string {
+	switch {
+	case info.IsClientStream && info.IsServerStream:
+		return metric.TypeGRPCBidiStream
+	case info.IsClientStream:
+		return metric.TypeGRPCClientStream
+	case info.IsServerStream:
+		return metric.TypeGRPCServerStream
+	}
+	return metric.TypeGRPCStream
+}
+
+// recoverError converts panic to grpc status with codes.Internal
+func recoverError(rec interface{}) error {
+	switch rec := rec.(type) {
+	case error:
+		return status.Errorf(codes.Internal, "panic: %s", rec.Error())
+	default:
+		return status.Errorf(codes.Internal, "panic: %v", rec)
+	}
+}
+
+func sentinelUnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	var aid = extractAID(ctx)
+	entry, blockErr := sentinel.EntryInbound(info.FullMethod, aid)
+	if blockErr != nil {
+		metric.ServerBlockedCounter.Inc(metric.TypeGRPCUnary, info.FullMethod, aid, blockErr.BlockType().String())
+		return nil, status.Errorf(codes.ResourceExhausted, "blocked by sentinel: %s", blockErr.Error())
+	}
+	defer entry.Exit()
+
+	resp, err := handler(ctx, req)
+	if err != nil {
+		sentinel.TraceError(entry, err)
+	}
+	return resp, err
+}
+
+func sentinelStreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	var aid = extractAID(ss.Context())
+	entry, blockErr := sentinel.EntryInbound(info.FullMethod, aid)
+	if blockErr != nil {
+		metric.ServerBlockedCounter.Inc(streamType(info), info.FullMethod, aid, blockErr.BlockType().String())
+		return status.Errorf(codes.ResourceExhausted, "blocked by sentinel: %s", blockErr.Error())
+	}
+	defer entry.Exit()
+
+	err := handler(srv, ss)
+	if err != nil {
+		sentinel.TraceError(entry, err)
+	}
+	return err
+}
+
+// timeoutUnaryServerInterceptor rejects requests arrived with deadline exceeded,
+// and caps deadline of request with method timeout
+func timeoutUnaryServerInterceptor(config *Config) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= 0 {
+			metric.ServerExpiredCounter.Inc(metric.TypeGRPCUnary, info.FullMethod, extractAID(ctx))
+			return nil, status.Errorf(codes.DeadlineExceeded, "deadline exceeded before handling")
+		}
+
+		if mc, ok := config.methodConfig(info.FullMethod); ok && mc.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, mc.Timeout)
+			defer cancel()
+		}
+		return handler(ctx, req)
+	}
+}
+
+// timeoutStreamServerInterceptor works as timeoutUnaryServerInterceptor for streams
+func timeoutStreamServerInterceptor(config *Config) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		var ctx = ss.Context()
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= 0 {
+			metric.ServerExpiredCounter.Inc(streamType(info), info.FullMethod, extractAID(ctx))
+			return status.Errorf(codes.DeadlineExceeded, "deadline exceeded before handling")
+		}
+
+		if mc, ok := config.methodConfig(info.FullMethod); ok && mc.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, mc.Timeout)
+			defer cancel()
+			ss = contextedServerStream{ServerStream: ss, ctx: ctx}
+		}
+		return handler(srv, ss)
+	}
 }
 
 func extractAID(ctx context.Context) string {
@@ -117,11 +257,13 @@ func extractAID(ctx context.Context) string {
 	return "unknown"
 }
 
-func defaultStreamServerInterceptor(logger *xlog.Logger, slowQueryThresholdInMilli int64) grpc.StreamServerInterceptor {
+func defaultStreamServerInterceptor(logger *xlog.Logger, slowQueryThresholdInMilli int64, policy *xlog.AccessPolicy) grpc.StreamServerInterceptor {
 	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
 		var beg = time.Now()
-		var fields = make([]xlog.Field, 0, 8)
+		var fields = make([]xlog.Field, 0, 12)
 		var event = "normal"
+		var kind = streamType(info)
+		var sent, received, sentBytes, receivedBytes int
 		defer func() {
 			if slowQueryThresholdInMilli > 0 {
 				if int64(time.Since(beg))/1e6 > slowQueryThresholdInMilli {
@@ -130,23 +272,27 @@ func defaultStreamServerInterceptor(logger *xlog.Logger, slowQueryThresholdInMil
 			}
 
 			if rec := recover(); rec != nil {
-				switch rec := rec.(type) {
-				case error:
-					err = rec
-				default:
-					err = fmt.Errorf("%v", rec)
-				}
+				err = recoverError(rec)
 				stack := make([]byte, 4096)
 				stack = stack[:runtime.Stack(stack, true)]
 				fields = append(fields, xlog.FieldStack(stack))
 				event = "recover"
 			}
 
+			var result = accessResult(event, err)
+			if !policy.Enabled(result, info.FullMethod) {
+				return
+			}
+
 			fields = append(fields,
-				xlog.Any("grpc interceptor type", "unary"),
+				xlog.Any("grpc interceptor type", kind),
 				xlog.FieldMethod(info.FullMethod),
 				xlog.FieldCost(time.Since(beg)),
 				xlog.FieldEvent(event),
+				xlog.Int("sent", sent),
+				xlog.Int("received", received),
+				xlog.Int("sentBytes", sentBytes),
+				xlog.Int("receivedBytes", receivedBytes),
 			)
 
 			for key, val := range getPeer(stream.Context()) {
@@ -155,12 +301,24 @@ func defaultStreamServerInterceptor(logger *xlog.Logger, slowQueryThresholdInMil
 
 			if err != nil {
 				fields = append(fields, zap.String("err", err.Error()))
-				logger.Error("access", fields...)
-				return
 			}
-			logger.Info("access", fields...)
+			policy.Log(logger, result, "access", fields...)
 		}()
-		return handler(srv, stream)
+		return handler(srv, &statsServerStream{
+			ServerStream: stream,
+			onMsg: func(direction string, size int) {
+				if direction == "sent" {
+					sent, sentBytes = sent+1, sentBytes+size
+				} else {
+					received, receivedBytes = received+1, receivedBytes+size
+				}
+				logger.Debug("stream msg",
+					xlog.FieldMethod(info.FullMethod),
+					xlog.String("direction", direction),
+					xlog.Int("size", size),
+				)
+			},
+		})
 	}
 }
 
@@ -170,25 +328,38 @@ func parseMethod(info *grpc.UnaryServerInfo) string {
 	return sli[len(sli)-1]
 }
 
-func defaultUnaryServerInterceptor(logger *xlog.Logger, slowQueryThresholdInMilli int64) grpc.UnaryServerInterceptor {
+// accessResult maps interceptor event and error to access log result
+func accessResult(event string, err error) string {
+	if err != nil {
+		return xlog.AccessResultError
+	}
+	if event == "slow" {
+		return xlog.AccessResultSlow
+	}
+	return xlog.AccessResultNormal
+}
+
+func defaultUnaryServerInterceptor(logger *xlog.Logger, slowQueryThresholdInMilli int64, policy *xlog.AccessPolicy) grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
 		var beg = time.Now()
-		var fields = make([]xlog.Field, 0, 8)
+		var fields = make([]xlog.Field, 0, 12)
 		var event = "normal"
-		var md, _ = metadata.FromIncomingContext(ctx)
+		var md, ok = metadata.FromIncomingContext(ctx)
+		if !ok {
+			md = metadata.MD{}
+		}
 		md.Set("X-Grpc-Method", parseMethod(info))
 
-		var l = logger.With(
+		fields = append(fields,
 			xlog.Any("grpc interceptor type", "unary"),
 			xlog.FieldMethod(info.FullMethod),
-			xlog.FieldEvent(event),
 		)
 
 		for key, val := range getPeer(ctx) {
 			if key == "clientIP" {
 				md.Append("X-Forwarded-For", val)
 			}
-			l = l.With(xlog.Any(key, val))
+			fields = append(fields, xlog.Any(key, val))
 		}
 
 		ctx = metadata.NewIncomingContext(ctx, md)
@@ -200,31 +371,31 @@ func defaultUnaryServerInterceptor(logger *xlog.Logger, slowQueryThresholdInMill
 				}
 			}
 			if rec := recover(); rec != nil {
-				switch rec := rec.(type) {
-				case error:
-					err = rec
-				default:
-					err = fmt.Errorf("%v", rec)
-				}
-
+				err = recoverError(rec)
 				stack := make([]byte, 4096)
 				stack = stack[:runtime.Stack(stack, true)]
 				fields = append(fields, xlog.FieldStack(stack))
 				event = "recover"
 			}
 
-			l = l.With(
+			var result = accessResult(event, err)
+			if !policy.Enabled(result, info.FullMethod) {
+				return
+			}
+
+			fields = append(fields,
 				xlog.FieldCost(time.Since(beg)),
+				xlog.FieldEvent(event),
+				policy.Payload("req", req),
 			)
-
 			if err != nil {
-				l.Error("reply", xlog.FieldErr(err))
-				return
+				fields = append(fields, xlog.FieldErr(err))
+			} else {
+				fields = append(fields, policy.Payload("reply", resp))
 			}
-			l.Info("reply", xlog.Any("reply", json.RawMessage(xstring.Json(resp))))
+			policy.Log(logger, result, "access", fields...)
 		}()
 
-		l.Info("req", xlog.Any("req", json.RawMessage(xstring.Json(req))))
 		return handler(ctx, req)
 	}
 }
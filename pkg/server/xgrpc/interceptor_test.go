@@ -0,0 +1,149 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xgrpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/metric"
+	"github.com/douyu/jupiter/pkg/xlog"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (fss *fakeServerStream) Context() context.Context {
+	return fss.ctx
+}
+
+func (fss *fakeServerStream) SendMsg(m interface{}) error {
+	return nil
+}
+
+func (fss *fakeServerStream) RecvMsg(m interface{}) error {
+	return nil
+}
+
+func TestStreamType(t *testing.T) {
+	convey.Convey("test stream type", t, func() {
+		convey.So(streamType(&grpc.StreamServerInfo{IsClientStream: true, IsServerStream: true}), convey.ShouldEqual, metric.TypeGRPCBidiStream)
+		convey.So(streamType(&grpc.StreamServerInfo{IsClientStream: true}), convey.ShouldEqual, metric.TypeGRPCClientStream)
+		convey.So(streamType(&grpc.StreamServerInfo{IsServerStream: true}), convey.ShouldEqual, metric.TypeGRPCServerStream)
+	})
+}
+
+func TestDefaultStreamServerInterceptor(t *testing.T) {
+	interceptor := defaultStreamServerInterceptor(xlog.JupiterLogger, 500, xlog.DefaultAccessConfig().Build())
+	info := &grpc.StreamServerInfo{FullMethod: "/test.Greeter/Chat", IsClientStream: true, IsServerStream: true}
+
+	convey.Convey("test stream interceptor counts messages", t, func() {
+		var stream grpc.ServerStream
+		err := interceptor(nil, &fakeServerStream{ctx: context.Background()}, info, func(srv interface{}, ss grpc.ServerStream) error {
+			stream = ss
+			convey.So(ss.SendMsg(nil), convey.ShouldBeNil)
+			convey.So(ss.RecvMsg(nil), convey.ShouldBeNil)
+			return nil
+		})
+		convey.So(err, convey.ShouldBeNil)
+		_, ok := stream.(*statsServerStream)
+		convey.So(ok, convey.ShouldBeTrue)
+	})
+
+	convey.Convey("test stream interceptor recovers panic", t, func() {
+		err := interceptor(nil, &fakeServerStream{ctx: context.Background()}, info, func(srv interface{}, ss grpc.ServerStream) error {
+			panic(errors.New("boom"))
+		})
+		convey.So(status.Code(err), convey.ShouldEqual, codes.Internal)
+	})
+}
+
+func TestPrometheusStreamServerInterceptor(t *testing.T) {
+	info := &grpc.StreamServerInfo{FullMethod: "/test.Greeter/ServerChat", IsServerStream: true}
+
+	convey.Convey("test handle metrics are labeled with stream type", t, func() {
+		err := prometheusStreamServerInterceptor(nil, &fakeServerStream{ctx: context.Background()}, info, func(srv interface{}, ss grpc.ServerStream) error {
+			return nil
+		})
+		convey.So(err, convey.ShouldBeNil)
+		message := ecode.ExtractCodes(nil).GetMessage()
+		convey.So(testutil.ToFloat64(metric.ServerHandleCounter.WithLabelValues(
+			metric.TypeGRPCServerStream, info.FullMethod, "unknown", message)), convey.ShouldEqual, 1)
+		convey.So(testutil.ToFloat64(metric.ServerHandleCounter.WithLabelValues(
+			metric.TypeGRPCStream, info.FullMethod, "unknown", message)), convey.ShouldEqual, 0)
+	})
+}
+
+func TestDefaultUnaryServerInterceptor(t *testing.T) {
+	interceptor := defaultUnaryServerInterceptor(xlog.JupiterLogger, 500, xlog.DefaultAccessConfig().Build())
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Greeter/SayHello"}
+
+	convey.Convey("test unary interceptor recovers panic", t, func() {
+		_, err := interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			panic("boom")
+		})
+		convey.So(status.Code(err), convey.ShouldEqual, codes.Internal)
+	})
+}
+
+func TestSentinelUnaryServerInterceptor(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Greeter/SayHello"}
+
+	convey.Convey("test sentinel interceptor passes without rules", t, func() {
+		resp, err := sentinelUnaryServerInterceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return "ok", nil
+		})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(resp, convey.ShouldEqual, "ok")
+	})
+}
+
+func TestTimeoutUnaryServerInterceptor(t *testing.T) {
+	config := DefaultConfig()
+	config.Methods["SayHello"] = MethodConfig{Timeout: 50 * time.Millisecond}
+	interceptor := timeoutUnaryServerInterceptor(config)
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Greeter/SayHello"}
+
+	convey.Convey("test timeout interceptor caps deadline", t, func() {
+		_, err := interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			deadline, ok := ctx.Deadline()
+			convey.So(ok, convey.ShouldBeTrue)
+			convey.So(time.Until(deadline), convey.ShouldBeLessThanOrEqualTo, 50*time.Millisecond)
+			return nil, nil
+		})
+		convey.So(err, convey.ShouldBeNil)
+	})
+
+	convey.Convey("test timeout interceptor rejects expired request", t, func() {
+		ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Millisecond))
+		defer cancel()
+		_, err := interceptor(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			t.Fatal("handler should not be called")
+			return nil, nil
+		})
+		convey.So(status.Code(err), convey.ShouldEqual, codes.DeadlineExceeded)
+	})
+}
@@ -16,6 +16,7 @@ package xgrpc
 
 import (
 	"context"
+	"crypto/tls"
 	"net"
 	"net/http"
 	"time"
@@ -23,32 +24,65 @@ import (
 	"github.com/douyu/jupiter/pkg/constant"
 	"github.com/douyu/jupiter/pkg/ecode"
 	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/util/xnet"
 	"github.com/douyu/jupiter/pkg/xlog"
 	"github.com/grpc-ecosystem/grpc-gateway/runtime"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/reflection"
 )
 
+// sniffTimeout is the timeout of sniffing connections when gateway shares port with grpc
+const sniffTimeout = 10 * time.Second
+
 // Server ...
 type Server struct {
 	*grpc.Server
 	listener        net.Listener
 	gatewayListener net.Listener
-	gatewayMux      *runtime.ServeMux
+	gatewayServer   *http.Server
+	mux             *xnet.Mux
+	health          *health.Server
 	*Config
 }
 
 func newServer(config *Config) *Server {
 	var s Server
 	s.Config = config
+	var sharePort = config.gwRegister != nil && config.GatewaySharePort
+	var tlsConfig *tls.Config
+	if config.TLS != nil && config.TLS.Enable {
+		var err error
+		tlsConfig, err = config.TLS.ServerTLSConfig()
+		if err != nil {
+			config.logger.Panic("new grpc server tls config err", xlog.FieldErrKind(ecode.ErrKindTLSErr), xlog.FieldErr(err))
+		}
+		// 共享端口时也由 grpc 终止 tls，grpc handler 才能从 peer.AuthInfo 取到 TLSInfo
+		config.serverOptions = append(config.serverOptions, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	if config.AccessLog == nil {
+		config.AccessLog = xlog.DefaultAccessConfig()
+	}
+	var accessPolicy = config.AccessLog.Build()
+
 	var streamInterceptors = append(
-		[]grpc.StreamServerInterceptor{defaultStreamServerInterceptor(config.logger, config.SlowQueryThresholdInMilli)},
+		[]grpc.StreamServerInterceptor{
+			defaultStreamServerInterceptor(config.logger, config.SlowQueryThresholdInMilli, accessPolicy),
+			timeoutStreamServerInterceptor(config),
+		},
 		config.streamInterceptors...,
 	)
 
 	var unaryInterceptors = append(
-		[]grpc.UnaryServerInterceptor{defaultUnaryServerInterceptor(config.logger, config.SlowQueryThresholdInMilli)},
+		[]grpc.UnaryServerInterceptor{
+			defaultUnaryServerInterceptor(config.logger, config.SlowQueryThresholdInMilli, accessPolicy),
+			timeoutUnaryServerInterceptor(config),
+		},
 		config.unaryInterceptors...,
 	)
 
@@ -68,56 +102,113 @@ func newServer(config *Config) *Server {
 	newServer := grpc.NewServer(config.serverOptions...)
 	s.Server = newServer
 
-	listener, err := net.Listen(config.Network, config.Address())
-	if err != nil {
-		config.logger.Panic("new grpc server err", xlog.FieldErrKind(ecode.ErrKindListenErr), xlog.FieldErr(err))
+	if !config.DisableHealth {
+		// 服务启动前保持 NOT_SERVING，Serve 之后才对外提供服务
+		s.health = health.NewServer()
+		s.health.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+		healthpb.RegisterHealthServer(newServer, s.health)
+	}
+
+	if config.EnableReflection {
+		reflection.Register(newServer)
+	}
+
+	var listener = config.listener
+	if listener == nil {
+		var err error
+		listener, err = net.Listen(config.Network, config.Address())
+		if err != nil {
+			config.logger.Panic("new grpc server err", xlog.FieldErrKind(ecode.ErrKindListenErr), xlog.FieldErr(err))
+		}
 	}
 	config.Port = listener.Addr().(*net.TCPAddr).Port
 	s.listener = listener
 
+	if sharePort {
+		s.mux = xnet.NewMux(listener, sniffTimeout)
+		if tlsConfig != nil {
+			// 按 client hello 的 ALPN 分流：grpc 客户端仅声明 h2(grpc-exp)，连接原样交给 grpc 终止 tls；
+			// 浏览器等 http 客户端同时声明 http/1.1，由 gateway 终止 tls
+			s.listener = s.mux.Match(xnet.MatchTLSALPN("h2", "grpc-exp"))
+			var gwTLSConfig = tlsConfig.Clone()
+			gwTLSConfig.NextProtos = []string{"h2", "http/1.1"}
+			s.gatewayListener = tls.NewListener(s.mux.Match(xnet.MatchAny()), gwTLSConfig)
+		} else {
+			s.listener = s.mux.Match(xnet.MatchHTTP2())
+			s.gatewayListener = s.mux.Match(xnet.MatchAny())
+		}
+		config.GatewayPort = config.Port
+	}
+
 	if config.gwRegister != nil && config.GatewayPort != 0 {
-		gwListener, err := net.Listen(config.Network, config.GwAddress())
-		if err != nil {
-			config.logger.Panic("new grpc gateway server err", xlog.FieldErrKind(ecode.ErrKindListenErr), xlog.FieldErr(err))
+		if !sharePort {
+			gwListener, err := net.Listen(config.Network, config.GwAddress())
+			if err != nil {
+				config.logger.Panic("new grpc gateway server err", xlog.FieldErrKind(ecode.ErrKindListenErr), xlog.FieldErr(err))
+			}
+			config.GatewayPort = gwListener.Addr().(*net.TCPAddr).Port
+			s.gatewayListener = gwListener
 		}
 
-		var muxOptions = []runtime.ServeMuxOption{
+		var mux = runtime.NewServeMux(
 			runtime.WithMarshalerOption(runtime.MIMEWildcard, &runtime.JSONPb{
 				EmitDefaults: true,
 				EnumsAsInts:  true,
 			}),
-		}
-
-		if config.withMetadataFunc != nil {
-			muxOptions = append(muxOptions, runtime.WithMetadata(config.withMetadataFunc))
-		}
+			runtime.WithMetadata(gatewayMetadata(config.withMetadataFunc)),
+			runtime.WithProtoErrorHandler(gatewayErrorHandler),
+		)
 
-		var mux = runtime.NewServeMux(muxOptions...)
-
-		err = config.gwRegister(context.Background(), mux, config.Address(), []grpc.DialOption{grpc.WithInsecure()})
+		err := config.gwRegister(context.Background(), mux, config.Address(), []grpc.DialOption{
+			gatewayDialOption(config),
+			grpc.WithUnaryInterceptor(gatewayUnaryClientInterceptor),
+			grpc.WithStreamInterceptor(gatewayStreamClientInterceptor),
+		})
 		if err != nil {
 			config.logger.Panic("register grpc gateway server err", xlog.FieldErrKind(ecode.ErrKindListenErr), xlog.FieldErr(err))
 		}
 
-		config.GatewayPort = gwListener.Addr().(*net.TCPAddr).Port
-		s.gatewayListener = gwListener
-		s.gatewayMux = mux
+		s.gatewayServer = &http.Server{Handler: gatewayHandler(config, mux)}
 
-		config.logger.Info("start gateway", xlog.FieldEvent("init"), xlog.FieldAddr(config.GwAddress()), xlog.String("scheme", "http"))
+		config.logger.Info("start gateway", xlog.FieldEvent("init"), xlog.FieldAddr(config.GwAddress()), xlog.String("scheme", "http"), xlog.Any("sharePort", sharePort))
 	}
 
 	return &s
 }
 
+// gatewayDialOption returns dial option of gateway connecting to its own grpc server
+func gatewayDialOption(config *Config) grpc.DialOption {
+	if config.TLS == nil || !config.TLS.Enable {
+		return grpc.WithInsecure()
+	}
+
+	// gateway dials the local grpc server, so server certificate is not verified,
+	// but server certificate is presented as client certificate for mTLS
+	var gwTLS = *config.TLS
+	gwTLS.InsecureSkipVerify = true
+	tlsConfig, err := gwTLS.ClientTLSConfig()
+	if err != nil {
+		config.logger.Panic("new grpc gateway tls config err", xlog.FieldErrKind(ecode.ErrKindTLSErr), xlog.FieldErr(err))
+	}
+	return grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))
+}
+
 // Server implements server.Server interface.
 func (s *Server) Serve() error {
 	var errChan = make(chan error, 1)
-	if s.gatewayListener != nil {
+	if s.gatewayServer != nil {
+		go func() {
+			errChan <- s.gatewayServer.Serve(s.gatewayListener)
+		}()
+	}
+	if s.mux != nil {
 		go func() {
-			errChan <- http.Serve(s.gatewayListener, s.gatewayMux)
+			errChan <- s.mux.Serve()
 		}()
 	}
 
+	s.Resume()
+
 	go func() {
 		errChan <- s.Server.Serve(s.listener)
 	}()
@@ -128,17 +219,76 @@ func (s *Server) Serve() error {
 // Stop implements server.Server interface
 // it will terminate echo server immediately
 func (s *Server) Stop() error {
+	if s.health != nil {
+		s.health.Shutdown()
+	}
+	if s.gatewayServer != nil {
+		_ = s.gatewayServer.Close()
+	}
 	s.Server.Stop()
+	if s.mux != nil {
+		_ = s.mux.Close()
+	}
 	return nil
 }
 
 // GracefulStop implements server.Server interface
-// it will stop echo server gracefully
+// it will flip health status to NOT_SERVING, wait DrainTimeout for
+// clients to drain, and then stop grpc server gracefully
 func (s *Server) GracefulStop(ctx context.Context) error {
+	if s.health != nil {
+		s.health.Shutdown()
+		if s.DrainTimeout > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(s.DrainTimeout):
+			}
+		}
+	}
+	if s.gatewayServer != nil {
+		_ = s.gatewayServer.Shutdown(ctx)
+	}
 	s.Server.GracefulStop()
+	if s.mux != nil {
+		_ = s.mux.Close()
+	}
 	return nil
 }
 
+// SetServingStatus sets the serving status of a service, empty service
+// represents the overall status of server.
+// It is a no-op if health service is disabled.
+func (s *Server) SetServingStatus(service string, status healthpb.HealthCheckResponse_ServingStatus) {
+	if s.health == nil {
+		return
+	}
+	s.health.SetServingStatus(service, status)
+}
+
+// Resume marks the server and all registered services as SERVING.
+func (s *Server) Resume() {
+	if s.health == nil {
+		return
+	}
+	s.health.Resume()
+	s.health.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	for name := range s.Server.GetServiceInfo() {
+		s.health.SetServingStatus(name, healthpb.HealthCheckResponse_SERVING)
+	}
+}
+
+// Suspend marks the server and all registered services as NOT_SERVING,
+// e.g. when application is not ready for traffic.
+func (s *Server) Suspend() {
+	if s.health == nil {
+		return
+	}
+	s.health.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	for name := range s.Server.GetServiceInfo() {
+		s.health.SetServingStatus(name, healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+}
+
 // Info returns server info, used by governor and consumer balancer
 func (s *Server) Info() *server.ServiceInfo {
 	serviceAddress := s.listener.Addr().String()
@@ -151,12 +301,17 @@ func (s *Server) Info() *server.ServiceInfo {
 		gwAddress = s.gatewayListener.Addr().String()
 	}
 
-	info := server.ApplyOptions(
+	var options = []server.Option{
 		server.WithScheme("grpc"),
 		server.WithAddress(serviceAddress),
 		server.WithAddressGW(gwAddress),
 		server.WithKind(constant.ServiceProvider),
 		server.WithWeight(s.Weight),
-	)
+	}
+	if s.TLS != nil && s.TLS.Enable {
+		options = append(options, server.WithMetaData("tls", "true"))
+	}
+
+	info := server.ApplyOptions(options...)
 	return &info
 }
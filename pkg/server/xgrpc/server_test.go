@@ -25,6 +25,7 @@ import (
 
 	"github.com/smartystreets/goconvey/convey"
 	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/status"
 )
 
@@ -153,6 +154,45 @@ func TestServer_Info(t *testing.T) {
 	})
 }
 
+func TestServer_Health(t *testing.T) {
+	convey.Convey("test server health", t, func(c convey.C) {
+		config := DefaultConfig()
+		config.Port = 0
+		config.DrainTimeout = time.Millisecond * 100
+		ns := newServer(config)
+		go func() {
+			_ = ns.Serve()
+		}()
+
+		cc, err := grpc.Dial(ns.listener.Addr().String(), grpc.WithInsecure(), grpc.WithBlock())
+		convey.So(err, convey.ShouldBeNil)
+		defer cc.Close()
+
+		client := healthpb.NewHealthClient(cc)
+		resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(resp.Status, convey.ShouldEqual, healthpb.HealthCheckResponse_SERVING)
+
+		resp, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "grpc.health.v1.Health"})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(resp.Status, convey.ShouldEqual, healthpb.HealthCheckResponse_SERVING)
+
+		ns.Suspend()
+		resp, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(resp.Status, convey.ShouldEqual, healthpb.HealthCheckResponse_NOT_SERVING)
+
+		ns.Resume()
+		go func() {
+			_ = ns.GracefulStop(context.TODO())
+		}()
+		time.Sleep(time.Millisecond * 50)
+		resp, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(resp.Status, convey.ShouldEqual, healthpb.HealthCheckResponse_NOT_SERVING)
+	})
+}
+
 func errorDesc(err error) string {
 	if s, ok := status.FromError(err); ok {
 		return s.Message()
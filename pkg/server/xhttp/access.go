@@ -0,0 +1,140 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xhttp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/xlog"
+)
+
+// AccessMiddleware logs access of requests, requests slower than slowThreshold are logged with
+// event slow and slow cost in milliseconds, slow request detection is disabled if slowThreshold is 0.
+// fields cost in seconds, slow and err are compatible with former access logs of gin, echo and goframe
+func AccessMiddleware(logger *xlog.Logger, slowThreshold time.Duration) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var beg = time.Now()
+			rw, r := prepare(w, r)
+			st := stateOf(r.Context())
+			defer func() {
+				var cost = time.Since(beg)
+				var event = "normal"
+				var slow = slowThreshold > 0 && cost > slowThreshold
+				if slow {
+					event = "slow"
+				}
+				if st.stack != nil {
+					event = "recover"
+				}
+
+				var size = rw.Size()
+				if size < 0 {
+					size = 0
+				}
+				var fields = make([]xlog.Field, 0, 14)
+				fields = append(fields,
+					xlog.FieldMethod(r.Method),
+					xlog.Int("code", rw.Status()),
+					xlog.Int("size", size),
+					xlog.String("host", r.Host),
+					xlog.String("path", r.URL.Path),
+					xlog.String("route", Route(r)),
+					xlog.String("ip", ClientIP(r)),
+					xlog.FieldAid(st.aid),
+					xlog.String("request_id", st.requestID),
+					xlog.Float64("cost", cost.Seconds()),
+					xlog.FieldEvent(event),
+				)
+				if slow {
+					fields = append(fields, xlog.Int64("slow", cost.Milliseconds()))
+				}
+				if st.stack != nil {
+					fields = append(fields, xlog.FieldStack(st.stack))
+				}
+				if st.err != nil {
+					fields = append(fields, xlog.String("err", st.err.Error()))
+				}
+				if st.err != nil || rw.Status() >= http.StatusInternalServerError {
+					logger.Error("access", fields...)
+					return
+				}
+				logger.Info("access", fields...)
+			}()
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// RecoveryMiddleware recovers panic of handler and responds 500 if response isn't written,
+// panic of any value is recovered as error, http.ErrAbortHandler is re-panicked
+func RecoveryMiddleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rw, r := prepare(w, r)
+			defer func() {
+				rec := recover()
+				if rec == nil {
+					return
+				}
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+
+				var err = recoverError(rec)
+				var stack = make([]byte, 4096)
+				st := stateOf(r.Context())
+				st.stack = stack[:runtime.Stack(stack, false)]
+				st.err = err
+				// 连接已断开, 无法写入响应
+				if isBrokenPipe(err) || rw.Written() {
+					return
+				}
+				rw.WriteHeader(http.StatusInternalServerError)
+			}()
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// recoverError converts recovered value to error
+func recoverError(rec interface{}) error {
+	switch rec := rec.(type) {
+	case error:
+		return fmt.Errorf("panic: %w", rec)
+	default:
+		return fmt.Errorf("panic: %v", rec)
+	}
+}
+
+func isBrokenPipe(err error) bool {
+	var ne *net.OpError
+	if !errors.As(err, &ne) {
+		return false
+	}
+	var se *os.SyscallError
+	if !errors.As(ne.Err, &se) {
+		return false
+	}
+	msg := strings.ToLower(se.Error())
+	return strings.Contains(msg, "broken pipe") || strings.Contains(msg, "connection reset by peer")
+}
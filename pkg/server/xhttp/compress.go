@@ -0,0 +1,326 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xhttp
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/douyu/jupiter/pkg/util/xcompressor"
+	"github.com/douyu/jupiter/pkg/xlog"
+)
+
+// CompressConfig response compression config
+type CompressConfig struct {
+	// Enable enable compression, false by default
+	Enable bool
+	// Encodings content encodings in order of preference, "br", "gzip" and "deflate" are built in,
+	// others are used once registered with RegisterCompressor, gzip by default
+	Encodings []string
+	// Level compress level between -2 ~ 9, default compression if 0
+	Level int
+	// MinLength responses shorter than MinLength bytes are not compressed, 1024 by default
+	MinLength int
+	// ContentTypes prefixes of content types to compress, text, json, javascript and xml by default
+	ContentTypes []string
+}
+
+var defaultCompressTypes = []string{
+	"text/", "application/json", "application/javascript", "application/xml", "image/svg+xml",
+}
+
+var compressors = map[string]func(level int) xcompressor.XCompressor{
+	"br":      xcompressor.NewBrotliCompressor,
+	"gzip":    xcompressor.NewGzipCompressor,
+	"deflate": xcompressor.NewZlibCompressor,
+}
+
+// RegisterCompressor registers compressor of content encoding, e.g. "zstd",
+// which is used if listed in CompressConfig.Encodings. Compressor built must implement
+// xcompressor.XStreamCompressor, so that response is compressed as written. It's not concurrent safe,
+// and should be called in init.
+func RegisterCompressor(encoding string, builder func(level int) xcompressor.XCompressor) {
+	compressors[encoding] = builder
+}
+
+// CompressMiddleware compresses responses with encoding accepted by client, response not compressible
+// by its headers is sent through, and at most MinLength bytes are buffered to decide
+func CompressMiddleware(config *CompressConfig) Middleware {
+	var (
+		encodings    = config.Encodings
+		level        = config.Level
+		minLength    = config.MinLength
+		contentTypes = config.ContentTypes
+	)
+	if len(encodings) == 0 {
+		encodings = []string{"gzip"}
+	}
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	if minLength <= 0 {
+		minLength = 1024
+	}
+	if len(contentTypes) == 0 {
+		contentTypes = defaultCompressTypes
+	}
+	var supported = make([]string, 0, len(encodings))
+	var built = make(map[string]xcompressor.XStreamCompressor, len(encodings))
+	for _, encoding := range encodings {
+		if builder, ok := compressors[encoding]; ok {
+			compressor, ok := builder(level).(xcompressor.XStreamCompressor)
+			if !ok {
+				xlog.JupiterLogger.Panic("compressor doesn't support streaming", xlog.FieldMod("server.http"), xlog.FieldName(encoding))
+			}
+			supported = append(supported, encoding)
+			built[encoding] = compressor
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			// response varies with Accept-Encoding whether it's compressed or not
+			w.Header().Add("Vary", "Accept-Encoding")
+			var encoding = negotiateEncoding(r.Header.Get("Accept-Encoding"), supported)
+			if encoding == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			var cw = &compressWriter{
+				ResponseWriter: NewResponseWriter(w),
+				encoding:       encoding,
+				compressor:     built[encoding],
+				minLength:      minLength,
+				contentTypes:   contentTypes,
+			}
+			next.ServeHTTP(cw, r)
+			cw.finish()
+		})
+	}
+}
+
+// negotiateEncoding returns the first encoding of supported accepted by client
+func negotiateEncoding(accept string, supported []string) string {
+	if accept == "" {
+		return ""
+	}
+	var accepted = make(map[string]bool)
+	for _, part := range strings.Split(accept, ",") {
+		var fields = strings.Split(part, ";")
+		var name = strings.ToLower(strings.TrimSpace(fields[0]))
+		var ok = true
+		for _, param := range fields[1:] {
+			param = strings.ReplaceAll(strings.TrimSpace(param), " ", "")
+			if strings.HasPrefix(param, "q=") && strings.Trim(param[2:], "0.") == "" {
+				ok = false
+			}
+		}
+		accepted[name] = ok
+	}
+	for _, encoding := range supported {
+		if ok, exist := accepted[encoding]; ok || !exist && accepted["*"] {
+			return encoding
+		}
+	}
+	return ""
+}
+
+// compressWriter decides whether to compress response once headers are written, and buffers
+// at most minLength bytes of body if it's still unknown, e.g. Content-Type isn't set
+type compressWriter struct {
+	ResponseWriter
+	encoding     string
+	compressor   xcompressor.XStreamCompressor
+	minLength    int
+	contentTypes []string
+
+	status int
+	buf    bytes.Buffer
+	// passthrough response is sent uncompressed
+	passthrough bool
+	// writer compresses body once response is decided to be compressed
+	writer io.WriteCloser
+}
+
+func (w *compressWriter) decided() bool {
+	return w.passthrough || w.writer != nil
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.passthrough {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if w.decided() || w.status != 0 {
+		return
+	}
+	w.status = status
+	if !w.mayCompress() {
+		w.writeRaw()
+	}
+}
+
+func (w *compressWriter) Write(data []byte) (int, error) {
+	if w.status == 0 && !w.decided() {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.passthrough {
+		return w.ResponseWriter.Write(data)
+	}
+	if w.writer != nil {
+		return w.writer.Write(data)
+	}
+	w.buf.Write(data)
+	if w.buf.Len() >= w.minLength {
+		if err := w.decide(); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (w *compressWriter) Status() int {
+	if w.decided() {
+		return w.ResponseWriter.Status()
+	}
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+func (w *compressWriter) Size() int {
+	if w.decided() {
+		return w.ResponseWriter.Size()
+	}
+	return w.buf.Len()
+}
+
+func (w *compressWriter) Written() bool {
+	if w.decided() {
+		return w.ResponseWriter.Written()
+	}
+	return w.status != 0
+}
+
+// Flush implements http.Flusher, streaming responses are sent uncompressed if flushed before
+// compression is decided
+func (w *compressWriter) Flush() {
+	if w.writer != nil {
+		if flusher, ok := w.writer.(interface{ Flush() error }); ok {
+			_ = flusher.Flush()
+		}
+	} else {
+		w.writeRaw()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := w.ResponseWriter.(http.Hijacker); ok {
+		w.passthrough = true
+		return hijacker.Hijack()
+	}
+	return nil, nil, errors.New("response writer doesn't implement http.Hijacker")
+}
+
+// writeRaw sends buffered response uncompressed, and stops buffering
+func (w *compressWriter) writeRaw() {
+	if w.decided() {
+		return
+	}
+	w.passthrough = true
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	if w.buf.Len() > 0 {
+		_, _ = w.ResponseWriter.Write(w.buf.Bytes())
+		w.buf.Reset()
+	}
+}
+
+// decide compresses response if its content type is compressible, and sends buffered body
+func (w *compressWriter) decide() error {
+	var header = w.Header()
+	if header.Get("Content-Type") == "" {
+		// sniffed by net/http otherwise, which sees compressed body
+		header.Set("Content-Type", http.DetectContentType(w.buf.Bytes()))
+	}
+	if w.buf.Len() < w.minLength || !w.mayCompress() {
+		w.writeRaw()
+		return nil
+	}
+	writer, err := w.compressor.NewWriter(w.ResponseWriter)
+	if err != nil {
+		w.writeRaw()
+		return nil
+	}
+
+	header.Set("Content-Encoding", w.encoding)
+	header.Del("Content-Length")
+	w.writer = writer
+	w.ResponseWriter.WriteHeader(w.status)
+	_, err = w.writer.Write(w.buf.Bytes())
+	w.buf.Reset()
+	return err
+}
+
+func (w *compressWriter) finish() {
+	if w.writer != nil {
+		_ = w.writer.Close()
+		return
+	}
+	if !w.passthrough && w.status != 0 {
+		_ = w.decide()
+	}
+}
+
+// mayCompress returns false if response isn't compressible by status or headers written
+func (w *compressWriter) mayCompress() bool {
+	if w.status < http.StatusOK || w.status == http.StatusNoContent || w.status == http.StatusNotModified {
+		return false
+	}
+	var header = w.Header()
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	if length, err := strconv.Atoi(header.Get("Content-Length")); err == nil && length < w.minLength {
+		return false
+	}
+	var contentType = header.Get("Content-Type")
+	if contentType == "" {
+		return true
+	}
+	for _, prefix := range w.contentTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xhttp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/douyu/jupiter/pkg/util/xcompressor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressMiddleware(t *testing.T) {
+	body := strings.Repeat(`{"name":"jupiter"}`, 100)
+	handler := Chain(CompressMiddleware(&CompressConfig{
+		Enable:    true,
+		Encodings: []string{"br", "gzip"},
+	}))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(body[:len(body)/2]))
+		_, _ = w.Write([]byte(body[len(body)/2:]))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "br;q=1.0, gzip")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "br", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+	data, err := xcompressor.NewBrotliCompressor(0).Uncompress(w.Body.Bytes())
+	assert.Nil(t, err)
+	assert.Equal(t, body, string(data))
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	reader, err := gzip.NewReader(bytes.NewReader(w.Body.Bytes()))
+	assert.Nil(t, err)
+	data, err = ioutil.ReadAll(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, body, string(data))
+
+	// neither br nor gzip accepted
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip;q=0, deflate")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, body, w.Body.String())
+}
+
+func TestCompressMiddleware_Skip(t *testing.T) {
+	handler := Chain(CompressMiddleware(&CompressConfig{Enable: true, MinLength: 8}))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/short":
+				_, _ = w.Write([]byte("hello"))
+			case "/image":
+				w.Header().Set("Content-Type", "image/png")
+				_, _ = w.Write(bytes.Repeat([]byte{0}, 64))
+			case "/stream":
+				_, _ = w.Write([]byte("data "))
+				w.(http.Flusher).Flush()
+				_, _ = w.Write([]byte(strings.Repeat("data ", 8)))
+			}
+		}))
+
+	for _, path := range []string{"/short", "/image", "/stream"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code, path)
+		assert.Empty(t, w.Header().Get("Content-Encoding"), path)
+		assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"), path)
+		assert.NotEmpty(t, w.Body.String(), path)
+	}
+}
+
+func TestCompressMiddleware_Stream(t *testing.T) {
+	var written bool
+	handler := Chain(CompressMiddleware(&CompressConfig{Enable: true, MinLength: 8}))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/video":
+				// sent through without buffering
+				w.Header().Set("Content-Type", "video/mp4")
+				_, _ = w.Write(bytes.Repeat([]byte{1}, 4096))
+				written = w.(ResponseWriter).Written() && w.(ResponseWriter).Size() == 4096
+			case "/events":
+				// compressed as written
+				w.Header().Set("Content-Type", "text/event-stream")
+				_, _ = w.Write([]byte(strings.Repeat("data: jupiter\n\n", 4)))
+				w.(http.Flusher).Flush()
+				written = w.(ResponseWriter).Size() > 0
+				_, _ = w.Write([]byte(strings.Repeat("data: jupiter\n\n", 4)))
+			}
+		}))
+
+	req := httptest.NewRequest(http.MethodGet, "/video", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.True(t, written)
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, 4096, w.Body.Len())
+
+	written = false
+	req = httptest.NewRequest(http.MethodGet, "/events", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.True(t, written)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	reader, err := gzip.NewReader(w.Body)
+	assert.Nil(t, err)
+	data, err := ioutil.ReadAll(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Repeat("data: jupiter\n\n", 8), string(data))
+}
@@ -0,0 +1,144 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xhttp
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/xlog"
+)
+
+// CORSConfig cross-origin resource sharing policy
+type CORSConfig struct {
+	// Enable enable cors, false by default
+	Enable bool
+	// AllowOrigins allowed origins, "*" allows any origin, and "https://*.douyu.com" allows subdomains
+	AllowOrigins []string
+	// AllowMethods allowed methods of preflight, GET/POST/PUT/PATCH/DELETE/HEAD by default
+	AllowMethods []string
+	// AllowHeaders allowed headers of preflight, headers requested are allowed if empty
+	AllowHeaders []string
+	// ExposeHeaders headers exposed to browser
+	ExposeHeaders []string
+	// AllowCredentials allows cookies and credentials, origins must be listed explicitly
+	// since "*" with credentials lets any site read responses of the user
+	AllowCredentials bool
+	// MaxAge seconds preflight result is cached by browser, not sent if 0
+	MaxAge int
+}
+
+var defaultCORSMethods = []string{
+	http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodHead,
+}
+
+// CORSMiddleware answers preflight requests and sets cors headers of requests from allowed origins,
+// preflight of disallowed origins is rejected with 403, it panics if "*" is allowed with credentials
+func CORSMiddleware(config *CORSConfig) Middleware {
+	var (
+		methods       = config.AllowMethods
+		allowAny      bool
+		allowHeaders  = strings.Join(config.AllowHeaders, ", ")
+		exposeHeaders = strings.Join(config.ExposeHeaders, ", ")
+	)
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+	var allowMethods = strings.Join(methods, ", ")
+	for _, origin := range config.AllowOrigins {
+		if origin == "*" {
+			allowAny = true
+		}
+	}
+	if allowAny && config.AllowCredentials {
+		xlog.JupiterLogger.Panic("cors allows any origin with credentials", xlog.FieldMod("server.http"), xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr))
+	}
+	var allowed = func(origin string) bool {
+		if allowAny {
+			return true
+		}
+		for _, pattern := range config.AllowOrigins {
+			if matchOrigin(pattern, origin) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var origin = r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var header = w.Header()
+			header.Add("Vary", "Origin")
+			var preflight = r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			if !allowed(origin) {
+				if preflight {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if allowAny {
+				header.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				header.Set("Access-Control-Allow-Origin", origin)
+			}
+			if config.AllowCredentials {
+				header.Set("Access-Control-Allow-Credentials", "true")
+			}
+			if !preflight {
+				if exposeHeaders != "" {
+					header.Set("Access-Control-Expose-Headers", exposeHeaders)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+			header.Set("Access-Control-Allow-Methods", allowMethods)
+			if allowHeaders != "" {
+				header.Set("Access-Control-Allow-Headers", allowHeaders)
+			} else if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+				header.Set("Access-Control-Allow-Headers", requested)
+			}
+			if config.MaxAge > 0 {
+				header.Set("Access-Control-Max-Age", strconv.Itoa(config.MaxAge))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// matchOrigin matches origin with pattern, which has at most one wildcard "*"
+func matchOrigin(pattern, origin string) bool {
+	var i = strings.IndexByte(pattern, '*')
+	if i < 0 {
+		return strings.EqualFold(pattern, origin)
+	}
+	var prefix, suffix = pattern[:i], pattern[i+1:]
+	return len(origin) >= len(prefix)+len(suffix) &&
+		strings.HasPrefix(strings.ToLower(origin), strings.ToLower(prefix)) &&
+		strings.HasSuffix(strings.ToLower(origin), strings.ToLower(suffix))
+}
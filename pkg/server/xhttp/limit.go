@@ -0,0 +1,258 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xhttp
+
+import (
+	"container/list"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/metric"
+	"github.com/douyu/jupiter/pkg/sentinel"
+	"github.com/douyu/jupiter/pkg/xlog"
+
+	"golang.org/x/time/rate"
+)
+
+// BodyLimitMiddleware rejects requests with body larger than limit bytes with 413,
+// body of chunked requests fails reading once limit exceeded
+func BodyLimitMiddleware(limit int64) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > limit {
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+				return
+			}
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = http.MaxBytesReader(w, r.Body, limit)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RateLimitConfig token bucket rate limit config, each route has its own bucket
+type RateLimitConfig struct {
+	// Enable enable rate limit, false by default
+	Enable bool
+	// Rate tokens per second of bucket, unlimited if 0
+	Rate float64
+	// Burst size of bucket, Rate rounded up by default
+	Burst int
+	// PerClient limits each client ip of route with its own bucket, client ip is the remote address,
+	// or the last address of X-Forwarded-For not in TrustedProxies if remote address is a trusted proxy
+	PerClient bool
+	// TrustedProxies ips or cidrs of proxies whose X-Forwarded-For is trusted, e.g. "10.0.0.0/8"
+	TrustedProxies []string
+	// Routes overrides rate and burst of routes
+	Routes []RateLimitRoute
+	// Sentinel checks requests with sentinel flow rules of resource "METHOD.route" instead of token buckets
+	Sentinel bool
+}
+
+// RateLimitRoute rate and burst of route
+type RateLimitRoute struct {
+	// Route route template of request, e.g. "/api/users/:id"
+	Route string
+	// Rate tokens per second of bucket, unlimited if 0
+	Rate float64
+	// Burst size of bucket, Rate rounded up by default
+	Burst int
+}
+
+// maxBuckets max buckets of rate limiter, the least recently used ones are evicted once exceeded
+const maxBuckets = 10000
+
+// RateLimitMiddleware rejects requests exceeding rate with 429
+func RateLimitMiddleware(config *RateLimitConfig) Middleware {
+	if config.Sentinel {
+		return sentinelMiddleware()
+	}
+	var limiter = newRateLimiter(config)
+	var proxies = parseTrustedProxies(config.TrustedProxies)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var route, aid = Route(r), AID(r.Context())
+			var client string
+			if config.PerClient {
+				// aid and the first address of X-Forwarded-For are set by clients, which can't identify them
+				client = remoteIP(r, proxies)
+			}
+			if !limiter.allow(route, client) {
+				metric.ServerBlockedCounter.Inc(metric.TypeHTTP, r.Method+"."+route, aid, "RateLimit")
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func sentinelMiddleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var resource, aid = r.Method + "." + Route(r), AID(r.Context())
+			entry, blockErr := sentinel.EntryInbound(resource, aid)
+			if blockErr != nil {
+				metric.ServerBlockedCounter.Inc(metric.TypeHTTP, resource, aid, blockErr.BlockType().String())
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			defer entry.Exit()
+
+			next.ServeHTTP(w, r)
+			if st := stateOf(r.Context()); st != nil && st.err != nil {
+				sentinel.TraceError(entry, st.err)
+			}
+		})
+	}
+}
+
+type bucketKey struct {
+	route  string
+	client string
+}
+
+type bucket struct {
+	*rate.Limiter
+	key bucketKey
+}
+
+type rateLimiter struct {
+	limit  rate.Limit
+	burst  int
+	routes map[string]RateLimitRoute
+
+	mu      sync.Mutex
+	buckets map[bucketKey]*list.Element
+	// buckets ordered by last use, the front is the most recently used
+	lru *list.List
+}
+
+func newRateLimiter(config *RateLimitConfig) *rateLimiter {
+	var limiter = &rateLimiter{
+		routes:  make(map[string]RateLimitRoute, len(config.Routes)),
+		buckets: make(map[bucketKey]*list.Element),
+		lru:     list.New(),
+	}
+	limiter.limit, limiter.burst = limitOf(config.Rate, config.Burst)
+	for _, route := range config.Routes {
+		limiter.routes[route.Route] = route
+	}
+	return limiter
+}
+
+// limitOf returns limit and burst of bucket, rate 0 means unlimited
+func limitOf(r float64, burst int) (rate.Limit, int) {
+	if r <= 0 {
+		return rate.Inf, 0
+	}
+	if burst <= 0 {
+		burst = int(math.Ceil(r))
+	}
+	return rate.Limit(r), burst
+}
+
+func (l *rateLimiter) allow(route, client string) bool {
+	var limit, burst = l.limit, l.burst
+	if rt, ok := l.routes[route]; ok {
+		limit, burst = limitOf(rt.Rate, rt.Burst)
+	}
+	if limit == rate.Inf {
+		return true
+	}
+
+	var key = bucketKey{route: route, client: client}
+	l.mu.Lock()
+	elem, ok := l.buckets[key]
+	if ok {
+		l.lru.MoveToFront(elem)
+	} else {
+		if l.lru.Len() >= maxBuckets {
+			oldest := l.lru.Back()
+			l.lru.Remove(oldest)
+			delete(l.buckets, oldest.Value.(*bucket).key)
+		}
+		elem = l.lru.PushFront(&bucket{Limiter: rate.NewLimiter(limit, burst), key: key})
+		l.buckets[key] = elem
+	}
+	b := elem.Value.(*bucket)
+	l.mu.Unlock()
+	return b.Allow()
+}
+
+// parseTrustedProxies parses ips or cidrs of proxies, panics if invalid
+func parseTrustedProxies(proxies []string) []*net.IPNet {
+	var nets = make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			xlog.JupiterLogger.Panic("parse trusted proxies", xlog.FieldMod("server.http"), xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr), xlog.FieldErr(err))
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets
+}
+
+// remoteIP returns ip of remote address, X-Forwarded-For is only honoured if remote address is a trusted proxy,
+// and the last address not in trusted proxies is returned, since addresses before it may be forged
+func remoteIP(r *http.Request, proxies []*net.IPNet) string {
+	ip, _, err := net.SplitHostPort(strings.TrimSpace(r.RemoteAddr))
+	if err != nil {
+		ip = strings.TrimSpace(r.RemoteAddr)
+	}
+	if !trusted(ip, proxies) {
+		return ip
+	}
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(forwarded[i])
+		if addr == "" {
+			continue
+		}
+		ip = addr
+		if !trusted(ip, proxies) {
+			break
+		}
+	}
+	return ip
+}
+
+func trusted(ip string, proxies []*net.IPNet) bool {
+	if len(proxies) == 0 {
+		return false
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, proxy := range proxies {
+		if proxy.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xhttp
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBodyLimitMiddleware(t *testing.T) {
+	handler := Chain(BodyLimitMiddleware(4))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := ioutil.ReadAll(r.Body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello")))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello"))
+	req.ContentLength = -1
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hi")))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	handler := Chain(RateLimitMiddleware(&RateLimitConfig{
+		Enable:         true,
+		Rate:           0.001,
+		Burst:          1,
+		PerClient:      true,
+		TrustedProxies: []string{"10.0.0.0/8"},
+		Routes:         []RateLimitRoute{{Route: "/unlimited"}},
+	}))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := func(path, remote, aid, forwarded string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remote + ":12345"
+		req.Header.Set("AID", aid)
+		req.Header.Set("X-Forwarded-For", forwarded)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, request("/users", "1.1.1.1", "1", ""))
+	assert.Equal(t, http.StatusTooManyRequests, request("/users", "1.1.1.1", "1", ""))
+	// aid and X-Forwarded-For set by clients don't bypass rate limit
+	assert.Equal(t, http.StatusTooManyRequests, request("/users", "1.1.1.1", "2", "3.3.3.3"))
+	// buckets of other clients and routes
+	assert.Equal(t, http.StatusOK, request("/users", "2.2.2.2", "1", ""))
+	assert.Equal(t, http.StatusOK, request("/rooms", "1.1.1.1", "1", ""))
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, request("/unlimited", "1.1.1.1", "1", ""))
+	}
+
+	// X-Forwarded-For of trusted proxies, the last address not of proxies is the client
+	assert.Equal(t, http.StatusOK, request("/users", "10.0.0.1", "", "3.3.3.3, 4.4.4.4, 10.0.0.2"))
+	assert.Equal(t, http.StatusTooManyRequests, request("/users", "10.0.0.3", "", "5.5.5.5, 4.4.4.4"))
+	assert.Equal(t, http.StatusOK, request("/users", "10.0.0.1", "", "4.4.4.4, 3.3.3.3"))
+}
+
+func TestRateLimiter_Evict(t *testing.T) {
+	limiter := newRateLimiter(&RateLimitConfig{Rate: 0.001, Burst: 1})
+	assert.True(t, limiter.allow("/users", "active"))
+	for i := 0; i < maxBuckets-1; i++ {
+		limiter.allow("/users", strconv.Itoa(i))
+	}
+	// active client is used recently, the least recently used one is evicted
+	assert.False(t, limiter.allow("/users", "active"))
+	assert.True(t, limiter.allow("/users", "new"))
+	assert.Equal(t, maxBuckets, len(limiter.buckets))
+	assert.False(t, limiter.allow("/users", "active"))
+	assert.True(t, limiter.allow("/users", "0"))
+}
+
+func TestRemoteIP(t *testing.T) {
+	proxies := parseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1", "::1"})
+	for _, c := range []struct {
+		remote, forwarded, want string
+	}{
+		{"1.1.1.1:80", "3.3.3.3", "1.1.1.1"},
+		{"10.0.0.1:80", "", "10.0.0.1"},
+		{"10.0.0.1:80", "3.3.3.3", "3.3.3.3"},
+		{"192.168.1.1:80", "3.3.3.3, 10.1.1.1", "3.3.3.3"},
+		{"[::1]:80", "3.3.3.3,4.4.4.4", "4.4.4.4"},
+		{"10.0.0.1:80", "10.0.0.2", "10.0.0.2"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = c.remote
+		req.Header.Set("X-Forwarded-For", c.forwarded)
+		assert.Equal(t, c.want, remoteIP(req, proxies), c)
+	}
+	assert.Panics(t, func() { parseTrustedProxies([]string{"invalid"}) })
+}
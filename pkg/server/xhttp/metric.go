@@ -0,0 +1,108 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xhttp
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/metric"
+	"github.com/douyu/jupiter/pkg/trace"
+)
+
+// DefaultMetricLabels default labels of server_http_handle metrics
+var DefaultMetricLabels = []string{"method", "route", "code_class"}
+
+// MetricMiddleware records handle histogram and counter of requests labeled with route
+// template, labels selects labels of server_http_handle metrics among metric.HTTPLabels,
+// DefaultMetricLabels if empty, unknown labels are ignored
+func MetricMiddleware(labels ...string) Middleware {
+	if len(labels) == 0 {
+		labels = DefaultMetricLabels
+	}
+	var selected = make(map[string]bool, len(labels))
+	for _, label := range labels {
+		selected[label] = true
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var beg = time.Now()
+			rw, r := prepare(w, r)
+			next.ServeHTTP(rw, r)
+
+			var cost = time.Since(beg).Seconds()
+			var route, aid, status = Route(r), AID(r.Context()), rw.Status()
+			metric.ServerHandleHistogram.Observe(cost, metric.TypeHTTP, r.Method+"."+route, aid)
+			metric.ServerHandleCounter.Inc(metric.TypeHTTP, r.Method+"."+route, aid, http.StatusText(status))
+
+			var values = make([]string, len(metric.HTTPLabels))
+			for i, label := range metric.HTTPLabels {
+				if !selected[label] {
+					continue
+				}
+				switch label {
+				case "method":
+					values[i] = r.Method
+				case "route":
+					values[i] = route
+				case "peer":
+					values[i] = aid
+				case "code":
+					values[i] = strconv.Itoa(status)
+				case "code_class":
+					values[i] = CodeClass(status)
+				}
+			}
+			metric.ServerHTTPHandleHistogram.Observe(cost, values...)
+			metric.ServerHTTPHandleCounter.Inc(values...)
+		})
+	}
+}
+
+// CodeClass returns class of status code, e.g. 2xx
+func CodeClass(status int) string {
+	return strconv.Itoa(status/100) + "xx"
+}
+
+// TraceMiddleware starts server span of request, span context is extracted from header
+func TraceMiddleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rw, r := prepare(w, r)
+			span, ctx := trace.StartSpanFromContext(
+				r.Context(),
+				r.Method+" "+Route(r),
+				trace.TagComponent("http"),
+				trace.TagSpanKind("server"),
+				trace.HeaderExtractor(r.Header),
+				trace.CustomTag("http.url", r.URL.Path),
+				trace.CustomTag("http.method", r.Method),
+				trace.CustomTag("peer.ipv4", ClientIP(r)),
+			)
+			defer span.Finish()
+			if id := RequestID(ctx); id != "" {
+				span.SetTag("http.request_id", id)
+			}
+
+			next.ServeHTTP(rw, r.WithContext(ctx))
+			// route may be matched after middlewares
+			var route = Route(r)
+			span.SetOperationName(r.Method + " " + route)
+			span.SetTag("http.route", route)
+			span.SetTag("http.status_code", rw.Status())
+		})
+	}
+}
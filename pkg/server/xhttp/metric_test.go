@@ -0,0 +1,59 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/douyu/jupiter/pkg/metric"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricMiddleware(t *testing.T) {
+	handler := Chain(MetricMiddleware("method", "route", "code", "code_class", "unknown"))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}))
+
+	for _, route := range []string{"/metric/:id", ""} {
+		for _, path := range []string{"/metric/1", "/metric/2"} {
+			handler.ServeHTTP(httptest.NewRecorder(), WithRoute(httptest.NewRequest(http.MethodGet, path, nil), route))
+		}
+	}
+	assert.Equal(t, float64(2), testutil.ToFloat64(
+		metric.ServerHTTPHandleCounter.WithLabelValues(http.MethodGet, "/metric/:id", "", "404", "4xx")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(
+		metric.ServerHTTPHandleCounter.WithLabelValues(http.MethodGet, UnmatchedRoute, "", "404", "4xx")))
+	assert.Equal(t, float64(0), testutil.ToFloat64(
+		metric.ServerHTTPHandleCounter.WithLabelValues(http.MethodGet, "/metric/1", "", "404", "4xx")))
+}
+
+func TestRoute(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	assert.Equal(t, "/users/1", Route(req))
+	req = WithRoute(req, "")
+	assert.Equal(t, UnmatchedRoute, Route(req))
+	SetRoute(req, "/users/:id")
+	assert.Equal(t, "/users/:id", Route(req))
+}
+
+func TestCodeClass(t *testing.T) {
+	assert.Equal(t, "2xx", CodeClass(http.StatusNoContent))
+	assert.Equal(t, "5xx", CodeClass(http.StatusBadGateway))
+}
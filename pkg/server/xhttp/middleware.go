@@ -0,0 +1,170 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package xhttp provides net/http middlewares shared by http servers,
+// servers of frameworks adapt them with thin adapters, so that behaviours and
+// log fields are identical across all http servers.
+package xhttp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// HeaderRequestID header of request id
+const HeaderRequestID = "X-Request-Id"
+
+// UnmatchedRoute route of requests matching no route, all of which share one series of metrics
+const UnmatchedRoute = "unmatched"
+
+// Middleware net/http middleware
+type Middleware func(http.Handler) http.Handler
+
+// Chain chains middlewares, the first one is the outermost
+func Chain(mws ...Middleware) Middleware {
+	return func(next http.Handler) http.Handler {
+		for i := len(mws) - 1; i >= 0; i-- {
+			next = mws[i](next)
+		}
+		var handler = next
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rw, r := prepare(w, r)
+			handler.ServeHTTP(rw, r)
+		})
+	}
+}
+
+type stateKey struct{}
+
+// state of request shared by middlewares
+type state struct {
+	aid       string
+	requestID string
+	route     string
+	routed    bool
+	err       error
+	stack     []byte
+}
+
+func stateOf(ctx context.Context) *state {
+	st, _ := ctx.Value(stateKey{}).(*state)
+	return st
+}
+
+// withState attaches state to r if absent
+func withState(r *http.Request) *http.Request {
+	if stateOf(r.Context()) != nil {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), stateKey{}, &state{
+		aid: r.Header.Get("AID"),
+	}))
+}
+
+// prepare wraps w to record status, and attaches state to r
+func prepare(w http.ResponseWriter, r *http.Request) (ResponseWriter, *http.Request) {
+	return NewResponseWriter(w), withState(r)
+}
+
+// WithRoute sets route template of request, which is used as name of metric and trace,
+// empty route means request matches no route
+func WithRoute(r *http.Request, route string) *http.Request {
+	r = withState(r)
+	SetRoute(r, route)
+	return r
+}
+
+// SetRoute sets route template of request with state, for frameworks which
+// match routes after global middlewares
+func SetRoute(r *http.Request, route string) {
+	if st := stateOf(r.Context()); st != nil {
+		st.route, st.routed = route, true
+	}
+}
+
+// SetError sets error of request returned by handler, which is logged in access log
+func SetError(r *http.Request, err error) {
+	if st := stateOf(r.Context()); st != nil && err != nil && st.err == nil {
+		st.err = err
+	}
+}
+
+// AID returns aid of caller
+func AID(ctx context.Context) string {
+	if st := stateOf(ctx); st != nil {
+		return st.aid
+	}
+	return ""
+}
+
+// RequestID returns request id set by RequestID middleware
+func RequestID(ctx context.Context) string {
+	if st := stateOf(ctx); st != nil {
+		return st.requestID
+	}
+	return ""
+}
+
+// Route returns route template of request, UnmatchedRoute if request matches no route,
+// or path if route isn't set
+func Route(r *http.Request) string {
+	if st := stateOf(r.Context()); st != nil && st.routed {
+		if st.route == "" {
+			return UnmatchedRoute
+		}
+		return st.route
+	}
+	return r.URL.Path
+}
+
+// ClientIP returns ip of client, parsed from X-Forwarded-For, X-Real-Ip or remote address
+func ClientIP(r *http.Request) string {
+	if ip := strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-For"), ",")[0]); ip != "" {
+		return ip
+	}
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-Ip")); ip != "" {
+		return ip
+	}
+	if ip, _, err := net.SplitHostPort(strings.TrimSpace(r.RemoteAddr)); err == nil {
+		return ip
+	}
+	return ""
+}
+
+// RequestIDMiddleware uses request id of header, or generates one if absent,
+// request id is set in response header
+func RequestIDMiddleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rw, r := prepare(w, r)
+			id := r.Header.Get(HeaderRequestID)
+			if id == "" {
+				id = newRequestID()
+			}
+			stateOf(r.Context()).requestID = id
+			rw.Header().Set(HeaderRequestID, id)
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+func newRequestID() string {
+	var buf = make([]byte, 16)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
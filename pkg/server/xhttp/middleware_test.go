@@ -0,0 +1,124 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xhttp
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/xlog"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func newTestLogger() (*xlog.Logger, *observer.ObservedLogs) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	config := xlog.DefaultConfig()
+	config.Core = core
+	return config.Build(), logs
+}
+
+func serve(logger *xlog.Logger, handler http.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	Chain(
+		RequestIDMiddleware(),
+		AccessMiddleware(logger, 10*time.Millisecond),
+		RecoveryMiddleware(),
+		MetricMiddleware(),
+		TraceMiddleware(),
+	)(handler).ServeHTTP(w, req)
+	return w
+}
+
+func TestAccessMiddleware(t *testing.T) {
+	logger, logs := newTestLogger()
+	req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	req.Header.Set("AID", "1001")
+	req.Header.Set("X-Forwarded-For", "10.0.0.1, 10.0.0.2")
+	req = WithRoute(req, "/users/:id")
+
+	w := serve(logger, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "1001", AID(r.Context()))
+		assert.NotEmpty(t, RequestID(r.Context()))
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("hello"))
+	}, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.NotEmpty(t, w.Header().Get(HeaderRequestID))
+
+	entries := logs.TakeAll()
+	assert.Len(t, entries, 1)
+	fields := entries[0].ContextMap()
+	assert.Equal(t, zapcore.InfoLevel, entries[0].Level)
+	assert.Equal(t, int64(http.StatusCreated), fields["code"])
+	assert.Equal(t, int64(5), fields["size"])
+	assert.Equal(t, "/users/:id", fields["route"])
+	assert.Equal(t, "10.0.0.1", fields["ip"])
+	assert.Equal(t, "1001", fields["aid"])
+	assert.Equal(t, w.Header().Get(HeaderRequestID), fields["request_id"])
+	assert.Equal(t, "normal", fields["event"])
+	assert.IsType(t, float64(0), fields["cost"])
+	assert.NotContains(t, fields, "slow")
+
+	// slow request
+	serve(logger, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+	}, httptest.NewRequest(http.MethodGet, "/", nil))
+	fields = logs.TakeAll()[0].ContextMap()
+	assert.Equal(t, "slow", fields["event"])
+	assert.GreaterOrEqual(t, fields["slow"], int64(20))
+
+	// error set by framework
+	serve(logger, func(w http.ResponseWriter, r *http.Request) {
+		SetError(r, errors.New("bad request"))
+		w.WriteHeader(http.StatusBadRequest)
+	}, httptest.NewRequest(http.MethodGet, "/", nil))
+	entry := logs.TakeAll()[0]
+	assert.Equal(t, zapcore.ErrorLevel, entry.Level)
+	assert.Equal(t, "bad request", entry.ContextMap()["err"])
+}
+
+func TestRecoveryMiddleware(t *testing.T) {
+	logger, logs := newTestLogger()
+	for _, rec := range []interface{}{"string panic", errors.New("error panic"), 42} {
+		w := serve(logger, func(w http.ResponseWriter, r *http.Request) {
+			panic(rec)
+		}, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+		entry := logs.TakeAll()[0]
+		assert.Equal(t, zapcore.ErrorLevel, entry.Level)
+		assert.Equal(t, "recover", entry.ContextMap()["event"])
+		assert.NotEmpty(t, entry.ContextMap()["stack"])
+	}
+
+	// status written before panic is kept
+	w := serve(logger, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		panic("after written")
+	}, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	assert.Panics(t, func() {
+		serve(logger, func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		}, httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+}
@@ -0,0 +1,76 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xhttp
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// SecureConfig security headers of response
+type SecureConfig struct {
+	// Enable enable security headers, false by default
+	Enable bool
+	// HSTSMaxAge max-age seconds of Strict-Transport-Security, which is only sent over https, not sent if 0
+	HSTSMaxAge int
+	// HSTSIncludeSubDomains appends includeSubDomains to Strict-Transport-Security
+	HSTSIncludeSubDomains bool
+	// HSTSPreload appends preload to Strict-Transport-Security
+	HSTSPreload bool
+	// ContentSecurityPolicy value of Content-Security-Policy, e.g. "default-src 'self'"
+	ContentSecurityPolicy string
+	// ContentTypeNosniff sends X-Content-Type-Options: nosniff
+	ContentTypeNosniff bool
+	// FrameOptions value of X-Frame-Options, e.g. DENY or SAMEORIGIN
+	FrameOptions string
+}
+
+// SecureMiddleware sets security headers of response
+func SecureMiddleware(config *SecureConfig) Middleware {
+	var hsts string
+	if config.HSTSMaxAge > 0 {
+		hsts = "max-age=" + strconv.Itoa(config.HSTSMaxAge)
+		if config.HSTSIncludeSubDomains {
+			hsts += "; includeSubDomains"
+		}
+		if config.HSTSPreload {
+			hsts += "; preload"
+		}
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var header = w.Header()
+			if hsts != "" && isHTTPS(r) {
+				header.Set("Strict-Transport-Security", hsts)
+			}
+			if config.ContentSecurityPolicy != "" {
+				header.Set("Content-Security-Policy", config.ContentSecurityPolicy)
+			}
+			if config.ContentTypeNosniff {
+				header.Set("X-Content-Type-Options", "nosniff")
+			}
+			if config.FrameOptions != "" {
+				header.Set("X-Frame-Options", config.FrameOptions)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// isHTTPS reports whether r is served over tls, or forwarded from https by proxy
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
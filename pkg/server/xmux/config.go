@@ -0,0 +1,101 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xmux
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/douyu/jupiter/pkg/util/xtls"
+	"github.com/douyu/jupiter/pkg/xlog"
+
+	"github.com/pkg/errors"
+)
+
+// ModName ..
+const ModName = "server.mux"
+
+// Config multiplexed server config
+type Config struct {
+	Host    string
+	Port    int
+	Network string
+	// ServiceAddress service address in registry info, default to 'Host:Port'
+	ServiceAddress string
+	// SniffTimeout connections not matched within SniffTimeout are closed
+	SniffTimeout time.Duration
+	// TLS tls config, plaintext if nil or not enabled.
+	// tls is terminated by mux, so servers mounted on mux should not enable tls
+	TLS *xtls.Config
+
+	logger *xlog.Logger
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		Host:         "127.0.0.1",
+		Port:         9090,
+		Network:      "tcp4",
+		SniffTimeout: xtime.Duration("10s"),
+		logger:       xlog.JupiterLogger.With(xlog.FieldMod(ModName)),
+	}
+}
+
+// StdConfig Jupiter Standard multiplexed server config
+func StdConfig(name string) *Config {
+	return RawConfig("jupiter.server." + name)
+}
+
+// RawConfig ...
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil &&
+		errors.Cause(err) != conf.ErrInvalidKey {
+		config.logger.Panic("mux server parse config panic", xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr), xlog.FieldErr(err), xlog.FieldKey(key), xlog.FieldValueAny(config))
+	}
+	return config
+}
+
+// WithLogger ...
+func (config *Config) WithLogger(logger *xlog.Logger) *Config {
+	config.logger = logger
+	return config
+}
+
+// WithHost ...
+func (config *Config) WithHost(host string) *Config {
+	config.Host = host
+	return config
+}
+
+// WithPort ...
+func (config *Config) WithPort(port int) *Config {
+	config.Port = port
+	return config
+}
+
+// Build create multiplexed server instance, servers are mounted by listeners of it
+func (config *Config) Build() *Server {
+	return newServer(config)
+}
+
+// Address ...
+func (config *Config) Address() string {
+	return fmt.Sprintf("%s:%d", config.Host, config.Port)
+}
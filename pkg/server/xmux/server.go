@@ -0,0 +1,182 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xmux
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"net"
+	"strings"
+
+	"github.com/douyu/jupiter/pkg/constant"
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/util/xnet"
+	"github.com/douyu/jupiter/pkg/xlog"
+)
+
+const (
+	// SchemeGRPC scheme of connections dispatched to GRPCListener
+	SchemeGRPC = "grpc"
+	// SchemeHTTP scheme of connections dispatched to HTTPListener
+	SchemeHTTP = "http"
+	// SchemeWebSocket scheme of connections dispatched to WebSocketListener
+	SchemeWebSocket = "ws"
+)
+
+// Server listens once and dispatches HTTP/2 gRPC, HTTP/1.1 and websocket
+// connections to listeners of servers mounted on it, e.g.
+//
+//	mux := xmux.StdConfig("mux").Build()
+//	grpcServer := xgrpc.StdConfig("grpc").WithListener(mux.GRPCListener()).Build()
+//	ginServer := xgin.StdConfig("http").WithListener(mux.HTTPListener()).Build()
+//	mux.Register(grpcServer, ginServer)
+//	app.Serve(mux)
+type Server struct {
+	config   *Config
+	listener net.Listener
+	mux      *xnet.Mux
+	grpcLis  net.Listener
+	httpLis  net.Listener
+	wsLis    net.Listener
+	servers  []server.Server
+	schemes  []string
+}
+
+func newServer(config *Config) *Server {
+	listener, err := net.Listen(config.Network, config.Address())
+	if err != nil {
+		config.logger.Panic("new xmux server err", xlog.FieldErrKind(ecode.ErrKindListenErr), xlog.FieldErr(err))
+	}
+	config.Port = listener.Addr().(*net.TCPAddr).Port
+	if config.TLS != nil && config.TLS.Enable {
+		tlsConfig, err := config.TLS.ServerTLSConfig()
+		if err != nil {
+			config.logger.Panic("new xmux server tls config err", xlog.FieldErrKind(ecode.ErrKindTLSErr), xlog.FieldErr(err))
+		}
+		// 服务端优先协商 http/1.1，浏览器等 http 客户端不会以 h2 连接到 grpc
+		tlsConfig.NextProtos = []string{"http/1.1", "h2"}
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	return &Server{
+		config:   config,
+		listener: listener,
+		mux:      xnet.NewMux(listener, config.SniffTimeout),
+	}
+}
+
+// GRPCListener returns listener of HTTP/2 connections
+func (s *Server) GRPCListener() net.Listener {
+	if s.grpcLis == nil {
+		s.grpcLis = s.mux.Match(xnet.MatchHTTP2())
+		s.schemes = append(s.schemes, SchemeGRPC)
+	}
+	return s.grpcLis
+}
+
+// HTTPListener returns listener of HTTP/1.x connections, websocket upgrades
+// are included unless WebSocketListener is used
+func (s *Server) HTTPListener() net.Listener {
+	if s.httpLis == nil {
+		var matchHTTP1, matchWebSocket = xnet.MatchHTTP1(), xnet.MatchWebSocket()
+		s.httpLis = s.mux.Match(func(r *bufio.Reader) bool {
+			if s.wsLis != nil && matchWebSocket(r) {
+				return false
+			}
+			return matchHTTP1(r)
+		})
+		s.schemes = append(s.schemes, SchemeHTTP)
+	}
+	return s.httpLis
+}
+
+// WebSocketListener returns listener of HTTP/1.x connections upgrading to websocket
+func (s *Server) WebSocketListener() net.Listener {
+	if s.wsLis == nil {
+		s.wsLis = s.mux.Match(xnet.MatchWebSocket())
+		s.schemes = append(s.schemes, SchemeWebSocket)
+	}
+	return s.wsLis
+}
+
+// Register mounts servers built on listeners of mux, they are served and stopped with mux
+func (s *Server) Register(servers ...server.Server) *Server {
+	s.servers = append(s.servers, servers...)
+	return s
+}
+
+// Serve implements server.Server interface.
+func (s *Server) Serve() error {
+	var errChan = make(chan error, len(s.servers)+1)
+	for _, srv := range s.servers {
+		go func(srv server.Server) {
+			errChan <- srv.Serve()
+		}(srv)
+	}
+	go func() {
+		errChan <- s.mux.Serve()
+	}()
+	s.config.logger.Info("start mux", xlog.FieldAddr(s.config.Address()), xlog.String("schemes", strings.Join(s.schemes, ",")))
+	return <-errChan
+}
+
+// Stop implements server.Server interface
+// it will terminate mounted servers immediately
+func (s *Server) Stop() error {
+	for _, srv := range s.servers {
+		if err := srv.Stop(); err != nil {
+			s.config.logger.Error("stop mounted server", xlog.FieldErr(err))
+		}
+	}
+	return s.mux.Close()
+}
+
+// GracefulStop implements server.Server interface
+// it will stop mounted servers gracefully
+func (s *Server) GracefulStop(ctx context.Context) error {
+	for _, srv := range s.servers {
+		if err := srv.GracefulStop(ctx); err != nil {
+			s.config.logger.Error("graceful stop mounted server", xlog.FieldErr(err))
+		}
+	}
+	return s.mux.Close()
+}
+
+// Info returns server info, used by governor and consumer balancer,
+// schemes served by mux are listed in metadata "schemes"
+func (s *Server) Info() *server.ServiceInfo {
+	serviceAddr := s.listener.Addr().String()
+	if s.config.ServiceAddress != "" {
+		serviceAddr = s.config.ServiceAddress
+	}
+
+	var scheme = SchemeHTTP
+	if s.grpcLis != nil {
+		scheme = SchemeGRPC
+	}
+	var options = []server.Option{
+		server.WithScheme(scheme),
+		server.WithAddress(serviceAddr),
+		server.WithKind(constant.ServiceProvider),
+		server.WithMetaData("schemes", strings.Join(s.schemes, ",")),
+	}
+	if s.config.TLS != nil && s.config.TLS.Enable {
+		options = append(options, server.WithMetaData("tls", "true"))
+	}
+
+	info := server.ApplyOptions(options...)
+	return &info
+}
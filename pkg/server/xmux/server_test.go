@@ -0,0 +1,89 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xmux
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/server/xecho"
+	"github.com/douyu/jupiter/pkg/server/xgin"
+	"github.com/douyu/jupiter/pkg/server/xgrpc"
+
+	"github.com/gin-gonic/gin"
+	"github.com/labstack/echo/v4"
+	"github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestServer(t *testing.T) {
+	mux := DefaultConfig().WithPort(0).Build()
+
+	grpcServer := xgrpc.DefaultConfig().WithListener(mux.GRPCListener()).Build()
+	ginServer := xgin.DefaultConfig().WithListener(mux.HTTPListener()).Build()
+	ginServer.GET("/hello", func(c *gin.Context) {
+		c.String(http.StatusOK, "http")
+	})
+	wsServer := xecho.DefaultConfig().WithListener(mux.WebSocketListener()).Build()
+	wsServer.GET("/hello", func(c echo.Context) error {
+		return c.String(http.StatusOK, "ws")
+	})
+	mux.Register(grpcServer, ginServer, wsServer)
+
+	go func() {
+		_ = mux.Serve()
+	}()
+	defer mux.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	var addr = mux.config.Address()
+	convey.Convey("test grpc on mux", t, func() {
+		conn, err := grpc.Dial(addr, grpc.WithInsecure())
+		convey.So(err, convey.ShouldBeNil)
+		defer conn.Close()
+		resp, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(resp.Status, convey.ShouldEqual, healthpb.HealthCheckResponse_SERVING)
+	})
+
+	convey.Convey("test http and websocket on mux", t, func() {
+		resp, err := http.Get("http://" + addr + "/hello")
+		convey.So(err, convey.ShouldBeNil)
+		bs, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		convey.So(string(bs), convey.ShouldEqual, "http")
+
+		req, _ := http.NewRequest(http.MethodGet, "http://"+addr+"/hello", nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		resp, err = http.DefaultClient.Do(req)
+		convey.So(err, convey.ShouldBeNil)
+		bs, _ = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		convey.So(string(bs), convey.ShouldEqual, "ws")
+	})
+
+	convey.Convey("test mux service info", t, func() {
+		info := mux.Info()
+		convey.So(info.Scheme, convey.ShouldEqual, SchemeGRPC)
+		convey.So(info.Address, convey.ShouldEqual, addr)
+		convey.So(info.Metadata["schemes"], convey.ShouldEqual, "grpc,http,ws")
+		convey.So(grpcServer.Info().Address, convey.ShouldEqual, addr)
+	})
+}
@@ -0,0 +1,53 @@
+package xcompressor
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/andybalholm/brotli"
+)
+
+type brotliCompressor struct {
+	compressLevel int
+}
+
+// level between 0 ~ 11 as brotli quality, default quality if negative
+func (c *brotliCompressor) Compress(in []byte) ([]byte, error) {
+	var b bytes.Buffer
+	var w = brotli.NewWriterLevel(&b, c.compressLevel)
+	_, err := w.Write(in)
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	// close writes the last meta-block, without which the stream is truncated
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+func (c *brotliCompressor) Uncompress(in []byte) ([]byte, error) {
+	var b bytes.Buffer
+	if _, err := io.Copy(&b, brotli.NewReader(bytes.NewReader(in))); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// NewWriter returns writer compressing to w
+func (c *brotliCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return brotli.NewWriterLevel(w, c.compressLevel), nil
+}
+
+func NewBrotliCompressor(compressLevel int) XCompressor {
+	if compressLevel < 0 {
+		compressLevel = brotli.DefaultCompression
+	}
+	if compressLevel > brotli.BestCompression {
+		compressLevel = brotli.BestCompression
+	}
+	return &brotliCompressor{compressLevel: compressLevel}
+}
@@ -0,0 +1,25 @@
+package xcompressor
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBrotliCompressor(t *testing.T) {
+	text := []byte(strings.Repeat(`{"name":"jupiter","code":0,"data":[1,2,3]}`, 1000))
+	for _, level := range []int{-1, 0, 5, 11, 20} {
+		c := NewBrotliCompressor(level)
+		out, err := c.Compress(text)
+		assert.Nil(t, err)
+		assert.Less(t, len(out), len(text)/50)
+		got, err := c.Uncompress(out)
+		assert.Nil(t, err)
+		assert.True(t, bytes.Equal(text, got), "level %d", level)
+	}
+
+	_, err := NewBrotliCompressor(-1).Uncompress([]byte("not brotli"))
+	assert.NotNil(t, err)
+}
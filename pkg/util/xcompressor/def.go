@@ -1,6 +1,15 @@
 package xcompressor
 
+import "io"
+
 type XCompressor interface {
 	Compress(in []byte) (out []byte, err error)
 	Uncompress(in []byte) (out []byte, err error)
 }
+
+// XStreamCompressor compresses data written to the writer returned by NewWriter,
+// which writes the trailer when closed
+type XStreamCompressor interface {
+	XCompressor
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+}
@@ -17,13 +17,15 @@ func (c *gzipCompressor) Compress(in []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	defer w.Close()
-
 	_, err = w.Write(in)
 	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	// close writes the trailer, without which the stream is truncated
+	if err = w.Close(); err != nil {
 		return nil, err
 	}
-	w.Flush()
 
 	return b.Bytes(), nil
 }
@@ -43,6 +45,11 @@ func (c *gzipCompressor) Uncompress(in []byte) ([]byte, error) {
 	return b.Bytes(), nil
 }
 
+// NewWriter returns writer compressing to w
+func (c *gzipCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriterLevel(w, c.compressLevel)
+}
+
 func NewGzipCompressor(compressLevel int) XCompressor {
 	return &gzipCompressor{compressLevel: compressLevel}
 }
@@ -17,13 +17,15 @@ func (c *zlibCompressor) Compress(in []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	defer w.Close()
-
 	_, err = w.Write(in)
 	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	// close writes the trailer, without which the stream is truncated
+	if err = w.Close(); err != nil {
 		return nil, err
 	}
-	w.Flush()
 
 	return b.Bytes(), nil
 }
@@ -43,6 +45,11 @@ func (c *zlibCompressor) Uncompress(in []byte) ([]byte, error) {
 	return b.Bytes(), nil
 }
 
+// NewWriter returns writer compressing to w
+func (c *zlibCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return zlib.NewWriterLevel(w, c.compressLevel)
+}
+
 func NewZlibCompressor(compressLevel int) XCompressor {
 	return &zlibCompressor{compressLevel: compressLevel}
 }
@@ -0,0 +1,378 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xnet
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrMuxClosed is returned by Accept of matched listeners after mux closed
+var ErrMuxClosed = errors.New("xnet: mux closed")
+
+// Matcher matches connection by peeking its leading bytes, it must not consume the reader
+type Matcher func(r *bufio.Reader) bool
+
+// Mux multiplexes connections of a listener to matched listeners by
+// sniffing leading bytes of connections, e.g. serving gRPC and HTTP on the same port
+type Mux struct {
+	root        net.Listener
+	listeners   []*muxListener
+	readTimeout time.Duration
+	donec       chan struct{}
+	closeOnce   sync.Once
+}
+
+// NewMux returns mux on listener l, connections which can't be matched
+// within readTimeout are closed
+func NewMux(l net.Listener, readTimeout time.Duration) *Mux {
+	return &Mux{
+		root:        l,
+		readTimeout: readTimeout,
+		donec:       make(chan struct{}),
+	}
+}
+
+// Match returns listener of connections matched by any of matchers,
+// listeners are matched in the order of Match calls
+func (m *Mux) Match(matchers ...Matcher) net.Listener {
+	ml := &muxListener{
+		mux:      m,
+		matchers: matchers,
+		connc:    make(chan net.Conn),
+		closec:   make(chan struct{}),
+	}
+	m.listeners = append(m.listeners, ml)
+	return ml
+}
+
+// Serve accepts connections from root listener and dispatches them,
+// it blocks until root listener returns error
+func (m *Mux) Serve() error {
+	defer m.Close()
+	for {
+		conn, err := m.root.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go m.dispatch(conn)
+	}
+}
+
+// Close closes root listener and all matched listeners
+func (m *Mux) Close() error {
+	var err error
+	m.closeOnce.Do(func() {
+		close(m.donec)
+		err = m.root.Close()
+	})
+	return err
+}
+
+func (m *Mux) dispatch(conn net.Conn) {
+	sc := &sniffConn{Conn: conn, r: bufio.NewReader(conn)}
+	if m.readTimeout > 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(m.readTimeout))
+	}
+	for _, ml := range m.listeners {
+		for _, matcher := range ml.matchers {
+			if !matcher(sc.r) {
+				continue
+			}
+			if m.readTimeout > 0 {
+				_ = conn.SetReadDeadline(time.Time{})
+			}
+			select {
+			case ml.connc <- sc:
+			case <-ml.closec:
+				_ = conn.Close()
+			case <-m.donec:
+				_ = conn.Close()
+			}
+			return
+		}
+	}
+	_ = conn.Close()
+}
+
+type muxListener struct {
+	mux       *Mux
+	matchers  []Matcher
+	connc     chan net.Conn
+	closec    chan struct{}
+	closeOnce sync.Once
+}
+
+// Accept implements net.Listener
+func (ml *muxListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-ml.connc:
+		return conn, nil
+	case <-ml.closec:
+		return nil, ErrMuxClosed
+	case <-ml.mux.donec:
+		return nil, ErrMuxClosed
+	}
+}
+
+// Close implements net.Listener, it only stops Accept of this listener
+func (ml *muxListener) Close() error {
+	ml.closeOnce.Do(func() {
+		close(ml.closec)
+	})
+	return nil
+}
+
+// Addr implements net.Listener
+func (ml *muxListener) Addr() net.Addr {
+	return ml.mux.root.Addr()
+}
+
+// sniffConn replays bytes peeked by matchers
+type sniffConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// Read implements net.Conn
+func (sc *sniffConn) Read(b []byte) (int, error) {
+	return sc.r.Read(b)
+}
+
+// http2Preface is the client connection preface of HTTP/2
+const http2Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+// MatchAny matches any connection
+func MatchAny() Matcher {
+	return func(r *bufio.Reader) bool {
+		return true
+	}
+}
+
+// MatchPrefix matches connections starting with prefix, bytes are peeked
+// one by one so that short connections mismatching prefix won't block
+func MatchPrefix(prefix string) Matcher {
+	return func(r *bufio.Reader) bool {
+		for i := 1; i <= len(prefix); i++ {
+			bs, err := r.Peek(i)
+			if err != nil || bs[i-1] != prefix[i-1] {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// MatchHTTP2 matches HTTP/2 connections with prior knowledge, e.g. gRPC
+func MatchHTTP2() Matcher {
+	return MatchPrefix(http2Preface)
+}
+
+// maxSniffSize max size of bytes peeked by http matchers, which is the buffer size of bufio.Reader
+const maxSniffSize = 4096
+
+// MatchHTTP1 matches HTTP/1.x connections by request line of the first request
+func MatchHTTP1() Matcher {
+	return func(r *bufio.Reader) bool {
+		line, ok := peekUntil(r, "\n")
+		if !ok {
+			return false
+		}
+		fields := strings.Fields(string(line))
+		return len(fields) == 3 && strings.HasPrefix(fields[2], "HTTP/1.")
+	}
+}
+
+// MatchWebSocket matches HTTP/1.x connections of which the first request is websocket upgrade
+func MatchWebSocket() Matcher {
+	return func(r *bufio.Reader) bool {
+		header, ok := peekUntil(r, "\r\n\r\n")
+		if !ok {
+			return false
+		}
+		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(header)))
+		if err != nil {
+			return false
+		}
+		return strings.EqualFold(req.Header.Get("Upgrade"), "websocket")
+	}
+}
+
+// peekUntil peeks bytes until delim is found, at most maxSniffSize bytes
+func peekUntil(r *bufio.Reader, delim string) ([]byte, bool) {
+	for n := 1; n <= maxSniffSize; n++ {
+		bs, err := r.Peek(n)
+		if err != nil {
+			return nil, false
+		}
+		if bytes.HasSuffix(bs, []byte(delim)) {
+			return bs, true
+		}
+	}
+	return nil, false
+}
+
+// MatchTLSALPN matches TLS connections of which ALPN protocols offered by client hello
+// are all in protos, e.g. MatchTLSALPN("h2") matches gRPC clients, but not browsers
+// which offer http/1.1 too. Connections are not terminated, so that TLS is handled
+// by the server accepting them.
+func MatchTLSALPN(protos ...string) Matcher {
+	return func(r *bufio.Reader) bool {
+		offered, ok := peekALPN(r)
+		if !ok || len(offered) == 0 {
+			return false
+		}
+		for _, proto := range offered {
+			var found bool
+			for _, p := range protos {
+				if proto == p {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+const (
+	tlsRecordHandshake  = 0x16
+	tlsClientHello      = 0x01
+	tlsExtensionALPN    = 0x10
+	tlsRecordHeaderSize = 5
+)
+
+// peekALPN peeks client hello of TLS connection, and returns ALPN protocols offered
+func peekALPN(r *bufio.Reader) ([]string, bool) {
+	header, err := r.Peek(tlsRecordHeaderSize)
+	if err != nil || header[0] != tlsRecordHandshake {
+		return nil, false
+	}
+	var size = tlsRecordHeaderSize + (int(header[3])<<8 | int(header[4]))
+	if size > maxSniffSize {
+		return nil, false
+	}
+	record, err := r.Peek(size)
+	if err != nil {
+		return nil, false
+	}
+
+	var hello = tlsReader(record[tlsRecordHeaderSize:])
+	if typ, ok := hello.uint8(); !ok || typ != tlsClientHello {
+		return nil, false
+	}
+	// length, version and random
+	if !hello.skip(3 + 2 + 32) {
+		return nil, false
+	}
+	// session id, cipher suites and compression methods
+	if !hello.skipVector(1) || !hello.skipVector(2) || !hello.skipVector(1) {
+		return nil, false
+	}
+	extensions, ok := hello.vector(2)
+	if !ok {
+		// client hello without extensions
+		return nil, true
+	}
+	for len(extensions) > 0 {
+		typ, ok1 := extensions.uint16()
+		data, ok2 := extensions.vector(2)
+		if !ok1 || !ok2 {
+			return nil, false
+		}
+		if typ != tlsExtensionALPN {
+			continue
+		}
+		list, ok := data.vector(2)
+		if !ok {
+			return nil, false
+		}
+		var protos []string
+		for len(list) > 0 {
+			proto, ok := list.vector(1)
+			if !ok {
+				return nil, false
+			}
+			protos = append(protos, string(proto))
+		}
+		return protos, true
+	}
+	return nil, true
+}
+
+// tlsReader reads fields of TLS handshake messages
+type tlsReader []byte
+
+func (tr *tlsReader) skip(n int) bool {
+	if len(*tr) < n {
+		return false
+	}
+	*tr = (*tr)[n:]
+	return true
+}
+
+func (tr *tlsReader) uint8() (uint8, bool) {
+	if len(*tr) < 1 {
+		return 0, false
+	}
+	v := (*tr)[0]
+	*tr = (*tr)[1:]
+	return v, true
+}
+
+func (tr *tlsReader) uint16() (uint16, bool) {
+	if len(*tr) < 2 {
+		return 0, false
+	}
+	v := uint16((*tr)[0])<<8 | uint16((*tr)[1])
+	*tr = (*tr)[2:]
+	return v, true
+}
+
+// vector reads vector prefixed with length of lenSize bytes
+func (tr *tlsReader) vector(lenSize int) (tlsReader, bool) {
+	if len(*tr) < lenSize {
+		return nil, false
+	}
+	var n int
+	for _, b := range (*tr)[:lenSize] {
+		n = n<<8 | int(b)
+	}
+	*tr = (*tr)[lenSize:]
+	if len(*tr) < n {
+		return nil, false
+	}
+	v := (*tr)[:n]
+	*tr = (*tr)[n:]
+	return v, true
+}
+
+func (tr *tlsReader) skipVector(lenSize int) bool {
+	_, ok := tr.vector(lenSize)
+	return ok
+}
@@ -0,0 +1,230 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package peakewma
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/util/xp2c"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// decay time window of ewma
+	decayTau = float64(100 * time.Millisecond)
+	// node not picked in forcePickGap will be picked once, to refresh its stats
+	forcePickGap = int64(time.Second)
+	// initial success rate
+	initSuccess = 1.0
+	// success rate is smoothed per request rather than by time, so that fast failures take effect quickly
+	successAlpha = 0.1
+	// initial latency of nodes not sampled yet, when no node is sampled
+	initLag = float64(10 * time.Millisecond)
+	// latency below minLag is regarded as jitter, so that similar nodes are picked evenly
+	minLag = float64(time.Millisecond)
+)
+
+type node struct {
+	item   interface{}
+	weight float64
+
+	mu      sync.Mutex
+	lag     float64 // peak ewma of latency in nanoseconds
+	success float64 // ewma of success rate
+	stamp   int64   // last update time
+	sampled bool    // whether any response is observed
+
+	inflight int64
+	picked   int64 // last picked time
+}
+
+// stats returns latency decayed as time goes by and success rate, ok is false if not sampled
+func (n *node) stats(now int64) (lag, success float64, ok bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	lag, success = n.lag, n.success
+	if td := now - n.stamp; td > 0 {
+		lag = lag * math.Exp(-float64(td)/decayTau)
+	}
+	return lag, success, n.sampled
+}
+
+func (n *node) observe(now int64, rtt float64, ok bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	td := now - n.stamp
+	if td < 0 {
+		td = 0
+	}
+	w := math.Exp(-float64(td) / decayTau)
+	n.stamp = now
+	n.sampled = true
+	if rtt > n.lag {
+		// 延迟上涨时立即生效
+		n.lag = rtt
+	} else {
+		n.lag = n.lag*w + rtt*(1-w)
+	}
+	var result float64
+	if ok {
+		result = 1
+	}
+	n.success = n.success*(1-successAlpha) + result*successAlpha
+	// avoid division by zero when a node always fails
+	if n.success < 0.01 {
+		n.success = 0.01
+	}
+}
+
+// PeakEWMA p2c balancer based on peak ewma latency
+type PeakEWMA struct {
+	items []*node
+	mu    sync.Mutex
+	rand  *rand.Rand
+	now   func() int64
+}
+
+// New returns p2c balancer which prefers the one with lower peak ewma latency * inflight
+// over success rate and weight
+func New() *PeakEWMA {
+	return &PeakEWMA{
+		items: make([]*node, 0),
+		rand:  rand.New(rand.NewSource(time.Now().UnixNano())),
+		now: func() int64 {
+			return time.Now().UnixNano()
+		},
+	}
+}
+
+var _ xp2c.P2c = (*PeakEWMA)(nil)
+
+// Add adds item with weight 1
+func (p *PeakEWMA) Add(item interface{}) {
+	p.AddWithWeight(item, 1)
+}
+
+// AddWithWeight adds item with weight, weight less than or equal to 0 is treated as 1
+func (p *PeakEWMA) AddWithWeight(item interface{}, weight float64) {
+	if weight <= 0 {
+		weight = 1
+	}
+	now := p.now()
+	p.items = append(p.items, &node{
+		item:    item,
+		weight:  weight,
+		success: initSuccess,
+		stamp:   now,
+		picked:  now,
+	})
+}
+
+// Next ...
+func (p *PeakEWMA) Next() (interface{}, func(balancer.DoneInfo)) {
+	var sc, backsc *node
+
+	switch len(p.items) {
+	case 0:
+		return nil, func(balancer.DoneInfo) {}
+	case 1:
+		sc = p.items[0]
+	default:
+		// rand needs lock
+		p.mu.Lock()
+		a := p.rand.Intn(len(p.items))
+		b := p.rand.Intn(len(p.items) - 1)
+		r := p.rand.Float64()
+		p.mu.Unlock()
+
+		if b >= a {
+			b = b + 1
+		}
+		sc, backsc = p.items[a], p.items[b]
+
+		// choose the item with probability inversely proportional to its score, rather than always
+		// the lower one, otherwise a similar node is starved by a single latency spike until it decays
+		now := p.now()
+		sa, sb := p.score(sc, now), p.score(backsc, now)
+		if r*(sa+sb) > sb {
+			sc, backsc = backsc, sc
+		}
+		// 长时间未被选中的节点强制选中一次, 以更新其统计
+		if now-atomic.LoadInt64(&backsc.picked) > forcePickGap {
+			sc = backsc
+		}
+	}
+
+	return p.pick(sc)
+}
+
+// score lower is better, latency * inflight over success rate and weight
+func (p *PeakEWMA) score(n *node, now int64) float64 {
+	lag, success, ok := n.stats(now)
+	if !ok {
+		// 未采样的节点以其它节点的平均延迟冷启动, 否则其 score 恒为 0, 在首个响应返回前会被集中选中
+		lag = p.coldLag(now)
+	}
+	if lag < minLag {
+		lag = minLag
+	}
+	// 成功率以平方惩罚, 降低异常节点被选中的概率
+	return lag * float64(atomic.LoadInt64(&n.inflight)+1) / (success * success * n.weight)
+}
+
+// coldLag returns latency of nodes not sampled yet, which is mean latency of sampled nodes
+func (p *PeakEWMA) coldLag(now int64) float64 {
+	var sum float64
+	var count int
+	for _, n := range p.items {
+		if lag, _, ok := n.stats(now); ok {
+			sum += lag
+			count++
+		}
+	}
+	if count == 0 || sum == 0 {
+		return initLag
+	}
+	return sum / float64(count)
+}
+
+func (p *PeakEWMA) pick(sc *node) (interface{}, func(balancer.DoneInfo)) {
+	start := p.now()
+	atomic.StoreInt64(&sc.picked, start)
+	atomic.AddInt64(&sc.inflight, 1)
+
+	return sc.item, func(di balancer.DoneInfo) {
+		atomic.AddInt64(&sc.inflight, -1)
+		now := p.now()
+		sc.observe(now, float64(now-start), !isFailure(di.Err))
+	}
+}
+
+// isFailure returns whether err indicates the node is unhealthy, business errors are not failures
+func isFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Internal, codes.Aborted:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package peakewma_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/util/xp2c/peakewma"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPeakEWMA(t *testing.T) {
+	t.Run("0 item", func(t *testing.T) {
+		p := peakewma.New()
+		item, done := p.Next()
+		done(balancer.DoneInfo{})
+		assert.Nil(t, item)
+	})
+
+	t.Run("1 item", func(t *testing.T) {
+		p := peakewma.New()
+		p.Add(1)
+		item, done := p.Next()
+		done(balancer.DoneInfo{})
+		assert.Equal(t, 1, item)
+	})
+
+	t.Run("slow item", func(t *testing.T) {
+		p := peakewma.New()
+		p.Add(1)
+		p.Add(2)
+
+		countMap := make(map[interface{}]int)
+		for i := 0; i < 200; i++ {
+			item, done := p.Next()
+			if item == 2 {
+				time.Sleep(20 * time.Millisecond)
+			}
+			done(balancer.DoneInfo{})
+			countMap[item]++
+		}
+		assert.Less(t, countMap[2], 20)
+	})
+
+	t.Run("failing item", func(t *testing.T) {
+		p := peakewma.New()
+		p.Add(1)
+		p.Add(2)
+
+		countMap := make(map[interface{}]int)
+		for i := 0; i < 200; i++ {
+			item, done := p.Next()
+			var err error
+			if item == 2 {
+				err = status.Error(codes.Unavailable, "unavailable")
+			}
+			time.Sleep(100 * time.Microsecond)
+			done(balancer.DoneInfo{Err: err})
+			countMap[item]++
+		}
+		assert.Less(t, countMap[2], 50)
+	})
+	t.Run("new item", func(t *testing.T) {
+		p := peakewma.New()
+		for i := 1; i <= 4; i++ {
+			p.Add(i)
+		}
+		for i := 0; i < 100; i++ {
+			_, done := p.Next()
+			time.Sleep(100 * time.Microsecond)
+			done(balancer.DoneInfo{})
+		}
+		p.Add(5)
+
+		// 新节点尚无延迟采样, 在其它节点请求堆积时也不应被集中选中
+		countMap := make(map[interface{}]int)
+		dones := make([]func(balancer.DoneInfo), 0)
+		for i := 0; i < 200; i++ {
+			item, done := p.Next()
+			dones = append(dones, done)
+			countMap[item]++
+		}
+		for _, done := range dones {
+			done(balancer.DoneInfo{})
+		}
+		assert.Less(t, countMap[5], 60, countMap)
+	})
+}
@@ -0,0 +1,198 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xtls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Config tls config shared by servers and clients
+type Config struct {
+	// Enable enable tls, false by default
+	Enable bool
+	// CertFile certificate file path
+	CertFile string
+	// KeyFile private key file path
+	KeyFile string
+	// CAFile ca file path, used to verify peer certificate
+	CAFile string
+	// ClientAuth client auth mode of server: none | request | require | verify_if_given | require_and_verify
+	ClientAuth string
+	// MinVersion min tls version: 1.0 | 1.1 | 1.2 | 1.3, 1.2 by default
+	MinVersion string
+	// CipherSuites cipher suite names, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+	CipherSuites []string
+	// ServerName SNI of client, also used to verify the hostname of server certificate
+	ServerName string
+	// InsecureSkipVerify client will not verify server certificate if true
+	InsecureSkipVerify bool
+	// DisableReload disable certificate hot reload on file change
+	DisableReload bool
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		Enable:     false,
+		ClientAuth: "none",
+		MinVersion: "1.2",
+	}
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"":                   tls.NoClientCert,
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify_if_given":    tls.VerifyClientCertIfGiven,
+	"require_and_verify": tls.RequireAndVerifyClientCert,
+}
+
+var tlsVersions = map[string]uint16{
+	"":    tls.VersionTLS12,
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ServerTLSConfig builds tls config for server,
+// certificate will be reloaded on file change unless DisableReload is set
+func (config *Config) ServerTLSConfig() (*tls.Config, error) {
+	if config.CertFile == "" || config.KeyFile == "" {
+		return nil, fmt.Errorf("tls: server requires cert file and key file")
+	}
+
+	tlsConfig, err := config.baseTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	clientAuth, ok := clientAuthTypes[strings.ToLower(config.ClientAuth)]
+	if !ok {
+		return nil, fmt.Errorf("tls: unknown client auth %q", config.ClientAuth)
+	}
+	tlsConfig.ClientAuth = clientAuth
+
+	if config.CAFile != "" {
+		pool, err := loadCertPool(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = pool
+	}
+
+	kp, err := config.keyPair()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.GetCertificate = kp.GetCertificate
+	return tlsConfig, nil
+}
+
+// ClientTLSConfig builds tls config for client,
+// client certificate is optional and will be reloaded on file change unless DisableReload is set
+func (config *Config) ClientTLSConfig() (*tls.Config, error) {
+	tlsConfig, err := config.baseTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig.ServerName = config.ServerName
+	tlsConfig.InsecureSkipVerify = config.InsecureSkipVerify
+
+	if config.CAFile != "" {
+		pool, err := loadCertPool(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.CertFile != "" && config.KeyFile != "" {
+		kp, err := config.keyPair()
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.GetClientCertificate = kp.GetClientCertificate
+	}
+	return tlsConfig, nil
+}
+
+func (config *Config) baseTLSConfig() (*tls.Config, error) {
+	minVersion, ok := tlsVersions[config.MinVersion]
+	if !ok {
+		return nil, fmt.Errorf("tls: unknown min version %q", config.MinVersion)
+	}
+
+	cipherSuites, err := parseCipherSuites(config.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		MinVersion:   minVersion,
+		CipherSuites: cipherSuites,
+	}, nil
+}
+
+func (config *Config) keyPair() (*keyPair, error) {
+	if config.DisableReload {
+		return newKeyPair(config.CertFile, config.KeyFile)
+	}
+	return watchKeyPair(config.CertFile, config.KeyFile)
+}
+
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	var suites = make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+
+	var ids = make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := suites[name]
+		if !ok {
+			return nil, fmt.Errorf("tls: unknown cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "tls: read ca file")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("tls: no certificate found in ca file %s", caFile)
+	}
+	return pool, nil
+}
@@ -0,0 +1,136 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xtls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeCert(t *testing.T, dir string, cn string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	certFile = filepath.Join(dir, "tls.crt")
+	keyFile = filepath.Join(dir, "tls.key")
+	assert.Nil(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.Nil(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return
+}
+
+func TestConfig_MutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xtls")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := writeCert(t, dir, "jupiter")
+	config := &Config{
+		Enable:        true,
+		CertFile:      certFile,
+		KeyFile:       keyFile,
+		CAFile:        certFile,
+		ClientAuth:    "require_and_verify",
+		ServerName:    "localhost",
+		DisableReload: true,
+	}
+
+	serverConfig, err := config.ServerTLSConfig()
+	assert.Nil(t, err)
+	clientConfig, err := config.ClientTLSConfig()
+	assert.Nil(t, err)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	assert.Nil(t, err)
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = conn.(*tls.Conn).Handshake()
+	}()
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), clientConfig)
+	assert.Nil(t, err)
+	defer conn.Close()
+	assert.Nil(t, conn.Handshake())
+	assert.Equal(t, "jupiter", conn.ConnectionState().PeerCertificates[0].Subject.CommonName)
+}
+
+func TestConfig_Reload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xtls")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := writeCert(t, dir, "before")
+	config := &Config{Enable: true, CertFile: certFile, KeyFile: keyFile}
+	serverConfig, err := config.ServerTLSConfig()
+	assert.Nil(t, err)
+
+	cert, err := serverConfig.GetCertificate(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "before", cert.Leaf.Subject.CommonName)
+
+	writeCert(t, dir, "after")
+	assert.Eventually(t, func() bool {
+		cert, err := serverConfig.GetCertificate(nil)
+		return err == nil && cert.Leaf.Subject.CommonName == "after"
+	}, time.Second*3, time.Millisecond*50)
+}
+
+func TestConfig_Invalid(t *testing.T) {
+	_, err := (&Config{Enable: true}).ServerTLSConfig()
+	assert.NotNil(t, err)
+
+	_, err = (&Config{MinVersion: "0.9"}).ClientTLSConfig()
+	assert.NotNil(t, err)
+
+	_, err = (&Config{CipherSuites: []string{"TLS_UNKNOWN"}}).ClientTLSConfig()
+	assert.NotNil(t, err)
+
+	_, err = (&Config{CertFile: "a", KeyFile: "b", ClientAuth: "unknown"}).ServerTLSConfig()
+	assert.NotNil(t, err)
+}
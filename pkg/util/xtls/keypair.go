@@ -0,0 +1,167 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xtls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/metric"
+	"github.com/douyu/jupiter/pkg/xlog"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+)
+
+// ModName ...
+const ModName = "util.tls"
+
+var (
+	keyPairsMu sync.Mutex
+	// keyPairs watched key pairs, shared by all configs using the same files
+	keyPairs = make(map[string]*keyPair)
+)
+
+type keyPair struct {
+	certFile string
+	keyFile  string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+
+	logger *xlog.Logger
+}
+
+func newKeyPair(certFile, keyFile string) (*keyPair, error) {
+	kp := &keyPair{
+		certFile: certFile,
+		keyFile:  keyFile,
+		logger:   xlog.JupiterLogger.With(xlog.FieldMod(ModName)),
+	}
+	if err := kp.reload(); err != nil {
+		return nil, err
+	}
+	return kp, nil
+}
+
+// watchKeyPair returns key pair which reloads itself when cert or key file changes
+func watchKeyPair(certFile, keyFile string) (*keyPair, error) {
+	keyPairsMu.Lock()
+	defer keyPairsMu.Unlock()
+
+	key := certFile + "|" + keyFile
+	if kp, ok := keyPairs[key]; ok {
+		return kp, nil
+	}
+
+	kp, err := newKeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, errors.Wrap(err, "tls: new file watcher")
+	}
+
+	var dirs = map[string]struct{}{
+		filepath.Dir(certFile): {},
+		filepath.Dir(keyFile):  {},
+	}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return nil, errors.Wrap(err, "tls: watch cert dir")
+		}
+	}
+
+	go kp.watch(watcher)
+	keyPairs[key] = kp
+	return kp, nil
+}
+
+func (kp *keyPair) watch(watcher *fsnotify.Watcher) {
+	defer watcher.Close()
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			// kubernetes secret volume swaps files with symlink,
+			// so any write/create/rename event in the dir may change the key pair
+			const changedMask = fsnotify.Write | fsnotify.Create | fsnotify.Rename
+			if event.Op&changedMask == 0 {
+				continue
+			}
+			if err := kp.reload(); err != nil {
+				kp.logger.Error("reload tls certificate", xlog.FieldErr(err), xlog.String("cert", kp.certFile), xlog.String("event", event.String()))
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			kp.logger.Error("watch tls certificate", xlog.FieldErr(err), xlog.String("cert", kp.certFile))
+		}
+	}
+}
+
+// reload loads key pair from files, current certificate is kept if failed
+func (kp *keyPair) reload() error {
+	cert, err := tls.LoadX509KeyPair(kp.certFile, kp.keyFile)
+	if err != nil {
+		metric.TLSCertReloadCounter.Inc(kp.certFile, "error")
+		return errors.Wrap(err, "tls: load key pair")
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		metric.TLSCertReloadCounter.Inc(kp.certFile, "error")
+		return errors.Wrap(err, "tls: parse certificate")
+	}
+	cert.Leaf = leaf
+
+	kp.mu.Lock()
+	kp.cert = &cert
+	kp.mu.Unlock()
+
+	metric.TLSCertReloadCounter.Inc(kp.certFile, "ok")
+	metric.TLSCertExpiryGauge.Set(float64(leaf.NotAfter.Unix()), kp.certFile, leaf.Subject.CommonName)
+	kp.logger.Info("load tls certificate",
+		xlog.String("cert", kp.certFile),
+		xlog.String("subject", leaf.Subject.CommonName),
+		xlog.String("notAfter", leaf.NotAfter.Format(time.RFC3339)),
+	)
+	return nil
+}
+
+func (kp *keyPair) certificate() *tls.Certificate {
+	kp.mu.RLock()
+	defer kp.mu.RUnlock()
+	return kp.cert
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (kp *keyPair) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return kp.certificate(), nil
+}
+
+// GetClientCertificate implements tls.Config.GetClientCertificate
+func (kp *keyPair) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return kp.certificate(), nil
+}
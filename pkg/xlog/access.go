@@ -0,0 +1,222 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+	"unsafe"
+
+	"github.com/douyu/jupiter/pkg/util/xrand"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/modern-go/reflect2"
+)
+
+// RedactTag struct tag which marks a field to be redacted in access log, e.g. `redact:"true"`
+const RedactTag = "redact"
+
+const redactedValue = "***"
+
+// access log results
+const (
+	AccessResultNormal = "normal"
+	AccessResultSlow   = "slow"
+	AccessResultError  = "error"
+	// AccessResultBizError business error, which is not a system error
+	AccessResultBizError = "biz_error"
+)
+
+// AccessConfig access log policy of servers and clients
+type AccessConfig struct {
+	// DisablePayload do not log request and reply payload, false by default
+	DisablePayload bool
+	// MaxPayloadSize payload over MaxPayloadSize bytes will be truncated, 0 means no limit
+	MaxPayloadSize int
+	// RedactFields payload fields to be redacted, matched by go field name, json name or proto field name,
+	// fields tagged with `redact:"true"` are always redacted
+	RedactFields []string
+	// SampleRate sampling rate of normal access log in [0, 1], 1 by default
+	SampleRate float64
+	// MethodSampleRates sampling rate of normal access log per method, overrides SampleRate
+	MethodSampleRates map[string]float64
+	// NormalLevel level of normal access log, info by default
+	NormalLevel string
+	// SlowLevel level of slow access log, warn by default
+	SlowLevel string
+	// ErrorLevel level of error access log, error by default
+	ErrorLevel string
+	// BizErrorLevel level of business error access log, warn by default
+	BizErrorLevel string
+}
+
+// DefaultAccessConfig ...
+func DefaultAccessConfig() *AccessConfig {
+	return &AccessConfig{
+		MaxPayloadSize:    4096,
+		SampleRate:        1,
+		MethodSampleRates: make(map[string]float64),
+		NormalLevel:       "info",
+		SlowLevel:         "warn",
+		ErrorLevel:        "error",
+		BizErrorLevel:     "warn",
+	}
+}
+
+// AccessPolicy decides whether and how an access log is written
+type AccessPolicy struct {
+	config *AccessConfig
+	json   jsoniter.API
+	levels map[string]Level
+}
+
+// Build ...
+func (config *AccessConfig) Build() *AccessPolicy {
+	policy := &AccessPolicy{
+		config: config,
+		json: jsoniter.Config{
+			SortMapKeys:            true,
+			UseNumber:              true,
+			CaseSensitive:          true,
+			EscapeHTML:             true,
+			ValidateJsonRawMessage: true,
+		}.Froze(),
+		levels: map[string]Level{
+			AccessResultNormal:   parseLevel(config.NormalLevel, InfoLevel),
+			AccessResultSlow:     parseLevel(config.SlowLevel, WarnLevel),
+			AccessResultError:    parseLevel(config.ErrorLevel, ErrorLevel),
+			AccessResultBizError: parseLevel(config.BizErrorLevel, WarnLevel),
+		},
+	}
+
+	var fields = make(map[string]struct{}, len(config.RedactFields))
+	for _, field := range config.RedactFields {
+		fields[field] = struct{}{}
+	}
+	policy.json.RegisterExtension(&redactExtension{fields: fields})
+	return policy
+}
+
+func parseLevel(text string, defaultLevel Level) Level {
+	var lv Level
+	if err := lv.UnmarshalText([]byte(strings.ToLower(text))); err != nil {
+		return defaultLevel
+	}
+	return lv
+}
+
+// Enabled reports whether access log of result should be written,
+// normal access log is sampled by method
+func (policy *AccessPolicy) Enabled(result string, method string) bool {
+	if result != AccessResultNormal {
+		return true
+	}
+	rate, ok := policy.config.MethodSampleRates[method]
+	if !ok {
+		rate = policy.config.SampleRate
+	}
+	if rate >= 1 {
+		return true
+	}
+	if rate <= 0 {
+		return false
+	}
+	return xrand.Float64() < rate
+}
+
+// Payload returns redacted and truncated payload field
+func (policy *AccessPolicy) Payload(key string, obj interface{}) Field {
+	if policy.config.DisablePayload {
+		return Skip
+	}
+
+	data, err := policy.json.Marshal(obj)
+	if err != nil {
+		return String(key, fmt.Sprintf("marshal payload: %s", err))
+	}
+	if max := policy.config.MaxPayloadSize; max > 0 && len(data) > max {
+		// cut at rune boundary, so that multi-byte characters are not split
+		for max > 0 && !utf8.RuneStart(data[max]) {
+			max--
+		}
+		return String(key, fmt.Sprintf("%s...(truncated %d bytes)", data[:max], len(data)-max))
+	}
+	return Any(key, json.RawMessage(data))
+}
+
+// Log writes access log with level of result
+func (policy *AccessPolicy) Log(logger *Logger, result string, msg string, fields ...Field) {
+	lv, ok := policy.levels[result]
+	if !ok {
+		lv = InfoLevel
+	}
+	// checked here rather than in a helper, so that caller skip of logger reports caller of Log
+	if logger.IsDebugMode() {
+		msg = normalizeMessage(msg)
+	}
+	if ce := logger.desugar.Check(lv, msg); ce != nil {
+		ce.Write(fields...)
+	}
+}
+
+// redactExtension replaces value of redacted fields with "***"
+type redactExtension struct {
+	jsoniter.DummyExtension
+	fields map[string]struct{}
+}
+
+// UpdateStructDescriptor ...
+func (re *redactExtension) UpdateStructDescriptor(structDescriptor *jsoniter.StructDescriptor) {
+	for _, binding := range structDescriptor.Fields {
+		if re.redacted(binding.Field) {
+			binding.Encoder = redactEncoder{}
+		}
+	}
+}
+
+func (re *redactExtension) redacted(field reflect2.StructField) bool {
+	tag := field.Tag()
+	if tag.Get(RedactTag) == "true" {
+		return true
+	}
+
+	var names = []string{field.Name(), strings.Split(tag.Get("json"), ",")[0]}
+	// protobuf:"bytes,1,opt,name=user_name,json=userName,proto3"
+	for _, part := range strings.Split(tag.Get("protobuf"), ",") {
+		if strings.HasPrefix(part, "name=") || strings.HasPrefix(part, "json=") {
+			names = append(names, part[5:])
+		}
+	}
+	for _, name := range names {
+		if _, ok := re.fields[name]; ok && name != "" {
+			return true
+		}
+	}
+	return false
+}
+
+type redactEncoder struct{}
+
+// IsEmpty ...
+func (redactEncoder) IsEmpty(ptr unsafe.Pointer) bool {
+	return false
+}
+
+// Encode ...
+func (redactEncoder) Encode(ptr unsafe.Pointer, stream *jsoniter.Stream) {
+	stream.WriteString(redactedValue)
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xlog
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type accessUser struct {
+	Name     string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Password string `protobuf:"bytes,2,opt,name=pass_word,json=passWord,proto3" json:"pass_word,omitempty"`
+	Token    string `json:"token" redact:"true"`
+}
+
+func payloadString(field Field) string {
+	if field.Type == zapcore.StringType {
+		return field.String
+	}
+	return string(field.Interface.(json.RawMessage))
+}
+
+func TestAccessPolicy_Payload(t *testing.T) {
+	config := DefaultAccessConfig()
+	config.RedactFields = []string{"pass_word"}
+	policy := config.Build()
+
+	payload := payloadString(policy.Payload("req", &accessUser{Name: "jupiter", Password: "secret", Token: "abc"}))
+	assert.Equal(t, `{"name":"jupiter","pass_word":"***","token":"***"}`, payload)
+
+	config.MaxPayloadSize = 10
+	payload = payloadString(config.Build().Payload("req", &accessUser{Name: "jupiter"}))
+	assert.True(t, strings.HasPrefix(payload, `{"name":"j...(truncated`))
+
+	// multi-byte characters are not split
+	config.MaxPayloadSize = 13
+	payload = payloadString(config.Build().Payload("req", &accessUser{Name: "木星"}))
+	assert.True(t, strings.HasPrefix(payload, `{"name":"木...(truncated`), payload)
+
+	config.DisablePayload = true
+	assert.Equal(t, Skip, config.Build().Payload("req", &accessUser{}))
+}
+
+func TestAccessPolicy_Enabled(t *testing.T) {
+	config := DefaultAccessConfig()
+	config.SampleRate = 0
+	config.MethodSampleRates = map[string]float64{"/test.Greeter/SayHello": 1}
+	policy := config.Build()
+
+	assert.True(t, policy.Enabled(AccessResultNormal, "/test.Greeter/SayHello"))
+	assert.False(t, policy.Enabled(AccessResultNormal, "/test.Greeter/SayHi"))
+	assert.True(t, policy.Enabled(AccessResultError, "/test.Greeter/SayHi"))
+	assert.True(t, policy.Enabled(AccessResultSlow, "/test.Greeter/SayHi"))
+}
+
+func TestAccessPolicy_Levels(t *testing.T) {
+	config := DefaultAccessConfig()
+	config.NormalLevel = "debug"
+	config.SlowLevel = "unknown"
+	policy := config.Build()
+
+	assert.Equal(t, DebugLevel, policy.levels[AccessResultNormal])
+	assert.Equal(t, WarnLevel, policy.levels[AccessResultSlow])
+	assert.Equal(t, ErrorLevel, policy.levels[AccessResultError])
+	assert.Equal(t, WarnLevel, policy.levels[AccessResultBizError])
+}
+
+func TestAccessPolicy_LogCaller(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger := Config{Core: core, AddCaller: true, CallerSkip: 1}.Build()
+
+	DefaultAccessConfig().Build().Log(logger, AccessResultNormal, "access")
+	assert.True(t, strings.HasSuffix(logs.TakeAll()[0].Caller.File, "xlog/access_test.go"))
+}
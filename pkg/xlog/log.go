@@ -68,6 +68,8 @@ var (
 	Any = zap.Any
 	// Int64 ...
 	Int64 = zap.Int64
+	// Float64 ...
+	Float64 = zap.Float64
 	// Int ...
 	Int = zap.Int
 	// Int32 ...
@@ -0,0 +1,57 @@
+package graph
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/util/xcolor"
+	"github.com/urfave/cli"
+)
+
+// Option ...
+type Option struct {
+	addr   string
+	format string
+	out    string
+}
+
+var option = Option{}
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// Export exports dependency graph through governor
+func Export(c *cli.Context) error {
+	if option.format != "dot" && option.format != "json" {
+		return fmt.Errorf("unknown format: %s", option.format)
+	}
+	addr := option.addr
+	if !strings.HasPrefix(addr, "http://") && !strings.HasPrefix(addr, "https://") {
+		addr = "http://" + addr
+	}
+	resp, err := client.Get(addr + "/debug/registry/graph?format=" + url.QueryEscape(option.format))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	if option.out == "" {
+		fmt.Print(string(body))
+		return nil
+	}
+	if err := ioutil.WriteFile(option.out, body, 0644); err != nil {
+		return err
+	}
+	fmt.Println(xcolor.Greenf("graph exported successfully. The path is as follows:", option.out))
+	return nil
+}
@@ -0,0 +1,30 @@
+package graph
+
+import "github.com/urfave/cli"
+
+var Cmd = cli.Command{
+	Name:      "graph",
+	Aliases:   []string{"g"},
+	Usage:     "Export dependency graph of services in registry",
+	Action:    Export,
+	UsageText: GraphHelpTemplate,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:        "addr,a",
+			Usage:       "Address of governor",
+			Required:    true,
+			Destination: &option.addr,
+		},
+		&cli.StringFlag{
+			Name:        "format,f",
+			Value:       "dot",
+			Usage:       "Format of graph, dot or json",
+			Destination: &option.format,
+		},
+		&cli.StringFlag{
+			Name:        "out,o",
+			Usage:       "Path of output file, stdout if empty",
+			Destination: &option.out,
+		},
+	},
+}
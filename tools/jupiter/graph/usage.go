@@ -0,0 +1,32 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+// GraphHelpTemplate ...
+const GraphHelpTemplate = `
+jupiter graph [flags]
+
+The commands & flags are:
+  graph         export dependency graph of services in registry
+  -a,--addr     address of governor
+  -f,--format   format of graph, dot or json, default dot
+  -o,--out      path of output file, stdout if empty
+Examples:
+   # Export dependency graph, and render it with graphviz
+   jupiter graph -a 127.0.0.1:9990 -o deps.dot && dot -Tsvg deps.dot -o deps.svg
+   # Export dependency graph in json
+   jupiter graph -a 127.0.0.1:9990 -f json
+
+`
@@ -18,8 +18,10 @@ import (
 	"log"
 	"os"
 
+	"github.com/douyu/jupiter/tools/jupiter/graph"
 	"github.com/douyu/jupiter/tools/jupiter/new"
 	"github.com/douyu/jupiter/tools/jupiter/protoc"
+	"github.com/douyu/jupiter/tools/jupiter/rule"
 
 	"github.com/urfave/cli"
 )
@@ -33,7 +35,9 @@ func main() {
 	app.Version = Version
 	app.Commands = []cli.Command{
 		new.Cmd,
+		graph.Cmd,
 		protoc.Cmd,
+		rule.Cmd,
 	}
 
 	err := app.Run(os.Args)
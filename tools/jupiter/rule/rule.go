@@ -0,0 +1,93 @@
+package rule
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/registry/etcdv3"
+	"github.com/douyu/jupiter/pkg/util/xcolor"
+	"github.com/urfave/cli"
+)
+
+type Option struct {
+	etcd     string
+	prefix   string
+	user     string
+	password string
+	name     string
+	file     string
+}
+
+var option = Option{}
+
+func Get(c *cli.Context) error {
+	publisher, closer, err := newPublisher()
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	rules, err := publisher.GetRules(context.Background(), option.name)
+	if err != nil {
+		return err
+	}
+	return printRules(rules)
+}
+
+func Publish(c *cli.Context) error {
+	content, err := ioutil.ReadFile(option.file)
+	if err != nil {
+		return err
+	}
+	var rules registry.Rules
+	if err := json.Unmarshal(content, &rules); err != nil {
+		return err
+	}
+	if err := rules.Validate(); err != nil {
+		return err
+	}
+
+	publisher, closer, err := newPublisher()
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	if err := publisher.PublishRules(context.Background(), option.name, rules); err != nil {
+		return err
+	}
+	fmt.Println(xcolor.Green("rules published successfully"))
+	return printRules(rules)
+}
+
+// newPublisher connects to etcd registry directly, rules are not published through governor of applications
+func newPublisher() (registry.RulePublisher, func(), error) {
+	config := etcdv3.DefaultConfig()
+	config.Endpoints = strings.Split(option.etcd, ",")
+	config.Prefix = option.prefix
+	if option.user != "" {
+		config.BasicAuth = true
+		config.UserName = option.user
+		config.Password = option.password
+	}
+	reg := config.Build()
+	publisher, ok := reg.(registry.RulePublisher)
+	if !ok {
+		_ = reg.Close()
+		return nil, nil, fmt.Errorf("registry doesn't support rules")
+	}
+	return publisher, func() { _ = reg.Close() }, nil
+}
+
+func printRules(rules registry.Rules) error {
+	body, err := json.MarshalIndent(rules, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(body))
+	return nil
+}
@@ -0,0 +1,66 @@
+package rule
+
+import "github.com/urfave/cli"
+
+var Cmd = cli.Command{
+	Name:      "rule",
+	Aliases:   []string{"r"},
+	Usage:     "Get or publish traffic rules of service",
+	UsageText: RuleHelpTemplate,
+	Subcommands: []cli.Command{
+		{
+			Name:   "get",
+			Usage:  "Get traffic rules of service",
+			Action: Get,
+			Flags:  flags(false),
+		},
+		{
+			Name:   "publish",
+			Usage:  "Publish traffic rules of service",
+			Action: Publish,
+			Flags:  flags(true),
+		},
+	},
+}
+
+func flags(withFile bool) []cli.Flag {
+	var flags = []cli.Flag{
+		&cli.StringFlag{
+			Name:        "etcd,e",
+			Usage:       "Endpoints of etcd registry, separated by comma",
+			Required:    true,
+			Destination: &option.etcd,
+		},
+		&cli.StringFlag{
+			Name:        "prefix,p",
+			Usage:       "Key prefix of etcd registry",
+			Value:       "jupiter",
+			Destination: &option.prefix,
+		},
+		&cli.StringFlag{
+			Name:        "user,u",
+			Usage:       "User name of etcd",
+			Destination: &option.user,
+		},
+		&cli.StringFlag{
+			Name:        "password",
+			Usage:       "Password of etcd",
+			Destination: &option.password,
+		},
+		&cli.StringFlag{
+			Name:        "name,n",
+			Usage:       "Name of service",
+			Required:    true,
+			Destination: &option.name,
+		},
+	}
+	if withFile {
+		flags = append(flags, &cli.StringFlag{
+			Name:        "file,f",
+			Usage:       "Path of rules file",
+			Required:    true,
+			Destination: &option.file,
+		})
+	}
+	return flags
+}
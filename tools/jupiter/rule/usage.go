@@ -0,0 +1,36 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rule
+
+// RuleHelpTemplate ...
+const RuleHelpTemplate = `
+jupiter rule [get|publish] [flags]
+
+The commands & flags are:
+  get           get traffic rules of service
+  publish       publish traffic rules of service
+  -e,--etcd     endpoints of etcd registry, separated by comma
+  -p,--prefix   key prefix of etcd registry, jupiter by default
+  -u,--user     user name of etcd
+  --password    password of etcd
+  -n,--name     name of service
+  -f,--file     path of rules file, in json
+Examples:
+   # Get traffic rules of service
+   jupiter rule get -e 127.0.0.1:2379 -n demo
+   # Publish traffic rules of service, which replace all rules of service
+   jupiter rule publish -e 127.0.0.1:2379 -n demo -f ./rules.json
+
+`